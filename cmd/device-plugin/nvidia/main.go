@@ -37,6 +37,7 @@ import (
 	"github.com/Project-HAMi/HAMi/pkg/util"
 	"github.com/Project-HAMi/HAMi/pkg/util/client"
 	flagutil "github.com/Project-HAMi/HAMi/pkg/util/flag"
+	"github.com/Project-HAMi/HAMi/pkg/util/nodelock"
 )
 
 func main() {
@@ -185,6 +186,11 @@ func start(c *cli.Context, flags []cli.Flag) error {
 	klog.Info("Starting FS watcher.")
 	util.NodeName = os.Getenv(util.NodeNameEnvName)
 	client.InitGlobalClient()
+	nodelock.LeaseNamespace = c.String("node-lock-lease-namespace")
+	if err := nodelock.SetBackend(c.String("node-lock-backend")); err != nil {
+		return err
+	}
+	plugin.RegisterPhysicalMemory = c.Bool("register-physical-memory")
 	watcher, err := newFSWatcher(kubeletdevicepluginv1beta1.DevicePluginPath)
 	if err != nil {
 		return fmt.Errorf("failed to create FS watcher: %v", err)
@@ -262,6 +268,7 @@ exit:
 	if err != nil {
 		return fmt.Errorf("error stopping plugins: %v", err)
 	}
+	teardownPlugins(plugins)
 	return nil
 }
 
@@ -344,6 +351,16 @@ func stopPlugins(plugins []plugin.Interface) error {
 	return errorsutil.NewAggregate(errs)
 }
 
+// teardownPlugins stops what the plugins leave running across restarts, as the
+// MPS control daemons.
+func teardownPlugins(plugins []plugin.Interface) {
+	for _, p := range plugins {
+		if p, ok := p.(*plugin.NvidiaDevicePlugin); ok {
+			p.StopMPSDaemons()
+		}
+	}
+}
+
 // disableResourceRenamingInConfig temporarily disable the resource renaming feature of the plugin.
 // We plan to reeenable this feature in a future release.
 func disableResourceRenamingInConfig(config *spec.Config) {
@@ -25,6 +25,7 @@ import (
 	"github.com/Project-HAMi/HAMi/pkg/device-plugin/nvidiadevice/nvinternal/plugin"
 	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
 	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/util/nodelock"
 
 	spec "github.com/NVIDIA/k8s-device-plugin/api/config/v1"
 	cli "github.com/urfave/cli/v2"
@@ -57,6 +58,12 @@ func addFlags() []cli.Flag {
 			Usage:   "the ratio for NVIDIA device cores scaling",
 			EnvVars: []string{"DEVICE_CORES_SCALING"},
 		},
+		&cli.BoolFlag{
+			Name:    "register-physical-memory",
+			Value:   false,
+			Usage:   "If set, register the physical memory of devices with a memory scaling above 1, only once the scheduler is upgraded",
+			EnvVars: []string{"REGISTER_PHYSICAL_MEMORY"},
+		},
 		&cli.BoolFlag{
 			Name:    "disable-core-limit",
 			Value:   false,
@@ -68,6 +75,18 @@ func addFlags() []cli.Flag {
 			Value: "nvidia.com/gpu",
 			Usage: "the name of field for number GPU visible in container",
 		},
+		&cli.StringFlag{
+			Name:    "node-lock-backend",
+			Value:   nodelock.BackendAnnotation,
+			Usage:   "node lock backend, annotation or lease, must match the scheduler",
+			EnvVars: []string{"NODE_LOCK_BACKEND"},
+		},
+		&cli.StringFlag{
+			Name:    "node-lock-lease-namespace",
+			Value:   nodelock.LeaseNamespace,
+			Usage:   "namespace of the node lock leases when the node lock backend is lease",
+			EnvVars: []string{"NODE_LOCK_LEASE_NAMESPACE"},
+		},
 	}
 	return addition
 }
@@ -0,0 +1,98 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	klog "k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/dra"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/util/client"
+	"github.com/Project-HAMi/HAMi/pkg/util/flag"
+	"github.com/Project-HAMi/HAMi/pkg/version"
+)
+
+var (
+	pluginConfig dra.KubeletPluginConfig
+
+	rootCmd = &cobra.Command{
+		Use:   "dra-driver",
+		Short: "HAMi vGPU Dynamic Resource Allocation driver",
+	}
+	controllerCmd = &cobra.Command{
+		Use:   "controller",
+		Short: "publish the vGPU slices of every node as ResourceSlices",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flag.PrintPFlags(cmd.Flags())
+			client.InitGlobalClient()
+			dra.NewController(client.GetClient()).Run(signalContext())
+			return nil
+		},
+	}
+	kubeletPluginCmd = &cobra.Command{
+		Use:   "kubelet-plugin",
+		Short: "prepare the ResourceClaims of the node for hami-core",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flag.PrintPFlags(cmd.Flags())
+			client.InitGlobalClient()
+			pluginConfig.HAMiCore.LibPath = pluginConfig.HAMiCore.HookPath + "/vgpu/libvgpu.so"
+			plugin, err := dra.NewKubeletPlugin(pluginConfig, client.GetClient())
+			if err != nil {
+				return err
+			}
+			return plugin.Run(signalContext())
+		},
+	}
+)
+
+func init() {
+	rootCmd.PersistentFlags().SortFlags = false
+	kubeletPluginCmd.Flags().SortFlags = false
+
+	hookPath, ok := os.LookupEnv("HOOK_PATH")
+	if !ok {
+		hookPath = "/usr/local/vgpu"
+	}
+	kubeletPluginCmd.Flags().StringVar(&pluginConfig.NodeName, "node-name", os.Getenv(util.NodeNameEnvName), "name of the node the plugin runs on")
+	kubeletPluginCmd.Flags().StringVar(&pluginConfig.PluginDir, "plugin-dir", "/var/lib/kubelet/plugins/"+dra.DriverName, "directory of the plugin socket and checkpoint")
+	kubeletPluginCmd.Flags().StringVar(&pluginConfig.RegistrarDir, "registrar-dir", "/var/lib/kubelet/plugins_registry", "directory the kubelet discovers plugins in")
+	kubeletPluginCmd.Flags().StringVar(&pluginConfig.CDIRoot, "cdi-root", "/var/run/cdi", "directory CDI specs of prepared claims are written to")
+	kubeletPluginCmd.Flags().StringVar(&pluginConfig.HAMiCore.HookPath, "hook-path", hookPath, "host directory hami-core is installed in")
+	kubeletPluginCmd.Flags().Float64Var(&pluginConfig.HAMiCore.MemoryScaling, "device-memory-scaling", 1, "device memory scaling of the node, as in the device plugin config")
+	kubeletPluginCmd.Flags().BoolVar(&pluginConfig.HAMiCore.DisableCoreLimit, "disable-core-limit", false, "do not limit the cores of the containers")
+	kubeletPluginCmd.Flags().StringVar((*string)(&pluginConfig.HAMiCore.LogLevel), "libcuda-log-level", "", "LIBCUDA_LOG_LEVEL of the containers")
+
+	rootCmd.AddCommand(controllerCmd, kubeletPluginCmd, version.VersionCmd)
+	rootCmd.PersistentFlags().AddGoFlagSet(util.InitKlogFlags())
+}
+
+func signalContext() context.Context {
+	ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	return ctx
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		klog.Fatal(err)
+	}
+}
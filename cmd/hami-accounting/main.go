@@ -0,0 +1,106 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+	klog "k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/accounting"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/version"
+)
+
+var (
+	dir           string
+	from          string
+	to            string
+	groupBy       string
+	output        string
+	prometheusURL string
+
+	rootCmd = &cobra.Command{
+		Use:   "hami-accounting [flags]",
+		Short: "report the GPU usage of each namespace or pod from the accounting ledger of the scheduler",
+		Long: `hami-accounting reads the ledger the scheduler keeps in --accounting-dir and
+reports the device memory and cores allocated to each namespace, or pod, over a
+period, integrated over time. The actual usage is added from the metrics of
+vGPUmonitor if a Prometheus server scraping them is given.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), cmd.OutOrStdout())
+		},
+	}
+)
+
+func init() {
+	rootCmd.Flags().SortFlags = false
+	rootCmd.Flags().StringVar(&dir, "dir", "", "accounting directory of the scheduler")
+	rootCmd.Flags().StringVar(&from, "from", "", "start of the period, RFC 3339 or YYYY-MM-DD; the start of the current month if empty")
+	rootCmd.Flags().StringVar(&to, "to", "", "end of the period, RFC 3339 or YYYY-MM-DD; now if empty")
+	rootCmd.Flags().StringVar(&groupBy, "by", accounting.GroupByNamespace, "group the usage by namespace or pod")
+	rootCmd.Flags().StringVarP(&output, "output", "o", "csv", "output format, csv or json")
+	rootCmd.Flags().StringVar(&prometheusURL, "prometheus-url", "", "URL of the Prometheus server scraping vGPUmonitor, for the actual usage")
+	rootCmd.MarkFlagRequired("dir")
+
+	rootCmd.AddCommand(version.VersionCmd)
+	rootCmd.Flags().AddGoFlagSet(util.InitKlogFlags())
+}
+
+func run(ctx context.Context, w io.Writer) error {
+	if output != "csv" && output != "json" {
+		return fmt.Errorf("unknown output format %s", output)
+	}
+	opts := accounting.ReportOptions{GroupBy: groupBy}
+	var err error
+	if from != "" {
+		if opts.From, err = accounting.ParseTime(from); err != nil {
+			return err
+		}
+	}
+	if to != "" {
+		if opts.To, err = accounting.ParseTime(to); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	if err := opts.Validate(now); err != nil {
+		return err
+	}
+	records, err := accounting.Load(dir)
+	if err != nil {
+		return fmt.Errorf("failed to load the accounting ledger: %v", err)
+	}
+	usage := accounting.Report(records, opts, now)
+	if prometheusURL != "" {
+		if err := accounting.AddActualUsage(ctx, prometheusURL, usage, opts); err != nil {
+			return err
+		}
+	}
+	return accounting.Write(w, output, usage)
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		klog.Fatal(err)
+	}
+}
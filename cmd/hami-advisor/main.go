@@ -0,0 +1,104 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	klog "k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/advisor"
+	"github.com/Project-HAMi/HAMi/pkg/device"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/version"
+)
+
+var (
+	clustersFile     string
+	deviceConfigFile string
+	httpBind         string
+	refreshInterval  time.Duration
+
+	rootCmd = &cobra.Command{
+		Use:   "hami-advisor",
+		Short: "gather the device inventories of several HAMi clusters and recommend where pods fit",
+		Long: `hami-advisor gathers the inventory of the devices of several HAMi schedulers
+from their /inventory endpoint into a global one, served on /inventory, and
+answers which clusters can fit a pod on /recommend.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run()
+		},
+	}
+)
+
+func init() {
+	rootCmd.Flags().SortFlags = false
+	rootCmd.Flags().StringVar(&clustersFile, "clusters-file", "", "path to the YAML file listing the clusters, with the URL of their scheduler and the file of its inventory token")
+	rootCmd.Flags().StringVar(&deviceConfigFile, "device-config-file", "", "path to the device config file of the clusters, the default nvidia.com resource names are used if empty")
+	rootCmd.Flags().StringVar(&httpBind, "http-bind", ":8080", "http server bind address")
+	rootCmd.Flags().DurationVar(&refreshInterval, "refresh-interval", 30*time.Second, "how often the inventories of the clusters are gathered")
+	rootCmd.Flags().StringVar(&config.NodeSchedulerPolicy, "node-scheduler-policy", util.NodeSchedulerPolicyBinpack.String(), "node scheduler policy")
+	rootCmd.Flags().StringVar(&config.GPUSchedulerPolicy, "gpu-scheduler-policy", util.GPUSchedulerPolicySpread.String(), "GPU scheduler policy")
+	rootCmd.Flags().Int32Var(&config.DefaultMem, "default-mem", 0, "default gpu device memory to allocate")
+	rootCmd.Flags().Int32Var(&config.DefaultCores, "default-cores", 0, "default gpu core percentage to allocate")
+	rootCmd.Flags().Int32Var(&config.DefaultResourceNum, "default-gpu", 1, "default gpu to allocate")
+
+	rootCmd.AddCommand(version.VersionCmd)
+	rootCmd.Flags().AddGoFlagSet(util.InitKlogFlags())
+}
+
+func run() error {
+	if clustersFile == "" {
+		return fmt.Errorf("--clusters-file is required")
+	}
+	if deviceConfigFile == "" {
+		device.InitDefaultDevices()
+	} else {
+		deviceConfig, err := device.LoadConfig(deviceConfigFile)
+		if err != nil {
+			return err
+		}
+		if err := device.InitDevicesWithConfig(deviceConfig); err != nil {
+			return err
+		}
+	}
+	clusters, err := advisor.LoadClusters(clustersFile)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %v", clustersFile, err)
+	}
+	a, err := advisor.New(clusters)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Run(ctx, refreshInterval)
+
+	klog.InfoS("Listening", "address", httpBind, "clusters", len(clusters))
+	return http.ListenAndServe(httpBind, a.Handler())
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		klog.Fatal(err)
+	}
+}
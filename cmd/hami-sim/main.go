@@ -0,0 +1,212 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	klog "k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/device"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/version"
+)
+
+var (
+	deviceConfigFile string
+	output           string
+	opts             scheduler.SimulationOptions
+
+	rootCmd = &cobra.Command{
+		Use:   "hami-sim [flags] FILE...",
+		Short: "simulate HAMi scheduling of the pending pods of a cluster snapshot",
+		Long: `hami-sim loads nodes and pods from YAML or JSON files, such as the output of
+"kubectl get nodes,pods -A -o yaml", and schedules the pending pods offline with
+the given scheduler and device config. It needs neither GPUs nor an API server.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(cmd.OutOrStdout(), args)
+		},
+	}
+)
+
+func init() {
+	rootCmd.Flags().SortFlags = false
+	rootCmd.Flags().StringVar(&deviceConfigFile, "device-config-file", "", "path to the device config file, the default nvidia.com resource names are used if empty")
+	rootCmd.Flags().StringVar(&config.NodeSchedulerPolicy, "node-scheduler-policy", util.NodeSchedulerPolicyBinpack.String(), "node scheduler policy")
+	rootCmd.Flags().StringVar(&config.GPUSchedulerPolicy, "gpu-scheduler-policy", util.GPUSchedulerPolicySpread.String(), "GPU scheduler policy")
+	rootCmd.Flags().Int32Var(&config.DefaultMem, "default-mem", 0, "default gpu device memory to allocate")
+	rootCmd.Flags().Int32Var(&config.DefaultCores, "default-cores", 0, "default gpu core percentage to allocate")
+	rootCmd.Flags().Int32Var(&config.DefaultResourceNum, "default-gpu", 1, "default gpu to allocate")
+	rootCmd.Flags().Int32Var(&opts.DeviceSplitCount, "device-split-count", 0, "simulate NVIDIA GPUs with this deviceSplitCount instead of the registered one, if not 0")
+	rootCmd.Flags().Float64Var(&opts.DeviceMemoryScaling, "device-memory-scaling", 0, "simulate NVIDIA GPUs with this deviceMemoryScaling, if not 0")
+	rootCmd.Flags().Float64Var(&opts.RegisteredMemoryScaling, "registered-memory-scaling", 1, "deviceMemoryScaling the nodes of the snapshot registered their memory with")
+	rootCmd.Flags().StringVarP(&output, "output", "o", "text", "output format, text or json")
+
+	rootCmd.AddCommand(version.VersionCmd)
+	rootCmd.Flags().AddGoFlagSet(util.InitKlogFlags())
+}
+
+func run(w io.Writer, files []string) error {
+	if output != "text" && output != "json" {
+		return fmt.Errorf("unknown output format %s", output)
+	}
+	if deviceConfigFile == "" {
+		device.InitDefaultDevices()
+	} else {
+		deviceConfig, err := device.LoadConfig(deviceConfigFile)
+		if err != nil {
+			return err
+		}
+		if err := device.InitDevicesWithConfig(deviceConfig); err != nil {
+			return err
+		}
+	}
+	var nodes []*corev1.Node
+	var pods []*corev1.Pod
+	for _, file := range files {
+		n, p, err := loadSnapshot(file)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %v", file, err)
+		}
+		nodes = append(nodes, n...)
+		pods = append(pods, p...)
+	}
+	res := scheduler.Simulate(nodes, pods, opts)
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+	printResult(w, res)
+	return nil
+}
+
+// loadSnapshot reads the nodes and pods of a YAML or JSON file holding one or
+// more documents, each an object or a List of objects. Other kinds are skipped.
+func loadSnapshot(file string) ([]*corev1.Node, []*corev1.Pod, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	var nodes []*corev1.Node
+	var pods []*corev1.Pod
+	var add func(obj runtime.Object) error
+	add = func(obj runtime.Object) error {
+		switch o := obj.(type) {
+		case *corev1.Node:
+			nodes = append(nodes, o)
+		case *corev1.Pod:
+			pods = append(pods, o)
+		case *corev1.List:
+			for _, item := range o.Items {
+				itemObj, _, err := scheme.Codecs.UniversalDeserializer().Decode(item.Raw, nil, nil)
+				if err != nil {
+					return err
+				}
+				if err := add(itemObj); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	decoder := utilyaml.NewYAMLOrJSONDecoder(f, 4096)
+	for {
+		raw := runtime.RawExtension{}
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, nil, err
+		}
+		if len(strings.TrimSpace(string(raw.Raw))) == 0 || string(raw.Raw) == "null" {
+			continue
+		}
+		obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(raw.Raw, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := add(obj); err != nil {
+			return nil, nil, err
+		}
+	}
+	return nodes, pods, nil
+}
+
+func printResult(w io.Writer, res *scheduler.SimulationResult) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "POD\tNODE\tSCORE\tDEVICES\tREASON")
+	for _, p := range res.Placements {
+		if p.Node != "" {
+			fmt.Fprintf(tw, "%s/%s\t%s\t%.2f\t%s\t\n", p.Namespace, p.Name, p.Node, p.Score, formatDevices(p.Devices))
+			continue
+		}
+		fmt.Fprintf(tw, "%s/%s\t<none>\t\t\t%s\n", p.Namespace, p.Name, p.Reason)
+		nodeIDs := make([]string, 0, len(p.FailedNodes))
+		for nodeID := range p.FailedNodes {
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+		sort.Strings(nodeIDs)
+		for _, nodeID := range nodeIDs {
+			fmt.Fprintf(tw, "\t%s\t\t\t%s\n", nodeID, p.FailedNodes[nodeID])
+		}
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "NODE\tFREE MEM\tLARGEST FREE MEM\tSTRANDED MEM\tFRAGMENTATION")
+	for _, n := range res.Nodes {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.2f\n", n.Node, n.FreeMem, n.LargestFreeMem, n.StrandedMem, n.Fragmentation)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "%d pods scheduled, %d unschedulable\n", res.Scheduled, res.Unschedulable)
+	tw.Flush()
+}
+
+// formatDevices lists the devices of a placement as UUID(memory,cores).
+func formatDevices(devices util.PodDevices) string {
+	var res []string
+	for _, ctrs := range devices {
+		for _, ctr := range ctrs {
+			for _, d := range ctr {
+				res = append(res, fmt.Sprintf("%s(%dMi,%d%%)", d.UUID, d.Usedmem, d.Usedcores))
+			}
+		}
+	}
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		klog.Fatal(err)
+	}
+}
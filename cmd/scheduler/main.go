@@ -17,9 +17,12 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/pprof"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
@@ -29,6 +32,7 @@ import (
 	"github.com/Project-HAMi/HAMi/pkg/device"
 	"github.com/Project-HAMi/HAMi/pkg/scheduler"
 	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/policy"
 	"github.com/Project-HAMi/HAMi/pkg/scheduler/routes"
 	"github.com/Project-HAMi/HAMi/pkg/util"
 	"github.com/Project-HAMi/HAMi/pkg/util/client"
@@ -69,12 +73,35 @@ func init() {
 	rootCmd.Flags().StringVar(&config.GPUSchedulerPolicy, "gpu-scheduler-policy", util.GPUSchedulerPolicySpread.String(), "GPU scheduler policy")
 	rootCmd.Flags().StringVar(&config.MetricsBindAddress, "metrics-bind-address", ":9395", "The TCP address that the scheduler should bind to for serving prometheus metrics(e.g. 127.0.0.1:9395, :9395)")
 	rootCmd.Flags().StringToStringVar(&config.NodeLabelSelector, "node-label-selector", nil, "key=value pairs separated by commas")
+	rootCmd.Flags().StringToStringVar(&config.NodeScorerWeights, "node-scorer-weights", nil, "scorer=weight pairs separated by commas, e.g. usage=10,fragmentation=5; scorers not set keep their default weight, 0 disables one")
 
 	rootCmd.Flags().Float32Var(&config.QPS, "kube-qps", client.DefaultQPS, "QPS to use while talking with kube-apiserver.")
 	rootCmd.Flags().IntVar(&config.Burst, "kube-burst", client.DefaultBurst, "Burst to use while talking with kube-apiserver.")
 	rootCmd.Flags().IntVar(&config.Timeout, "kube-timeout", client.DefaultTimeout, "Timeout to use while talking with kube-apiserver.")
 	rootCmd.Flags().BoolVar(&enableProfiling, "profiling", false, "Enable pprof profiling via HTTP server")
 	rootCmd.Flags().DurationVar(&config.NodeLockTimeout, "node-lock-timeout", time.Minute*5, "timeout for node locks")
+	rootCmd.Flags().StringVar(&config.NodeLockBackend, "node-lock-backend", nodelock.BackendAnnotation, "node lock backend, annotation or lease, must match the device plugins")
+	rootCmd.Flags().StringVar(&config.NodeLockLeaseNamespace, "node-lock-lease-namespace", nodelock.LeaseNamespace, "namespace of the node lock leases when the node lock backend is lease")
+	rootCmd.Flags().DurationVar(&config.NodeLockLeaseDuration, "node-lock-lease-duration", nodelock.LeaseDuration, "how long a node lock lease lives without being renewed, locks left by a crashed scheduler are freed after it")
+	rootCmd.Flags().DurationVar(&config.PodGroupTimeout, "pod-group-timeout", time.Minute*5, "timeout for pod groups to gather their members, and to get bound once reserved, partial reservations are released afterwards")
+	rootCmd.Flags().StringVar(&config.QuotaConfigMap, "quota-configmap", "", "namespace/name of the ConfigMap holding per-namespace device quotas, quotas are disabled if empty")
+	rootCmd.Flags().BoolVar(&config.EnablePreemption, "enable-preemption", false, "evict lower-priority pods holding devices when a pod does not fit on any node")
+	rootCmd.Flags().BoolVar(&config.PreemptionDryRun, "preemption-dry-run", false, "only record events and logs for the pods that would be preempted, without evicting them")
+	rootCmd.Flags().BoolVar(&config.LeaderElect, "leader-elect", false, "run leader election, standby replicas keep warm caches and forward filter and bind requests to the leader")
+	rootCmd.Flags().StringVar(&config.LeaderElectResourceName, "leader-elect-resource-name", "hami-scheduler-extender", "name of the leader election lease")
+	rootCmd.Flags().StringVar(&config.LeaderElectResourceNamespace, "leader-elect-resource-namespace", "kube-system", "namespace of the leader election lease")
+	rootCmd.Flags().StringVar(&config.LeaderElectAdvertiseAddress, "leader-elect-advertise-address", "", "URL standby replicas forward filter and bind requests to while this replica leads, e.g. https://$(POD_IP):443, requests are rejected if empty")
+	rootCmd.Flags().IntVar(&config.ExplainBufferSize, "explain-buffer-size", 100, "how many recent scheduling decisions are kept for /explain/{podUID}, 0 disables it")
+	rootCmd.Flags().BoolVar(&config.EnableDeviceAllocationCRD, "enable-device-allocation-crd", false, "keep the devices reserved for each pod in a DeviceAllocation, restored on startup; the CRD must be installed")
+	rootCmd.Flags().BoolVar(&config.EnableMigReconfiguration, "enable-mig-reconfiguration", false, "plan the geometries of idle MIG GPUs for the pending pods, for device plugins to partition them ahead of allocations")
+	rootCmd.Flags().StringVar(&config.PodDevicesEncoding, "pod-devices-encoding", util.PodDevicesEncodingLegacy, "encoding of the device annotations written on pods, legacy or json; only switch to json once all device plugins and monitors read it")
+	rootCmd.Flags().BoolVar(&config.WatchDeviceConfig, "watch-device-config", false, "reload the device config file when it changes, swapping in its devices and scheduler settings without a restart")
+	rootCmd.Flags().StringVar(&config.InventoryTokenFile, "inventory-token-file", "", "file holding the bearer token of /inventory, which reports the devices of the nodes and their usage to hami-advisor; it is disabled if empty")
+	rootCmd.Flags().StringVar(&config.AccountingDir, "accounting-dir", "", "directory of the ledger recording how long pods hold their devices, reported on /accounting; accounting is disabled if empty")
+	rootCmd.Flags().DurationVar(&config.AccountingRetention, "accounting-retention", 90*24*time.Hour, "how long the accounting records of released devices are kept, 0 keeps them all")
+	rootCmd.Flags().StringVar(&config.AccountingPrometheusURL, "accounting-prometheus-url", "", "URL of the Prometheus server scraping vGPUmonitor, queried for the actual usage in accounting reports if set")
+	rootCmd.Flags().IntVar(&config.FilterParallelism, "filter-parallelism", 16, "how many nodes are fitted concurrently when filtering a pod")
+	rootCmd.Flags().IntVar(&config.PercentageOfNodesToScore, "percentage-of-nodes-to-score", 100, "percentage of the nodes fitted before filtering stops once enough of them fit, as kube-scheduler's percentageOfNodesToScore; 0 adapts it to the size of the cluster, 100 fits every node")
 	rootCmd.Flags().BoolVar(&config.ForceOverwriteDefaultScheduler, "force-overwrite-default-scheduler", true, "Overwrite schedulerName in Pod Spec when set to the const DefaultSchedulerName in https://k8s.io/api/core/v1 package")
 
 	rootCmd.PersistentFlags().AddGoFlagSet(device.GlobalFlagSet())
@@ -106,6 +133,23 @@ func start() error {
 	// Initialize node lock timeout from config
 	nodelock.NodeLockTimeout = config.NodeLockTimeout
 	klog.InfoS("Set node lock timeout", "timeout", nodelock.NodeLockTimeout)
+	nodelock.LeaseNamespace = config.NodeLockLeaseNamespace
+	nodelock.LeaseDuration = config.NodeLockLeaseDuration
+	if err := nodelock.SetBackend(config.NodeLockBackend); err != nil {
+		return err
+	}
+	if err := util.ValidatePodDevicesEncoding(config.PodDevicesEncoding); err != nil {
+		return err
+	}
+	util.PodDevicesEncoding = config.PodDevicesEncoding
+	weights, err := policy.ParseScorerWeights(config.NodeScorerWeights)
+	if err != nil {
+		return err
+	}
+	if err := policy.SetScorerWeights(weights); err != nil {
+		return err
+	}
+	klog.InfoS("Set node scorer weights", "weights", policy.ScorerWeights())
 	client.InitGlobalClient(
 		client.WithBurst(config.Burst),
 		client.WithQPS(config.QPS),
@@ -113,9 +157,30 @@ func start() error {
 	)
 
 	device.InitDevices()
+	if config.WatchDeviceConfig {
+		stopWatch := make(chan struct{})
+		defer close(stopWatch)
+		if err := device.WatchConfigFile(stopWatch); err != nil {
+			return fmt.Errorf("failed to watch device config: %v", err)
+		}
+	}
 	sher = scheduler.NewScheduler()
 	sher.Start()
 	defer sher.Stop()
+	if config.LeaderElect {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			if err := sher.RunLeaderElection(ctx); err != nil {
+				klog.Fatalf("leader election failed: %v", err)
+			}
+		}()
+		if len(tlsCertFile) != 0 {
+			if err := routes.SetLeaderCertificate(tlsCertFile); err != nil {
+				return fmt.Errorf("failed to load certificate for leader forwarding: %v", err)
+			}
+		}
+	}
 
 	// start monitor metrics
 	go sher.RegisterFromNodeAnnotations()
@@ -126,7 +191,23 @@ func start() error {
 	router.POST("/filter", routes.PredicateRoute(sher))
 	router.POST("/bind", routes.Bind(sher))
 	router.POST("/webhook", routes.WebHookRoute())
+	router.POST("/validate", routes.ValidatingWebHookRoute(sher))
 	router.GET("/healthz", routes.HealthzRoute())
+	router.GET("/explain/:uid", routes.ExplainRoute(sher))
+	if len(config.InventoryTokenFile) != 0 {
+		token, err := os.ReadFile(config.InventoryTokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read inventory token: %v", err)
+		}
+		inventoryToken := strings.TrimSpace(string(token))
+		if len(inventoryToken) == 0 {
+			return fmt.Errorf("inventory token file %s is empty", config.InventoryTokenFile)
+		}
+		router.GET("/inventory", routes.InventoryRoute(sher, inventoryToken))
+	}
+	if len(config.AccountingDir) != 0 {
+		router.GET("/accounting", routes.AccountingRoute(sher))
+	}
 	klog.Info("listen on ", config.HTTPBind)
 
 	if enableProfiling {
@@ -26,6 +26,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	klog "k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/device"
 )
 
 // ClusterManager is an example for a system that might have been built without
@@ -194,8 +196,74 @@ func (cc ClusterManagerCollector) Collect(ch chan<- prometheus.Metric) {
 		"vGPU core allocated from a container",
 		[]string{"podnamespace", "nodename", "podname", "containeridx", "deviceuuid"}, nil,
 	)
+	quotaUsedDesc := prometheus.NewDesc(
+		"QuotaUsed",
+		"Device resource used by a namespace with a quota",
+		[]string{"quotanamespace", "devicevendor", "resource"}, nil,
+	)
+	quotaLimitDesc := prometheus.NewDesc(
+		"QuotaLimit",
+		"Device resource quota limit of a namespace, 0 means unlimited",
+		[]string{"quotanamespace", "devicevendor", "resource"}, nil,
+	)
+	for _, q := range sher.InspectNamespaceQuotas() {
+		for resource, val := range map[string][2]int64{
+			"memory": {q.Used.Memory * 1024 * 1024, q.Limit.Memory * 1024 * 1024},
+			"cores":  {q.Used.Cores, q.Limit.Cores},
+			"count":  {q.Used.Count, q.Limit.Count},
+		} {
+			ch <- prometheus.MustNewConstMetric(
+				quotaUsedDesc,
+				prometheus.GaugeValue,
+				float64(val[0]),
+				q.Namespace, q.DeviceVendor, resource,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				quotaLimitDesc,
+				prometheus.GaugeValue,
+				float64(val[1]),
+				q.Namespace, q.DeviceVendor, resource,
+			)
+		}
+	}
+
+	deviceConfigInfoDesc := prometheus.NewDesc(
+		"DeviceConfigInfo",
+		"Version of the active device config, a hash of its content",
+		[]string{"version"}, nil,
+	)
+	deviceConfigReloadFailuresDesc := prometheus.NewDesc(
+		"DeviceConfigReloadFailures",
+		"Number of device config reloads that were rejected",
+		nil, nil,
+	)
+	ch <- prometheus.MustNewConstMetric(deviceConfigInfoDesc, prometheus.GaugeValue, 1, device.ConfigVersion())
+	ch <- prometheus.MustNewConstMetric(deviceConfigReloadFailuresDesc, prometheus.CounterValue, float64(device.ReloadFailures()))
+
+	filterStageLatencyDesc := prometheus.NewDesc(
+		"FilterStageLatency",
+		"Latency of each stage of filtering a pod in seconds",
+		[]string{"stage"}, nil,
+	)
+	for stage, h := range sher.FilterLatency() {
+		ch <- prometheus.MustNewConstHistogram(filterStageLatencyDesc, h.Count, h.Sum, h.Buckets, stage)
+	}
+
+	podNodeScoreDesc := prometheus.NewDesc(
+		"PodNodeScore",
+		"Score of a scorer on the node a pod was assigned to, before its weight",
+		[]string{"podnamespace", "nodename", "podname", "scorer"}, nil,
+	)
 	schedpods, _ := sher.GetScheduledPods()
 	for _, val := range schedpods {
+		for scorer, score := range val.Scores {
+			ch <- prometheus.MustNewConstMetric(
+				podNodeScoreDesc,
+				prometheus.GaugeValue,
+				float64(score),
+				val.Namespace, val.NodeID, val.Name, scorer,
+			)
+		}
 		for _, podSingleDevice := range val.Devices {
 			for ctridx, ctrdevs := range podSingleDevice {
 				for _, ctrdevval := range ctrdevs {
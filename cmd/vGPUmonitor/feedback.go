@@ -17,7 +17,9 @@ limitations under the License.
 package main
 
 import (
+	"cmp"
 	"os"
+	"slices"
 	"sort"
 	"strings"
 
@@ -34,8 +36,14 @@ var cgroupDriver int
 //	mtime      uint64
 //}
 
+// UtilizationPerDevice is the number of active containers on a device by
+// priority class, 0 being the highest.
 type UtilizationPerDevice []int
 
+// fullSlice is the time slice of a container sharing no device with other
+// containers of its priority class, the whole time of its devices.
+const fullSlice = 100
+
 func setcGgroupDriver() int {
 	// 1 for cgroupfs 2 for systemd
 	kubeletconfig, err := os.ReadFile("/hostvar/lib/kubelet/config.yaml")
@@ -85,12 +93,29 @@ func getUsedGPUPid() ([]uint, nvml.Return) {
 	return result, nvml.SUCCESS
 }
 
+// priorityClass returns the priority class of a container.
+func priorityClass(c *nvidia.ContainerUsage) int {
+	return max(c.Info.GetPriority(), 0)
+}
+
+// containerDevices returns the UUIDs of the devices of a container.
+func containerDevices(c *nvidia.ContainerUsage) []string {
+	uuids := make([]string, 0)
+	for i := range c.Info.DeviceMax() {
+		if c.Info.IsValidUUID(i) {
+			uuids = append(uuids, c.Info.DeviceUUID(i))
+		}
+	}
+	return uuids
+}
+
+// Check whether task with higher priority use GPU.
 func CheckBlocking(utSwitchOn map[string]UtilizationPerDevice, p int, c *nvidia.ContainerUsage) bool {
 	for i := range c.Info.DeviceMax() {
 		uuid := c.Info.DeviceUUID(i)
 		_, ok := utSwitchOn[uuid]
 		if ok {
-			for i := range p {
+			for i := range min(p, len(utSwitchOn[uuid])) {
 				if utSwitchOn[uuid][i] > 0 {
 					return true
 				}
@@ -107,12 +132,12 @@ func CheckPriority(utSwitchOn map[string]UtilizationPerDevice, p int, c *nvidia.
 		uuid := c.Info.DeviceUUID(i)
 		_, ok := utSwitchOn[uuid]
 		if ok {
-			for i := range p {
+			for i := range min(p, len(utSwitchOn[uuid])) {
 				if utSwitchOn[uuid][i] > 0 {
 					return true
 				}
 			}
-			if utSwitchOn[uuid][p] > 1 {
+			if p < len(utSwitchOn[uuid]) && utSwitchOn[uuid][p] > 1 {
 				return true
 			}
 		}
@@ -120,33 +145,97 @@ func CheckPriority(utSwitchOn map[string]UtilizationPerDevice, p int, c *nvidia.
 	return false
 }
 
+// TimeSlices arbitrates the time of the devices between the active containers
+// on them, returning the percentage of the time of its devices each container
+// gets, by key. On each device, the containers of the highest priority class
+// active on it share the time by weight, the others being blocked. Percents
+// left by rounding go to the largest remainders, then in key order. A
+// container gets its smallest slice over its devices, and fullSlice if it
+// shares none of them.
+func TimeSlices(utSwitchOn map[string]UtilizationPerDevice, containers map[string]*nvidia.ContainerUsage, active map[string]bool) map[string]int32 {
+	keys := make([]string, 0, len(active))
+	for key := range containers {
+		if active[key] {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	contenders := map[string][]string{}
+	for _, key := range keys {
+		c := containers[key]
+		p := priorityClass(c)
+		for _, uuid := range containerDevices(c) {
+			if slices.IndexFunc(utSwitchOn[uuid], func(n int) bool { return n > 0 }) == p {
+				contenders[uuid] = append(contenders[uuid], key)
+			}
+		}
+	}
+
+	result := map[string]int32{}
+	for _, key := range keys {
+		result[key] = fullSlice
+	}
+	for _, sharing := range contenders {
+		if len(sharing) < 2 {
+			continue
+		}
+		total := 0
+		for _, key := range sharing {
+			total += max(containers[key].Weight, 1)
+		}
+		slice := make(map[string]int, len(sharing))
+		remainder := make(map[string]int, len(sharing))
+		left := fullSlice
+		for _, key := range sharing {
+			weight := max(containers[key].Weight, 1)
+			slice[key] = fullSlice * weight / total
+			remainder[key] = fullSlice * weight % total
+			left -= slice[key]
+		}
+		order := slices.Clone(sharing)
+		slices.SortStableFunc(order, func(a, b string) int {
+			return cmp.Compare(remainder[b], remainder[a])
+		})
+		for _, key := range order[:left] {
+			slice[key]++
+		}
+		for _, key := range sharing {
+			result[key] = min(result[key], int32(max(slice[key], 1)))
+		}
+	}
+	return result
+}
+
 func Observe(lister *nvidia.ContainerLister) {
+	observeContainers(lister.ListContainers())
+}
+
+// observeContainers sets the blocking, utilization switch and time slice of
+// the containers from their recent kernels.
+func observeContainers(containers map[string]*nvidia.ContainerUsage) {
 	utSwitchOn := map[string]UtilizationPerDevice{}
-	containers := lister.ListContainers()
+	active := map[string]bool{}
 
-	for _, c := range containers {
+	for key, c := range containers {
 		recentKernel := c.Info.GetRecentKernel()
 		if recentKernel > 0 {
 			recentKernel--
 			if recentKernel > 0 {
-				for i := range c.Info.DeviceMax() {
-					//for _, devuuid := range val.sr.uuids {
-					// Null device condition
-					if !c.Info.IsValidUUID(i) {
-						continue
-					}
-					uuid := c.Info.DeviceUUID(i)
-					if len(utSwitchOn[uuid]) == 0 {
-						utSwitchOn[uuid] = []int{0, 0}
+				active[key] = true
+				p := priorityClass(c)
+				for _, uuid := range containerDevices(c) {
+					if len(utSwitchOn[uuid]) <= p {
+						utSwitchOn[uuid] = append(utSwitchOn[uuid], make(UtilizationPerDevice, p+1-len(utSwitchOn[uuid]))...)
 					}
-					utSwitchOn[uuid][c.Info.GetPriority()]++
+					utSwitchOn[uuid][p]++
 				}
 			}
 			c.Info.SetRecentKernel(recentKernel)
 		}
 	}
+	timeSlices := TimeSlices(utSwitchOn, containers, active)
 	for idx, c := range containers {
-		priority := c.Info.GetPriority()
+		priority := priorityClass(c)
 		recentKernel := c.Info.GetRecentKernel()
 		utilizationSwitch := c.Info.GetUtilizationSwitch()
 		if CheckBlocking(utSwitchOn, priority, c) {
@@ -175,5 +264,10 @@ func Observe(lister *nvidia.ContainerLister) {
 				c.Info.SetUtilizationSwitch(0)
 			}
 		}
+		// Idle containers keep the slice they were given until they are active again.
+		if timeSlice, ok := timeSlices[idx]; ok && c.Info.GetTimeSlice() != timeSlice {
+			klog.V(5).Infof("Setting TimeSlice of %v to %d", idx, timeSlice)
+			c.Info.SetTimeSlice(timeSlice)
+		}
 	}
 }
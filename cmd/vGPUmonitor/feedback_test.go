@@ -0,0 +1,177 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/Project-HAMi/HAMi/pkg/monitor/nvidia"
+)
+
+// fakeUsage is the shared region of a container using uuids.
+type fakeUsage struct {
+	uuids             []string
+	priority          int
+	recentKernel      int32
+	utilizationSwitch int32
+	timeSlice         int32
+}
+
+func (f *fakeUsage) DeviceMax() int                         { return 16 }
+func (f *fakeUsage) DeviceNum() int                         { return len(f.uuids) }
+func (f *fakeUsage) DeviceMemoryContextSize(idx int) uint64 { return 0 }
+func (f *fakeUsage) DeviceMemoryModuleSize(idx int) uint64  { return 0 }
+func (f *fakeUsage) DeviceMemoryBufferSize(idx int) uint64  { return 0 }
+func (f *fakeUsage) DeviceMemoryOffset(idx int) uint64      { return 0 }
+func (f *fakeUsage) DeviceMemoryTotal(idx int) uint64       { return 0 }
+func (f *fakeUsage) DeviceSmUtil(idx int) uint64            { return 0 }
+func (f *fakeUsage) SetDeviceSmLimit(l uint64)              {}
+func (f *fakeUsage) IsValidUUID(idx int) bool               { return idx < len(f.uuids) }
+func (f *fakeUsage) DeviceMemoryLimit(idx int) uint64       { return 0 }
+func (f *fakeUsage) SetDeviceMemoryLimit(l uint64)          {}
+func (f *fakeUsage) LastKernelTime() int64                  { return 0 }
+func (f *fakeUsage) GetPriority() int                       { return f.priority }
+func (f *fakeUsage) GetRecentKernel() int32                 { return f.recentKernel }
+func (f *fakeUsage) SetRecentKernel(v int32)                { f.recentKernel = v }
+func (f *fakeUsage) GetUtilizationSwitch() int32            { return f.utilizationSwitch }
+func (f *fakeUsage) SetUtilizationSwitch(v int32)           { f.utilizationSwitch = v }
+func (f *fakeUsage) GetTimeSlice() int32                    { return f.timeSlice }
+func (f *fakeUsage) SetTimeSlice(v int32)                   { f.timeSlice = v }
+
+func (f *fakeUsage) DeviceUUID(idx int) string {
+	if idx < len(f.uuids) {
+		return f.uuids[idx]
+	}
+	return ""
+}
+
+// fakeContainer is an active container, unless recentKernel is set to 0.
+func fakeContainer(priority, weight int, uuids ...string) *nvidia.ContainerUsage {
+	return &nvidia.ContainerUsage{
+		Weight: weight,
+		Info:   &fakeUsage{uuids: uuids, priority: priority, recentKernel: 2},
+	}
+}
+
+func Test_observeContainers(t *testing.T) {
+	tests := []struct {
+		name          string
+		containers    map[string]*nvidia.ContainerUsage
+		wantTimeSlice map[string]int32
+		wantBlocked   []string
+	}{
+		{
+			name: "alone on its device",
+			containers: map[string]*nvidia.ContainerUsage{
+				"a": fakeContainer(0, 1, "GPU-0"),
+				"b": fakeContainer(0, 1, "GPU-1"),
+			},
+			wantTimeSlice: map[string]int32{"a": 100, "b": 100},
+		},
+		{
+			name: "shared by weight",
+			containers: map[string]*nvidia.ContainerUsage{
+				"a": fakeContainer(1, 3, "GPU-0"),
+				"b": fakeContainer(1, 1, "GPU-0"),
+			},
+			wantTimeSlice: map[string]int32{"a": 75, "b": 25},
+		},
+		{
+			name: "rounding left to the largest remainders in key order",
+			containers: map[string]*nvidia.ContainerUsage{
+				"a": fakeContainer(0, 1, "GPU-0"),
+				"b": fakeContainer(0, 1, "GPU-0"),
+				"c": fakeContainer(0, 1, "GPU-0"),
+			},
+			wantTimeSlice: map[string]int32{"a": 34, "b": 33, "c": 33},
+		},
+		{
+			name: "lower classes blocked",
+			containers: map[string]*nvidia.ContainerUsage{
+				"a": fakeContainer(1, 1, "GPU-0"),
+				"b": fakeContainer(1, 1, "GPU-0"),
+				"c": fakeContainer(2, 5, "GPU-0"),
+				"d": fakeContainer(3, 1, "GPU-0"),
+			},
+			wantTimeSlice: map[string]int32{"a": 50, "b": 50, "c": 100, "d": 100},
+			wantBlocked:   []string{"c", "d"},
+		},
+		{
+			name: "smallest slice over the devices",
+			containers: map[string]*nvidia.ContainerUsage{
+				"a": fakeContainer(0, 1, "GPU-0", "GPU-1"),
+				"b": fakeContainer(0, 1, "GPU-0"),
+				"c": fakeContainer(0, 3, "GPU-1"),
+			},
+			wantTimeSlice: map[string]int32{"a": 25, "b": 50, "c": 75},
+		},
+		{
+			name: "idle containers do not share",
+			containers: map[string]*nvidia.ContainerUsage{
+				"a": fakeContainer(0, 1, "GPU-0"),
+				"b": {Weight: 1, Info: &fakeUsage{uuids: []string{"GPU-0"}}},
+			},
+			wantTimeSlice: map[string]int32{"a": 100, "b": 0},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			observeContainers(test.containers)
+			timeSlices := map[string]int32{}
+			blocked := []string{}
+			for _, key := range []string{"a", "b", "c", "d"} {
+				c, ok := test.containers[key]
+				if !ok {
+					continue
+				}
+				timeSlices[key] = c.Info.GetTimeSlice()
+				if c.Info.GetRecentKernel() < 0 {
+					blocked = append(blocked, key)
+				}
+			}
+			assert.DeepEqual(t, timeSlices, test.wantTimeSlice)
+			if test.wantBlocked == nil {
+				test.wantBlocked = []string{}
+			}
+			assert.DeepEqual(t, blocked, test.wantBlocked)
+		})
+	}
+}
+
+func Test_TimeSlices_deterministic(t *testing.T) {
+	containers := map[string]*nvidia.ContainerUsage{}
+	active := map[string]bool{}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		containers[key] = fakeContainer(0, 1, "GPU-0")
+		active[key] = true
+	}
+	utSwitchOn := map[string]UtilizationPerDevice{"GPU-0": {7}}
+	want := TimeSlices(utSwitchOn, containers, active)
+	assert.DeepEqual(t, want, map[string]int32{"a": 15, "b": 15, "c": 14, "d": 14, "e": 14, "f": 14, "g": 14})
+	for range 10 {
+		assert.DeepEqual(t, TimeSlices(utSwitchOn, containers, active), want)
+	}
+}
+
+func Test_CheckPriority(t *testing.T) {
+	utSwitchOn := map[string]UtilizationPerDevice{"GPU-0": {0, 1}}
+	assert.Assert(t, !CheckPriority(utSwitchOn, 1, fakeContainer(1, 1, "GPU-0")))
+	assert.Assert(t, CheckPriority(utSwitchOn, 2, fakeContainer(2, 1, "GPU-0")), "a higher class is active")
+	assert.Assert(t, !CheckPriority(utSwitchOn, 0, fakeContainer(0, 1, "GPU-0")))
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accounting
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+)
+
+// actualStep is the resolution the vGPUmonitor metrics are integrated with.
+const actualStep = time.Minute
+
+const (
+	// actualMemoryQuery sums the device memory used by the containers of each
+	// pod, in bytes, sampled every step over the period.
+	actualMemoryQuery = `sum by (podnamespace, podname) (sum_over_time(vGPU_device_memory_usage_in_bytes[%ds:%ds]))`
+	// actualCoreQuery sums the SM utilization of the containers of each pod,
+	// in percent, sampled every step over the period.
+	actualCoreQuery = `sum by (podnamespace, podname) (sum_over_time(Device_utilization_desc_of_container[%ds:%ds]))`
+)
+
+// AddActualUsage fills the memory and cores the NVIDIA rows of a report used,
+// integrating the metrics of vGPUmonitor over the period of the report, from
+// the Prometheus server scraping them at url.
+func AddActualUsage(ctx context.Context, url string, usage []Usage, opts ReportOptions) error {
+	c, err := api.NewClient(api.Config{Address: url})
+	if err != nil {
+		return err
+	}
+	prom := promv1.NewAPI(c)
+	period := int64(opts.To.Sub(opts.From).Seconds())
+	step := int64(actualStep.Seconds())
+	memory, err := queryActual(ctx, prom, fmt.Sprintf(actualMemoryQuery, period, step), opts)
+	if err != nil {
+		return fmt.Errorf("query actual memory usage: %v", err)
+	}
+	cores, err := queryActual(ctx, prom, fmt.Sprintf(actualCoreQuery, period, step), opts)
+	if err != nil {
+		return fmt.Errorf("query actual core usage: %v", err)
+	}
+	for i := range usage {
+		if usage[i].Type != nvidia.NvidiaGPUDevice {
+			continue
+		}
+		k := usageKey(usage[i].Namespace, usage[i].Pod)
+		mem := memory[k] * float64(step) / (1024 * 1024)
+		core := cores[k] * float64(step) / 100
+		usage[i].ActualMemorySeconds = &mem
+		usage[i].ActualCoreSeconds = &core
+	}
+	return nil
+}
+
+func usageKey(namespace, pod string) string {
+	return namespace + "/" + pod
+}
+
+// queryActual runs a query at the end of the period and sums its samples by
+// namespace, or by pod, as the report is grouped.
+func queryActual(ctx context.Context, prom promv1.API, query string, opts ReportOptions) (map[string]float64, error) {
+	value, warnings, err := prom.Query(ctx, query, opts.To)
+	if err != nil {
+		return nil, err
+	}
+	if len(warnings) > 0 {
+		klog.InfoS("Prometheus query returned warnings", "query", query, "warnings", warnings)
+	}
+	vector, ok := value.(model.Vector)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type %s", value.Type())
+	}
+	sums := make(map[string]float64)
+	for _, sample := range vector {
+		pod := ""
+		if opts.GroupBy == GroupByPod {
+			pod = string(sample.Metric["podname"])
+		}
+		sums[usageKey(string(sample.Metric["podnamespace"]), pod)] += float64(sample.Value)
+	}
+	return sums, nil
+}
@@ -0,0 +1,369 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package accounting records how long pods hold their devices, to report the
+// memory and cores allocated to each namespace over a period.
+package accounting
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+const (
+	// journalFile is the file of the ledger in its directory.
+	journalFile = "journal.jsonl"
+	// CheckpointInterval is how often a running ledger records that it is
+	// alive, which bounds the time allocations are charged for after the
+	// scheduler stopped.
+	CheckpointInterval = time.Minute
+	// CompactInterval is how often a running ledger compacts its journal and
+	// drops the records past the retention.
+	CompactInterval = time.Hour
+)
+
+// Record is the devices of one vendor a pod held during a period. End is zero
+// while the pod holds them.
+type Record struct {
+	UID       string `json:"uid"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Node      string `json:"node"`
+	// Type is the device vendor, e.g. NVIDIA.
+	Type    string `json:"type"`
+	Devices int    `json:"devices"`
+	// Memory is the device memory allocated, in MiB.
+	Memory int64 `json:"memory"`
+	// Cores is the sum of the core percentages allocated on each device.
+	Cores int64     `json:"cores"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end,omitzero"`
+}
+
+// entry is a line of the journal. The allocations of UID are closed at Time
+// if Closed, then Open are opened. Records are closed allocations kept by a
+// compaction, and an entry with only a Time is a checkpoint.
+type entry struct {
+	Time    time.Time `json:"time"`
+	UID     string    `json:"uid,omitempty"`
+	Closed  bool      `json:"closed,omitempty"`
+	Open    []Record  `json:"open,omitempty"`
+	Records []Record  `json:"records,omitempty"`
+}
+
+// journal is the state replayed from a journal.
+type journal struct {
+	closed []Record
+	open   map[string][]Record
+	// lastSeen is the time of the last entry, when the ledger was last known to run.
+	lastSeen time.Time
+}
+
+func readJournal(r io.Reader) (*journal, error) {
+	j := &journal{open: make(map[string][]Record)}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		e := entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A line cut by a crash can only be the last one.
+			klog.ErrorS(err, "Skipping invalid accounting journal entry", "line", line)
+			continue
+		}
+		j.closed = append(j.closed, e.Records...)
+		if e.Closed {
+			j.closed = append(j.closed, closeRecords(j.open[e.UID], e.Time)...)
+			delete(j.open, e.UID)
+		}
+		if len(e.Open) > 0 {
+			j.open[e.UID] = e.Open
+		}
+		if e.Time.After(j.lastSeen) {
+			j.lastSeen = e.Time
+		}
+	}
+	return j, scanner.Err()
+}
+
+func closeRecords(records []Record, end time.Time) []Record {
+	closed := make([]Record, 0, len(records))
+	for _, r := range records {
+		r.End = end
+		closed = append(closed, r)
+	}
+	return closed
+}
+
+// records returns the closed and open records, sorted by start.
+func (j *journal) records() []Record {
+	records := slices.Clone(j.closed)
+	for _, uid := range slices.Sorted(maps.Keys(j.open)) {
+		records = append(records, j.open[uid]...)
+	}
+	slices.SortStableFunc(records, func(a, b Record) int { return a.Start.Compare(b.Start) })
+	return records
+}
+
+// Load reads the records of the ledger in dir without opening it, so it may
+// be read while a scheduler writes it.
+func Load(dir string) ([]Record, error) {
+	f, err := os.Open(filepath.Join(dir, journalFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	j, err := readJournal(f)
+	if err != nil {
+		return nil, err
+	}
+	return j.records(), nil
+}
+
+// Ledger keeps the allocations of the pods in a journal file, appended to on
+// every change and compacted when opened and by Compact.
+type Ledger struct {
+	mutex     sync.Mutex
+	dir       string
+	retention time.Duration
+	file      *os.File
+	journal   *journal
+	// restored is the UIDs whose allocations were read from the journal and
+	// not started again since.
+	restored map[string]bool
+	now      func() time.Time
+}
+
+// Open opens the ledger in dir, creating it if needed. Closed records that
+// ended longer than retention ago are dropped, none if retention is 0.
+func Open(dir string, retention time.Duration) (*Ledger, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := &Ledger{
+		dir:       dir,
+		retention: retention,
+		journal:   &journal{open: make(map[string][]Record)},
+		restored:  make(map[string]bool),
+		now:       time.Now,
+	}
+	path := filepath.Join(dir, journalFile)
+	if f, err := os.Open(path); err == nil {
+		l.journal, err = readJournal(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read accounting journal: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	for uid := range l.journal.open {
+		l.restored[uid] = true
+	}
+	if err := l.compact(); err != nil {
+		return nil, fmt.Errorf("compact accounting journal: %v", err)
+	}
+	klog.InfoS("Accounting ledger opened", "dir", dir, "records", len(l.journal.closed), "open", len(l.journal.open))
+	return l, nil
+}
+
+// compact rewrites the journal with one entry of the closed records and one
+// per pod holding devices, dropping the records past the retention.
+func (l *Ledger) compact() error {
+	if l.retention > 0 {
+		cutoff := l.now().Add(-l.retention)
+		l.journal.closed = slices.DeleteFunc(l.journal.closed, func(r Record) bool { return r.End.Before(cutoff) })
+	}
+	tmp, err := os.CreateTemp(l.dir, journalFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	enc := json.NewEncoder(tmp)
+	err = enc.Encode(&entry{Time: l.journal.lastSeen, Records: l.journal.closed})
+	for _, uid := range slices.Sorted(maps.Keys(l.journal.open)) {
+		if err != nil {
+			break
+		}
+		err = enc.Encode(&entry{Time: l.journal.open[uid][0].Start, UID: uid, Open: l.journal.open[uid]})
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(l.dir, journalFile)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file = file
+	return nil
+}
+
+// Compact rewrites the journal and drops the records past the retention, so
+// that neither grows while the ledger runs.
+func (l *Ledger) Compact() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	before := len(l.journal.closed)
+	if err := l.compact(); err != nil {
+		return err
+	}
+	klog.V(4).InfoS("Accounting ledger compacted", "records", len(l.journal.closed), "dropped", before-len(l.journal.closed), "open", len(l.journal.open))
+	return nil
+}
+
+// append writes an entry to the journal and applies it.
+func (l *Ledger) append(e entry) {
+	line, err := json.Marshal(&e)
+	if err == nil {
+		_, err = l.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		klog.ErrorS(err, "Failed to write accounting journal entry", "uid", e.UID)
+	}
+	if e.Closed {
+		l.journal.closed = append(l.journal.closed, closeRecords(l.journal.open[e.UID], e.Time)...)
+		delete(l.journal.open, e.UID)
+	}
+	if len(e.Open) > 0 {
+		l.journal.open[e.UID] = e.Open
+	}
+	l.journal.lastSeen = e.Time
+}
+
+// allocationRecords returns a record per vendor of the devices of a pod.
+func allocationRecords(uid, namespace, name, node string, devices util.PodDevices, start time.Time) []Record {
+	records := make([]Record, 0, len(devices))
+	for _, vendor := range slices.Sorted(maps.Keys(devices)) {
+		r := Record{UID: uid, Namespace: namespace, Pod: name, Node: node, Type: vendor, Start: start}
+		for _, ctr := range devices[vendor] {
+			for _, dev := range ctr {
+				r.Devices++
+				r.Memory += int64(dev.Usedmem)
+				r.Cores += int64(dev.Usedcores)
+			}
+		}
+		if r.Devices > 0 {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+func sameAllocation(a, b []Record) bool {
+	return slices.EqualFunc(a, b, func(x, y Record) bool {
+		return x.Node == y.Node && x.Type == y.Type && x.Devices == y.Devices && x.Memory == y.Memory && x.Cores == y.Cores
+	})
+}
+
+// Start records that a pod holds devices from now on. The allocation is kept
+// if the pod already holds the same devices, and closed first otherwise.
+func (l *Ledger) Start(uid, namespace, name, node string, devices util.PodDevices) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.restored, uid)
+	now := l.now()
+	records := allocationRecords(uid, namespace, name, node, devices, now)
+	open, ok := l.journal.open[uid]
+	if ok && sameAllocation(open, records) {
+		return
+	}
+	if !ok && len(records) == 0 {
+		return
+	}
+	l.append(entry{Time: now, UID: uid, Closed: ok, Open: records})
+}
+
+// Stop records that a pod released its devices.
+func (l *Ledger) Stop(uid string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.restored, uid)
+	if _, ok := l.journal.open[uid]; !ok {
+		return
+	}
+	l.append(entry{Time: l.now(), UID: uid, Closed: true})
+}
+
+// Sweep closes the allocations read from the journal of the pods that are not
+// live anymore, which were deleted while the scheduler was not running. They
+// are closed at the last time the previous scheduler was known to run.
+func (l *Ledger) Sweep(live func(uid string) bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, uid := range slices.Sorted(maps.Keys(l.restored)) {
+		delete(l.restored, uid)
+		if live(uid) {
+			continue
+		}
+		klog.InfoS("Closing allocation of a pod deleted while stopped", "uid", uid, "end", l.journal.lastSeen)
+		l.append(entry{Time: l.journal.lastSeen, UID: uid, Closed: true})
+	}
+}
+
+// Checkpoint records that the ledger is alive.
+func (l *Ledger) Checkpoint() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.append(entry{Time: l.now()})
+}
+
+// Records returns the closed and open records, sorted by start.
+func (l *Ledger) Records() []Record {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.journal.records()
+}
+
+// Close closes the journal file.
+func (l *Ledger) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.file.Close()
+}
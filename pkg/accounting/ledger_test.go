@@ -0,0 +1,239 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+var t0 = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+// testClock is a clock the test moves forward.
+type testClock struct{ now time.Time }
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func (c *testClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func openTestLedger(t *testing.T, dir string, clock *testClock) *Ledger {
+	l, err := Open(dir, 0)
+	require.NoError(t, err)
+	l.now = clock.Now
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func gpus(mems ...int32) util.PodDevices {
+	ctr := util.ContainerDevices{}
+	for _, mem := range mems {
+		ctr = append(ctr, util.ContainerDevice{UUID: "gpu", Type: nvidia.NvidiaGPUDevice, Usedmem: mem, Usedcores: 50})
+	}
+	return util.PodDevices{nvidia.NvidiaGPUDevice: util.PodSingleDevice{ctr}}
+}
+
+func Test_Ledger(t *testing.T) {
+	dir := t.TempDir()
+	clock := &testClock{now: t0}
+	l := openTestLedger(t, dir, clock)
+
+	l.Start("uid1", "team-a", "train", "node1", gpus(1000, 1000))
+	clock.advance(time.Hour)
+	l.Start("uid1", "team-a", "train", "node1", gpus(1000, 1000))
+	l.Start("uid2", "team-b", "infer", "node1", gpus(4000))
+	l.Start("uid3", "team-b", "cpu-only", "node1", util.PodDevices{})
+	clock.advance(time.Hour)
+	l.Start("uid2", "team-b", "infer", "node1", gpus(2000))
+	l.Stop("uid1")
+
+	records := l.Records()
+	require.Len(t, records, 3)
+	assert.Equal(t, Record{UID: "uid1", Namespace: "team-a", Pod: "train", Node: "node1", Type: nvidia.NvidiaGPUDevice,
+		Devices: 2, Memory: 2000, Cores: 100, Start: t0, End: t0.Add(2 * time.Hour)}, records[0])
+	assert.Equal(t, t0.Add(2*time.Hour), records[1].End, "an allocation is closed when the devices change")
+	assert.Equal(t, int64(2000), records[2].Memory)
+	assert.True(t, records[2].End.IsZero())
+
+	// The scheduler restarts an hour later, uid2 was deleted in the meantime.
+	clock.advance(time.Minute)
+	l.Checkpoint()
+	require.NoError(t, l.Close())
+	clock.advance(time.Hour)
+	reopened := openTestLedger(t, dir, clock)
+	assert.Equal(t, records, reopened.Records())
+	reopened.Sweep(func(uid string) bool { return false })
+	records = reopened.Records()
+	assert.Equal(t, t0.Add(2*time.Hour+time.Minute), records[2].End, "allocations of deleted pods end at the last checkpoint")
+
+	loaded, err := Load(dir)
+	require.NoError(t, err)
+	assert.Equal(t, records, loaded)
+}
+
+func Test_Ledger_restart(t *testing.T) {
+	dir := t.TempDir()
+	clock := &testClock{now: t0}
+	l := openTestLedger(t, dir, clock)
+	l.Start("uid1", "team-a", "train", "node1", gpus(1000))
+	require.NoError(t, l.Close())
+
+	// The pod is added again from the informer, then the ledger is swept.
+	clock.advance(time.Hour)
+	reopened := openTestLedger(t, dir, clock)
+	reopened.Start("uid1", "team-a", "train", "node1", gpus(1000))
+	reopened.Sweep(func(uid string) bool { return false })
+	records := reopened.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, t0, records[0].Start, "the allocation of a pod still running is continued")
+	assert.True(t, records[0].End.IsZero())
+}
+
+func Test_Ledger_retention(t *testing.T) {
+	dir := t.TempDir()
+	// Retention is applied with the time the ledger is opened at.
+	clock := &testClock{now: time.Now().Add(-72 * time.Hour)}
+	l := openTestLedger(t, dir, clock)
+	l.Start("old", "team-a", "old", "node1", gpus(1000))
+	l.Stop("old")
+	clock.advance(48 * time.Hour)
+	l.Start("new", "team-a", "new", "node1", gpus(1000))
+	l.Stop("new")
+	require.NoError(t, l.Close())
+
+	reopened, err := Open(dir, 36*time.Hour)
+	require.NoError(t, err)
+	defer reopened.Close()
+	records := reopened.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, "new", records[0].UID)
+}
+
+func Test_Ledger_Compact(t *testing.T) {
+	dir := t.TempDir()
+	clock := &testClock{now: time.Now()}
+	l, err := Open(dir, 36*time.Hour)
+	require.NoError(t, err)
+	l.now = clock.Now
+	t.Cleanup(func() { l.Close() })
+	l.Start("old", "team-a", "old", "node1", gpus(1000))
+	l.Stop("old")
+	l.Start("running", "team-a", "running", "node1", gpus(1000))
+	for range 100 {
+		l.Checkpoint()
+	}
+	clock.advance(48 * time.Hour)
+	l.Start("new", "team-a", "new", "node1", gpus(1000))
+	l.Stop("new")
+
+	require.NoError(t, l.Compact())
+	records := l.Records()
+	require.Len(t, records, 2, "the records past the retention are dropped while the ledger runs")
+	assert.Equal(t, "running", records[0].UID)
+	assert.Equal(t, "new", records[1].UID)
+	data, err := os.ReadFile(filepath.Join(dir, journalFile))
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"), "the journal holds the closed records and the open allocation")
+
+	// The ledger keeps appending to the compacted journal.
+	l.Stop("running")
+	loaded, err := Load(dir)
+	require.NoError(t, err)
+	require.Len(t, loaded, 2)
+	assert.False(t, loaded[0].End.IsZero())
+}
+
+func Test_Load_truncated(t *testing.T) {
+	dir := t.TempDir()
+	journal := `{"time":"2024-05-01T00:00:00Z","uid":"uid1","open":[{"uid":"uid1","namespace":"team-a","pod":"train","node":"node1","type":"NVIDIA","devices":1,"memory":1000,"cores":0,"start":"2024-05-01T00:00:00Z"}]}
+{"time":"2024-05-01T01:00:00Z","uid":"uid1","clo`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, journalFile), []byte(journal), 0644))
+	records, err := Load(dir)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.True(t, records[0].End.IsZero(), "a line cut by a crash is skipped")
+}
+
+func Test_Report(t *testing.T) {
+	records := []Record{
+		{Namespace: "team-a", Pod: "train", Type: nvidia.NvidiaGPUDevice, Devices: 2, Memory: 2000, Cores: 100, Start: t0, End: t0.Add(2 * time.Hour)},
+		{Namespace: "team-a", Pod: "eval", Type: nvidia.NvidiaGPUDevice, Devices: 1, Memory: 1000, Cores: 0, Start: t0.Add(time.Hour)},
+		{Namespace: "team-b", Pod: "old", Type: nvidia.NvidiaGPUDevice, Devices: 1, Memory: 1000, Start: t0.Add(-2 * time.Hour), End: t0},
+	}
+	opts := ReportOptions{From: t0, To: t0.Add(3 * time.Hour)}
+	require.NoError(t, opts.Validate(t0.Add(4*time.Hour)))
+
+	usage := Report(records, opts, t0.Add(4*time.Hour))
+	assert.Equal(t, []Usage{{Namespace: "team-a", Type: nvidia.NvidiaGPUDevice, DeviceSeconds: 4*3600 + 2*3600, MemorySeconds: 2000*7200 + 1000*7200, CoreSeconds: 7200}}, usage)
+
+	opts.GroupBy = GroupByPod
+	usage = Report(records, opts, t0.Add(150*time.Minute))
+	require.Len(t, usage, 2)
+	assert.Equal(t, "eval", usage[0].Pod)
+	assert.Equal(t, float64(1000*5400), usage[0].MemorySeconds, "open records are integrated up to now")
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Write(buf, "csv", usage))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, "namespace,pod,type,device_seconds,memory_mib_seconds,core_seconds,actual_memory_mib_seconds,actual_core_seconds", lines[0])
+	assert.Equal(t, "team-a,eval,NVIDIA,5400.00,5400000.00,0.00,,", lines[1])
+
+	assert.ErrorContains(t, (&ReportOptions{From: t0, To: t0}).Validate(t0), "report period is empty")
+	assert.ErrorContains(t, (&ReportOptions{GroupBy: "node"}).Validate(t0.Add(time.Hour)), "unknown grouping")
+}
+
+func Test_AddActualUsage(t *testing.T) {
+	var queries []string
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		queries = append(queries, r.Form.Get("query"))
+		value := "1048576"
+		if strings.Contains(r.Form.Get("query"), "utilization") {
+			value = "50"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[
+			{"metric":{"podnamespace":"team-a","podname":"train"},"value":[1714521600,"` + value + `"]},
+			{"metric":{"podnamespace":"team-a","podname":"eval"},"value":[1714521600,"` + value + `"]}]}}`))
+	}))
+	defer prom.Close()
+
+	usage := []Usage{
+		{Namespace: "team-a", Type: nvidia.NvidiaGPUDevice},
+		{Namespace: "team-a", Type: "OTHER"},
+		{Namespace: "team-b", Type: nvidia.NvidiaGPUDevice},
+	}
+	opts := ReportOptions{From: t0, To: t0.Add(time.Hour), GroupBy: GroupByNamespace}
+	require.NoError(t, AddActualUsage(context.Background(), prom.URL, usage, opts))
+	assert.Contains(t, queries[0], "vGPU_device_memory_usage_in_bytes[3600s:60s]")
+	assert.Equal(t, float64(2*60), *usage[0].ActualMemorySeconds)
+	assert.Equal(t, float64(60), *usage[0].ActualCoreSeconds)
+	assert.Nil(t, usage[1].ActualMemorySeconds, "vGPUmonitor only reports NVIDIA devices")
+	assert.Equal(t, float64(0), *usage[2].ActualMemorySeconds)
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accounting
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	GroupByNamespace = "namespace"
+	GroupByPod       = "pod"
+)
+
+// ReportOptions selects the period of a report and how it is grouped.
+type ReportOptions struct {
+	From time.Time
+	To   time.Time
+	// GroupBy is GroupByNamespace or GroupByPod.
+	GroupBy string
+}
+
+// Usage is the devices of one vendor a namespace, or a pod, was allocated
+// during the period of a report, integrated over time.
+type Usage struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod,omitempty"`
+	Type      string `json:"type"`
+	// DeviceSeconds is the number of devices allocated times the seconds they were.
+	DeviceSeconds float64 `json:"deviceSeconds"`
+	// MemorySeconds is the device memory allocated, in MiB, times the seconds it was.
+	MemorySeconds float64 `json:"memorySeconds"`
+	// CoreSeconds is the cores allocated, a whole device counting as one,
+	// times the seconds they were.
+	CoreSeconds float64 `json:"coreSeconds"`
+	// ActualMemorySeconds and ActualCoreSeconds are the memory and cores
+	// used, as reported by vGPUmonitor, if queried.
+	ActualMemorySeconds *float64 `json:"actualMemorySeconds,omitempty"`
+	ActualCoreSeconds   *float64 `json:"actualCoreSeconds,omitempty"`
+}
+
+// ParseTime parses a report bound, an RFC 3339 time or a date.
+func ParseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or YYYY-MM-DD", value)
+	}
+	return t, nil
+}
+
+// Validate checks the options, and defaults the period to the current month
+// up to now and the grouping to namespaces.
+func (o *ReportOptions) Validate(now time.Time) error {
+	if o.From.IsZero() {
+		o.From = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	}
+	if o.To.IsZero() {
+		o.To = now
+	}
+	if !o.From.Before(o.To) {
+		return fmt.Errorf("report period is empty, from %s to %s", o.From.Format(time.RFC3339), o.To.Format(time.RFC3339))
+	}
+	switch o.GroupBy {
+	case "":
+		o.GroupBy = GroupByNamespace
+	case GroupByNamespace, GroupByPod:
+	default:
+		return fmt.Errorf("unknown grouping %q, expected %s or %s", o.GroupBy, GroupByNamespace, GroupByPod)
+	}
+	return nil
+}
+
+// Report integrates the records over the period of the options, open records
+// up to now. Rows are sorted by namespace, pod and device type.
+func Report(records []Record, opts ReportOptions, now time.Time) []Usage {
+	type key struct{ namespace, pod, vendor string }
+	rows := make(map[key]*Usage)
+	for _, r := range records {
+		end := r.End
+		if end.IsZero() {
+			end = now
+		}
+		start := maxTime(r.Start, opts.From)
+		end = minTime(end, opts.To)
+		if !start.Before(end) {
+			continue
+		}
+		k := key{namespace: r.Namespace, vendor: r.Type}
+		if opts.GroupBy == GroupByPod {
+			k.pod = r.Pod
+		}
+		u, ok := rows[k]
+		if !ok {
+			u = &Usage{Namespace: k.namespace, Pod: k.pod, Type: k.vendor}
+			rows[k] = u
+		}
+		seconds := end.Sub(start).Seconds()
+		u.DeviceSeconds += float64(r.Devices) * seconds
+		u.MemorySeconds += float64(r.Memory) * seconds
+		u.CoreSeconds += float64(r.Cores) / 100 * seconds
+	}
+	usage := make([]Usage, 0, len(rows))
+	for _, u := range rows {
+		usage = append(usage, *u)
+	}
+	slices.SortFunc(usage, func(a, b Usage) int {
+		return strings.Compare(a.Namespace+"/"+a.Pod+"/"+a.Type, b.Namespace+"/"+b.Pod+"/"+b.Type)
+	})
+	return usage
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// Write writes a report in the format, csv or json.
+func Write(w io.Writer, format string, usage []Usage) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(usage)
+	case "csv":
+		return writeCSV(w, usage)
+	default:
+		return fmt.Errorf("unknown report format %q, expected csv or json", format)
+	}
+}
+
+func writeCSV(w io.Writer, usage []Usage) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"namespace", "pod", "type", "device_seconds", "memory_mib_seconds", "core_seconds", "actual_memory_mib_seconds", "actual_core_seconds"})
+	for _, u := range usage {
+		cw.Write([]string{u.Namespace, u.Pod, u.Type, formatFloat(u.DeviceSeconds), formatFloat(u.MemorySeconds), formatFloat(u.CoreSeconds),
+			formatOptional(u.ActualMemorySeconds), formatOptional(u.ActualCoreSeconds)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
+
+func formatOptional(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return formatFloat(*f)
+}
@@ -0,0 +1,304 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package advisor gathers the device inventories of several HAMi schedulers
+// into a global one, and recommends the clusters a pod fits in.
+package advisor
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/device"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// Cluster is a HAMi scheduler serving its inventory.
+type Cluster struct {
+	Name string `yaml:"name"`
+	// URL is the base URL of the scheduler, e.g. https://hami-scheduler.kube-system.svc:443.
+	URL string `yaml:"url"`
+	// TokenFile holds the bearer token of the inventory endpoint of the scheduler.
+	TokenFile string `yaml:"tokenFile"`
+	// CAFile holds the certificate authorities of the scheduler, the system ones are used if empty.
+	CAFile             string `yaml:"caFile"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+// LoadClusters reads the clusters of a YAML file, a list under `clusters`.
+func LoadClusters(path string) ([]Cluster, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Clusters []Cluster `yaml:"clusters"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, c := range file.Clusters {
+		if c.Name == "" || c.URL == "" {
+			return nil, fmt.Errorf("cluster %q needs a name and a url", c.Name)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("cluster %q is listed twice", c.Name)
+		}
+		names[c.Name] = true
+	}
+	return file.Clusters, nil
+}
+
+// VendorSummary is the capacity of the devices of a vendor in a cluster.
+type VendorSummary struct {
+	Devices   int   `json:"devices"`
+	Totalmem  int64 `json:"totalmem"`
+	Freemem   int64 `json:"freemem"`
+	Totalcore int64 `json:"totalcore"`
+	Freecore  int64 `json:"freecore"`
+}
+
+// ClusterInventory is the last inventory gathered from a cluster.
+type ClusterInventory struct {
+	Name string `json:"name"`
+	// Updated is when the inventory was gathered, Error why the last attempt failed.
+	Updated   time.Time                `json:"updated,omitempty"`
+	Error     string                   `json:"error,omitempty"`
+	Summary   map[string]VendorSummary `json:"summary,omitempty"`
+	Inventory *scheduler.Inventory     `json:"inventory,omitempty"`
+}
+
+// ClusterPlacement is where a pod fits in a cluster, or why it does not.
+type ClusterPlacement struct {
+	Cluster string  `json:"cluster"`
+	Fits    bool    `json:"fits"`
+	Node    string  `json:"node,omitempty"`
+	Score   float32 `json:"score,omitempty"`
+	Reason  string  `json:"reason,omitempty"`
+	// FailedNodes is why each node was rejected.
+	FailedNodes map[string]string `json:"failedNodes,omitempty"`
+}
+
+// Recommendation lists the clusters a pod fits in first, those with the most
+// free memory first, then the others.
+type Recommendation struct {
+	// Cluster is the recommended cluster, empty if the pod fits in none.
+	Cluster  string             `json:"cluster,omitempty"`
+	Clusters []ClusterPlacement `json:"clusters"`
+}
+
+type cluster struct {
+	Cluster
+	client *http.Client
+}
+
+// Advisor gathers the inventories of clusters.
+type Advisor struct {
+	clusters    []cluster
+	mutex       sync.RWMutex
+	inventories map[string]*ClusterInventory
+}
+
+// New returns an advisor of clusters, with an HTTP client for each.
+func New(clusters []Cluster) (*Advisor, error) {
+	a := &Advisor{inventories: make(map[string]*ClusterInventory)}
+	for _, c := range clusters {
+		tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+		if c.CAFile != "" {
+			pem, err := os.ReadFile(c.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate found in %s", c.CAFile)
+			}
+		}
+		client := &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		}
+		a.clusters = append(a.clusters, cluster{Cluster: c, client: client})
+		a.inventories[c.Name] = &ClusterInventory{Name: c.Name}
+	}
+	return a, nil
+}
+
+// fetch gets the inventory of a cluster.
+func (c cluster) fetch(ctx context.Context) (*scheduler.Inventory, error) {
+	token, err := os.ReadFile(c.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.URL, "/")+"/inventory", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("inventory request failed with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	inv := &scheduler.Inventory{}
+	if err := json.NewDecoder(resp.Body).Decode(inv); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// summarize sums the capacity of the devices of an inventory by vendor.
+func summarize(inv *scheduler.Inventory) map[string]VendorSummary {
+	summary := make(map[string]VendorSummary)
+	for _, n := range inv.Nodes {
+		for _, d := range n.Devices {
+			vendor := vendorOf(d.DeviceInfo)
+			s := summary[vendor]
+			s.Devices++
+			s.Totalmem += int64(d.Devmem)
+			s.Freemem += int64(max(d.Devmem-d.Usedmem, 0))
+			s.Totalcore += int64(d.Devcore)
+			s.Freecore += int64(max(d.Devcore-d.Usedcores, 0))
+			summary[vendor] = s
+		}
+	}
+	return summary
+}
+
+// vendorOf returns the vendor a device was registered under. Schedulers of
+// earlier releases only report it for some vendors, it is then found from the
+// type of the device, which contains its vendor or the common word of it.
+func vendorOf(d util.DeviceInfo) string {
+	if d.DeviceVendor != "" {
+		return d.DeviceVendor
+	}
+	vendor := ""
+	for name, dev := range device.GetDevices() {
+		if (strings.Contains(d.Type, name) || strings.Contains(d.Type, dev.CommonWord())) && len(name) > len(vendor) {
+			vendor = name
+		}
+	}
+	return vendor
+}
+
+// Refresh gathers the inventories of all clusters. A cluster that fails keeps
+// its last inventory, with the error.
+func (a *Advisor) Refresh(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, c := range a.clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			inv, err := c.fetch(ctx)
+			a.mutex.Lock()
+			defer a.mutex.Unlock()
+			state := a.inventories[c.Name]
+			if err != nil {
+				klog.ErrorS(err, "Failed to gather inventory", "cluster", c.Name)
+				state.Error = err.Error()
+				return
+			}
+			a.inventories[c.Name] = &ClusterInventory{
+				Name:      c.Name,
+				Updated:   time.Now(),
+				Summary:   summarize(inv),
+				Inventory: inv,
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Run refreshes the inventories every interval until ctx is done.
+func (a *Advisor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		a.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Inventory returns the last inventory of every cluster, by name.
+func (a *Advisor) Inventory() []ClusterInventory {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	res := make([]ClusterInventory, 0, len(a.inventories))
+	for _, inv := range a.inventories {
+		res = append(res, *inv)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// Recommend places a pod in every cluster as its scheduler would, from the
+// last inventory of the cluster. Devices must have been initialized with the
+// resource names of the clusters.
+func (a *Advisor) Recommend(pod *corev1.Pod) *Recommendation {
+	inventories := a.Inventory()
+	freemem := make(map[string]int64)
+	res := &Recommendation{Clusters: make([]ClusterPlacement, 0, len(inventories))}
+	for _, inv := range inventories {
+		placement := ClusterPlacement{Cluster: inv.Name}
+		if inv.Inventory == nil {
+			placement.Reason = "no inventory gathered: " + inv.Error
+			res.Clusters = append(res.Clusters, placement)
+			continue
+		}
+		p := scheduler.FitInventory(inv.Inventory, pod)
+		placement.Fits = p.Node != ""
+		placement.Node, placement.Score, placement.Reason, placement.FailedNodes = p.Node, p.Score, p.Reason, p.FailedNodes
+		for _, s := range inv.Summary {
+			freemem[inv.Name] += s.Freemem
+		}
+		res.Clusters = append(res.Clusters, placement)
+	}
+	sort.SliceStable(res.Clusters, func(i, j int) bool {
+		ci, cj := res.Clusters[i], res.Clusters[j]
+		if ci.Fits != cj.Fits {
+			return ci.Fits
+		}
+		return freemem[ci.Cluster] > freemem[cj.Cluster]
+	})
+	if len(res.Clusters) > 0 && res.Clusters[0].Fits {
+		res.Cluster = res.Clusters[0].Cluster
+	}
+	return res
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package advisor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/device"
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+const testToken = "secret"
+
+// fakeScheduler serves the inventory of a cluster with one node of GPUs of
+// devmem, each with usedmem in use. The devices are reported without their
+// vendor, as by schedulers of earlier releases.
+func fakeScheduler(t *testing.T, devmem int32, usedmem ...int32) *httptest.Server {
+	node := scheduler.InventoryNode{Name: "node1"}
+	for i, used := range usedmem {
+		id := "gpu" + string(rune('0'+i))
+		node.Devices = append(node.Devices, scheduler.InventoryDevice{
+			DeviceInfo: util.DeviceInfo{ID: id, Count: 10, Devmem: devmem, Devcore: 100, Type: "NVIDIA-A100", Health: true},
+			Usedmem:    used,
+		})
+		if used > 0 {
+			node.Allocations = append(node.Allocations, scheduler.InventoryAllocation{Devices: util.PodDevices{
+				nvidia.NvidiaGPUDevice: util.PodSingleDevice{{{UUID: id, Type: nvidia.NvidiaGPUDevice, Usedmem: used}}},
+			}})
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/inventory" || r.Header.Get("Authorization") != "Bearer "+testToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(&scheduler.Inventory{Nodes: []scheduler.InventoryNode{node}})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testAdvisor(t *testing.T, clusters map[string]string) *Advisor {
+	device.InitDefaultDevices()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte(testToken+"\n"), 0600))
+	list := make([]Cluster, 0)
+	for name, url := range clusters {
+		list = append(list, Cluster{Name: name, URL: url, TokenFile: tokenFile})
+	}
+	a, err := New(list)
+	require.NoError(t, err)
+	a.Refresh(context.Background())
+	return a
+}
+
+func Test_LoadClusters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clusters.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+clusters:
+- name: east
+  url: https://east:443
+  tokenFile: /tokens/east
+`), 0644))
+	clusters, err := LoadClusters(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Cluster{{Name: "east", URL: "https://east:443", TokenFile: "/tokens/east"}}, clusters)
+
+	require.NoError(t, os.WriteFile(path, []byte("clusters:\n- name: east\n  url: a\n- name: east\n  url: b\n"), 0644))
+	_, err = LoadClusters(path)
+	assert.ErrorContains(t, err, "listed twice")
+}
+
+func Test_Advisor(t *testing.T) {
+	unauthorized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer unauthorized.Close()
+	a := testAdvisor(t, map[string]string{
+		"small":        fakeScheduler(t, 16000, 0, 0).URL,
+		"large-busy":   fakeScheduler(t, 40000, 38000).URL,
+		"large-free":   fakeScheduler(t, 40000, 0, 10000).URL,
+		"unauthorized": unauthorized.URL,
+	})
+
+	inventories := a.Inventory()
+	require.Len(t, inventories, 4)
+	assert.Equal(t, "large-busy", inventories[0].Name)
+	assert.Equal(t, VendorSummary{Devices: 2, Totalmem: 80000, Freemem: 70000, Totalcore: 200, Freecore: 200}, inventories[1].Summary[nvidia.NvidiaGPUDevice])
+	assert.Nil(t, inventories[3].Inventory)
+	assert.Contains(t, inventories[3].Error, "401 Unauthorized")
+
+	rec := a.Recommend(testPod("20000"))
+	assert.Equal(t, "large-free", rec.Cluster)
+	require.Len(t, rec.Clusters, 4)
+	assert.Equal(t, []bool{true, false, false, false}, []bool{rec.Clusters[0].Fits, rec.Clusters[1].Fits, rec.Clusters[2].Fits, rec.Clusters[3].Fits})
+	assert.Equal(t, "node1", rec.Clusters[0].Node)
+
+	rec = a.Recommend(testPod("8000"))
+	assert.Equal(t, "large-free", rec.Cluster, "the cluster with the most free memory is recommended")
+	assert.Equal(t, "small", rec.Clusters[1].Cluster)
+	assert.True(t, rec.Clusters[1].Fits)
+
+	rec = a.Recommend(testPod("50000"))
+	assert.Empty(t, rec.Cluster)
+}
+
+func Test_Handler(t *testing.T) {
+	a := testAdvisor(t, map[string]string{"east": fakeScheduler(t, 16000, 0).URL})
+	srv := httptest.NewServer(a.Handler())
+	defer srv.Close()
+
+	body, err := json.Marshal(testPod("4000"))
+	require.NoError(t, err)
+	resp, err := http.Post(srv.URL+"/recommend", "application/json", strings.NewReader(string(body)))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	rec := &Recommendation{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(rec))
+	assert.Equal(t, "east", rec.Cluster)
+
+	resp, err = http.Post(srv.URL+"/recommend", "application/yaml", strings.NewReader("spec: ["))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func testPod(mem string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "train", Namespace: "default"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name: "main",
+			Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+				"nvidia.com/gpu":    resource.MustParse("1"),
+				"nvidia.com/gpumem": resource.MustParse(mem),
+			}},
+		}}},
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package advisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	corev1 "k8s.io/api/core/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/klog/v2"
+)
+
+// maxPodSize is the largest pod accepted by the recommendation endpoint.
+const maxPodSize = 1 << 20
+
+func writeJSON(w http.ResponseWriter, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// Handler serves the global inventory on GET /inventory, and the clusters a
+// pod in YAML or JSON fits in on POST /recommend.
+func (a *Advisor) Handler() http.Handler {
+	router := httprouter.New()
+	router.GET("/inventory", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		writeJSON(w, a.Inventory())
+	})
+	router.POST("/recommend", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		pod := &corev1.Pod{}
+		if err := utilyaml.NewYAMLOrJSONDecoder(http.MaxBytesReader(w, r.Body, maxPodSize), 4096).Decode(pod); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode pod: %v", err), http.StatusBadRequest)
+			return
+		}
+		rec := a.Recommend(pod)
+		klog.InfoS("Recommended cluster", "pod", klog.KObj(pod), "cluster", rec.Cluster)
+		writeJSON(w, rec)
+	})
+	router.GET("/healthz", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return router
+}
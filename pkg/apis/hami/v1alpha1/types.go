@@ -0,0 +1,98 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+)
+
+// DeviceAllocationPhase is how far the allocation of the devices of a pod went.
+type DeviceAllocationPhase string
+
+const (
+	// DeviceAllocationReserved is set when the scheduler reserved the devices on a node.
+	DeviceAllocationReserved DeviceAllocationPhase = "Reserved"
+	// DeviceAllocationAllocating is set once the pod is bound, until the device plugin allocated the devices.
+	DeviceAllocationAllocating DeviceAllocationPhase = "Allocating"
+	// DeviceAllocationSucceeded is set when the device plugin allocated the devices.
+	DeviceAllocationSucceeded DeviceAllocationPhase = "Succeeded"
+	// DeviceAllocationFailed is set when the device plugin failed to allocate the devices.
+	DeviceAllocationFailed DeviceAllocationPhase = "Failed"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:scope=Namespaced,shortName=da
+// +kubebuilder:printcolumn:name="Pod",type=string,JSONPath=`.spec.podName`
+// +kubebuilder:printcolumn:name="Node",type=string,JSONPath=`.spec.nodeName`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+
+// DeviceAllocation records the devices the scheduler assigned to a pod. It has
+// the name and namespace of the pod and is owned by it.
+type DeviceAllocation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DeviceAllocationSpec   `json:"spec"`
+	Status DeviceAllocationStatus `json:"status,omitempty"`
+}
+
+type DeviceAllocationSpec struct {
+	PodName  string       `json:"podName"`
+	PodUID   k8stypes.UID `json:"podUID"`
+	NodeName string       `json:"nodeName"`
+	// Devices are the devices of each vendor, as in the devices-to-allocate
+	// annotations of the pod.
+	Devices []VendorAllocation `json:"devices,omitempty"`
+}
+
+// VendorAllocation is the devices of one vendor, one entry of Containers per
+// container of the pod that uses them.
+type VendorAllocation struct {
+	Vendor     string                `json:"vendor"`
+	Containers []ContainerAllocation `json:"containers"`
+}
+
+type ContainerAllocation struct {
+	Devices []AllocatedDevice `json:"devices"`
+}
+
+// AllocatedDevice is a share of a device, like util.ContainerDevice without
+// the vendor specific CustomInfo.
+type AllocatedDevice struct {
+	Idx       int    `json:"idx,omitempty"`
+	UUID      string `json:"uuid"`
+	Type      string `json:"type"`
+	Usedmem   int32  `json:"usedmem"`
+	Usedcores int32  `json:"usedcores"`
+}
+
+type DeviceAllocationStatus struct {
+	Phase DeviceAllocationPhase `json:"phase,omitempty"`
+	// LastTransitionTime is when Phase last changed.
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// DeviceAllocationList is a list of DeviceAllocations.
+type DeviceAllocationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []DeviceAllocation `json:"items"`
+}
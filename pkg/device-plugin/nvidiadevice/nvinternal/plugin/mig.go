@@ -0,0 +1,242 @@
+/*
+ * Copyright (c) 2024, HAMi.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/util/client"
+	"github.com/Project-HAMi/HAMi/pkg/util/nodelock"
+)
+
+// migWatchInterval is how often the geometries the scheduler planned for the
+// node are checked.
+const migWatchInterval = 30 * time.Second
+
+// WatchMigGeometries partitions the idle MIG GPUs of the node to the
+// geometries the scheduler planned in MigDesiredGeometriesAnnos, so that the
+// pods it places on them are allocated without partitioning them in Allocate.
+// The geometries applied are reported in MigAppliedGeometriesAnnos.
+func (plugin *NvidiaDevicePlugin) WatchMigGeometries(stop <-chan any) {
+	klog.Info("Starting WatchMigGeometries")
+	ticker := time.NewTicker(migWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if err := plugin.applyMigGeometries(context.Background()); err != nil {
+			klog.ErrorS(err, "Failed to apply desired MIG geometries")
+		}
+	}
+}
+
+func (plugin *NvidiaDevicePlugin) applyMigGeometries(ctx context.Context) error {
+	node, err := util.GetNode(util.NodeName)
+	if err != nil {
+		return err
+	}
+	desired, err := nvidia.DecodeMigGeometries(node.Annotations[nvidia.MigDesiredGeometriesAnnos])
+	if err != nil {
+		return err
+	}
+	if len(desired) > 0 {
+		if err := plugin.partitionIdleDevices(ctx, node.Name, desired); err != nil {
+			return err
+		}
+	}
+	plugin.migMutex.Lock()
+	applied := nvidia.EncodeMigGeometries(plugin.migApplied)
+	plugin.migMutex.Unlock()
+	if node.Annotations[nvidia.MigAppliedGeometriesAnnos] == applied {
+		return nil
+	}
+	return util.PatchNodeAnnotations(node, map[string]string{nvidia.MigAppliedGeometriesAnnos: applied})
+}
+
+// partitionIdleDevices applies the desired geometries of the GPUs no pod holds.
+// Nothing is applied while the scheduler allocates devices on the node, which
+// it locks for that, or while another MIG apply holds the lock file.
+func (plugin *NvidiaDevicePlugin) partitionIdleDevices(ctx context.Context, nodeName string, desired map[string]int32) error {
+	if _, err := os.Stat(MigApplyLockFile); err == nil {
+		klog.V(4).InfoS("MIG apply in progress, skip desired geometries", "lockFile", MigApplyLockFile)
+		return nil
+	}
+	if locked, err := nodeLocked(ctx, nodeName); err != nil || locked {
+		return err
+	}
+	busy, err := busyDevices(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+
+	plugin.migMutex.Lock()
+	defer plugin.migMutex.Unlock()
+	geometries := migGeometriesToApply(desired, plugin.migApplied, busy)
+	if len(geometries) == 0 {
+		return nil
+	}
+	previous := cloneMigPartedSpec(plugin.migCurrent)
+	applied := make(map[string]int32, len(geometries))
+	needsreset := false
+	for uuid, tidx := range geometries {
+		devtype, devindex := GetIndexAndTypeFromUUID(uuid)
+		pos, reset := plugin.GenerateMigTemplate(devtype, devindex, util.ContainerDevice{UUID: fmt.Sprintf("%s[%d-0]", uuid, tidx)})
+		if pos < 0 {
+			klog.InfoS("Desired MIG geometry not allowed for the device", "uuid", uuid, "type", devtype, "geometry", tidx)
+			continue
+		}
+		needsreset = needsreset || reset
+		applied[uuid] = tidx
+	}
+	if needsreset {
+		// The scheduler may have locked the node, or assigned a pod to one of
+		// the GPUs, since they were found idle.
+		if idle, err := stillIdle(ctx, nodeName, applied); err != nil || !idle {
+			plugin.migCurrent = previous
+			return err
+		}
+		klog.InfoS("Partitioning idle MIG devices", "geometries", applied)
+		plugin.ApplyMigTemplate()
+	}
+	for uuid, tidx := range applied {
+		plugin.setMigGeometry(uuid, tidx)
+	}
+	return nil
+}
+
+// nodeLocked returns whether the scheduler holds the lock of the node to
+// allocate devices on it.
+func nodeLocked(ctx context.Context, nodeName string) (bool, error) {
+	ns, name, err := nodelock.GetNodeLockHolder(ctx, nodeName, NodeLockNvidia)
+	if err != nil {
+		return false, err
+	}
+	if name != "" {
+		klog.V(4).InfoS("Node locked by an allocation, skip desired geometries", "pod", klog.KRef(ns, name))
+		return true, nil
+	}
+	return false, nil
+}
+
+// stillIdle checks again that the node is not locked and that no pod holds
+// the GPUs of geometries, right before they are partitioned.
+func stillIdle(ctx context.Context, nodeName string, geometries map[string]int32) (bool, error) {
+	if locked, err := nodeLocked(ctx, nodeName); err != nil || locked {
+		return false, err
+	}
+	busy, err := busyDevices(ctx, nodeName)
+	if err != nil {
+		return false, err
+	}
+	for uuid := range geometries {
+		if busy[uuid] {
+			klog.V(4).InfoS("MIG device allocated meanwhile, skip desired geometries", "uuid", uuid)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// cloneMigPartedSpec returns a copy of spec that can be modified without
+// modifying it.
+func cloneMigPartedSpec(spec nvidia.MigPartedSpec) nvidia.MigPartedSpec {
+	res := nvidia.MigPartedSpec{Version: spec.Version}
+	if spec.MigConfigs == nil {
+		return res
+	}
+	res.MigConfigs = make(map[string]nvidia.MigConfigSpecSlice, len(spec.MigConfigs))
+	for name, configs := range spec.MigConfigs {
+		cloned := make(nvidia.MigConfigSpecSlice, 0, len(configs))
+		for _, c := range configs {
+			c.Devices = slices.Clone(c.Devices)
+			c.MigDevices = maps.Clone(c.MigDevices)
+			cloned = append(cloned, c)
+		}
+		res.MigConfigs[name] = cloned
+	}
+	return res
+}
+
+// setMigGeometry keeps the geometry a GPU was partitioned to, the migMutex
+// must be held.
+func (plugin *NvidiaDevicePlugin) setMigGeometry(uuid string, tidx int32) {
+	if plugin.migApplied == nil {
+		plugin.migApplied = make(map[string]int32)
+	}
+	plugin.migApplied[uuid] = tidx
+}
+
+// migGeometriesToApply returns the desired geometries of the GPUs that are not
+// busy and were not partitioned to them yet.
+func migGeometriesToApply(desired, applied map[string]int32, busy map[string]bool) map[string]int32 {
+	res := maps.Clone(desired)
+	maps.DeleteFunc(res, func(uuid string, tidx int32) bool {
+		current, ok := applied[uuid]
+		return busy[uuid] || ok && current == tidx
+	})
+	return res
+}
+
+// busyDevices returns the UUIDs of the GPUs allocated to the pods of a node
+// that are not done, including the pods the scheduler assigned to the node
+// that are not bound yet.
+func busyDevices(ctx context.Context, nodeName string) (map[string]bool, error) {
+	bound, err := client.GetClient().CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("spec.nodeName=%s", nodeName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	assigned, err := client.GetClient().CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", util.AssignedNodeAnnotations, nodeName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	busy := make(map[string]bool)
+	for _, p := range append(bound.Items, assigned.Items...) {
+		if p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		pd, err := util.DecodePodDevices(util.SupportDevices, p.Annotations)
+		if err != nil {
+			klog.ErrorS(err, "Failed to decode pod devices", "pod", klog.KObj(&p))
+			continue
+		}
+		for _, ctrdevs := range pd[nvidia.NvidiaGPUDevice] {
+			for _, d := range ctrdevs {
+				busy[strings.Split(d.UUID, "[")[0]] = true
+			}
+		}
+	}
+	return busy, nil
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2024, HAMi.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"context"
+	"maps"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/util/client"
+)
+
+func TestMigGeometriesToApply(t *testing.T) {
+	desired := map[string]int32{"GPU-0": 1, "GPU-1": 0, "GPU-2": 3, "GPU-3": 2}
+	applied := map[string]int32{"GPU-0": 1, "GPU-1": 2}
+	busy := map[string]bool{"GPU-3": true}
+
+	got := migGeometriesToApply(desired, applied, busy)
+	want := map[string]int32{"GPU-1": 0, "GPU-2": 3}
+	if !maps.Equal(got, want) {
+		t.Errorf("migGeometriesToApply() = %v, want %v", got, want)
+	}
+	if len(desired) != 4 {
+		t.Errorf("migGeometriesToApply() modified the desired geometries: %v", desired)
+	}
+}
+
+func TestBusyDevices(t *testing.T) {
+	nvidia.InitNvidiaDevice(nvidia.NvidiaConfig{})
+	pod := func(name, uuid string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Annotations: map[string]string{nvidia.AllocatedAnnos: uuid + ",NVIDIA,1000,0:;"},
+			},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+	}
+	bound := pod("bound", "GPU-0[1-0]", corev1.PodRunning)
+	bound.Spec.NodeName = "node1"
+	assigned := pod("assigned", "GPU-1[1-0]", corev1.PodPending)
+	assigned.Labels = map[string]string{util.AssignedNodeAnnotations: "node1"}
+	done := pod("done", "GPU-2[1-0]", corev1.PodSucceeded)
+	done.Spec.NodeName = "node1"
+	client.KubeClient = fake.NewSimpleClientset(bound, assigned, done)
+	t.Cleanup(func() { client.KubeClient = nil })
+
+	got, err := busyDevices(context.Background(), "node1")
+	if err != nil {
+		t.Fatalf("busyDevices() error = %v", err)
+	}
+	want := map[string]bool{"GPU-0": true, "GPU-1": true}
+	if !maps.Equal(got, want) {
+		t.Errorf("busyDevices() = %v, want %v, pods assigned to the node and not bound yet are busy", got, want)
+	}
+}
+
+func TestCloneMigPartedSpec(t *testing.T) {
+	spec := nvidia.MigPartedSpec{MigConfigs: map[string]nvidia.MigConfigSpecSlice{
+		"current": {{Devices: []int32{0}, MigEnabled: true, MigDevices: map[string]int32{"1g.10gb": 7}}},
+	}}
+	cloned := cloneMigPartedSpec(spec)
+	cloned.MigConfigs["current"][0].MigDevices["1g.10gb"] = 1
+	cloned.MigConfigs["current"][0].Devices[0] = 1
+	if spec.MigConfigs["current"][0].MigDevices["1g.10gb"] != 7 || spec.MigConfigs["current"][0].Devices[0] != 0 {
+		t.Errorf("cloneMigPartedSpec() shares the devices of the spec: %v", spec)
+	}
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2024, HAMi.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+)
+
+// mpsWatchInterval is how often the MPS control daemons are checked, and
+// restarted if they exited.
+const mpsWatchInterval = 30 * time.Second
+
+// mpsComputeModeFile is the file in the log directory of a daemon keeping the
+// compute mode its GPU had before the daemon was started.
+const mpsComputeModeFile = "compute-mode"
+
+// nvidiaSMI runs nvidia-smi with args.
+var nvidiaSMI = func(args ...string) (string, error) {
+	out, err := exec.Command("nvidia-smi", args...).CombinedOutput()
+	return string(out), err
+}
+
+// mpsDaemon is the MPS control daemon of a GPU, serving the containers it is
+// allocated to through its pipe directory.
+type mpsDaemon struct {
+	uuid    string
+	pipeDir string
+	logDir  string
+}
+
+func newMPSDaemon(root, uuid string) mpsDaemon {
+	return mpsDaemon{
+		uuid:    uuid,
+		pipeDir: nvidia.MPSPipeDirectory(root, uuid),
+		logDir:  nvidia.MPSLogDirectory(root, uuid),
+	}
+}
+
+func (d mpsDaemon) env() []string {
+	return []string{
+		"CUDA_VISIBLE_DEVICES=" + d.uuid,
+		"CUDA_MPS_PIPE_DIRECTORY=" + d.pipeDir,
+		"CUDA_MPS_LOG_DIRECTORY=" + d.logDir,
+	}
+}
+
+// control runs nvidia-cuda-mps-control with the environment of the daemon,
+// sending it a command on stdin if not empty.
+func (d mpsDaemon) control(command string, args ...string) (string, error) {
+	cmd := exec.Command("nvidia-cuda-mps-control", args...)
+	cmd.Env = append(os.Environ(), d.env()...)
+	if command != "" {
+		cmd.Stdin = strings.NewReader(command + "\n")
+	}
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// start sets the GPU to exclusive process compute mode, so that only the
+// daemon creates contexts on it, and starts the daemon.
+func (d mpsDaemon) start() error {
+	for _, dir := range []string{d.pipeDir, d.logDir} {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+		os.Chmod(dir, 0777)
+	}
+	d.saveComputeMode()
+	if out, err := nvidiaSMI("-i", d.uuid, "-c", "EXCLUSIVE_PROCESS"); err != nil {
+		klog.ErrorS(err, "Failed to set exclusive process compute mode", "uuid", d.uuid, "output", out)
+	}
+	if out, err := d.control("", "-d"); err != nil {
+		return fmt.Errorf("start mps control daemon of %s: %v, %s", d.uuid, err, out)
+	}
+	klog.InfoS("Started MPS control daemon", "uuid", d.uuid, "pipeDirectory", d.pipeDir)
+	return nil
+}
+
+// running reports whether the daemon answers on its pipe directory.
+func (d mpsDaemon) running() bool {
+	_, err := d.control("get_server_list")
+	return err == nil
+}
+
+// stop stops the daemon and restores the compute mode of its GPU.
+func (d mpsDaemon) stop() {
+	if out, err := d.control("quit"); err != nil {
+		klog.ErrorS(err, "Failed to stop MPS control daemon", "uuid", d.uuid, "output", out)
+		return
+	}
+	klog.InfoS("Stopped MPS control daemon", "uuid", d.uuid)
+	d.restoreComputeMode()
+}
+
+// saveComputeMode keeps the compute mode of the GPU, unless one is kept
+// already: a daemon restarted after it exited finds the GPU in exclusive
+// process compute mode.
+func (d mpsDaemon) saveComputeMode() {
+	file := filepath.Join(d.logDir, mpsComputeModeFile)
+	if _, err := os.Stat(file); err == nil {
+		return
+	}
+	out, err := nvidiaSMI("-i", d.uuid, "--query-gpu=compute_mode", "--format=csv,noheader")
+	if err != nil {
+		klog.ErrorS(err, "Failed to get compute mode", "uuid", d.uuid, "output", out)
+		return
+	}
+	mode := strings.ToUpper(strings.TrimSpace(out))
+	if err := os.WriteFile(file, []byte(mode), 0644); err != nil {
+		klog.ErrorS(err, "Failed to save compute mode", "uuid", d.uuid)
+	}
+}
+
+// restoreComputeMode sets the GPU back to the compute mode kept by
+// saveComputeMode.
+func (d mpsDaemon) restoreComputeMode() {
+	file := filepath.Join(d.logDir, mpsComputeModeFile)
+	mode, err := os.ReadFile(file)
+	if err != nil {
+		return
+	}
+	if out, err := nvidiaSMI("-i", d.uuid, "-c", string(mode)); err != nil {
+		klog.ErrorS(err, "Failed to restore compute mode", "uuid", d.uuid, "mode", string(mode), "output", out)
+		return
+	}
+	os.Remove(file)
+}
+
+// mpsDaemons returns the daemons of the GPUs of the plugin.
+func (plugin *NvidiaDevicePlugin) mpsDaemons() []mpsDaemon {
+	daemons := make([]mpsDaemon, 0)
+	for _, uuid := range slices.Sorted(maps.Keys(plugin.Devices())) {
+		daemons = append(daemons, newMPSDaemon(nvidia.MPSRoot, uuid))
+	}
+	return daemons
+}
+
+// StartMPSDaemons starts an MPS control daemon for every GPU that does not run one.
+func (plugin *NvidiaDevicePlugin) StartMPSDaemons() error {
+	for _, d := range plugin.mpsDaemons() {
+		if d.running() {
+			continue
+		}
+		if err := d.start(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WatchMPSDaemons restarts the MPS control daemons that exited.
+func (plugin *NvidiaDevicePlugin) WatchMPSDaemons(stop <-chan any) {
+	ticker := time.NewTicker(mpsWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if err := plugin.StartMPSDaemons(); err != nil {
+			klog.ErrorS(err, "Failed to restart MPS control daemons")
+		}
+	}
+}
+
+// StopMPSDaemons stops the MPS control daemons of the GPUs of a plugin in the
+// mps operating mode and restores the compute modes of the GPUs. The daemons
+// serve running containers, so that they are left running when the plugin is
+// only restarted and stopped on teardown only.
+func (plugin *NvidiaDevicePlugin) StopMPSDaemons() {
+	if plugin.operatingMode != nvidia.MpsMode {
+		return
+	}
+	for _, d := range plugin.mpsDaemons() {
+		d.stop()
+	}
+}
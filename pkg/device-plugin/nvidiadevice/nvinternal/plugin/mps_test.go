@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2024, HAMi.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMPSDaemonEnv(t *testing.T) {
+	d := newMPSDaemon("/tmp/hami/mps", "GPU-0")
+	want := []string{
+		"CUDA_VISIBLE_DEVICES=GPU-0",
+		"CUDA_MPS_PIPE_DIRECTORY=/tmp/hami/mps/GPU-0/pipe",
+		"CUDA_MPS_LOG_DIRECTORY=/tmp/hami/mps/GPU-0/log",
+	}
+	if got := d.env(); !slices.Equal(got, want) {
+		t.Errorf("env() = %v, want %v", got, want)
+	}
+}
+
+func TestMPSDaemonComputeMode(t *testing.T) {
+	orig := nvidiaSMI
+	defer func() { nvidiaSMI = orig }()
+	var calls []string
+	nvidiaSMI = func(args ...string) (string, error) {
+		calls = append(calls, strings.Join(args, " "))
+		return "Default\n", nil
+	}
+
+	d := newMPSDaemon(t.TempDir(), "GPU-0")
+	if err := os.MkdirAll(d.logDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	d.saveComputeMode()
+	// A daemon restarted after it exited keeps the mode saved first.
+	nvidiaSMI = func(args ...string) (string, error) {
+		calls = append(calls, strings.Join(args, " "))
+		return "Exclusive_Process\n", nil
+	}
+	d.saveComputeMode()
+	mode, err := os.ReadFile(filepath.Join(d.logDir, mpsComputeModeFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(mode) != "DEFAULT" {
+		t.Errorf("saved compute mode = %q, want %q", mode, "DEFAULT")
+	}
+
+	d.restoreComputeMode()
+	want := []string{
+		"-i GPU-0 --query-gpu=compute_mode --format=csv,noheader",
+		"-i GPU-0 -c DEFAULT",
+	}
+	if !slices.Equal(calls, want) {
+		t.Errorf("nvidia-smi calls = %v, want %v", calls, want)
+	}
+	if _, err := os.Stat(filepath.Join(d.logDir, mpsComputeModeFile)); !os.IsNotExist(err) {
+		t.Errorf("compute mode file not removed after restore: %v", err)
+	}
+}
@@ -144,10 +144,19 @@ func (plugin *NvidiaDevicePlugin) getAPIDevices() *[]*util.DeviceInfo {
 		}
 
 		registeredmem := int32(memoryTotal / 1024 / 1024)
-		if *plugin.schedulerConfig.DeviceMemoryScaling != 1 {
-			registeredmem = int32(float64(registeredmem) * *plugin.schedulerConfig.DeviceMemoryScaling)
+		physmem := int32(0)
+		scaling := *plugin.schedulerConfig.DeviceMemoryScaling
+		// MPS pins the memory limit of a client on the device, it can't be oversubscribed.
+		if plugin.operatingMode == nvidia.MpsMode && scaling > 1 {
+			scaling = 1
 		}
-		klog.Infoln("MemoryScaling=", plugin.schedulerConfig.DeviceMemoryScaling, "registeredmem=", registeredmem)
+		if scaling > 1 && RegisterPhysicalMemory {
+			physmem = registeredmem
+		}
+		if scaling != 1 {
+			registeredmem = int32(float64(registeredmem) * scaling)
+		}
+		klog.Infoln("MemoryScaling=", scaling, "registeredmem=", registeredmem)
 		health := true
 		for _, val := range devs {
 			if strings.Compare(val.ID, UUID) == 0 {
@@ -170,6 +179,7 @@ func (plugin *NvidiaDevicePlugin) getAPIDevices() *[]*util.DeviceInfo {
 			Index:   uint(idx),
 			Count:   int32(*plugin.schedulerConfig.DeviceSplitCount),
 			Devmem:  registeredmem,
+			Physmem: physmem,
 			Devcore: int32(*plugin.schedulerConfig.DeviceCoreScaling * 100),
 			Type:    fmt.Sprintf("%v-%v", "NVIDIA", Model),
 			Numa:    numa,
@@ -181,6 +191,30 @@ func (plugin *NvidiaDevicePlugin) getAPIDevices() *[]*util.DeviceInfo {
 	return &res
 }
 
+// getDeviceTemperatures returns the temperatures of the GPUs of the plugin in
+// degrees Celsius, without those NVML fails to read.
+func (plugin *NvidiaDevicePlugin) getDeviceTemperatures() map[string]int {
+	res := make(map[string]int)
+	if ret := nvml.Init(); ret != nvml.SUCCESS {
+		klog.ErrorS(nil, "nvml Init failed, skip device temperatures", "ret", ret)
+		return res
+	}
+	defer nvml.Shutdown()
+	for uuid := range plugin.Devices() {
+		ndev, ret := nvml.DeviceGetHandleByUUID(uuid)
+		if ret != nvml.SUCCESS {
+			continue
+		}
+		temperature, ret := ndev.GetTemperature(nvml.TEMPERATURE_GPU)
+		if ret != nvml.SUCCESS {
+			klog.V(4).InfoS("Failed to get device temperature", "uuid", uuid, "ret", ret)
+			continue
+		}
+		res[uuid] = int(temperature)
+	}
+	return res
+}
+
 func (plugin *NvidiaDevicePlugin) RegistrInAnnotation() error {
 	devices := plugin.getAPIDevices()
 	klog.InfoS("start working on the devices", "devices", devices)
@@ -210,6 +244,11 @@ func (plugin *NvidiaDevicePlugin) RegistrInAnnotation() error {
 	if len(data) > 0 {
 		annos[nvidia.RegisterGPUPairScore] = string(data)
 	}
+	if temperatures := plugin.getDeviceTemperatures(); len(temperatures) > 0 {
+		if data, err := json.Marshal(temperatures); err == nil {
+			annos[util.NodeDeviceTemperatureAnnotation] = string(data)
+		}
+	}
 	klog.Infof("patch node with the following annos %v", fmt.Sprintf("%v", annos))
 	err = util.PatchNodeAnnotations(node, annos)
 
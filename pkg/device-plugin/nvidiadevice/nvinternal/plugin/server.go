@@ -37,6 +37,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"os"
 	"os/exec"
@@ -62,6 +63,7 @@ import (
 	"github.com/Project-HAMi/HAMi/pkg/device-plugin/nvidiadevice/nvinternal/rm"
 	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
 	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/util/nodelock"
 )
 
 // Constants for use by the 'volume-mounts' device list strategy
@@ -75,6 +77,9 @@ const (
 var (
 	hostHookPath string
 	ConfigFile   *string
+	// RegisterPhysicalMemory registers the physical memory of devices with a
+	// memory scaling above 1, which schedulers of earlier releases cannot read.
+	RegisterPhysicalMemory bool
 )
 
 func init() {
@@ -102,6 +107,10 @@ type NvidiaDevicePlugin struct {
 
 	operatingMode string
 	migCurrent    nvidia.MigPartedSpec
+	// migMutex guards migCurrent and migApplied, the geometries this plugin
+	// partitioned GPUs to, by UUID.
+	migMutex   sync.Mutex
+	migApplied map[string]int32
 
 	server *grpc.Server
 	health chan *rm.Device
@@ -314,6 +323,17 @@ func (plugin *NvidiaDevicePlugin) Start() error {
 
 	if deviceSupportMig {
 		plugin.ApplyMigTemplate()
+		if plugin.operatingMode == "mig" {
+			go plugin.WatchMigGeometries(plugin.stop)
+		}
+	}
+
+	if plugin.operatingMode == nvidia.MpsMode {
+		if err := plugin.StartMPSDaemons(); err != nil {
+			plugin.Stop()
+			return err
+		}
+		go plugin.WatchMPSDaemons(plugin.stop)
 	}
 
 	return nil
@@ -462,6 +482,10 @@ func (plugin *NvidiaDevicePlugin) Allocate(ctx context.Context, reqs *kubeletdev
 		return &kubeletdevicepluginv1beta1.AllocateResponse{}, err
 	}
 	klog.Infof("Allocate pod name is %s/%s, annotation is %+v", current.Namespace, current.Name, current.Annotations)
+	if err := nodelock.CheckNodeLock(ctx, nodename, NodeLockNvidia, current); err != nil {
+		device.PodAllocationFailed(nodename, current, NodeLockNvidia)
+		return &kubeletdevicepluginv1beta1.AllocateResponse{}, err
+	}
 
 	for idx, req := range reqs.ContainerRequests {
 		// If the devices being allocated are replicas, then (conditionally)
@@ -510,41 +534,25 @@ func (plugin *NvidiaDevicePlugin) Allocate(ctx context.Context, reqs *kubeletdev
 				return &kubeletdevicepluginv1beta1.AllocateResponse{}, err
 			}
 
-			if plugin.operatingMode != "mig" {
-				for i, dev := range devreq {
-					limitKey := fmt.Sprintf("CUDA_DEVICE_MEMORY_LIMIT_%v", i)
-					response.Envs[limitKey] = fmt.Sprintf("%vm", dev.Usedmem)
-				}
-				response.Envs["CUDA_DEVICE_SM_LIMIT"] = fmt.Sprint(devreq[0].Usedcores)
-				response.Envs["CUDA_DEVICE_MEMORY_SHARED_CACHE"] = fmt.Sprintf("%s/vgpu/%v.cache", hostHookPath, uuid.New().String())
-				if *plugin.schedulerConfig.DeviceMemoryScaling > 1 {
-					response.Envs["CUDA_OVERSUBSCRIBE"] = "true"
+			if plugin.operatingMode == nvidia.MpsMode {
+				if len(devreq) != 1 {
+					device.PodAllocationFailed(nodename, current, NodeLockNvidia)
+					return &kubeletdevicepluginv1beta1.AllocateResponse{}, errors.New("mps mode allocates a single device per container")
 				}
-				if *plugin.schedulerConfig.LogLevel != "" {
-					response.Envs["LIBCUDA_LOG_LEVEL"] = string(*plugin.schedulerConfig.LogLevel)
+				maps.Copy(response.Envs, nvidia.MPSEnvs(devreq[0]))
+				for _, m := range nvidia.MPSMounts(nvidia.MPSRoot, devreq[0]) {
+					response.Mounts = append(response.Mounts, &kubeletdevicepluginv1beta1.Mount{
+						ContainerPath: m.ContainerPath,
+						HostPath:      m.HostPath,
+						ReadOnly:      m.ReadOnly,
+					})
 				}
-				if plugin.schedulerConfig.DisableCoreLimit {
-					response.Envs[util.CoreLimitSwitch] = "disable"
+			} else if plugin.operatingMode != "mig" {
+				hamiCore := plugin.hamiCoreConfig()
+				for k, v := range nvidia.HAMiCoreEnvs(devreq, hamiCore) {
+					response.Envs[k] = v
 				}
-				cacheFileHostDirectory := fmt.Sprintf("%s/vgpu/containers/%s_%s", hostHookPath, current.UID, currentCtr.Name)
-				os.RemoveAll(cacheFileHostDirectory)
-
-				os.MkdirAll(cacheFileHostDirectory, 0777)
-				os.Chmod(cacheFileHostDirectory, 0777)
-				os.MkdirAll("/tmp/vgpulock", 0777)
-				os.Chmod("/tmp/vgpulock", 0777)
-				response.Mounts = append(response.Mounts,
-					&kubeletdevicepluginv1beta1.Mount{ContainerPath: fmt.Sprintf("%s/vgpu/libvgpu.so", hostHookPath),
-						HostPath: GetLibPath(),
-						ReadOnly: true},
-					&kubeletdevicepluginv1beta1.Mount{ContainerPath: fmt.Sprintf("%s/vgpu", hostHookPath),
-						HostPath: cacheFileHostDirectory,
-						ReadOnly: false},
-					&kubeletdevicepluginv1beta1.Mount{ContainerPath: "/tmp/vgpulock",
-						HostPath: "/tmp/vgpulock",
-						ReadOnly: false},
-				)
-				found := false
+				preload := true
 				for _, val := range currentCtr.Env {
 					if strings.Compare(val.Name, "CUDA_DISABLE_CONTROL") == 0 {
 						// if env existed but is set to false or can not be parsed, ignore
@@ -552,28 +560,17 @@ func (plugin *NvidiaDevicePlugin) Allocate(ctx context.Context, reqs *kubeletdev
 						if !t {
 							continue
 						}
-						// only env existed and set to true, we mark it "found"
-						found = true
+						// only env existed and set to true, libvgpu.so is not preloaded
+						preload = false
 						break
 					}
 				}
-				if !found {
-					response.Mounts = append(response.Mounts, &kubeletdevicepluginv1beta1.Mount{ContainerPath: "/etc/ld.so.preload",
-						HostPath: hostHookPath + "/vgpu/ld.so.preload",
-						ReadOnly: true},
-					)
-				}
-				_, err = os.Stat(fmt.Sprintf("%s/vgpu/license", hostHookPath))
-				if err == nil {
-					response.Mounts = append(response.Mounts, &kubeletdevicepluginv1beta1.Mount{
-						ContainerPath: "/tmp/license",
-						HostPath:      fmt.Sprintf("%s/vgpu/license", hostHookPath),
-						ReadOnly:      true,
-					})
+				cacheFileHostDirectory := fmt.Sprintf("%s/vgpu/containers/%s_%s", hostHookPath, current.UID, currentCtr.Name)
+				for _, m := range nvidia.HAMiCoreMounts(cacheFileHostDirectory, preload, hamiCore) {
 					response.Mounts = append(response.Mounts, &kubeletdevicepluginv1beta1.Mount{
-						ContainerPath: "/usr/bin/vgpuvalidator",
-						HostPath:      fmt.Sprintf("%s/vgpu/vgpuvalidator", hostHookPath),
-						ReadOnly:      true,
+						ContainerPath: m.ContainerPath,
+						HostPath:      m.HostPath,
+						ReadOnly:      m.ReadOnly,
 					})
 				}
 			}
@@ -585,6 +582,22 @@ func (plugin *NvidiaDevicePlugin) Allocate(ctx context.Context, reqs *kubeletdev
 	return &responses, nil
 }
 
+// hamiCoreConfig returns how hami-core is injected into the containers this plugin allocates.
+func (plugin *NvidiaDevicePlugin) hamiCoreConfig() nvidia.HAMiCoreConfig {
+	cfg := nvidia.HAMiCoreConfig{
+		HookPath:         hostHookPath,
+		LibPath:          GetLibPath(),
+		DisableCoreLimit: plugin.schedulerConfig.DisableCoreLimit,
+	}
+	if plugin.schedulerConfig.DeviceMemoryScaling != nil {
+		cfg.MemoryScaling = *plugin.schedulerConfig.DeviceMemoryScaling
+	}
+	if plugin.schedulerConfig.LogLevel != nil {
+		cfg.LogLevel = *plugin.schedulerConfig.LogLevel
+	}
+	return cfg
+}
+
 func (plugin *NvidiaDevicePlugin) getAllocateResponse(requestIds []string) (*kubeletdevicepluginv1beta1.ContainerAllocateResponse, error) {
 	deviceIDs := plugin.deviceIDsFromAnnotatedDeviceIDs(requestIds)
 
@@ -90,6 +90,14 @@ func EraseNextDeviceTypeFromAnnotation(dtype string, p corev1.Pod) error {
 	klog.Infoln("After erase res=", res)
 	newannos := make(map[string]string)
 	newannos[util.InRequestDevices[dtype]] = util.EncodePodSingleDevice(res)
+	// Keep the encoding the scheduler chose, whatever this plugin defaults to.
+	if util.IsJSONPodDevices(p.Annotations[util.InRequestDevices[dtype]]) {
+		encoded, err := util.MarshalPodSingleDevice(res)
+		if err != nil {
+			return err
+		}
+		newannos[util.InRequestDevices[dtype]] = encoded
+	}
 	return util.PatchPodAnnotations(&p, newannos)
 }
 
@@ -416,6 +424,8 @@ func (nv *NvidiaDevicePlugin) GetContainerDeviceStrArray(c util.ContainerDevices
 	tmp := []string{}
 	needsreset := false
 	position := 0
+	nv.migMutex.Lock()
+	defer nv.migMutex.Unlock()
 	for _, val := range c {
 		if !strings.Contains(val.UUID, "[") {
 			tmp = append(tmp, val.UUID)
@@ -425,6 +435,9 @@ func (nv *NvidiaDevicePlugin) GetContainerDeviceStrArray(c util.ContainerDevices
 			if needsreset {
 				nv.ApplyMigTemplate()
 			}
+			if tidx, _, err := util.ExtractMigTemplatesFromUUID(val.UUID); err == nil && position >= 0 {
+				nv.setMigGeometry(strings.Split(val.UUID, "[")[0], int32(tidx))
+			}
 			tmp = append(tmp, GetMigUUIDFromIndex(val.UUID, position))
 		}
 	}
@@ -87,9 +87,9 @@ func InitDevices(config []VNPUConfig) []*Devices {
 		sort.Slice(dev.config.Templates, func(i, j int) bool {
 			return dev.config.Templates[i].Memory < dev.config.Templates[j].Memory
 		})
-		util.InRequestDevices[commonWord] = fmt.Sprintf("hami.io/%s-devices-to-allocate", commonWord)
-		util.SupportDevices[commonWord] = fmt.Sprintf("hami.io/%s-devices-allocated", commonWord)
-		util.HandshakeAnnos[commonWord] = dev.handshakeAnno
+		util.SetDeviceAnno(util.InRequestDevices, commonWord, fmt.Sprintf("hami.io/%s-devices-to-allocate", commonWord))
+		util.SetDeviceAnno(util.SupportDevices, commonWord, fmt.Sprintf("hami.io/%s-devices-allocated", commonWord))
+		util.SetDeviceAnno(util.HandshakeAnnos, commonWord, dev.handshakeAnno)
 		devs = append(devs, dev)
 		klog.Infof("load ascend vnpu config %s: %v", commonWord, dev.config)
 	}
@@ -60,7 +60,7 @@ type AWSNeuronConfig struct {
 }
 
 func InitAWSNeuronDevice(config AWSNeuronConfig) *AWSNeuronDevices {
-	util.SupportDevices[AWSNeuronDevice] = "hami.io/aws-neuron-devices-allocated"
+	util.SetDeviceAnno(util.SupportDevices, AWSNeuronDevice, "hami.io/aws-neuron-devices-allocated")
 	return &AWSNeuronDevices{
 		resourceCountName: config.ResourceCountName,
 		resourceCoreName:  config.ResourceCoreName,
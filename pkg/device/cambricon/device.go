@@ -80,8 +80,8 @@ func InitMLUDevice(config CambriconConfig) *CambriconDevices {
 	MLUResourceCount = config.ResourceCountName
 	MLUResourceMemory = config.ResourceMemoryName
 	MLUResourceCores = config.ResourceCoreName
-	util.InRequestDevices[CambriconMLUDevice] = "hami.io/cambricon-mlu-devices-to-allocate"
-	util.SupportDevices[CambriconMLUDevice] = "hami.io/cambricon-mlu-devices-allocated"
+	util.SetDeviceAnno(util.InRequestDevices, CambriconMLUDevice, "hami.io/cambricon-mlu-devices-to-allocate")
+	util.SetDeviceAnno(util.SupportDevices, CambriconMLUDevice, "hami.io/cambricon-mlu-devices-allocated")
 	return &CambriconDevices{}
 }
 
@@ -27,7 +27,9 @@ const (
 	CardTimeSlicingExhausted          = "CardTimeSlicingExhausted"
 	CardComputeUnitsExhausted         = "CardComputeUnitsExhausted"
 	CardInsufficientMemory            = "CardInsufficientMemory"
+	CardInsufficientPhysicalMemory    = "CardInsufficientPhysicalMemory"
 	CardInsufficientCore              = "CardInsufficientCore"
+	CardMpsMultipleDevices            = "CardMpsMultipleDevices"
 	NumaNotFit                        = "NumaNotFit"
 	ExclusiveDeviceAllocateConflict   = "ExclusiveDeviceAllocateConflict"
 	CardNotFoundCustomFilterRule      = "CardNotFoundCustomFilterRule"
@@ -35,6 +37,7 @@ const (
 	AllocatedCardsInsufficientRequest = "AllocatedCardsInsufficientRequest"
 	NodeUnfitPod                      = "NodeUnfitPod"
 	NodeFitPod                        = "NodeFitPod"
+	PluginUnavailable                 = "PluginUnavailable"
 )
 
 func GenReason(reasons map[string]int, cards int) string {
@@ -22,7 +22,9 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/Project-HAMi/HAMi/pkg/device/ascend"
 	"github.com/Project-HAMi/HAMi/pkg/device/awsneuron"
@@ -34,6 +36,9 @@ import (
 	"github.com/Project-HAMi/HAMi/pkg/device/metax"
 	"github.com/Project-HAMi/HAMi/pkg/device/mthreads"
 	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/device/profile"
+	"github.com/Project-HAMi/HAMi/pkg/device/remote"
+	schedulerconfig "github.com/Project-HAMi/HAMi/pkg/scheduler/config"
 	"github.com/Project-HAMi/HAMi/pkg/util"
 	"github.com/Project-HAMi/HAMi/pkg/util/client"
 	"github.com/Project-HAMi/HAMi/pkg/util/nodelock"
@@ -72,21 +77,59 @@ type Config struct {
 	KunlunConfig    kunlun.KunlunConfig       `yaml:"kunlun"`
 	AWSNeuronConfig awsneuron.AWSNeuronConfig `yaml:"awsneuron"`
 	VNPUs           []ascend.VNPUConfig       `yaml:"vnpus"`
+	// Profiles declare the devices supported without a Go package.
+	Profiles []profile.Profile `yaml:"profiles"`
+	// RemoteVendors declare the devices served by out-of-tree plugins over gRPC.
+	RemoteVendors []remote.Config `yaml:"remoteVendors"`
+	// Scheduler holds the scheduler settings overriding its flags, which can be reloaded at runtime.
+	Scheduler schedulerconfig.Reloadable `yaml:"scheduler"`
 }
 
 var (
-	HandshakeAnnos  = map[string]string{}
-	RegisterAnnos   = map[string]string{}
+	HandshakeAnnos = map[string]string{}
+	RegisterAnnos  = map[string]string{}
+	// devicesLock guards devicesMap, DevicesToHandle, currentConfig and
+	// configVersion, which are replaced as a whole when the config is
+	// reloaded, never modified.
+	devicesLock     sync.RWMutex
 	devicesMap      map[string]Devices
 	DevicesToHandle []string
+	currentConfig   *Config
+	configVersion   string
 	configFile      string
 	DebugMode       bool
 )
 
 func GetDevices() map[string]Devices {
+	devicesLock.RLock()
+	defer devicesLock.RUnlock()
 	return devicesMap
 }
 
+// devicesToHandle returns the common words of the initialized devices.
+func devicesToHandle() []string {
+	devicesLock.RLock()
+	defer devicesLock.RUnlock()
+	return DevicesToHandle
+}
+
+// ConfigVersion returns the version of the config the devices were initialized with.
+func ConfigVersion() string {
+	devicesLock.RLock()
+	defer devicesLock.RUnlock()
+	return configVersion
+}
+
+// setDevices swaps in the devices and the scheduler settings of a config.
+func setDevices(config *Config, devices map[string]Devices, toHandle []string) {
+	version := configHash(config)
+	devicesLock.Lock()
+	devicesMap, DevicesToHandle, currentConfig, configVersion = devices, toHandle, config, version
+	devicesLock.Unlock()
+	schedulerconfig.SetReloadable(&config.Scheduler)
+	nodelock.SetNodeLockTimeout(config.Scheduler.NodeLockTimeout)
+}
+
 func InitDevicesWithConfig(config *Config) error {
 	if err := validateConfig(config); err != nil {
 		klog.Errorf("Invalid configuration: %v", err)
@@ -95,12 +138,35 @@ func InitDevicesWithConfig(config *Config) error {
 
 	klog.Info("Initializing devices with configuration")
 
-	devicesMap = make(map[string]Devices)
-	DevicesToHandle = []string{}
+	devices, toHandle, err := newDevices(config, nil, nil)
+	setDevices(config, devices, toHandle)
+	if err != nil {
+		return err
+	}
+	klog.Info("All devices initialized successfully")
+	return nil
+}
+
+// newDevices initializes the devices of a config. The devices initialized
+// successfully are returned along with the errors of the others. The current
+// devices whose section is the same in the previous config are kept, with the
+// state they learnt from the nodes.
+func newDevices(config *Config, previous *Config, current map[string]Devices) (map[string]Devices, []string, error) {
+	devices := make(map[string]Devices)
+	toHandle := []string{}
 	var initErrors []error
+	var previousConfigs map[string]any
+	if previous != nil {
+		previousConfigs = vendorConfigs(previous)
+	}
 
 	// Helper function to initialize devices and handle errors
 	initializeDevice := func(deviceType string, commonWord string, initFunc func(any) (Devices, error), config any) {
+		if device, ok := current[deviceType]; ok && reflect.DeepEqual(config, previousConfigs[deviceType]) {
+			devices[deviceType] = device
+			toHandle = append(toHandle, commonWord)
+			return
+		}
 		klog.Infof("Initializing %s device", commonWord)
 		device, err := initFunc(config)
 		if err != nil {
@@ -108,8 +174,8 @@ func InitDevicesWithConfig(config *Config) error {
 			initErrors = append(initErrors, fmt.Errorf("%s: %v", commonWord, err))
 			return
 		}
-		devicesMap[deviceType] = device
-		DevicesToHandle = append(DevicesToHandle, commonWord)
+		devices[deviceType] = device
+		toHandle = append(toHandle, commonWord)
 		klog.Infof("%s device initialized successfully", commonWord)
 	}
 
@@ -198,23 +264,126 @@ func InitDevicesWithConfig(config *Config) error {
 	}
 
 	// Initialize Ascend devices
-	for _, dev := range ascend.InitDevices(config.VNPUs) {
-		commonWord := dev.CommonWord()
-		devicesMap[commonWord] = dev
-		DevicesToHandle = append(DevicesToHandle, commonWord)
-		klog.Infof("Ascend device %s initialized", commonWord)
+	if previous != nil && reflect.DeepEqual(config.VNPUs, previous.VNPUs) {
+		for _, vnpu := range config.VNPUs {
+			if dev, ok := current[vnpu.CommonWord]; ok {
+				devices[vnpu.CommonWord] = dev
+				toHandle = append(toHandle, vnpu.CommonWord)
+			}
+		}
+	} else {
+		for _, dev := range ascend.InitDevices(config.VNPUs) {
+			commonWord := dev.CommonWord()
+			devices[commonWord] = dev
+			toHandle = append(toHandle, commonWord)
+			klog.Infof("Ascend device %s initialized", commonWord)
+		}
+	}
+
+	// Initialize the devices of the profiles
+	if previous != nil && reflect.DeepEqual(config.Profiles, previous.Profiles) {
+		for _, p := range config.Profiles {
+			if dev, ok := current[p.Name]; ok {
+				devices[p.Name] = dev
+				toHandle = append(toHandle, p.Name)
+			}
+		}
+	} else {
+		for _, dev := range profile.InitDevices(config.Profiles) {
+			commonWord := dev.CommonWord()
+			devices[commonWord] = dev
+			toHandle = append(toHandle, commonWord)
+			klog.Infof("Profile device %s initialized", commonWord)
+		}
+	}
+
+	// Connect to the plugins of the remote vendors
+	for _, vendor := range config.RemoteVendors {
+		if dev, ok := current[vendor.Name]; ok && previous != nil && slices.ContainsFunc(previous.RemoteVendors, func(c remote.Config) bool {
+			return reflect.DeepEqual(c, vendor)
+		}) {
+			devices[vendor.Name] = dev
+			toHandle = append(toHandle, vendor.Name)
+			continue
+		}
+		dev, err := remote.NewDevices(vendor)
+		if err != nil {
+			klog.Errorf("Failed to initialize remote vendor %s: %v", vendor.Name, err)
+			initErrors = append(initErrors, err)
+			continue
+		}
+		devices[vendor.Name] = dev
+		toHandle = append(toHandle, vendor.Name)
+		klog.Infof("Remote vendor %s initialized, endpoint %s", vendor.Name, vendor.Endpoint)
 	}
 
 	if len(initErrors) > 0 {
-		return fmt.Errorf("errors occurred during initialization: %v", initErrors)
+		return devices, toHandle, fmt.Errorf("errors occurred during initialization: %v", initErrors)
 	}
+	return devices, toHandle, nil
+}
 
-	klog.Info("All devices initialized successfully")
+// vendorConfigs returns the section of a config of each device type.
+func vendorConfigs(config *Config) map[string]any {
+	return map[string]any{
+		nvidia.NvidiaGPUDevice:       config.NvidiaConfig,
+		cambricon.CambriconMLUDevice: config.CambriconConfig,
+		hygon.HygonDCUDevice:         config.HygonConfig,
+		iluvatar.IluvatarGPUDevice:   config.IluvatarConfig,
+		enflame.EnflameGPUDevice:     config.EnflameConfig,
+		mthreads.MthreadsGPUDevice:   config.MthreadsConfig,
+		metax.MetaxGPUDevice:         config.MetaxConfig,
+		metax.MetaxSGPUDevice:        config.MetaxConfig,
+		kunlun.KunlunGPUDevice:       config.KunlunConfig,
+		awsneuron.AWSNeuronDevice:    config.AWSNeuronConfig,
+	}
+}
+
+// closeRemoteVendors closes the connections of the remote vendors of devices
+// that are not kept in another map of devices.
+func closeRemoteVendors(devices map[string]Devices, kept map[string]Devices) {
+	for name, dev := range devices {
+		if rv, ok := dev.(*remote.Devices); ok && kept[name] != dev {
+			if err := rv.Close(); err != nil {
+				klog.ErrorS(err, "Failed to close remote vendor", "vendor", name)
+			}
+		}
+	}
+}
+
+// validateDeviceTypes checks the profiles and the remote vendors, whose names
+// must differ from each other and from the device types of the vendor packages.
+func validateDeviceTypes(config *Config) error {
+	names := make(map[string]bool)
+	for deviceType := range vendorConfigs(config) {
+		names[deviceType] = true
+	}
+	for _, vnpu := range config.VNPUs {
+		names[vnpu.CommonWord] = true
+	}
+	for _, p := range config.Profiles {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		if names[p.Name] {
+			return fmt.Errorf("profile %s: the device type is already used", p.Name)
+		}
+		names[p.Name] = true
+	}
+	for _, vendor := range config.RemoteVendors {
+		if err := vendor.Validate(); err != nil {
+			return err
+		}
+		if names[vendor.Name] {
+			return fmt.Errorf("remote vendor %s: the device type is already used", vendor.Name)
+		}
+		names[vendor.Name] = true
+	}
 	return nil
 }
 
 func InitDevices() {
-	if len(devicesMap) > 0 {
+	if len(GetDevices()) > 0 {
 		klog.Info("Devices are already initialized, skipping initialization")
 		return
 	}
@@ -384,7 +553,7 @@ func PodAllocationTrySuccess(nodeName string, devName string, lockName string, p
 	}
 	annos := refreshed.Annotations[util.InRequestDevices[devName]]
 	klog.Infof("Trying allocation success: %s", annos)
-	for _, val := range DevicesToHandle {
+	for _, val := range devicesToHandle() {
 		if strings.Contains(annos, val) {
 			return
 		}
@@ -459,9 +628,14 @@ func validateConfig(config *Config) error {
 	hasAnyConfig = hasAnyConfig || !reflect.DeepEqual(config.KunlunConfig, kunlun.KunlunConfig{})
 	hasAnyConfig = hasAnyConfig || !reflect.DeepEqual(config.AWSNeuronConfig, awsneuron.AWSNeuronConfig{})
 	hasAnyConfig = hasAnyConfig || len(config.VNPUs) > 0
+	hasAnyConfig = hasAnyConfig || len(config.Profiles) > 0
+	hasAnyConfig = hasAnyConfig || len(config.RemoteVendors) > 0
 
 	if !hasAnyConfig {
 		return fmt.Errorf("all configurations are empty")
 	}
-	return nil
+	if err := validateDeviceTypes(config); err != nil {
+		return err
+	}
+	return config.Scheduler.Validate()
 }
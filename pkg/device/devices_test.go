@@ -39,6 +39,8 @@ import (
 	"github.com/Project-HAMi/HAMi/pkg/device/metax"
 	"github.com/Project-HAMi/HAMi/pkg/device/mthreads"
 	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/device/profile"
+	"github.com/Project-HAMi/HAMi/pkg/device/remote"
 	"github.com/Project-HAMi/HAMi/pkg/util"
 	"github.com/Project-HAMi/HAMi/pkg/util/client"
 )
@@ -570,3 +572,32 @@ func Test_validateConfig(t *testing.T) {
 		})
 	}
 }
+
+func Test_validateDeviceTypes(t *testing.T) {
+	acme := profile.Profile{Name: "ACME", ResourceCountName: "acme.com/gpu", Topology: profile.Topology{Source: profile.TopologyAnnotation}}
+	beta := remote.Config{Name: "BETA", Endpoint: "dns:///beta-plugin:9443", ResourceCountName: "beta.com/gpu"}
+	tests := []struct {
+		name     string
+		profiles []profile.Profile
+		vendors  []remote.Config
+		err      string
+	}{
+		{"valid profile", []profile.Profile{acme}, nil, ""},
+		{"name of a vendor package", []profile.Profile{{Name: hygon.HygonDCUDevice, ResourceCountName: "acme.com/dcu", Topology: acme.Topology}}, nil, "the device type is already used"},
+		{"duplicate profiles", []profile.Profile{acme, acme}, nil, "the device type is already used"},
+		{"invalid profile", []profile.Profile{{Name: "ACME", Topology: acme.Topology}}, nil, "resourceCountName is required"},
+		{"profile and remote vendor", []profile.Profile{acme}, []remote.Config{beta}, ""},
+		{"remote vendor named as a profile", []profile.Profile{acme}, []remote.Config{{Name: "ACME", Endpoint: beta.Endpoint, ResourceCountName: "beta.com/gpu"}}, "the device type is already used"},
+		{"remote vendor without endpoint", nil, []remote.Config{{Name: "BETA", ResourceCountName: "beta.com/gpu"}}, "endpoint is required"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateConfig(&Config{Profiles: test.profiles, RemoteVendors: test.vendors})
+			if test.err == "" {
+				assert.NilError(t, err)
+			} else {
+				assert.ErrorContains(t, err, test.err)
+			}
+		})
+	}
+}
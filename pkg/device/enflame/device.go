@@ -67,7 +67,7 @@ type EnflameConfig struct {
 func InitEnflameDevice(config EnflameConfig) *EnflameDevices {
 	EnflameResourceCount = config.ResourceCountName
 	EnflameResourcePercentage = config.ResourcePercentageName
-	util.SupportDevices[EnflameGPUDevice] = "hami.io/enflame-vgpu-devices-allocated"
+	util.SetDeviceAnno(util.SupportDevices, EnflameGPUDevice, "hami.io/enflame-vgpu-devices-allocated")
 	return &EnflameDevices{
 		factor: 0,
 	}
@@ -67,9 +67,9 @@ func InitDCUDevice(config HygonConfig) *DCUDevices {
 	HygonResourceCount = config.ResourceCountName
 	HygonResourceMemory = config.ResourceMemoryName
 	HygonResourceCores = config.ResourceCoreName
-	util.InRequestDevices[HygonDCUDevice] = "hami.io/dcu-devices-to-allocate"
-	util.SupportDevices[HygonDCUDevice] = "hami.io/dcu-devices-allocated"
-	util.HandshakeAnnos[HygonDCUDevice] = HandshakeAnnos
+	util.SetDeviceAnno(util.InRequestDevices, HygonDCUDevice, "hami.io/dcu-devices-to-allocate")
+	util.SetDeviceAnno(util.SupportDevices, HygonDCUDevice, "hami.io/dcu-devices-allocated")
+	util.SetDeviceAnno(util.HandshakeAnnos, HygonDCUDevice, HandshakeAnnos)
 	return &DCUDevices{}
 }
 
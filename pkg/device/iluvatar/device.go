@@ -61,8 +61,8 @@ func InitIluvatarDevice(config IluvatarConfig) *IluvatarDevices {
 	IluvatarResourceCount = config.ResourceCountName
 	IluvatarResourceMemory = config.ResourceMemoryName
 	IluvatarResourceCores = config.ResourceCoreName
-	util.InRequestDevices[IluvatarGPUDevice] = "hami.io/iluvatar-vgpu-devices-to-allocate"
-	util.SupportDevices[IluvatarGPUDevice] = "hami.io/iluvatar-vgpu-devices-allocated"
+	util.SetDeviceAnno(util.InRequestDevices, IluvatarGPUDevice, "hami.io/iluvatar-vgpu-devices-to-allocate")
+	util.SetDeviceAnno(util.SupportDevices, IluvatarGPUDevice, "hami.io/iluvatar-vgpu-devices-allocated")
 	return &IluvatarDevices{}
 }
 
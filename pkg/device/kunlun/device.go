@@ -56,7 +56,7 @@ type KunlunConfig struct {
 
 func InitKunlunDevice(config KunlunConfig) *KunlunDevices {
 	KunlunResourceCount = config.ResourceCountName
-	util.SupportDevices[KunlunGPUDevice] = "hami.io/kunlun-allocated"
+	util.SetDeviceAnno(util.SupportDevices, KunlunGPUDevice, "hami.io/kunlun-allocated")
 	return &KunlunDevices{}
 }
 
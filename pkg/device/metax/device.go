@@ -45,8 +45,8 @@ var (
 
 func InitMetaxDevice(config MetaxConfig) *MetaxDevices {
 	MetaxResourceCount = config.ResourceCountName
-	util.InRequestDevices[MetaxGPUDevice] = "hami.io/metax-gpu-devices-to-allocate"
-	util.SupportDevices[MetaxGPUDevice] = "hami.io/metax-gpu-devices-allocated"
+	util.SetDeviceAnno(util.InRequestDevices, MetaxGPUDevice, "hami.io/metax-gpu-devices-to-allocate")
+	util.SetDeviceAnno(util.SupportDevices, MetaxGPUDevice, "hami.io/metax-gpu-devices-allocated")
 	return &MetaxDevices{}
 }
 
@@ -59,8 +59,8 @@ func InitMetaxSDevice(config MetaxConfig) *MetaxSDevices {
 	MetaxResourceNameVMemory = config.ResourceVMemoryName
 	MetaxTopologyAware = config.TopologyAware
 
-	util.InRequestDevices[MetaxSGPUDevice] = "hami.io/metax-sgpu-devices-to-allocate"
-	util.SupportDevices[MetaxSGPUDevice] = "hami.io/metax-sgpu-devices-allocated"
+	util.SetDeviceAnno(util.InRequestDevices, MetaxSGPUDevice, "hami.io/metax-sgpu-devices-to-allocate")
+	util.SetDeviceAnno(util.SupportDevices, MetaxSGPUDevice, "hami.io/metax-sgpu-devices-allocated")
 
 	return &MetaxSDevices{
 		jqCache: NewJitteryQosCache(),
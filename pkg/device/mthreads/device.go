@@ -68,8 +68,8 @@ func InitMthreadsDevice(config MthreadsConfig) *MthreadsDevices {
 	MthreadsResourceCount = config.ResourceCountName
 	MthreadsResourceCores = config.ResourceCoreName
 	MthreadsResourceMemory = config.ResourceMemoryName
-	util.InRequestDevices[MthreadsGPUDevice] = "hami.io/mthreads-vgpu-devices-to-allocate"
-	util.SupportDevices[MthreadsGPUDevice] = "hami.io/mthreads-vgpu-devices-allocated"
+	util.SetDeviceAnno(util.InRequestDevices, MthreadsGPUDevice, "hami.io/mthreads-vgpu-devices-to-allocate")
+	util.SetDeviceAnno(util.SupportDevices, MthreadsGPUDevice, "hami.io/mthreads-vgpu-devices-allocated")
 	return &MthreadsDevices{}
 }
 
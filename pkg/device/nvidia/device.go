@@ -38,6 +38,7 @@ import (
 const (
 	HandshakeAnnos       = "hami.io/node-handshake"
 	RegisterAnnos        = "hami.io/node-nvidia-register"
+	AllocatedAnnos       = "hami.io/vgpu-devices-allocated"
 	RegisterGPUPairScore = "hami.io/node-nvidia-score"
 	NvidiaGPUDevice      = "NVIDIA"
 	NvidiaGPUCommonWord  = "GPU"
@@ -160,9 +161,9 @@ type NvidiaGPUDevices struct {
 
 func InitNvidiaDevice(nvconfig NvidiaConfig) *NvidiaGPUDevices {
 	klog.InfoS("initializing nvidia device", "resourceName", nvconfig.ResourceCountName, "resourceMem", nvconfig.ResourceMemoryName, "DefaultGPUNum", nvconfig.DefaultGPUNum)
-	util.InRequestDevices[NvidiaGPUDevice] = "hami.io/vgpu-devices-to-allocate"
-	util.SupportDevices[NvidiaGPUDevice] = "hami.io/vgpu-devices-allocated"
-	util.HandshakeAnnos[NvidiaGPUDevice] = HandshakeAnnos
+	util.SetDeviceAnno(util.InRequestDevices, NvidiaGPUDevice, "hami.io/vgpu-devices-to-allocate")
+	util.SetDeviceAnno(util.SupportDevices, NvidiaGPUDevice, AllocatedAnnos)
+	util.SetDeviceAnno(util.HandshakeAnnos, NvidiaGPUDevice, HandshakeAnnos)
 	return &NvidiaGPUDevices{
 		config: nvconfig,
 	}
@@ -503,6 +504,10 @@ func (dev *NvidiaGPUDevices) CustomFilterRule(allocated *util.PodDevices, reques
 	}
 	deviceUsageCurrent.UsageList = append(deviceUsageCurrent.UsageList, deviceUsageSnapshot.UsageList...)
 	if device.Mode == MigMode {
+		// An idle device set to a geometry the request does not fit is partitioned again.
+		if MigDeviceIdle(device) && !migFits(deviceUsageCurrent.UsageList, request.Memreq) {
+			deviceUsageCurrent.UsageList = make(util.MIGS, 0)
+		}
 		if len(deviceUsageCurrent.UsageList) == 0 {
 			tmpfound := false
 			for tidx, templates := range device.MigTemplate {
@@ -549,14 +554,16 @@ func (dev *NvidiaGPUDevices) ScoreNode(node *corev1.Node, podDevices util.PodSin
 	return 0
 }
 
-func (dev *NvidiaGPUDevices) migNeedsReset(n *util.DeviceUsage) bool {
+func (dev *NvidiaGPUDevices) migNeedsReset(n *util.DeviceUsage, memreq int32) bool {
 	if len(n.MigUsage.UsageList) == 0 {
 		return true
 	}
-	for _, val := range n.MigUsage.UsageList {
-		if val.InUse {
-			return false
-		}
+	if !MigDeviceIdle(n) {
+		return false
+	}
+	// An idle device keeps the geometry it is set to if the container fits it.
+	if migFits(n.MigUsage.UsageList, memreq) {
+		return false
 	}
 	n.MigUsage.UsageList = make(util.MIGS, 0)
 	return true
@@ -565,7 +572,7 @@ func (dev *NvidiaGPUDevices) migNeedsReset(n *util.DeviceUsage) bool {
 func (dev *NvidiaGPUDevices) AddResourceUsage(pod *corev1.Pod, n *util.DeviceUsage, ctr *util.ContainerDevice) error {
 	n.Used++
 	if n.Mode == MigMode {
-		if dev.migNeedsReset(n) {
+		if dev.migNeedsReset(n, ctr.Usedmem) {
 			for tidx, templates := range n.MigTemplate {
 				if templates[0].Memory < ctr.Usedmem {
 					continue
@@ -600,9 +607,20 @@ func (dev *NvidiaGPUDevices) AddResourceUsage(pod *corev1.Pod, n *util.DeviceUsa
 	}
 	n.Usedcores += ctr.Usedcores
 	n.Usedmem += ctr.Usedmem
+	if !util.ToleratesMemoryOversubscription(pod) {
+		n.Guaranteedmem += ctr.Usedmem
+	}
 	return nil
 }
 
+// physicalMemory is the memory of a device that is not backed by host memory.
+func physicalMemory(d *util.DeviceUsage) int32 {
+	if d.Physmem > 0 && d.Physmem < d.Totalmem {
+		return d.Physmem
+	}
+	return d.Totalmem
+}
+
 func (nv *NvidiaGPUDevices) Fit(devices []*util.DeviceUsage, request util.ContainerDeviceRequest, annos map[string]string, pod *corev1.Pod, nodeInfo *util.NodeInfo, allocated *util.PodDevices) (bool, map[string]util.ContainerDevices, string) {
 	k := request
 	originReq := k.Nums
@@ -611,7 +629,8 @@ func (nv *NvidiaGPUDevices) Fit(devices []*util.DeviceUsage, request util.Contai
 	var tmpDevs map[string]util.ContainerDevices
 	tmpDevs = make(map[string]util.ContainerDevices)
 	reason := make(map[string]int)
-	needTopology := util.GetGPUSchedulerPolicyByPod(config.GPUSchedulerPolicy, pod) == util.GPUSchedulerPolicyTopology.String()
+	needTopology := util.GetGPUSchedulerPolicyByPod(config.Current().GPUSchedulerPolicy, pod) == util.GPUSchedulerPolicyTopology.String()
+	guaranteed := !util.ToleratesMemoryOversubscription(pod)
 	for i := len(devices) - 1; i >= 0; i-- {
 		dev := devices[i]
 		klog.V(4).InfoS("scoring pod", "pod", klog.KObj(pod), "device", dev.ID, "Memreq", k.Memreq, "MemPercentagereq", k.MemPercentagereq, "Coresreq", k.Coresreq, "Nums", k.Nums, "device index", i)
@@ -636,6 +655,12 @@ func (nv *NvidiaGPUDevices) Fit(devices []*util.DeviceUsage, request util.Contai
 			klog.V(5).InfoS(common.CardUUIDMismatch, "pod", klog.KObj(pod), "device", dev.ID, "current device info is:", *dev)
 			continue
 		}
+		// An MPS client connects to the control daemon of a single device.
+		if dev.Mode == MpsMode && originReq > 1 {
+			reason[common.CardMpsMultipleDevices]++
+			klog.V(5).InfoS(common.CardMpsMultipleDevices, "pod", klog.KObj(pod), "device", dev.ID, "request", originReq)
+			continue
+		}
 
 		memreq := int32(0)
 		if dev.Count <= dev.Used {
@@ -653,13 +678,24 @@ func (nv *NvidiaGPUDevices) Fit(devices []*util.DeviceUsage, request util.Contai
 		}
 		if k.MemPercentagereq != 101 && k.Memreq == 0 {
 			//This incurs an issue
-			memreq = dev.Totalmem * k.MemPercentagereq / 100
+			if guaranteed {
+				memreq = physicalMemory(dev) * k.MemPercentagereq / 100
+			} else {
+				memreq = dev.Totalmem * k.MemPercentagereq / 100
+			}
 		}
 		if dev.Totalmem-dev.Usedmem < memreq {
 			reason[common.CardInsufficientMemory]++
 			klog.V(5).InfoS(common.CardInsufficientMemory, "pod", klog.KObj(pod), "device", dev.ID, "device index", i, "device total memory", dev.Totalmem, "device used memory", dev.Usedmem, "request memory", memreq)
 			continue
 		}
+		// Pods that do not tolerate oversubscription only get physical memory,
+		// and no host memory is handed out on the devices they use.
+		if (guaranteed || dev.Guaranteedmem > 0) && physicalMemory(dev)-dev.Usedmem < memreq {
+			reason[common.CardInsufficientPhysicalMemory]++
+			klog.V(5).InfoS(common.CardInsufficientPhysicalMemory, "pod", klog.KObj(pod), "device", dev.ID, "device index", i, "device physical memory", physicalMemory(dev), "device used memory", dev.Usedmem, "device guaranteed memory", dev.Guaranteedmem, "request memory", memreq)
+			continue
+		}
 		if dev.Totalcore-dev.Usedcores < k.Coresreq {
 			reason[common.CardInsufficientCore]++
 			klog.V(5).InfoS(common.CardInsufficientCore, "pod", klog.KObj(pod), "device", dev.ID, "device index", i, "device total core", dev.Totalcore, "device used core", dev.Usedcores, "request cores", k.Coresreq)
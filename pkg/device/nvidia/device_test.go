@@ -985,6 +985,42 @@ func TestDevices_Fit(t *testing.T) {
 			wantDevIDs: []string{"dev-0"},
 			wantReason: "",
 		},
+		{
+			name: "fit fail: mps devices for a multi-device request",
+			devices: []*util.DeviceUsage{
+				{
+					ID:        "dev-0",
+					Index:     0,
+					Count:     100,
+					Totalmem:  1280,
+					Totalcore: 100,
+					Mode:      MpsMode,
+					Type:      NvidiaGPUDevice,
+					Health:    true,
+				},
+				{
+					ID:        "dev-1",
+					Index:     1,
+					Count:     100,
+					Totalmem:  1280,
+					Totalcore: 100,
+					Mode:      MpsMode,
+					Type:      NvidiaGPUDevice,
+					Health:    true,
+				},
+			},
+			request: util.ContainerDeviceRequest{
+				Nums:     2,
+				Memreq:   64,
+				Coresreq: 20,
+				Type:     NvidiaGPUDevice,
+			},
+			annos:      map[string]string{},
+			wantFit:    false,
+			wantLen:    0,
+			wantDevIDs: []string{},
+			wantReason: "2/2 CardMpsMultipleDevices",
+		},
 	}
 
 	for _, test := range tests {
@@ -1012,6 +1048,103 @@ func TestDevices_Fit(t *testing.T) {
 	}
 }
 
+func TestDevices_Fit_oversubscription(t *testing.T) {
+	dev := InitNvidiaDevice(NvidiaConfig{})
+	tolerant := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{util.MemoryOversubscriptionAnnotation: "true"}}}
+
+	tests := []struct {
+		name       string
+		device     util.DeviceUsage
+		pod        *corev1.Pod
+		request    util.ContainerDeviceRequest
+		wantFit    bool
+		wantMem    int32
+		wantReason string
+	}{
+		{
+			name:    "guaranteed pod gets physical memory",
+			device:  util.DeviceUsage{Usedmem: 1000, Totalmem: 8000, Physmem: 4000},
+			pod:     &corev1.Pod{},
+			request: util.ContainerDeviceRequest{Nums: 1, Memreq: 3000},
+			wantFit: true,
+			wantMem: 3000,
+		},
+		{
+			name:       "guaranteed pod does not get host memory",
+			device:     util.DeviceUsage{Usedmem: 1000, Totalmem: 8000, Physmem: 4000},
+			pod:        &corev1.Pod{},
+			request:    util.ContainerDeviceRequest{Nums: 1, Memreq: 3001},
+			wantReason: "1/1 CardInsufficientPhysicalMemory",
+		},
+		{
+			name:       "guaranteed pod does not land on an oversubscribed device",
+			device:     util.DeviceUsage{Usedmem: 5000, Totalmem: 8000, Physmem: 4000},
+			pod:        &corev1.Pod{},
+			request:    util.ContainerDeviceRequest{Nums: 1, Memreq: 100},
+			wantReason: "1/1 CardInsufficientPhysicalMemory",
+		},
+		{
+			name:    "guaranteed memory percentage is of the physical memory",
+			device:  util.DeviceUsage{Totalmem: 8000, Physmem: 4000},
+			pod:     &corev1.Pod{},
+			request: util.ContainerDeviceRequest{Nums: 1, MemPercentagereq: 50},
+			wantFit: true,
+			wantMem: 2000,
+		},
+		{
+			name:    "tolerant pod gets host memory",
+			device:  util.DeviceUsage{Usedmem: 1000, Totalmem: 8000, Physmem: 4000},
+			pod:     tolerant,
+			request: util.ContainerDeviceRequest{Nums: 1, Memreq: 6000},
+			wantFit: true,
+			wantMem: 6000,
+		},
+		{
+			name:       "tolerant pod does not oversubscribe a device of guaranteed pods",
+			device:     util.DeviceUsage{Usedmem: 1000, Guaranteedmem: 1000, Totalmem: 8000, Physmem: 4000},
+			pod:        tolerant,
+			request:    util.ContainerDeviceRequest{Nums: 1, Memreq: 6000},
+			wantReason: "1/1 CardInsufficientPhysicalMemory",
+		},
+		{
+			name:       "tolerant pod is capped by the registered memory",
+			device:     util.DeviceUsage{Usedmem: 1000, Totalmem: 8000, Physmem: 4000},
+			pod:        tolerant,
+			request:    util.ContainerDeviceRequest{Nums: 1, Memreq: 7001},
+			wantReason: "1/1 CardInsufficientMemory",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := test.device
+			d.ID = "dev-0"
+			d.Count = 10
+			d.Totalcore = 100
+			d.Type = NvidiaGPUDevice
+			d.Health = true
+			test.request.Type = NvidiaGPUDevice
+			fit, result, reason := dev.Fit([]*util.DeviceUsage{&d}, test.request, map[string]string{}, test.pod, &util.NodeInfo{}, &util.PodDevices{})
+			assert.Equal(t, test.wantFit, fit)
+			assert.Equal(t, test.wantReason, reason)
+			if test.wantFit {
+				assert.Equal(t, test.wantMem, result[NvidiaGPUDevice][0].Usedmem)
+			}
+		})
+	}
+}
+
+func TestDevices_AddResourceUsage_guaranteedmem(t *testing.T) {
+	dev := InitNvidiaDevice(NvidiaConfig{})
+	d := &util.DeviceUsage{Totalmem: 8000, Physmem: 4000}
+	tolerant := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{util.MemoryOversubscriptionAnnotation: "true"}}}
+
+	assert.NilError(t, dev.AddResourceUsage(&corev1.Pod{}, d, &util.ContainerDevice{Usedmem: 1000}))
+	assert.NilError(t, dev.AddResourceUsage(tolerant, d, &util.ContainerDevice{Usedmem: 2000}))
+	assert.Equal(t, int32(3000), d.Usedmem)
+	assert.Equal(t, int32(1000), d.Guaranteedmem)
+}
+
 func TestDevices_AddResourceUsage(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -0,0 +1,95 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nvidia
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/google/uuid"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// HAMiCoreConfig is how hami-core is injected into containers on a node.
+type HAMiCoreConfig struct {
+	// HookPath is the host directory hami-core is installed in.
+	HookPath string
+	// LibPath is the host path of libvgpu.so.
+	LibPath          string
+	MemoryScaling    float64
+	LogLevel         LibCudaLogLevel
+	DisableCoreLimit bool
+}
+
+// Mount is a host path mounted into a container.
+type Mount struct {
+	ContainerPath string
+	HostPath      string
+	ReadOnly      bool
+}
+
+// HAMiCoreEnvs returns the environment that limits a container to the memory
+// and cores allocated to it on devices.
+func HAMiCoreEnvs(devices util.ContainerDevices, cfg HAMiCoreConfig) map[string]string {
+	envs := make(map[string]string)
+	for i, dev := range devices {
+		limitKey := fmt.Sprintf("CUDA_DEVICE_MEMORY_LIMIT_%v", i)
+		envs[limitKey] = fmt.Sprintf("%vm", dev.Usedmem)
+	}
+	if len(devices) > 0 {
+		envs["CUDA_DEVICE_SM_LIMIT"] = fmt.Sprint(devices[0].Usedcores)
+	}
+	envs["CUDA_DEVICE_MEMORY_SHARED_CACHE"] = fmt.Sprintf("%s/vgpu/%v.cache", cfg.HookPath, uuid.New().String())
+	if cfg.MemoryScaling > 1 {
+		envs["CUDA_OVERSUBSCRIBE"] = "true"
+	}
+	if cfg.LogLevel != "" {
+		envs["LIBCUDA_LOG_LEVEL"] = string(cfg.LogLevel)
+	}
+	if cfg.DisableCoreLimit {
+		envs[util.CoreLimitSwitch] = "disable"
+	}
+	return envs
+}
+
+// HAMiCoreMounts recreates cacheDir, the host directory hami-core keeps the
+// usage of a container in, and returns the mounts hami-core needs. libvgpu.so
+// is only preloaded if preload is set, it is not when the container sets
+// CUDA_DISABLE_CONTROL.
+func HAMiCoreMounts(cacheDir string, preload bool, cfg HAMiCoreConfig) []Mount {
+	os.RemoveAll(cacheDir)
+	os.MkdirAll(cacheDir, 0777)
+	os.Chmod(cacheDir, 0777)
+	os.MkdirAll("/tmp/vgpulock", 0777)
+	os.Chmod("/tmp/vgpulock", 0777)
+	mounts := []Mount{
+		{ContainerPath: fmt.Sprintf("%s/vgpu/libvgpu.so", cfg.HookPath), HostPath: cfg.LibPath, ReadOnly: true},
+		{ContainerPath: fmt.Sprintf("%s/vgpu", cfg.HookPath), HostPath: cacheDir, ReadOnly: false},
+		{ContainerPath: "/tmp/vgpulock", HostPath: "/tmp/vgpulock", ReadOnly: false},
+	}
+	if preload {
+		mounts = append(mounts, Mount{ContainerPath: "/etc/ld.so.preload", HostPath: cfg.HookPath + "/vgpu/ld.so.preload", ReadOnly: true})
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s/vgpu/license", cfg.HookPath)); err == nil {
+		mounts = append(mounts,
+			Mount{ContainerPath: "/tmp/license", HostPath: fmt.Sprintf("%s/vgpu/license", cfg.HookPath), ReadOnly: true},
+			Mount{ContainerPath: "/usr/bin/vgpuvalidator", HostPath: fmt.Sprintf("%s/vgpu/vgpuvalidator", cfg.HookPath), ReadOnly: true},
+		)
+	}
+	return mounts
+}
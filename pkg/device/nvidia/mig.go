@@ -0,0 +1,88 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nvidia
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+const (
+	// MigDesiredGeometriesAnnos is set by the scheduler on a node to the index
+	// in the allowed geometries of its model that idle MIG GPUs should be
+	// partitioned to, e.g. "GPU-0:1,GPU-1:3".
+	MigDesiredGeometriesAnnos = "hami.io/node-mig-desired-geometries"
+	// MigAppliedGeometriesAnnos is set by the device plugin on its node to the
+	// geometries it applied ahead of allocations, in the same encoding.
+	MigAppliedGeometriesAnnos = "hami.io/node-mig-applied-geometries"
+)
+
+// EncodeMigGeometries encodes the geometry index of MIG GPUs by UUID.
+func EncodeMigGeometries(geometries map[string]int32) string {
+	entries := make([]string, 0, len(geometries))
+	for _, uuid := range slices.Sorted(maps.Keys(geometries)) {
+		entries = append(entries, uuid+":"+strconv.Itoa(int(geometries[uuid])))
+	}
+	return strings.Join(entries, ",")
+}
+
+// DecodeMigGeometries decodes the geometry index of MIG GPUs by UUID.
+func DecodeMigGeometries(str string) (map[string]int32, error) {
+	res := make(map[string]int32)
+	for entry := range strings.SplitSeq(str, ",") {
+		if entry == "" {
+			continue
+		}
+		uuid, idx, found := strings.Cut(entry, ":")
+		if !found || uuid == "" {
+			return nil, fmt.Errorf("invalid mig geometry %q, expected uuid:index", entry)
+		}
+		i, err := strconv.ParseInt(idx, 10, 32)
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("invalid mig geometry index %q of %s", idx, uuid)
+		}
+		res[uuid] = int32(i)
+	}
+	return res, nil
+}
+
+// MigDeviceIdle reports whether no MIG instance of a device is in use, so
+// that it can be partitioned to another geometry.
+func MigDeviceIdle(d *util.DeviceUsage) bool {
+	for _, val := range d.MigUsage.UsageList {
+		if val.InUse {
+			return false
+		}
+	}
+	return true
+}
+
+// migFits reports whether an instance of the current geometry of a device is
+// free and large enough for memreq.
+func migFits(usage util.MIGS, memreq int32) bool {
+	for _, val := range usage {
+		if !val.InUse && val.Memory >= memreq {
+			return true
+		}
+	}
+	return false
+}
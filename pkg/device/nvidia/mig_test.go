@@ -0,0 +1,97 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nvidia
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+func Test_MigGeometries(t *testing.T) {
+	geometries := map[string]int32{"GPU-1": 3, "GPU-0": 0}
+	encoded := EncodeMigGeometries(geometries)
+	assert.Equal(t, encoded, "GPU-0:0,GPU-1:3")
+	decoded, err := DecodeMigGeometries(encoded)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, decoded, geometries)
+
+	decoded, err = DecodeMigGeometries("")
+	assert.NilError(t, err)
+	assert.Equal(t, len(decoded), 0)
+
+	for _, invalid := range []string{"GPU-0", "GPU-0:x", "GPU-0:-1", ":1"} {
+		_, err := DecodeMigGeometries(invalid)
+		assert.ErrorContains(t, err, "invalid mig geometry", invalid)
+	}
+}
+
+func migTestDevice(preset int) *util.DeviceUsage {
+	d := &util.DeviceUsage{
+		ID:       "gpu0",
+		Count:    10,
+		Totalmem: 40960,
+		Mode:     MigMode,
+		Health:   true,
+		MigTemplate: []util.Geometry{
+			{{Name: "1g.5gb", Memory: 5120, Count: 7}},
+			{{Name: "3g.20gb", Memory: 20480, Count: 2}},
+		},
+	}
+	if preset >= 0 {
+		util.PlatternMIG(&d.MigUsage, d.MigTemplate, preset)
+	}
+	return d
+}
+
+func TestDevices_AddResourceUsage_migGeometry(t *testing.T) {
+	tests := []struct {
+		name        string
+		preset      int
+		usedmem     int32
+		wantUUID    string
+		wantUsedmem int32
+	}{
+		{name: "first fitting geometry", preset: -1, usedmem: 4000, wantUUID: "gpu0[0-0]", wantUsedmem: 5120},
+		{name: "preset geometry is kept", preset: 1, usedmem: 4000, wantUUID: "gpu0[1-0]", wantUsedmem: 20480},
+		{name: "preset geometry too small", preset: 0, usedmem: 10000, wantUUID: "gpu0[1-0]", wantUsedmem: 20480},
+	}
+	dev := &NvidiaGPUDevices{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := migTestDevice(test.preset)
+			ctr := &util.ContainerDevice{UUID: "gpu0", Usedmem: test.usedmem}
+			assert.NilError(t, dev.AddResourceUsage(nil, d, ctr))
+			assert.Equal(t, ctr.UUID, test.wantUUID)
+			assert.Equal(t, ctr.Usedmem, test.wantUsedmem)
+			assert.Assert(t, !MigDeviceIdle(d))
+		})
+	}
+}
+
+func TestDevices_CustomFilterRule_migGeometry(t *testing.T) {
+	dev := &NvidiaGPUDevices{}
+	req := util.ContainerDeviceRequest{Nums: 1, Type: NvidiaGPUDevice, Memreq: 10000}
+	d := migTestDevice(0)
+	assert.Assert(t, dev.CustomFilterRule(nil, req, util.ContainerDevices{}, d), "an idle device is partitioned again")
+	assert.Equal(t, len(d.MigUsage.UsageList), 7, "the device usage is not modified")
+
+	d.MigUsage.UsageList[0].InUse = true
+	assert.Assert(t, !dev.CustomFilterRule(nil, req, util.ContainerDevices{}, d), "a device in use keeps its geometry")
+}
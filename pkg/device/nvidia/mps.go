@@ -0,0 +1,65 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nvidia
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+const (
+	// MPSRoot is the host directory holding the pipe and log directories of
+	// the MPS control daemon of each GPU, by UUID.
+	MPSRoot = "/tmp/hami/mps"
+	// MPSContainerPipeDirectory is where the pipe directory of the daemon of
+	// its GPU is mounted in a container.
+	MPSContainerPipeDirectory = "/tmp/nvidia-mps"
+)
+
+// MPSPipeDirectory is the host pipe directory of the MPS control daemon of a GPU.
+func MPSPipeDirectory(root, uuid string) string {
+	return filepath.Join(root, uuid, "pipe")
+}
+
+// MPSLogDirectory is the host log directory of the MPS control daemon of a GPU.
+func MPSLogDirectory(root, uuid string) string {
+	return filepath.Join(root, uuid, "log")
+}
+
+// MPSEnvs returns the environment that connects a container to the MPS
+// control daemon of its GPU and limits it to the memory and cores allocated to
+// it. A client only connects to one daemon, so a container gets one GPU.
+func MPSEnvs(dev util.ContainerDevice) map[string]string {
+	envs := map[string]string{
+		"CUDA_MPS_PIPE_DIRECTORY":          MPSContainerPipeDirectory,
+		"CUDA_MPS_PINNED_DEVICE_MEM_LIMIT": fmt.Sprintf("0=%dM", dev.Usedmem),
+	}
+	if dev.Usedcores > 0 && dev.Usedcores < 100 {
+		envs["CUDA_MPS_ACTIVE_THREAD_PERCENTAGE"] = fmt.Sprint(dev.Usedcores)
+	}
+	return envs
+}
+
+// MPSMounts returns the mounts of the pipe directory of the MPS control daemon
+// of a GPU.
+func MPSMounts(root string, dev util.ContainerDevice) []Mount {
+	return []Mount{
+		{ContainerPath: MPSContainerPipeDirectory, HostPath: MPSPipeDirectory(root, dev.UUID), ReadOnly: false},
+	}
+}
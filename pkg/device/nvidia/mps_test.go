@@ -0,0 +1,61 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nvidia
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+func Test_MPSEnvs(t *testing.T) {
+	tests := []struct {
+		name string
+		dev  util.ContainerDevice
+		want map[string]string
+	}{
+		{
+			name: "cores and memory limited",
+			dev:  util.ContainerDevice{UUID: "GPU-0", Usedmem: 2000, Usedcores: 30},
+			want: map[string]string{
+				"CUDA_MPS_PIPE_DIRECTORY":           MPSContainerPipeDirectory,
+				"CUDA_MPS_PINNED_DEVICE_MEM_LIMIT":  "0=2000M",
+				"CUDA_MPS_ACTIVE_THREAD_PERCENTAGE": "30",
+			},
+		},
+		{
+			name: "cores not limited",
+			dev:  util.ContainerDevice{UUID: "GPU-0", Usedmem: 2000},
+			want: map[string]string{
+				"CUDA_MPS_PIPE_DIRECTORY":          MPSContainerPipeDirectory,
+				"CUDA_MPS_PINNED_DEVICE_MEM_LIMIT": "0=2000M",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.DeepEqual(t, MPSEnvs(test.dev), test.want)
+		})
+	}
+}
+
+func Test_MPSMounts(t *testing.T) {
+	mounts := MPSMounts("/tmp/hami/mps", util.ContainerDevice{UUID: "GPU-0"})
+	assert.DeepEqual(t, mounts, []Mount{{ContainerPath: MPSContainerPipeDirectory, HostPath: "/tmp/hami/mps/GPU-0/pipe"}})
+}
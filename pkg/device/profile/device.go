@@ -0,0 +1,365 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/common"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/util/nodelock"
+)
+
+// Devices implements the devices of a profile.
+type Devices struct {
+	profile Profile
+}
+
+// InitDevices initializes the devices of the profiles, which must be valid.
+func InitDevices(profiles []Profile) []*Devices {
+	devs := make([]*Devices, 0, len(profiles))
+	for _, p := range profiles {
+		dev := &Devices{profile: p.withDefaults()}
+		util.SetDeviceAnno(util.InRequestDevices, p.Name, dev.profile.Annotations.ToAllocate)
+		util.SetDeviceAnno(util.SupportDevices, p.Name, dev.profile.Annotations.Allocated)
+		if dev.profile.Annotations.Handshake != "" {
+			util.SetDeviceAnno(util.HandshakeAnnos, p.Name, dev.profile.Annotations.Handshake)
+		}
+		devs = append(devs, dev)
+		klog.Infof("load device profile %s: %v", p.Name, dev.profile)
+	}
+	return devs
+}
+
+func (dev *Devices) CommonWord() string {
+	return dev.profile.Name
+}
+
+func (dev *Devices) MutateAdmission(ctr *corev1.Container, p *corev1.Pod) (bool, error) {
+	_, ok := ctr.Resources.Limits[corev1.ResourceName(dev.profile.ResourceCountName)]
+	return ok, nil
+}
+
+func (dev *Devices) CheckHealth(devType string, n *corev1.Node) (bool, bool) {
+	if dev.profile.Annotations.Handshake == "" {
+		return true, true
+	}
+	return util.CheckHealth(devType, n)
+}
+
+func (dev *Devices) NodeCleanUp(nn string) error {
+	if dev.profile.Annotations.Handshake == "" {
+		return nil
+	}
+	return util.MarkAnnotationsToDelete(dev.profile.Annotations.Handshake, nn)
+}
+
+func (dev *Devices) GetNodeDevices(n corev1.Node) ([]*util.DeviceInfo, error) {
+	var nodedevices []*util.DeviceInfo
+	var err error
+	if dev.profile.Topology.Source == TopologyCapacity {
+		nodedevices, err = dev.capacityDevices(n)
+	} else {
+		nodedevices, err = dev.registeredDevices(n)
+	}
+	if err != nil {
+		return []*util.DeviceInfo{}, err
+	}
+	for _, d := range nodedevices {
+		// Devices are told apart from the devices of other vendors on a node
+		// by their type containing the common word.
+		if !strings.Contains(d.Type, dev.profile.Name) {
+			d.Type = dev.profile.Name + "-" + d.Type
+		}
+		d.DeviceVendor = dev.profile.Name
+	}
+	return nodedevices, nil
+}
+
+// registeredDevices decodes the devices the device plugin registered.
+func (dev *Devices) registeredDevices(n corev1.Node) ([]*util.DeviceInfo, error) {
+	devEncoded, ok := n.Annotations[dev.profile.Annotations.Register]
+	if !ok {
+		return nil, errors.New("annos not found " + dev.profile.Annotations.Register)
+	}
+	nodedevices, err := util.DecodeNodeDevices(devEncoded)
+	if err != nil {
+		klog.ErrorS(err, "failed to decode node devices", "node", n.Name, "device annotation", devEncoded)
+		return nil, err
+	}
+	if len(nodedevices) == 0 {
+		klog.InfoS("no device found", "node", n.Name, "device annotation", devEncoded)
+		return nil, fmt.Errorf("no %s device found on node", dev.profile.Name)
+	}
+	return nodedevices, nil
+}
+
+// capacityDevices derives the devices of a node from the capacity of the count
+// resource, advertised SplitCount times per device, and shares the capacity of
+// the memory resource among them.
+func (dev *Devices) capacityDevices(n corev1.Node) ([]*util.DeviceInfo, error) {
+	split := int64(dev.profile.Topology.SplitCount)
+	count, ok := n.Status.Capacity.Name(corev1.ResourceName(dev.profile.ResourceCountName), resource.DecimalSI).AsInt64()
+	if !ok || count/split == 0 {
+		return nil, fmt.Errorf("device not found %s", dev.profile.ResourceCountName)
+	}
+	cards := count / split
+	memoryTotal := int64(0)
+	if dev.profile.ResourceMemoryName != "" {
+		memoryTotal, _ = n.Status.Capacity.Name(corev1.ResourceName(dev.profile.ResourceMemoryName), resource.DecimalSI).AsInt64()
+	}
+	nodedevices := make([]*util.DeviceInfo, 0, cards)
+	for i := range cards {
+		nodedevices = append(nodedevices, &util.DeviceInfo{
+			Index:   uint(i),
+			ID:      fmt.Sprintf("%s-%s-%d", n.Name, strings.ToLower(dev.profile.Name), i),
+			Count:   int32(split),
+			Devmem:  int32(memoryTotal * int64(dev.profile.MemoryFactor) / cards),
+			Devcore: 100,
+			Type:    dev.profile.Name,
+			Health:  true,
+		})
+	}
+	return nodedevices, nil
+}
+
+func (dev *Devices) LockNode(n *corev1.Node, p *corev1.Pod) error {
+	if !dev.profile.NodeLock || !dev.requested(p) {
+		return nil
+	}
+	return nodelock.LockNode(n.Name, nodelock.NodeLockKey, p)
+}
+
+func (dev *Devices) ReleaseNodeLock(n *corev1.Node, p *corev1.Pod) error {
+	if !dev.profile.NodeLock || !dev.requested(p) {
+		return nil
+	}
+	return nodelock.ReleaseNodeLock(n.Name, nodelock.NodeLockKey, p, false)
+}
+
+// requested reports whether a container of the pod requests the devices.
+func (dev *Devices) requested(p *corev1.Pod) bool {
+	for _, ctr := range p.Spec.Containers {
+		if dev.GenerateResourceRequests(&ctr).Nums > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// quantity returns the value of a resource in the limits, or else the
+// requests, of a container.
+func quantity(ctr *corev1.Container, name string) (int64, bool) {
+	if name == "" {
+		return 0, false
+	}
+	v, ok := ctr.Resources.Limits[corev1.ResourceName(name)]
+	if !ok {
+		v, ok = ctr.Resources.Requests[corev1.ResourceName(name)]
+	}
+	if !ok {
+		return 0, false
+	}
+	return v.AsInt64()
+}
+
+func (dev *Devices) GenerateResourceRequests(ctr *corev1.Container) util.ContainerDeviceRequest {
+	n, ok := quantity(ctr, dev.profile.ResourceCountName)
+	if !ok {
+		return util.ContainerDeviceRequest{}
+	}
+	klog.V(5).InfoS("Found profile devices", "profile", dev.profile.Name, "container", ctr.Name, "count", n)
+	memnum := int64(0)
+	if dev.profile.MemoryShareable {
+		if mem, ok := quantity(ctr, dev.profile.ResourceMemoryName); ok {
+			memnum = mem * int64(dev.profile.MemoryFactor)
+		}
+	}
+	mempnum := int32(0)
+	if memnum == 0 {
+		mempnum = 100
+	}
+	corenum := int64(100)
+	if dev.profile.CoresShareable {
+		corenum = int64(dev.profile.DefaultCores)
+		if core, ok := quantity(ctr, dev.profile.ResourceCoreName); ok {
+			corenum = core
+		}
+	}
+	return util.ContainerDeviceRequest{
+		Nums:             int32(n),
+		Type:             dev.profile.Name,
+		Memreq:           int32(memnum),
+		MemPercentagereq: mempnum,
+		Coresreq:         int32(corenum),
+	}
+}
+
+func (dev *Devices) PatchAnnotations(pod *corev1.Pod, annoinput *map[string]string, pd util.PodDevices) map[string]string {
+	devlist, ok := pd[dev.profile.Name]
+	if ok && len(devlist) > 0 {
+		deviceStr := util.EncodePodSingleDevice(devlist)
+		(*annoinput)[dev.profile.Annotations.ToAllocate] = deviceStr
+		(*annoinput)[dev.profile.Annotations.Allocated] = deviceStr
+		klog.V(5).Infof("pod add notation key [%s], values is [%s]", dev.profile.Annotations.Allocated, deviceStr)
+	}
+	return *annoinput
+}
+
+func (dev *Devices) ScoreNode(node *corev1.Node, podDevices util.PodSingleDevice, previous []*util.DeviceUsage, policy string) float32 {
+	return 0
+}
+
+func (dev *Devices) AddResourceUsage(pod *corev1.Pod, n *util.DeviceUsage, ctr *util.ContainerDevice) error {
+	n.Used++
+	n.Usedcores += ctr.Usedcores
+	n.Usedmem += ctr.Usedmem
+	return nil
+}
+
+// annotationValues returns the comma separated values of an annotation.
+func annotationValues(annos map[string]string, key string) ([]string, bool) {
+	value, ok := annos[key]
+	if !ok {
+		return nil, false
+	}
+	return strings.Split(value, ","), true
+}
+
+// checkType reports whether the type of a device is selected by the type
+// annotations of a pod, matching case insensitive substrings.
+func (dev *Devices) checkType(annos map[string]string, d util.DeviceUsage) bool {
+	contains := func(values []string) bool {
+		return slices.ContainsFunc(values, func(v string) bool {
+			return strings.Contains(strings.ToUpper(d.Type), strings.ToUpper(v))
+		})
+	}
+	if values, ok := annotationValues(annos, dev.profile.Annotations.UseType); ok {
+		return contains(values)
+	}
+	if values, ok := annotationValues(annos, dev.profile.Annotations.NoUseType); ok {
+		return !contains(values)
+	}
+	return true
+}
+
+func (dev *Devices) checkUUID(annos map[string]string, d util.DeviceUsage) bool {
+	if values, ok := annotationValues(annos, dev.profile.Annotations.UseUUID); ok {
+		return slices.Contains(values, d.ID)
+	}
+	if values, ok := annotationValues(annos, dev.profile.Annotations.NoUseUUID); ok {
+		return !slices.Contains(values, d.ID)
+	}
+	return true
+}
+
+func (dev *Devices) Fit(devices []*util.DeviceUsage, request util.ContainerDeviceRequest, annos map[string]string, pod *corev1.Pod, nodeInfo *util.NodeInfo, allocated *util.PodDevices) (bool, map[string]util.ContainerDevices, string) {
+	k := request
+	originReq := k.Nums
+	prevnuma := -1
+	klog.InfoS("Allocating device for container request", "pod", klog.KObj(pod), "card request", k)
+	tmpDevs := make(map[string]util.ContainerDevices)
+	reason := make(map[string]int)
+	for i := len(devices) - 1; i >= 0; i-- {
+		d := devices[i]
+		klog.V(4).InfoS("scoring pod", "pod", klog.KObj(pod), "device", d.ID, "Memreq", k.Memreq, "MemPercentagereq", k.MemPercentagereq, "Coresreq", k.Coresreq, "Nums", k.Nums, "device index", i)
+
+		if k.Type != dev.profile.Name || !dev.checkType(annos, *d) {
+			reason[common.CardTypeMismatch]++
+			klog.V(5).InfoS(common.CardTypeMismatch, "pod", klog.KObj(pod), "device", d.ID, d.Type, k.Type)
+			continue
+		}
+		if dev.profile.Topology.NUMA && prevnuma != d.Numa {
+			if k.Nums != originReq {
+				reason[common.NumaNotFit] += len(tmpDevs)
+				klog.V(5).InfoS(common.NumaNotFit, "pod", klog.KObj(pod), "device", d.ID, "k.nums", k.Nums, "prevnuma", prevnuma, "device numa", d.Numa)
+			}
+			k.Nums = originReq
+			prevnuma = d.Numa
+			tmpDevs = make(map[string]util.ContainerDevices)
+		}
+		if !dev.checkUUID(annos, *d) {
+			reason[common.CardUUIDMismatch]++
+			klog.V(5).InfoS(common.CardUUIDMismatch, "pod", klog.KObj(pod), "device", d.ID, "current device info is:", *d)
+			continue
+		}
+		if d.Count <= d.Used {
+			reason[common.CardTimeSlicingExhausted]++
+			klog.V(5).InfoS(common.CardTimeSlicingExhausted, "pod", klog.KObj(pod), "device", d.ID, "count", d.Count, "used", d.Used)
+			continue
+		}
+		if k.Coresreq > 100 {
+			klog.ErrorS(nil, "core limit can't exceed 100", "pod", klog.KObj(pod), "device", d.ID)
+			k.Coresreq = 100
+		}
+		memreq := k.Memreq
+		if k.Memreq == 0 {
+			memreq = d.Totalmem * k.MemPercentagereq / 100
+		}
+		if d.Totalmem-d.Usedmem < memreq {
+			reason[common.CardInsufficientMemory]++
+			klog.V(5).InfoS(common.CardInsufficientMemory, "pod", klog.KObj(pod), "device", d.ID, "device index", i, "device total memory", d.Totalmem, "device used memory", d.Usedmem, "request memory", memreq)
+			continue
+		}
+		if d.Totalcore-d.Usedcores < k.Coresreq {
+			reason[common.CardInsufficientCore]++
+			klog.V(5).InfoS(common.CardInsufficientCore, "pod", klog.KObj(pod), "device", d.ID, "device index", i, "device total core", d.Totalcore, "device used core", d.Usedcores, "request cores", k.Coresreq)
+			continue
+		}
+		if dev.profile.ExclusiveWhenFull {
+			// Coresreq=100 indicates it wants this card exclusively
+			if d.Totalcore == 100 && k.Coresreq == 100 && d.Used > 0 {
+				reason[common.ExclusiveDeviceAllocateConflict]++
+				klog.V(5).InfoS(common.ExclusiveDeviceAllocateConflict, "pod", klog.KObj(pod), "device", d.ID, "device index", i, "used", d.Used)
+				continue
+			}
+			// You can't allocate core=0 job to an already full card
+			if d.Totalcore != 0 && d.Usedcores == d.Totalcore && k.Coresreq == 0 {
+				reason[common.CardComputeUnitsExhausted]++
+				klog.V(5).InfoS(common.CardComputeUnitsExhausted, "pod", klog.KObj(pod), "device", d.ID, "device index", i)
+				continue
+			}
+		}
+		if k.Nums > 0 {
+			klog.V(5).InfoS("find fit device", "pod", klog.KObj(pod), "device", d.ID)
+			k.Nums--
+			tmpDevs[k.Type] = append(tmpDevs[k.Type], util.ContainerDevice{
+				Idx:       int(d.Index),
+				UUID:      d.ID,
+				Type:      k.Type,
+				Usedmem:   memreq,
+				Usedcores: k.Coresreq,
+			})
+		}
+		if k.Nums == 0 {
+			klog.V(4).InfoS("device allocate success", "pod", klog.KObj(pod), "allocate device", tmpDevs)
+			return true, tmpDevs, ""
+		}
+	}
+	if len(tmpDevs) > 0 {
+		reason[common.AllocatedCardsInsufficientRequest] = len(tmpDevs)
+		klog.V(5).InfoS(common.AllocatedCardsInsufficientRequest, "pod", klog.KObj(pod), "request", originReq, "allocated", len(tmpDevs))
+	}
+	return false, tmpDevs, common.GenReason(reason, len(devices))
+}
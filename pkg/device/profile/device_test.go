@@ -0,0 +1,241 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package profile
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+const acmeProfile = `
+name: ACME
+resourceCountName: acme.com/gpu
+resourceMemoryName: acme.com/gpumem
+resourceCoreName: acme.com/gpucores
+memoryShareable: true
+coresShareable: true
+exclusiveWhenFull: true
+topology:
+  source: annotation
+  numa: true
+annotations:
+  handshake: hami.io/node-handshake-acme
+`
+
+func loadProfile(t *testing.T, data string) *Devices {
+	p := Profile{}
+	assert.NilError(t, yaml.Unmarshal([]byte(data), &p))
+	assert.NilError(t, p.Validate())
+	devs := InitDevices([]Profile{p})
+	assert.Equal(t, len(devs), 1)
+	return devs[0]
+}
+
+func container(limits map[string]string) *corev1.Container {
+	ctr := &corev1.Container{Name: "main", Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{}}}
+	for name, value := range limits {
+		ctr.Resources.Limits[corev1.ResourceName(name)] = resource.MustParse(value)
+	}
+	return ctr
+}
+
+func Test_InitDevices(t *testing.T) {
+	dev := loadProfile(t, acmeProfile)
+	assert.Equal(t, dev.CommonWord(), "ACME")
+	assert.Equal(t, dev.profile.MemoryFactor, int32(1))
+	assert.Equal(t, dev.profile.Topology.SplitCount, int32(1))
+	assert.Equal(t, dev.profile.Annotations.Register, "hami.io/node-register-ACME")
+	assert.Equal(t, util.InRequestDevices["ACME"], "hami.io/ACME-devices-to-allocate")
+	assert.Equal(t, util.SupportDevices["ACME"], "hami.io/ACME-devices-allocated")
+	assert.Equal(t, util.HandshakeAnnos["ACME"], "hami.io/node-handshake-acme")
+}
+
+func Test_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		err     string
+	}{
+		{"invalid name", Profile{Name: "acme.com", ResourceCountName: "acme.com/gpu", Topology: Topology{Source: TopologyCapacity}}, "invalid profile name"},
+		{"no count resource", Profile{Name: "ACME", Topology: Topology{Source: TopologyCapacity}}, "resourceCountName is required"},
+		{"unknown topology source", Profile{Name: "ACME", ResourceCountName: "acme.com/gpu"}, "unknown topology source"},
+		{"default cores over 100", Profile{Name: "ACME", ResourceCountName: "acme.com/gpu", DefaultCores: 150, Topology: Topology{Source: TopologyCapacity}}, "defaultCores must be within 0 and 100"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.ErrorContains(t, test.profile.Validate(), test.err)
+		})
+	}
+}
+
+func Test_GetNodeDevices(t *testing.T) {
+	dev := loadProfile(t, acmeProfile)
+	node := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Annotations: map[string]string{
+		"hami.io/node-register-ACME": "acme-0,4,16384,100,X1,0,true:acme-1,4,16384,100,ACME-X1,1,true:",
+	}}}
+	devices, err := dev.GetNodeDevices(node)
+	assert.NilError(t, err)
+	assert.Equal(t, len(devices), 2)
+	assert.Equal(t, devices[0].Type, "ACME-X1", "the type is prefixed with the common word")
+	assert.Equal(t, devices[1].Type, "ACME-X1")
+	assert.Equal(t, devices[0].DeviceVendor, "ACME")
+	assert.Equal(t, devices[1].Numa, 1)
+
+	_, err = dev.GetNodeDevices(corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}})
+	assert.ErrorContains(t, err, "annos not found")
+
+	capacity := loadProfile(t, `
+name: BETA
+resourceCountName: beta.com/vgpu
+resourceMemoryName: beta.com/memory
+memoryFactor: 256
+topology:
+  source: capacity
+  splitCount: 4
+`)
+	node = corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}, Status: corev1.NodeStatus{Capacity: corev1.ResourceList{
+		"beta.com/vgpu":   resource.MustParse("8"),
+		"beta.com/memory": resource.MustParse("128"),
+	}}}
+	devices, err = capacity.GetNodeDevices(node)
+	assert.NilError(t, err)
+	assert.Equal(t, len(devices), 2)
+	assert.DeepEqual(t, *devices[1], util.DeviceInfo{ID: "node1-beta-1", Index: 1, Count: 4, Devmem: 128 * 256 / 2, Devcore: 100, Type: "BETA", Health: true, DeviceVendor: "BETA"})
+
+	_, err = capacity.GetNodeDevices(corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}})
+	assert.ErrorContains(t, err, "device not found")
+}
+
+func Test_GenerateResourceRequests(t *testing.T) {
+	dev := loadProfile(t, acmeProfile)
+	exclusive := loadProfile(t, `
+name: GAMMA
+resourceCountName: gamma.com/npu
+resourceMemoryName: gamma.com/memory
+resourceCoreName: gamma.com/cores
+topology:
+  source: annotation
+`)
+	tests := []struct {
+		name   string
+		dev    *Devices
+		limits map[string]string
+		want   util.ContainerDeviceRequest
+	}{
+		{"no devices", dev, map[string]string{"cpu": "1"}, util.ContainerDeviceRequest{}},
+		{"memory and cores", dev, map[string]string{"acme.com/gpu": "2", "acme.com/gpumem": "4096", "acme.com/gpucores": "30"},
+			util.ContainerDeviceRequest{Nums: 2, Type: "ACME", Memreq: 4096, Coresreq: 30}},
+		{"whole memory by default", dev, map[string]string{"acme.com/gpu": "1"},
+			util.ContainerDeviceRequest{Nums: 1, Type: "ACME", MemPercentagereq: 100}},
+		{"memory and cores not shareable", exclusive, map[string]string{"gamma.com/npu": "1", "gamma.com/memory": "4096", "gamma.com/cores": "30"},
+			util.ContainerDeviceRequest{Nums: 1, Type: "GAMMA", MemPercentagereq: 100, Coresreq: 100}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.DeepEqual(t, test.dev.GenerateResourceRequests(container(test.limits)), test.want)
+		})
+	}
+}
+
+func usage(id string, numa int, used, usedmem, usedcores int32) *util.DeviceUsage {
+	return &util.DeviceUsage{ID: id, Count: 4, Used: used, Totalmem: 16384, Usedmem: usedmem, Totalcore: 100, Usedcores: usedcores, Numa: numa, Type: "ACME-X1", Health: true}
+}
+
+func Test_Fit(t *testing.T) {
+	dev := loadProfile(t, acmeProfile)
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}}
+	tests := []struct {
+		name    string
+		devices []*util.DeviceUsage
+		request util.ContainerDeviceRequest
+		annos   map[string]string
+		fit     bool
+		uuids   []string
+		reason  string
+	}{
+		{
+			name:    "fits on the last device first",
+			devices: []*util.DeviceUsage{usage("acme-0", 0, 0, 0, 0), usage("acme-1", 0, 0, 0, 0)},
+			request: util.ContainerDeviceRequest{Nums: 1, Type: "ACME", Memreq: 4096, Coresreq: 30},
+			fit:     true,
+			uuids:   []string{"acme-1"},
+		},
+		{
+			name:    "insufficient memory",
+			devices: []*util.DeviceUsage{usage("acme-0", 0, 1, 14000, 0)},
+			request: util.ContainerDeviceRequest{Nums: 1, Type: "ACME", Memreq: 4096},
+			reason:  "1/1 CardInsufficientMemory",
+		},
+		{
+			name:    "exclusive request on a device in use",
+			devices: []*util.DeviceUsage{usage("acme-0", 0, 1, 1000, 0)},
+			request: util.ContainerDeviceRequest{Nums: 1, Type: "ACME", MemPercentagereq: 10, Coresreq: 100},
+			reason:  "1/1 ExclusiveDeviceAllocateConflict",
+		},
+		{
+			name:    "devices of a container on one numa node",
+			devices: []*util.DeviceUsage{usage("acme-0", 0, 0, 0, 0), usage("acme-1", 0, 0, 0, 0), usage("acme-2", 1, 0, 0, 0)},
+			request: util.ContainerDeviceRequest{Nums: 2, Type: "ACME", Memreq: 1000},
+			fit:     true,
+			uuids:   []string{"acme-1", "acme-0"},
+		},
+		{
+			name:    "uuid not selected",
+			devices: []*util.DeviceUsage{usage("acme-0", 0, 0, 0, 0)},
+			request: util.ContainerDeviceRequest{Nums: 1, Type: "ACME", Memreq: 1000},
+			annos:   map[string]string{"hami.io/use-ACME-uuid": "acme-1"},
+			reason:  "1/1 CardUuidMismatch",
+		},
+		{
+			name:    "type not selected",
+			devices: []*util.DeviceUsage{usage("acme-0", 0, 0, 0, 0)},
+			request: util.ContainerDeviceRequest{Nums: 1, Type: "ACME", Memreq: 1000},
+			annos:   map[string]string{"hami.io/no-use-ACME-type": "x1"},
+			reason:  "1/1 CardTypeMismatch",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fit, devs, reason := dev.Fit(test.devices, test.request, test.annos, pod, &util.NodeInfo{}, &util.PodDevices{})
+			assert.Equal(t, fit, test.fit)
+			assert.Equal(t, reason, test.reason)
+			if test.fit {
+				uuids := []string{}
+				for _, d := range devs["ACME"] {
+					uuids = append(uuids, d.UUID)
+				}
+				assert.DeepEqual(t, uuids, test.uuids)
+			}
+		})
+	}
+}
+
+func Test_PatchAnnotations(t *testing.T) {
+	dev := loadProfile(t, acmeProfile)
+	annos := map[string]string{}
+	pd := util.PodDevices{"ACME": util.PodSingleDevice{{{Idx: 0, UUID: "acme-0", Type: "ACME", Usedmem: 4096, Usedcores: 30}}}}
+	dev.PatchAnnotations(&corev1.Pod{}, &annos, pd)
+	assert.Equal(t, annos["hami.io/ACME-devices-allocated"], util.EncodePodSingleDevice(pd["ACME"]))
+	assert.Equal(t, annos["hami.io/ACME-devices-to-allocate"], util.EncodePodSingleDevice(pd["ACME"]))
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package profile
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	// TopologyAnnotation reads the devices of a node from the register
+	// annotation its device plugin writes, in the format of util.EncodeNodeDevices.
+	TopologyAnnotation = "annotation"
+	// TopologyCapacity derives the devices of a node from the capacity of the
+	// resources its device plugin advertises.
+	TopologyCapacity = "capacity"
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+
+// Annotations are the annotations a profile reads and writes. The empty ones
+// default to names derived from the profile name.
+type Annotations struct {
+	// Register is the node annotation the device plugin registers the devices in.
+	Register string `yaml:"register"`
+	// Handshake is the node annotation the scheduler and the device plugin
+	// check each other's liveness with. Devices are not health checked if empty.
+	Handshake string `yaml:"handshake"`
+	// ToAllocate and Allocated are the pod annotations the devices assigned to
+	// a pod are written to, read by the device plugin.
+	ToAllocate string `yaml:"toAllocate"`
+	Allocated  string `yaml:"allocated"`
+	// UseUUID and NoUseUUID are the pod annotations selecting devices by ID.
+	UseUUID   string `yaml:"useUUID"`
+	NoUseUUID string `yaml:"noUseUUID"`
+	// UseType and NoUseType are the pod annotations selecting devices by type.
+	UseType   string `yaml:"useType"`
+	NoUseType string `yaml:"noUseType"`
+}
+
+// Topology is where the devices of a node are read from.
+type Topology struct {
+	// Source is TopologyAnnotation or TopologyCapacity.
+	Source string `yaml:"source"`
+	// SplitCount is how many containers may share a device. With the
+	// capacity source, the count resource is advertised SplitCount times per
+	// device. 1 if 0.
+	SplitCount int32 `yaml:"splitCount"`
+	// NUMA allocates the devices of a container on one NUMA node.
+	NUMA bool `yaml:"numa"`
+}
+
+// Profile declares an accelerator whose scheduling follows the common rules
+// of the vendors of HAMi, so that it is supported without a Go package.
+type Profile struct {
+	// Name is the device type and common word, e.g. ACME.
+	Name string `yaml:"name"`
+	// ResourceCountName is the resource of the number of devices, e.g. acme.com/gpu.
+	ResourceCountName string `yaml:"resourceCountName"`
+	// ResourceMemoryName is the resource of the device memory, optional.
+	ResourceMemoryName string `yaml:"resourceMemoryName"`
+	// ResourceCoreName is the resource of the core percentage, optional.
+	ResourceCoreName string `yaml:"resourceCoreName"`
+	// MemoryFactor is the MiB of a unit of the memory resource, 1 if 0.
+	MemoryFactor int32 `yaml:"memoryFactor"`
+	// MemoryShareable lets containers request part of the memory of a
+	// device; otherwise each gets all of it.
+	MemoryShareable bool `yaml:"memoryShareable"`
+	// CoresShareable lets containers request part of the cores of a device;
+	// otherwise each gets all of them.
+	CoresShareable bool `yaml:"coresShareable"`
+	// DefaultCores is the core percentage of containers not requesting the
+	// core resource, when cores are shareable.
+	DefaultCores int32 `yaml:"defaultCores"`
+	// ExclusiveWhenFull gives a device to a container requesting all its
+	// cores only if no other container uses it, and no container to a device
+	// whose cores are all allocated.
+	ExclusiveWhenFull bool `yaml:"exclusiveWhenFull"`
+	// NodeLock locks the node from the bind of a pod to its allocation by the
+	// device plugin.
+	NodeLock    bool        `yaml:"nodeLock"`
+	Topology    Topology    `yaml:"topology"`
+	Annotations Annotations `yaml:"annotations"`
+}
+
+// Validate checks the fields a profile cannot do without.
+func (p *Profile) Validate() error {
+	if !namePattern.MatchString(p.Name) {
+		return fmt.Errorf("invalid profile name %q, expected letters and digits", p.Name)
+	}
+	if p.ResourceCountName == "" {
+		return fmt.Errorf("profile %s: resourceCountName is required", p.Name)
+	}
+	switch p.Topology.Source {
+	case TopologyAnnotation, TopologyCapacity:
+	default:
+		return fmt.Errorf("profile %s: unknown topology source %q, expected %s or %s", p.Name, p.Topology.Source, TopologyAnnotation, TopologyCapacity)
+	}
+	if p.MemoryFactor < 0 || p.DefaultCores < 0 || p.DefaultCores > 100 || p.Topology.SplitCount < 0 {
+		return fmt.Errorf("profile %s: memoryFactor and splitCount must not be negative, defaultCores must be within 0 and 100", p.Name)
+	}
+	return nil
+}
+
+// withDefaults returns the profile with its empty optional fields set.
+func (p Profile) withDefaults() Profile {
+	if p.MemoryFactor == 0 {
+		p.MemoryFactor = 1
+	}
+	if p.Topology.SplitCount == 0 {
+		p.Topology.SplitCount = 1
+	}
+	defaults := []struct {
+		value  *string
+		format string
+	}{
+		{&p.Annotations.Register, "hami.io/node-register-%s"},
+		{&p.Annotations.ToAllocate, "hami.io/%s-devices-to-allocate"},
+		{&p.Annotations.Allocated, "hami.io/%s-devices-allocated"},
+		{&p.Annotations.UseUUID, "hami.io/use-%s-uuid"},
+		{&p.Annotations.NoUseUUID, "hami.io/no-use-%s-uuid"},
+		{&p.Annotations.UseType, "hami.io/use-%s-type"},
+		{&p.Annotations.NoUseType, "hami.io/no-use-%s-type"},
+	}
+	for _, d := range defaults {
+		if *d.value == "" {
+			*d.value = fmt.Sprintf(d.format, p.Name)
+		}
+	}
+	return p
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package device
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v2"
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// configReloadDelay is how long the config file must stay unchanged before it
+// is reloaded, as an update comes as several events.
+const configReloadDelay = time.Second
+
+// reloadFailures counts the config reloads that were rejected.
+var reloadFailures atomic.Int64
+
+// ReloadFailures returns the number of config reloads that were rejected.
+func ReloadFailures() int64 {
+	return reloadFailures.Load()
+}
+
+// configHash returns the version of a config, a hash of its content.
+func configHash(config *Config) string {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// ReloadConfig loads the config file at path and swaps in its devices and
+// scheduler settings, if it changed. The config is rejected if it is invalid,
+// a device fails to initialize, or it adds or removes device types or changes
+// the annotations of a device, which requires a restart. It reports whether the config was swapped.
+func ReloadConfig(path string) (bool, error) {
+	swapped, err := reloadConfig(path)
+	if err != nil {
+		reloadFailures.Add(1)
+		klog.ErrorS(err, "Rejected device config", "path", path, "activeVersion", ConfigVersion())
+	}
+	return swapped, err
+}
+
+func reloadConfig(path string) (bool, error) {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return false, err
+	}
+	version := configHash(config)
+	devicesLock.RLock()
+	previous, current, toHandle, previousVersion := currentConfig, devicesMap, DevicesToHandle, configVersion
+	devicesLock.RUnlock()
+	if version == previousVersion {
+		return false, nil
+	}
+	if err := validateConfig(config); err != nil {
+		return false, err
+	}
+	var devices map[string]Devices
+	var newToHandle []string
+	changed := util.KeepDeviceAnnos(func() {
+		devices, newToHandle, err = newDevices(config, previous, current)
+	})
+	if err == nil && len(changed) > 0 {
+		err = fmt.Errorf("device annotations changed to %s, which requires a restart", strings.Join(changed, ","))
+	}
+	if err != nil {
+		closeRemoteVendors(devices, current)
+		return false, err
+	}
+	if !slices.Equal(slices.Sorted(slices.Values(toHandle)), slices.Sorted(slices.Values(newToHandle))) {
+		closeRemoteVendors(devices, current)
+		return false, fmt.Errorf("device types changed from %s to %s, which requires a restart",
+			strings.Join(toHandle, ","), strings.Join(newToHandle, ","))
+	}
+	setDevices(config, devices, newToHandle)
+	closeRemoteVendors(current, devices)
+	klog.InfoS("Reloaded device config", "path", path, "version", version, "previousVersion", previousVersion)
+	return true, nil
+}
+
+// WatchConfigFile reloads the device config file whenever it changes, until
+// stop is closed. The directory of the file is watched, as a mounted ConfigMap
+// is updated by swapping the symlink of its data directory.
+func WatchConfigFile(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	path := filepath.Clean(configFile)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+	klog.InfoS("Watching device config", "path", path, "version", ConfigVersion())
+	go func() {
+		defer watcher.Close()
+		timer := time.NewTimer(configReloadDelay)
+		timer.Stop()
+		for {
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == path || strings.HasPrefix(filepath.Base(event.Name), "..") {
+					timer.Reset(configReloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				klog.ErrorS(err, "Failed to watch device config", "path", path)
+			case <-timer.C:
+				ReloadConfig(path)
+			}
+		}
+	}()
+	return nil
+}
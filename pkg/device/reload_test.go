@@ -0,0 +1,154 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/cambricon"
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	schedulerconfig "github.com/Project-HAMi/HAMi/pkg/scheduler/config"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/util/nodelock"
+)
+
+// setupReloadTest initializes the devices from a config file holding the test config.
+func setupReloadTest(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "device-config.yaml")
+	assert.NilError(t, os.WriteFile(path, []byte(loadTestConfig()), 0644))
+	config, err := LoadConfig(path)
+	assert.NilError(t, err)
+	assert.NilError(t, InitDevicesWithConfig(config))
+	t.Cleanup(func() {
+		schedulerconfig.SetReloadable(nil)
+		nodelock.SetNodeLockTimeout(0)
+	})
+	return path
+}
+
+func Test_ReloadConfig(t *testing.T) {
+	path := setupReloadTest(t)
+	version := ConfigVersion()
+	previous := GetDevices()
+
+	swapped, err := ReloadConfig(path)
+	assert.NilError(t, err)
+	assert.Assert(t, !swapped, "an unchanged config is not swapped")
+
+	config := strings.Replace(loadTestConfig(), "defaultMemory: 0", "defaultMemory: 1024", 1) + `
+scheduler:
+  nodeSchedulerPolicy: spread
+  nodeLabelSelector:
+    gpu: "on"
+  nodeLockTimeout: 2m
+`
+	assert.NilError(t, os.WriteFile(path, []byte(config), 0644))
+	swapped, err = ReloadConfig(path)
+	assert.NilError(t, err)
+	assert.Assert(t, swapped)
+	assert.Assert(t, ConfigVersion() != version)
+
+	devices := GetDevices()
+	assert.Equal(t, len(devices), len(previous))
+	assert.Assert(t, devices[nvidia.NvidiaGPUDevice] != previous[nvidia.NvidiaGPUDevice], "the changed vendor is initialized again")
+	assert.Assert(t, devices[cambricon.CambriconMLUDevice] == previous[cambricon.CambriconMLUDevice], "the unchanged vendors are kept")
+	assert.Assert(t, previous[nvidia.NvidiaGPUDevice] != nil, "the previous devices are not modified")
+
+	current := schedulerconfig.Current()
+	assert.Equal(t, current.NodeSchedulerPolicy, "spread")
+	assert.Equal(t, current.GPUSchedulerPolicy, schedulerconfig.GPUSchedulerPolicy, "the fields left out keep their flag")
+	assert.DeepEqual(t, current.NodeLabelSelector, map[string]string{"gpu": "on"})
+	assert.Equal(t, current.NodeLockTimeout, 2*time.Minute)
+}
+
+func Test_ReloadConfig_Rejected(t *testing.T) {
+	path := setupReloadTest(t)
+	version := ConfigVersion()
+	failures := ReloadFailures()
+
+	for _, config := range []string{
+		"nvidia: [",
+		loadTestConfig() + "\nscheduler:\n  nodeSchedulerPolicy: random\n",
+		loadTestConfig() + "\nscheduler:\n  gpuSchedulerPolicy: random\n",
+		loadTestConfig() + "\nscheduler:\n  nodeLockTimeout: -1m\n",
+	} {
+		assert.NilError(t, os.WriteFile(path, []byte(config), 0644))
+		swapped, err := ReloadConfig(path)
+		assert.Assert(t, err != nil, config)
+		assert.Assert(t, !swapped, config)
+	}
+	assert.Equal(t, ConfigVersion(), version, "the previous config stays active")
+	assert.Equal(t, ReloadFailures(), failures+4)
+}
+
+func Test_WatchConfigFile(t *testing.T) {
+	path := setupReloadTest(t)
+	version := ConfigVersion()
+	previousFile := configFile
+	configFile = path
+	t.Cleanup(func() { configFile = previousFile })
+
+	stop := make(chan struct{})
+	defer close(stop)
+	assert.NilError(t, WatchConfigFile(stop))
+
+	config := loadTestConfig() + "\nscheduler:\n  nodeSchedulerPolicy: spread\n"
+	assert.NilError(t, os.WriteFile(path, []byte(config), 0644))
+	deadline := time.Now().Add(10 * time.Second)
+	for ConfigVersion() == version && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Assert(t, ConfigVersion() != version, "the config is reloaded when the file changes")
+	assert.Equal(t, schedulerconfig.Current().NodeSchedulerPolicy, "spread")
+}
+
+func Test_ReloadConfig_annotationsChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "device-config.yaml")
+	withProfile := func(allocated string) string {
+		return loadTestConfig() + `
+profiles:
+- name: RELOAD
+  resourceCountName: reload.com/gpu
+  topology:
+    source: annotation
+  annotations:
+    allocated: ` + allocated + "\n"
+	}
+	assert.NilError(t, os.WriteFile(path, []byte(withProfile("hami.io/reload-allocated")), 0644))
+	config, err := LoadConfig(path)
+	assert.NilError(t, err)
+	assert.NilError(t, InitDevicesWithConfig(config))
+	t.Cleanup(func() {
+		schedulerconfig.SetReloadable(nil)
+		nodelock.SetNodeLockTimeout(0)
+	})
+	version := ConfigVersion()
+
+	assert.NilError(t, os.WriteFile(path, []byte(withProfile("hami.io/reload-allocated-v2")), 0644))
+	swapped, err := ReloadConfig(path)
+	assert.ErrorContains(t, err, "RELOAD=hami.io/reload-allocated-v2")
+	assert.Assert(t, !swapped)
+	assert.Equal(t, ConfigVersion(), version, "the previous config stays active")
+	assert.Equal(t, util.SupportDevices["RELOAD"], "hami.io/reload-allocated", "the annotations read by the scheduler are not modified")
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package remote lets device vendors run out of tree, as gRPC servers the
+// scheduler calls for each method of the Devices interface.
+//
+// The messages are JSON encoded, with the gRPC content-subtype "json", so
+// that plugins can be written in any language without generated code. The
+// Kubernetes objects are encoded as by the API server, and the types of
+// pkg/util with their Go field names.
+package remote
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+const (
+	// APIVersion is the version of the Device service the scheduler calls.
+	APIVersion = "v1alpha1"
+	// RegistrationServiceName is the service the scheduler checks the name
+	// and the API versions of a plugin with, before calling it.
+	RegistrationServiceName = "hami.device.registration.v1.Registration"
+	// DeviceServiceName is the service mirroring the Devices interface.
+	DeviceServiceName = "hami.device." + APIVersion + ".Device"
+
+	codecName = "json"
+)
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec is the codec of the messages, selected by the content-subtype.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return codecName }
+
+type Empty struct{}
+
+type InfoRequest struct{}
+
+// Info is how a plugin registers: the device type it serves and the versions
+// of the Device service it implements.
+type Info struct {
+	Name        string   `json:"name"`
+	APIVersions []string `json:"apiVersions"`
+}
+
+type MutateAdmissionRequest struct {
+	Container *corev1.Container `json:"container"`
+	Pod       *corev1.Pod       `json:"pod"`
+}
+
+// MutateAdmissionResponse holds the container and the pod as mutated. Of the
+// changes to the pod, those to its containers are ignored.
+type MutateAdmissionResponse struct {
+	Found     bool              `json:"found"`
+	Container *corev1.Container `json:"container"`
+	Pod       *corev1.Pod       `json:"pod"`
+}
+
+type CheckHealthRequest struct {
+	Type string       `json:"type"`
+	Node *corev1.Node `json:"node"`
+}
+
+type CheckHealthResponse struct {
+	Healthy bool `json:"healthy"`
+	Changed bool `json:"changed"`
+}
+
+type NodeCleanUpRequest struct {
+	Node string `json:"node"`
+}
+
+type GetNodeDevicesRequest struct {
+	Node *corev1.Node `json:"node"`
+}
+
+type GetNodeDevicesResponse struct {
+	Devices []*util.DeviceInfo `json:"devices"`
+}
+
+// NodeLockRequest is the request of LockNode and ReleaseNodeLock.
+type NodeLockRequest struct {
+	Node *corev1.Node `json:"node"`
+	Pod  *corev1.Pod  `json:"pod"`
+}
+
+type GenerateResourceRequestsRequest struct {
+	Container *corev1.Container `json:"container"`
+}
+
+type GenerateResourceRequestsResponse struct {
+	Request util.ContainerDeviceRequest `json:"request"`
+}
+
+type PatchAnnotationsRequest struct {
+	Pod         *corev1.Pod       `json:"pod"`
+	Annotations map[string]string `json:"annotations"`
+	Devices     util.PodDevices   `json:"devices"`
+}
+
+// PatchAnnotationsResponse holds the annotations of the request, with those
+// of the plugin added.
+type PatchAnnotationsResponse struct {
+	Annotations map[string]string `json:"annotations"`
+}
+
+type ScoreNodeRequest struct {
+	Node     *corev1.Node         `json:"node"`
+	Devices  util.PodSingleDevice `json:"devices"`
+	Previous []*util.DeviceUsage  `json:"previous"`
+	Policy   string               `json:"policy"`
+}
+
+type ScoreNodeResponse struct {
+	Score float32 `json:"score"`
+}
+
+type AddResourceUsageRequest struct {
+	Pod    *corev1.Pod           `json:"pod"`
+	Usage  *util.DeviceUsage     `json:"usage"`
+	Device *util.ContainerDevice `json:"device"`
+}
+
+// AddResourceUsageResponse holds the usage and the device of the request as updated.
+type AddResourceUsageResponse struct {
+	Usage  *util.DeviceUsage     `json:"usage"`
+	Device *util.ContainerDevice `json:"device"`
+}
+
+type FitRequest struct {
+	Devices     []*util.DeviceUsage         `json:"devices"`
+	Request     util.ContainerDeviceRequest `json:"request"`
+	Annotations map[string]string           `json:"annotations"`
+	Pod         *corev1.Pod                 `json:"pod"`
+	NodeInfo    *util.NodeInfo              `json:"nodeInfo"`
+	Allocated   util.PodDevices             `json:"allocated"`
+}
+
+type FitResponse struct {
+	Fit     bool                             `json:"fit"`
+	Devices map[string]util.ContainerDevices `json:"devices"`
+	Reason  string                           `json:"reason"`
+}
+
+// RegistrationServer is the server of the registration service.
+type RegistrationServer interface {
+	GetInfo(context.Context, *InfoRequest) (*Info, error)
+}
+
+// DeviceServer is the server of the Device service. The errors it returns
+// with codes.FailedPrecondition are the errors of the Devices interface, such
+// as a denied admission or a node already locked; the others are failures of
+// the plugin.
+type DeviceServer interface {
+	MutateAdmission(context.Context, *MutateAdmissionRequest) (*MutateAdmissionResponse, error)
+	CheckHealth(context.Context, *CheckHealthRequest) (*CheckHealthResponse, error)
+	NodeCleanUp(context.Context, *NodeCleanUpRequest) (*Empty, error)
+	GetNodeDevices(context.Context, *GetNodeDevicesRequest) (*GetNodeDevicesResponse, error)
+	LockNode(context.Context, *NodeLockRequest) (*Empty, error)
+	ReleaseNodeLock(context.Context, *NodeLockRequest) (*Empty, error)
+	GenerateResourceRequests(context.Context, *GenerateResourceRequestsRequest) (*GenerateResourceRequestsResponse, error)
+	PatchAnnotations(context.Context, *PatchAnnotationsRequest) (*PatchAnnotationsResponse, error)
+	ScoreNode(context.Context, *ScoreNodeRequest) (*ScoreNodeResponse, error)
+	AddResourceUsage(context.Context, *AddResourceUsageRequest) (*AddResourceUsageResponse, error)
+	Fit(context.Context, *FitRequest) (*FitResponse, error)
+}
+
+// RegisterRegistrationServer registers the registration service on a gRPC server.
+func RegisterRegistrationServer(s *grpc.Server, srv RegistrationServer) {
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: RegistrationServiceName,
+		HandlerType: (*RegistrationServer)(nil),
+		Methods: []grpc.MethodDesc{
+			method(RegistrationServiceName, "GetInfo", RegistrationServer.GetInfo),
+		},
+	}, srv)
+}
+
+// RegisterDeviceServer registers the Device service on a gRPC server.
+func RegisterDeviceServer(s *grpc.Server, srv DeviceServer) {
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: DeviceServiceName,
+		HandlerType: (*DeviceServer)(nil),
+		Methods: []grpc.MethodDesc{
+			method(DeviceServiceName, "MutateAdmission", DeviceServer.MutateAdmission),
+			method(DeviceServiceName, "CheckHealth", DeviceServer.CheckHealth),
+			method(DeviceServiceName, "NodeCleanUp", DeviceServer.NodeCleanUp),
+			method(DeviceServiceName, "GetNodeDevices", DeviceServer.GetNodeDevices),
+			method(DeviceServiceName, "LockNode", DeviceServer.LockNode),
+			method(DeviceServiceName, "ReleaseNodeLock", DeviceServer.ReleaseNodeLock),
+			method(DeviceServiceName, "GenerateResourceRequests", DeviceServer.GenerateResourceRequests),
+			method(DeviceServiceName, "PatchAnnotations", DeviceServer.PatchAnnotations),
+			method(DeviceServiceName, "ScoreNode", DeviceServer.ScoreNode),
+			method(DeviceServiceName, "AddResourceUsage", DeviceServer.AddResourceUsage),
+			method(DeviceServiceName, "Fit", DeviceServer.Fit),
+		},
+	}, srv)
+}
+
+// method returns the description of a unary method calling a method of the
+// server interface S.
+func method[S, Req, Resp any](service, name string, call func(S, context.Context, *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			in := new(Req)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(S), ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + service + "/" + name}
+			return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+				return call(srv.(S), ctx, req.(*Req))
+			})
+		},
+	}
+}
+
+// invoke calls a method of a service with the JSON codec.
+func invoke(ctx context.Context, cc grpc.ClientConnInterface, service, name string, in, out any) error {
+	return cc.Invoke(ctx, "/"+service+"/"+name, in, out, grpc.CallContentSubtype(codecName))
+}
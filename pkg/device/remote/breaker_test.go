@@ -0,0 +1,65 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func Test_breaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	fail := func() {
+		probe, err := b.allow()
+		assert.NilError(t, err)
+		b.done(probe, true)
+	}
+
+	fail()
+	assert.Assert(t, !b.open(), "one failure is below the threshold")
+	probe, err := b.allow()
+	assert.NilError(t, err)
+	b.done(probe, false)
+	fail()
+	assert.Assert(t, !b.open(), "a success resets the failures")
+	fail()
+	assert.Assert(t, b.open())
+
+	_, err = b.allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	now = now.Add(time.Minute)
+	probe, err = b.allow()
+	assert.NilError(t, err)
+	assert.Assert(t, probe)
+	_, err = b.allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen, "a single call probes the plugin")
+	b.done(probe, true)
+	_, err = b.allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen, "a failed probe opens the circuit again")
+
+	now = now.Add(time.Minute)
+	probe, err = b.allow()
+	assert.NilError(t, err)
+	b.done(probe, false)
+	assert.Assert(t, !b.open(), "a successful probe closes the circuit")
+}
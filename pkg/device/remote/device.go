@@ -0,0 +1,393 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/common"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+const (
+	DefaultTimeout          = time.Second
+	DefaultFailureThreshold = 3
+	DefaultOpenDuration     = 30 * time.Second
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+
+// Annotations are the pod annotations the devices assigned to a pod are
+// written to. The empty ones default to names derived from the vendor name.
+type Annotations struct {
+	ToAllocate string `yaml:"toAllocate"`
+	Allocated  string `yaml:"allocated"`
+}
+
+// TLS configures the TLS connection to a plugin.
+type TLS struct {
+	// CAFile verifies the certificate of the plugin, the system roots if empty.
+	CAFile string `yaml:"caFile"`
+	// CertFile and KeyFile are the client certificate, for plugins verifying
+	// the scheduler.
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// ServerName is the name of the certificate of the plugin, the host of
+	// the endpoint if empty.
+	ServerName string `yaml:"serverName"`
+}
+
+// Config declares a vendor served by a plugin.
+type Config struct {
+	// Name is the device type and common word, e.g. ACME. The plugin must
+	// register with the same name.
+	Name string `yaml:"name"`
+	// Endpoint is the gRPC target of the plugin, e.g.
+	// dns:///acme-plugin.kube-system:9443 or unix:///run/acme/plugin.sock.
+	Endpoint string `yaml:"endpoint"`
+	// ResourceCountName is the resource of the number of devices, and
+	// ResourceNames the other resources of the vendor. The plugin is only
+	// called for the containers requesting one of them.
+	ResourceCountName string   `yaml:"resourceCountName"`
+	ResourceNames     []string `yaml:"resourceNames"`
+	// Timeout bounds each call, DefaultTimeout if 0.
+	Timeout time.Duration `yaml:"timeout"`
+	// FailureThreshold is how many consecutive calls fail before the plugin
+	// is no longer called for OpenDuration, DefaultFailureThreshold and
+	// DefaultOpenDuration if 0.
+	FailureThreshold int           `yaml:"failureThreshold"`
+	OpenDuration     time.Duration `yaml:"openDuration"`
+	Annotations      Annotations   `yaml:"annotations"`
+	// TLS encrypts the connection to the plugin, which is not if nil.
+	TLS *TLS `yaml:"tls"`
+}
+
+// Validate checks the fields a vendor cannot do without.
+func (c *Config) Validate() error {
+	if !namePattern.MatchString(c.Name) {
+		return fmt.Errorf("invalid remote vendor name %q, expected letters and digits", c.Name)
+	}
+	if c.Endpoint == "" {
+		return fmt.Errorf("remote vendor %s: endpoint is required", c.Name)
+	}
+	if c.ResourceCountName == "" {
+		return fmt.Errorf("remote vendor %s: resourceCountName is required", c.Name)
+	}
+	if c.Timeout < 0 || c.FailureThreshold < 0 || c.OpenDuration < 0 {
+		return fmt.Errorf("remote vendor %s: timeout, failureThreshold and openDuration must not be negative", c.Name)
+	}
+	if c.TLS != nil && (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("remote vendor %s: tls certFile and keyFile must be set together", c.Name)
+	}
+	return nil
+}
+
+// transportCredentials returns the credentials of the connection to the plugin.
+func (c *Config) transportCredentials() (credentials.TransportCredentials, error) {
+	if c.TLS == nil {
+		return insecure.NewCredentials(), nil
+	}
+	tlsConfig := &tls.Config{ServerName: c.TLS.ServerName, MinVersion: tls.VersionTLS12}
+	if c.TLS.CAFile != "" {
+		ca, err := os.ReadFile(c.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", c.TLS.CAFile)
+		}
+	}
+	if c.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// withDefaults returns the config with its empty optional fields set.
+func (c Config) withDefaults() Config {
+	if c.Timeout == 0 {
+		c.Timeout = DefaultTimeout
+	}
+	if c.FailureThreshold == 0 {
+		c.FailureThreshold = DefaultFailureThreshold
+	}
+	if c.OpenDuration == 0 {
+		c.OpenDuration = DefaultOpenDuration
+	}
+	if c.Annotations.ToAllocate == "" {
+		c.Annotations.ToAllocate = fmt.Sprintf("hami.io/%s-devices-to-allocate", c.Name)
+	}
+	if c.Annotations.Allocated == "" {
+		c.Annotations.Allocated = fmt.Sprintf("hami.io/%s-devices-allocated", c.Name)
+	}
+	return c
+}
+
+// Devices calls the plugin of a vendor. Calls are bounded by the timeout of
+// the vendor, and fail fast while its breaker is open; the scheduler then
+// treats the vendor as having no fit, keeping the devices it learnt.
+type Devices struct {
+	config     Config
+	conn       *grpc.ClientConn
+	breaker    *breaker
+	registered atomic.Bool
+}
+
+// NewDevices returns the devices of a vendor.
+func NewDevices(config Config) (*Devices, error) {
+	config = config.withDefaults()
+	creds, err := config.transportCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("remote vendor %s: %v", config.Name, err)
+	}
+	conn, err := grpc.NewClient(config.Endpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("remote vendor %s: %v", config.Name, err)
+	}
+	util.SetDeviceAnno(util.InRequestDevices, config.Name, config.Annotations.ToAllocate)
+	util.SetDeviceAnno(util.SupportDevices, config.Name, config.Annotations.Allocated)
+	return &Devices{
+		config:  config,
+		conn:    conn,
+		breaker: newBreaker(config.FailureThreshold, config.OpenDuration),
+	}, nil
+}
+
+// Close closes the connection to the plugin.
+func (dev *Devices) Close() error {
+	return dev.conn.Close()
+}
+
+// Available returns whether the plugin is called, i.e. its breaker is closed.
+func (dev *Devices) Available() bool {
+	return !dev.breaker.open()
+}
+
+// register checks that the plugin serves the vendor with the API version of the scheduler.
+func (dev *Devices) register(ctx context.Context) error {
+	info := &Info{}
+	if err := invoke(ctx, dev.conn, RegistrationServiceName, "GetInfo", &InfoRequest{}, info); err != nil {
+		return err
+	}
+	if info.Name != dev.config.Name || !slices.Contains(info.APIVersions, APIVersion) {
+		return fmt.Errorf("plugin registered %s with API versions %v, expected %s with %s", info.Name, info.APIVersions, dev.config.Name, APIVersion)
+	}
+	dev.registered.Store(true)
+	klog.InfoS("Remote vendor registered", "vendor", dev.config.Name, "endpoint", dev.config.Endpoint, "apiVersion", APIVersion)
+	return nil
+}
+
+// call calls a method of the Device service, registering the plugin first if
+// needed. The errors of the Devices interface are returned as plain errors.
+func (dev *Devices) call(method string, in, out any) error {
+	probe, err := dev.breaker.allow()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dev.config.Timeout)
+	defer cancel()
+	if !dev.registered.Load() {
+		err = dev.register(ctx)
+	}
+	if err == nil {
+		err = invoke(ctx, dev.conn, DeviceServiceName, method, in, out)
+	}
+	if status.Code(err) == codes.FailedPrecondition {
+		dev.breaker.done(probe, false)
+		return errors.New(status.Convert(err).Message())
+	}
+	dev.breaker.done(probe, err != nil)
+	if err != nil {
+		dev.registered.Store(false)
+		klog.ErrorS(err, "Remote vendor call failed", "vendor", dev.config.Name, "method", method, "circuitOpen", dev.breaker.open())
+	}
+	return err
+}
+
+func (dev *Devices) CommonWord() string {
+	return dev.config.Name
+}
+
+// requests returns whether a container requests a resource of the vendor.
+func (dev *Devices) requests(ctr *corev1.Container) bool {
+	for _, name := range append([]string{dev.config.ResourceCountName}, dev.config.ResourceNames...) {
+		if _, ok := ctr.Resources.Limits[corev1.ResourceName(name)]; ok {
+			return true
+		}
+		if _, ok := ctr.Resources.Requests[corev1.ResourceName(name)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (dev *Devices) podRequests(pod *corev1.Pod) bool {
+	for i := range pod.Spec.Containers {
+		if dev.requests(&pod.Spec.Containers[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (dev *Devices) MutateAdmission(ctr *corev1.Container, p *corev1.Pod) (bool, error) {
+	if !dev.requests(ctr) {
+		return false, nil
+	}
+	out := &MutateAdmissionResponse{}
+	if err := dev.call("MutateAdmission", &MutateAdmissionRequest{Container: ctr, Pod: p}, out); err != nil {
+		return false, fmt.Errorf("%s: %v", dev.config.Name, err)
+	}
+	if out.Container != nil {
+		*ctr = *out.Container
+	}
+	if out.Pod != nil {
+		containers := p.Spec.Containers
+		*p = *out.Pod
+		p.Spec.Containers = containers
+	}
+	return out.Found, nil
+}
+
+// CheckHealth keeps the devices of the node as they are if the plugin fails.
+func (dev *Devices) CheckHealth(devType string, n *corev1.Node) (bool, bool) {
+	out := &CheckHealthResponse{}
+	if err := dev.call("CheckHealth", &CheckHealthRequest{Type: devType, Node: n}, out); err != nil {
+		return true, false
+	}
+	return out.Healthy, out.Changed
+}
+
+func (dev *Devices) NodeCleanUp(nn string) error {
+	return dev.call("NodeCleanUp", &NodeCleanUpRequest{Node: nn}, &Empty{})
+}
+
+func (dev *Devices) GetNodeDevices(n corev1.Node) ([]*util.DeviceInfo, error) {
+	out := &GetNodeDevicesResponse{}
+	if err := dev.call("GetNodeDevices", &GetNodeDevicesRequest{Node: &n}, out); err != nil {
+		return nil, err
+	}
+	return out.Devices, nil
+}
+
+func (dev *Devices) LockNode(n *corev1.Node, p *corev1.Pod) error {
+	if !dev.podRequests(p) {
+		return nil
+	}
+	return dev.call("LockNode", &NodeLockRequest{Node: n, Pod: p}, &Empty{})
+}
+
+func (dev *Devices) ReleaseNodeLock(n *corev1.Node, p *corev1.Pod) error {
+	if !dev.podRequests(p) {
+		return nil
+	}
+	return dev.call("ReleaseNodeLock", &NodeLockRequest{Node: n, Pod: p}, &Empty{})
+}
+
+// GenerateResourceRequests falls back to the number of devices requested if
+// the plugin fails, so that the pod does not fit rather than being scheduled
+// without its devices.
+func (dev *Devices) GenerateResourceRequests(ctr *corev1.Container) util.ContainerDeviceRequest {
+	if !dev.requests(ctr) {
+		return util.ContainerDeviceRequest{}
+	}
+	out := &GenerateResourceRequestsResponse{}
+	if err := dev.call("GenerateResourceRequests", &GenerateResourceRequestsRequest{Container: ctr}, out); err != nil {
+		nums := int32(1)
+		if v, ok := ctr.Resources.Limits[corev1.ResourceName(dev.config.ResourceCountName)]; ok {
+			nums = int32(v.Value())
+		}
+		return util.ContainerDeviceRequest{Nums: nums, Type: dev.config.Name}
+	}
+	return out.Request
+}
+
+// PatchAnnotations writes the devices in the annotations of the vendor if the plugin fails.
+func (dev *Devices) PatchAnnotations(pod *corev1.Pod, annoinput *map[string]string, pd util.PodDevices) map[string]string {
+	devlist, ok := pd[dev.config.Name]
+	if !ok || len(devlist) == 0 {
+		return *annoinput
+	}
+	out := &PatchAnnotationsResponse{}
+	if err := dev.call("PatchAnnotations", &PatchAnnotationsRequest{Pod: pod, Annotations: *annoinput, Devices: pd}, out); err != nil {
+		deviceStr := util.EncodePodSingleDevice(devlist)
+		(*annoinput)[dev.config.Annotations.ToAllocate] = deviceStr
+		(*annoinput)[dev.config.Annotations.Allocated] = deviceStr
+		return *annoinput
+	}
+	for k, v := range out.Annotations {
+		(*annoinput)[k] = v
+	}
+	return *annoinput
+}
+
+func (dev *Devices) ScoreNode(node *corev1.Node, podDevices util.PodSingleDevice, previous []*util.DeviceUsage, policy string) float32 {
+	out := &ScoreNodeResponse{}
+	if err := dev.call("ScoreNode", &ScoreNodeRequest{Node: node, Devices: podDevices, Previous: previous, Policy: policy}, out); err != nil {
+		return 0
+	}
+	return out.Score
+}
+
+func (dev *Devices) AddResourceUsage(pod *corev1.Pod, n *util.DeviceUsage, ctr *util.ContainerDevice) error {
+	out := &AddResourceUsageResponse{}
+	if err := dev.call("AddResourceUsage", &AddResourceUsageRequest{Pod: pod, Usage: n, Device: ctr}, out); err != nil {
+		return err
+	}
+	if out.Usage != nil {
+		*n = *out.Usage
+	}
+	if out.Device != nil {
+		*ctr = *out.Device
+	}
+	return nil
+}
+
+// Fit does not fit any device if the plugin fails.
+func (dev *Devices) Fit(devices []*util.DeviceUsage, request util.ContainerDeviceRequest, annos map[string]string, pod *corev1.Pod, nodeInfo *util.NodeInfo, allocated *util.PodDevices) (bool, map[string]util.ContainerDevices, string) {
+	in := &FitRequest{Devices: devices, Request: request, Annotations: annos, Pod: pod, NodeInfo: nodeInfo}
+	if allocated != nil {
+		in.Allocated = *allocated
+	}
+	out := &FitResponse{}
+	if err := dev.call("Fit", in, out); err != nil {
+		return false, nil, common.GenReason(map[string]int{common.PluginUnavailable: len(devices)}, len(devices))
+	}
+	return out.Fit, out.Devices, out.Reason
+}
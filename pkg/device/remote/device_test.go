@@ -0,0 +1,220 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/profile"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// slowVendor is a vendor whose Fit takes delay.
+type slowVendor struct {
+	Vendor
+	delay time.Duration
+}
+
+func (v *slowVendor) Fit(devices []*util.DeviceUsage, request util.ContainerDeviceRequest, annos map[string]string, pod *corev1.Pod, nodeInfo *util.NodeInfo, allocated *util.PodDevices) (bool, map[string]util.ContainerDevices, string) {
+	time.Sleep(v.delay)
+	return v.Vendor.Fit(devices, request, annos, pod, nodeInfo, allocated)
+}
+
+func acmeVendor(t *testing.T) Vendor {
+	p := profile.Profile{
+		Name:               "ACME",
+		ResourceCountName:  "acme.com/gpu",
+		ResourceMemoryName: "acme.com/gpumem",
+		MemoryShareable:    true,
+		CoresShareable:     true,
+		Topology:           profile.Topology{Source: profile.TopologyAnnotation},
+	}
+	assert.NilError(t, p.Validate())
+	return profile.InitDevices([]profile.Profile{p})[0]
+}
+
+// servePlugin serves a vendor on a unix socket and returns its endpoint.
+func servePlugin(t *testing.T, vendor Vendor, opts ...grpc.ServerOption) string {
+	socket := filepath.Join(t.TempDir(), "plugin.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.NilError(t, err)
+	server := grpc.NewServer(opts...)
+	NewVendorServer(vendor).Register(server)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return "unix://" + socket
+}
+
+func newDevices(t *testing.T, config Config) *Devices {
+	dev, err := NewDevices(config)
+	assert.NilError(t, err)
+	t.Cleanup(func() { dev.Close() })
+	return dev
+}
+
+func acmeNode() corev1.Node {
+	return corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Annotations: map[string]string{
+		"hami.io/node-register-ACME": "acme-0,4,16384,100,X1,0,true:acme-1,4,16384,100,X1,0,true:",
+	}}}
+}
+
+func acmeContainer() *corev1.Container {
+	return &corev1.Container{Name: "main", Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+		"acme.com/gpu":    resource.MustParse("1"),
+		"acme.com/gpumem": resource.MustParse("4096"),
+	}}}
+}
+
+func Test_Devices(t *testing.T) {
+	vendor := acmeVendor(t)
+	dev := newDevices(t, Config{Name: "ACME", Endpoint: servePlugin(t, vendor), ResourceCountName: "acme.com/gpu", ResourceNames: []string{"acme.com/gpumem"}})
+	assert.Equal(t, util.InRequestDevices["ACME"], "hami.io/ACME-devices-to-allocate")
+
+	node := acmeNode()
+	devices, err := dev.GetNodeDevices(node)
+	assert.NilError(t, err)
+	want, _ := vendor.GetNodeDevices(node)
+	assert.DeepEqual(t, devices, want)
+
+	_, err = dev.GetNodeDevices(corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}})
+	assert.ErrorContains(t, err, "annos not found")
+	assert.Assert(t, dev.Available(), "the errors of the vendor are not failures of the plugin")
+
+	ctr := acmeContainer()
+	assert.DeepEqual(t, dev.GenerateResourceRequests(ctr), util.ContainerDeviceRequest{Nums: 1, Type: "ACME", Memreq: 4096})
+	assert.DeepEqual(t, dev.GenerateResourceRequests(&corev1.Container{}), util.ContainerDeviceRequest{})
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}, Spec: corev1.PodSpec{Containers: []corev1.Container{*ctr}}}
+	usage := []*util.DeviceUsage{
+		{ID: "acme-0", Count: 4, Totalmem: 16384, Totalcore: 100, Type: "ACME-X1", Health: true},
+		{ID: "acme-1", Count: 4, Totalmem: 16384, Totalcore: 100, Type: "ACME-X1", Health: true},
+	}
+	fit, assigned, reason := dev.Fit(usage, dev.GenerateResourceRequests(ctr), nil, pod, &util.NodeInfo{ID: "node1"}, &util.PodDevices{})
+	assert.Assert(t, fit, reason)
+	assert.DeepEqual(t, assigned, map[string]util.ContainerDevices{"ACME": {{UUID: "acme-1", Type: "ACME", Usedmem: 4096}}})
+
+	assert.NilError(t, dev.AddResourceUsage(pod, usage[1], &assigned["ACME"][0]))
+	assert.Equal(t, usage[1].Used, int32(1))
+	assert.Equal(t, usage[1].Usedmem, int32(4096))
+
+	annos := map[string]string{"existing": "kept"}
+	dev.PatchAnnotations(pod, &annos, util.PodDevices{"ACME": {assigned["ACME"]}})
+	assert.Equal(t, annos["existing"], "kept")
+	assert.Equal(t, annos["hami.io/ACME-devices-allocated"], util.EncodePodSingleDevice(util.PodSingleDevice{assigned["ACME"]}))
+}
+
+func Test_Devices_registration(t *testing.T) {
+	endpoint := servePlugin(t, acmeVendor(t))
+	dev := newDevices(t, Config{Name: "BETA", Endpoint: endpoint, ResourceCountName: "beta.com/gpu"})
+	_, err := dev.GetNodeDevices(acmeNode())
+	assert.ErrorContains(t, err, "plugin registered ACME with API versions [v1alpha1], expected BETA with v1alpha1")
+}
+
+func Test_Devices_circuitBreaker(t *testing.T) {
+	vendor := &slowVendor{Vendor: acmeVendor(t), delay: time.Second}
+	dev := newDevices(t, Config{
+		Name:              "ACME",
+		Endpoint:          servePlugin(t, vendor),
+		ResourceCountName: "acme.com/gpu",
+		Timeout:           100 * time.Millisecond,
+		FailureThreshold:  2,
+		OpenDuration:      time.Hour,
+	})
+	usage := []*util.DeviceUsage{{ID: "acme-0", Count: 4, Totalmem: 16384, Totalcore: 100, Type: "ACME-X1", Health: true}}
+	request := util.ContainerDeviceRequest{Nums: 1, Type: "ACME", Memreq: 1024}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}}
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		fit, _, reason := dev.Fit(usage, request, nil, pod, &util.NodeInfo{}, &util.PodDevices{})
+		assert.Assert(t, !fit)
+		assert.Equal(t, reason, "1/1 PluginUnavailable")
+		assert.Assert(t, time.Since(start) < time.Second, "the call is bounded by the timeout")
+	}
+	assert.Assert(t, !dev.Available())
+
+	start := time.Now()
+	_, err := dev.GetNodeDevices(acmeNode())
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Assert(t, time.Since(start) < 50*time.Millisecond, "calls fail fast while the circuit is open")
+	healthy, changed := dev.CheckHealth("ACME", &corev1.Node{})
+	assert.Assert(t, healthy && !changed, "the devices are kept while the circuit is open")
+}
+
+// selfSignedCert writes a self-signed certificate for name and its key to dir,
+// and returns their files.
+func selfSignedCert(t *testing.T, dir, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NilError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NilError(t, err)
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	assert.NilError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NilError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func Test_Devices_TLS(t *testing.T) {
+	certFile, keyFile := selfSignedCert(t, t.TempDir(), "acme-plugin")
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	assert.NilError(t, err)
+	endpoint := servePlugin(t, acmeVendor(t), grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}})))
+
+	dev := newDevices(t, Config{Name: "ACME", Endpoint: endpoint, ResourceCountName: "acme.com/gpu", TLS: &TLS{CAFile: certFile, ServerName: "acme-plugin"}})
+	_, err = dev.GetNodeDevices(acmeNode())
+	assert.NilError(t, err)
+
+	dev = newDevices(t, Config{Name: "ACME", Endpoint: endpoint, ResourceCountName: "acme.com/gpu", TLS: &TLS{CAFile: certFile, ServerName: "other"}})
+	_, err = dev.GetNodeDevices(acmeNode())
+	assert.ErrorContains(t, err, "certificate")
+
+	_, err = NewDevices(Config{Name: "ACME", Endpoint: endpoint, TLS: &TLS{CAFile: keyFile}})
+	assert.ErrorContains(t, err, "no certificate found")
+
+	config := Config{Name: "ACME", Endpoint: endpoint, ResourceCountName: "acme.com/gpu", TLS: &TLS{CertFile: certFile}}
+	assert.ErrorContains(t, config.Validate(), "certFile and keyFile must be set together")
+}
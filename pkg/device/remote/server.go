@@ -0,0 +1,144 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// Vendor is the Devices interface of pkg/device, which cannot be imported
+// here. Vendor packages satisfy it as they are.
+type Vendor interface {
+	CommonWord() string
+	MutateAdmission(ctr *corev1.Container, pod *corev1.Pod) (bool, error)
+	CheckHealth(devType string, n *corev1.Node) (bool, bool)
+	NodeCleanUp(nn string) error
+	GetNodeDevices(n corev1.Node) ([]*util.DeviceInfo, error)
+	LockNode(n *corev1.Node, p *corev1.Pod) error
+	ReleaseNodeLock(n *corev1.Node, p *corev1.Pod) error
+	GenerateResourceRequests(ctr *corev1.Container) util.ContainerDeviceRequest
+	PatchAnnotations(pod *corev1.Pod, annoinput *map[string]string, pd util.PodDevices) map[string]string
+	ScoreNode(node *corev1.Node, podDevices util.PodSingleDevice, previous []*util.DeviceUsage, policy string) float32
+	AddResourceUsage(pod *corev1.Pod, n *util.DeviceUsage, ctr *util.ContainerDevice) error
+	Fit(devices []*util.DeviceUsage, request util.ContainerDeviceRequest, annos map[string]string, pod *corev1.Pod, nodeInfo *util.NodeInfo, allocated *util.PodDevices) (bool, map[string]util.ContainerDevices, string)
+}
+
+// VendorServer serves a Vendor written in Go as a plugin.
+type VendorServer struct {
+	vendor Vendor
+}
+
+var (
+	_ RegistrationServer = &VendorServer{}
+	_ DeviceServer       = &VendorServer{}
+)
+
+// NewVendorServer returns the server of a vendor.
+func NewVendorServer(vendor Vendor) *VendorServer {
+	return &VendorServer{vendor: vendor}
+}
+
+// Register registers the registration and the Device services on a gRPC server.
+func (s *VendorServer) Register(server *grpc.Server) {
+	RegisterRegistrationServer(server, s)
+	RegisterDeviceServer(server, s)
+}
+
+// answer returns an error of the Devices interface as a gRPC error.
+func answer(err error) error {
+	if err == nil {
+		return nil
+	}
+	return status.Error(codes.FailedPrecondition, err.Error())
+}
+
+func (s *VendorServer) GetInfo(context.Context, *InfoRequest) (*Info, error) {
+	return &Info{Name: s.vendor.CommonWord(), APIVersions: []string{APIVersion}}, nil
+}
+
+func (s *VendorServer) MutateAdmission(_ context.Context, in *MutateAdmissionRequest) (*MutateAdmissionResponse, error) {
+	found, err := s.vendor.MutateAdmission(in.Container, in.Pod)
+	if err != nil {
+		return nil, answer(err)
+	}
+	return &MutateAdmissionResponse{Found: found, Container: in.Container, Pod: in.Pod}, nil
+}
+
+func (s *VendorServer) CheckHealth(_ context.Context, in *CheckHealthRequest) (*CheckHealthResponse, error) {
+	healthy, changed := s.vendor.CheckHealth(in.Type, in.Node)
+	return &CheckHealthResponse{Healthy: healthy, Changed: changed}, nil
+}
+
+func (s *VendorServer) NodeCleanUp(_ context.Context, in *NodeCleanUpRequest) (*Empty, error) {
+	return &Empty{}, answer(s.vendor.NodeCleanUp(in.Node))
+}
+
+func (s *VendorServer) GetNodeDevices(_ context.Context, in *GetNodeDevicesRequest) (*GetNodeDevicesResponse, error) {
+	devices, err := s.vendor.GetNodeDevices(*in.Node)
+	if err != nil {
+		return nil, answer(err)
+	}
+	return &GetNodeDevicesResponse{Devices: devices}, nil
+}
+
+func (s *VendorServer) LockNode(_ context.Context, in *NodeLockRequest) (*Empty, error) {
+	return &Empty{}, answer(s.vendor.LockNode(in.Node, in.Pod))
+}
+
+func (s *VendorServer) ReleaseNodeLock(_ context.Context, in *NodeLockRequest) (*Empty, error) {
+	return &Empty{}, answer(s.vendor.ReleaseNodeLock(in.Node, in.Pod))
+}
+
+func (s *VendorServer) GenerateResourceRequests(_ context.Context, in *GenerateResourceRequestsRequest) (*GenerateResourceRequestsResponse, error) {
+	return &GenerateResourceRequestsResponse{Request: s.vendor.GenerateResourceRequests(in.Container)}, nil
+}
+
+func (s *VendorServer) PatchAnnotations(_ context.Context, in *PatchAnnotationsRequest) (*PatchAnnotationsResponse, error) {
+	annos := in.Annotations
+	if annos == nil {
+		annos = map[string]string{}
+	}
+	s.vendor.PatchAnnotations(in.Pod, &annos, in.Devices)
+	return &PatchAnnotationsResponse{Annotations: annos}, nil
+}
+
+func (s *VendorServer) ScoreNode(_ context.Context, in *ScoreNodeRequest) (*ScoreNodeResponse, error) {
+	return &ScoreNodeResponse{Score: s.vendor.ScoreNode(in.Node, in.Devices, in.Previous, in.Policy)}, nil
+}
+
+func (s *VendorServer) AddResourceUsage(_ context.Context, in *AddResourceUsageRequest) (*AddResourceUsageResponse, error) {
+	if err := s.vendor.AddResourceUsage(in.Pod, in.Usage, in.Device); err != nil {
+		return nil, answer(err)
+	}
+	return &AddResourceUsageResponse{Usage: in.Usage, Device: in.Device}, nil
+}
+
+func (s *VendorServer) Fit(_ context.Context, in *FitRequest) (*FitResponse, error) {
+	allocated := in.Allocated
+	if allocated == nil {
+		allocated = util.PodDevices{}
+	}
+	fit, devices, reason := s.vendor.Fit(in.Devices, in.Request, in.Annotations, in.Pod, in.NodeInfo, &allocated)
+	return &FitResponse{Fit: fit, Devices: devices, Reason: reason}, nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dra
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// Controller publishes the vGPU slices of every node as a ResourceSlice, kept
+// in sync with the devices the device plugin registers in node annotations.
+type Controller struct {
+	kubeClient kubernetes.Interface
+}
+
+func NewController(kubeClient kubernetes.Interface) *Controller {
+	return &Controller{kubeClient: kubeClient}
+}
+
+// Run syncs the ResourceSlices of all nodes until ctx is done.
+func (c *Controller) Run(ctx context.Context) {
+	factory := informers.NewSharedInformerFactoryWithOptions(c.kubeClient, time.Hour*1)
+	factory.Core().V1().Nodes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			if node, ok := obj.(*corev1.Node); ok {
+				c.syncNode(ctx, node)
+			}
+		},
+		UpdateFunc: func(_, obj any) {
+			if node, ok := obj.(*corev1.Node); ok {
+				c.syncNode(ctx, node)
+			}
+		},
+		// ResourceSlices are owned by their node and garbage collected with it.
+	})
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	klog.InfoS("DRA controller started", "driver", DriverName)
+	<-ctx.Done()
+}
+
+// syncNode creates, updates or deletes the ResourceSlice of a node to match its
+// registered devices. The pool generation is bumped on every change.
+func (c *Controller) syncNode(ctx context.Context, node *corev1.Node) {
+	slices := c.kubeClient.ResourceV1alpha3().ResourceSlices()
+	devices, err := nodeDevices(node)
+	if err != nil {
+		klog.ErrorS(err, "Failed to decode node devices", "node", node.Name)
+		return
+	}
+	current, err := slices.Get(ctx, resourceSliceName(node.Name), metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.ErrorS(err, "Failed to get ResourceSlice", "node", node.Name)
+		return
+	}
+	found := err == nil
+	if len(devices) == 0 {
+		if found {
+			klog.InfoS("Deleting ResourceSlice of node without vGPUs", "node", node.Name)
+			if err := slices.Delete(ctx, current.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				klog.ErrorS(err, "Failed to delete ResourceSlice", "node", node.Name)
+			}
+		}
+		return
+	}
+	if !found {
+		if _, err := slices.Create(ctx, BuildResourceSlice(node, devices, 0), metav1.CreateOptions{}); err != nil {
+			klog.ErrorS(err, "Failed to create ResourceSlice", "node", node.Name)
+			return
+		}
+		klog.InfoS("Created ResourceSlice", "node", node.Name, "devices", len(devices))
+		return
+	}
+	desired := BuildResourceSlice(node, devices, current.Spec.Pool.Generation)
+	if apiequality.Semantic.DeepEqual(current.Spec, desired.Spec) {
+		return
+	}
+	desired.Spec.Pool.Generation++
+	desired.ResourceVersion = current.ResourceVersion
+	if _, err := slices.Update(ctx, desired, metav1.UpdateOptions{}); err != nil {
+		klog.ErrorS(err, "Failed to update ResourceSlice", "node", node.Name)
+		return
+	}
+	klog.InfoS("Updated ResourceSlice", "node", node.Name, "generation", desired.Spec.Pool.Generation)
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dra
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	resourceapi "k8s.io/api/resource/v1alpha3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	drapb "k8s.io/kubelet/pkg/apis/dra/v1alpha4"
+	cdispec "tags.cncf.io/container-device-interface/specs-go"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+func testNode(devices ...*util.DeviceInfo) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node1",
+		UID:         "node1-uid",
+		Annotations: map[string]string{nvidia.RegisterAnnos: util.EncodeNodeDevices(devices)},
+	}}
+}
+
+func testDevice(index uint, mode string) *util.DeviceInfo {
+	return &util.DeviceInfo{
+		ID:      "GPU-" + string(rune('a'+index)),
+		Index:   index,
+		Count:   2,
+		Devmem:  16000,
+		Devcore: 100,
+		Type:    "NVIDIA-A10",
+		Mode:    mode,
+		Health:  true,
+	}
+}
+
+func testClaim(name string, config string, devices ...string) *resourceapi.ResourceClaim {
+	claim := &resourceapi.ResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: k8stypes.UID("uid-" + name)},
+		Status:     resourceapi.ResourceClaimStatus{Allocation: &resourceapi.AllocationResult{}},
+	}
+	for _, d := range devices {
+		claim.Status.Allocation.Devices.Results = append(claim.Status.Allocation.Devices.Results, resourceapi.DeviceRequestAllocationResult{
+			Request: "gpu", Driver: DriverName, Pool: "node1", Device: d,
+		})
+	}
+	if config != "" {
+		claim.Status.Allocation.Devices.Config = []resourceapi.DeviceAllocationConfiguration{{
+			Source: resourceapi.AllocationConfigSourceClaim,
+			DeviceConfiguration: resourceapi.DeviceConfiguration{Opaque: &resourceapi.OpaqueDeviceConfiguration{
+				Driver:     DriverName,
+				Parameters: runtime.RawExtension{Raw: []byte(config)},
+			}},
+		}}
+	}
+	return claim
+}
+
+func Test_BuildResourceSlice(t *testing.T) {
+	unhealthy := testDevice(2, nvidia.HamiCoreMode)
+	unhealthy.Health = false
+	slice := BuildResourceSlice(testNode(), []*util.DeviceInfo{testDevice(0, nvidia.HamiCoreMode), testDevice(1, nvidia.MigMode), unhealthy}, 3)
+
+	assert.Equal(t, "node1-vgpu-hami-io", slice.Name)
+	assert.Equal(t, DriverName, slice.Spec.Driver)
+	assert.Equal(t, int64(3), slice.Spec.Pool.Generation)
+	require.Len(t, slice.Spec.Devices, 2, "a GPU is split into Count slices, MIG and unhealthy GPUs are skipped")
+	assert.Equal(t, "gpu-0-1", slice.Spec.Devices[1].Name)
+	basic := slice.Spec.Devices[1].Basic
+	assert.Equal(t, "GPU-a", *basic.Attributes[AttributeUUID].StringValue)
+	assert.Equal(t, int64(1), *basic.Attributes[AttributeSlot].IntValue)
+	memory := basic.Capacity[CapacityMemory]
+	assert.Equal(t, int64(16000*1024*1024), memory.Value())
+}
+
+func Test_parseDeviceName(t *testing.T) {
+	index, err := parseDeviceName(deviceName(3, 7))
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), index)
+	for _, name := range []string{"gpu-3", "mig-3-1", "gpu-x-1"} {
+		_, err := parseDeviceName(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func Test_Controller_syncNode(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := fake.NewSimpleClientset()
+	c := NewController(kubeClient)
+	getSlice := func() *resourceapi.ResourceSlice {
+		slice, err := kubeClient.ResourceV1alpha3().ResourceSlices().Get(ctx, "node1-vgpu-hami-io", metav1.GetOptions{})
+		require.NoError(t, err)
+		return slice
+	}
+
+	c.syncNode(ctx, testNode(testDevice(0, nvidia.HamiCoreMode)))
+	assert.Len(t, getSlice().Spec.Devices, 2)
+	assert.Equal(t, int64(0), getSlice().Spec.Pool.Generation)
+
+	c.syncNode(ctx, testNode(testDevice(0, nvidia.HamiCoreMode)))
+	assert.Equal(t, int64(0), getSlice().Spec.Pool.Generation, "an unchanged node is not updated")
+
+	c.syncNode(ctx, testNode(testDevice(0, nvidia.HamiCoreMode), testDevice(1, nvidia.HamiCoreMode)))
+	assert.Len(t, getSlice().Spec.Devices, 4)
+	assert.Equal(t, int64(1), getSlice().Spec.Pool.Generation)
+
+	c.syncNode(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}})
+	_, err := kubeClient.ResourceV1alpha3().ResourceSlices().Get(ctx, "node1-vgpu-hami-io", metav1.GetOptions{})
+	assert.Error(t, err, "the slice of a node without vGPUs is deleted")
+}
+
+func Test_claimDevices(t *testing.T) {
+	devices := []*util.DeviceInfo{testDevice(0, nvidia.HamiCoreMode), testDevice(1, nvidia.HamiCoreMode)}
+	tests := []struct {
+		name    string
+		claim   *resourceapi.ResourceClaim
+		want    util.ContainerDevices
+		wantErr bool
+	}{
+		{
+			name:  "memory and cores",
+			claim: testClaim("a", `{"memory":4000,"cores":30}`, "gpu-1-0"),
+			want:  util.ContainerDevices{{Idx: 1, UUID: "GPU-b", Type: nvidia.NvidiaGPUDevice, Usedmem: 4000, Usedcores: 30}},
+		},
+		{
+			name:  "memory percentage",
+			claim: testClaim("a", `{"memoryPercentage":25}`, "gpu-0-1"),
+			want:  util.ContainerDevices{{Idx: 0, UUID: "GPU-a", Type: nvidia.NvidiaGPUDevice, Usedmem: 4000}},
+		},
+		{
+			name:  "no config gets the whole memory",
+			claim: testClaim("a", "", "gpu-0-0"),
+			want:  util.ContainerDevices{{Idx: 0, UUID: "GPU-a", Type: nvidia.NvidiaGPUDevice, Usedmem: 16000}},
+		},
+		{
+			name:    "invalid cores",
+			claim:   testClaim("a", `{"cores":120}`, "gpu-0-0"),
+			wantErr: true,
+		},
+		{
+			name:    "unknown device",
+			claim:   testClaim("a", "", "gpu-5-0"),
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := claimDevices(test.claim, devices)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func Test_KubeletPlugin_prepare(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	kubeClient := fake.NewSimpleClientset(
+		testNode(testDevice(0, nvidia.HamiCoreMode)),
+		testClaim("a", `{"memory":10000,"cores":50}`, "gpu-0-0"),
+		testClaim("b", `{"memory":10000,"cores":50}`, "gpu-0-1"),
+	)
+	config := KubeletPluginConfig{
+		NodeName:  "node1",
+		PluginDir: filepath.Join(dir, "plugin"),
+		CDIRoot:   filepath.Join(dir, "cdi"),
+		HAMiCore:  nvidia.HAMiCoreConfig{HookPath: filepath.Join(dir, "hook")},
+	}
+	p, err := NewKubeletPlugin(config, kubeClient)
+	require.NoError(t, err)
+
+	resp, err := p.NodePrepareResources(ctx, &drapb.NodePrepareResourcesRequest{Claims: []*drapb.Claim{
+		{Namespace: "default", Name: "a", UID: "uid-a"},
+		{Namespace: "default", Name: "b", UID: "uid-b"},
+	}})
+	require.NoError(t, err)
+	require.Empty(t, resp.Claims["uid-a"].Error)
+	assert.Equal(t, []string{"vgpu.hami.io/claim=uid-a"}, resp.Claims["uid-a"].Devices[0].CDIDeviceIDs)
+	assert.Contains(t, resp.Claims["uid-b"].Error, "not enough memory", "both claims do not fit on the GPU")
+
+	data, err := os.ReadFile(filepath.Join(config.CDIRoot, "vgpu-hami-io-uid-a.json"))
+	require.NoError(t, err)
+	spec := cdispec.Spec{}
+	require.NoError(t, json.Unmarshal(data, &spec))
+	assert.Contains(t, spec.Devices[0].ContainerEdits.Env, "NVIDIA_VISIBLE_DEVICES=GPU-a")
+	assert.Contains(t, spec.Devices[0].ContainerEdits.Env, "CUDA_DEVICE_MEMORY_LIMIT_0=10000m")
+	assert.Contains(t, spec.Devices[0].ContainerEdits.Env, "CUDA_DEVICE_SM_LIMIT=50")
+
+	// A restarted plugin still accounts for the prepared claim.
+	p, err = NewKubeletPlugin(config, kubeClient)
+	require.NoError(t, err)
+	assert.Contains(t, p.prepared, "uid-a")
+
+	_, err = p.NodeUnprepareResources(ctx, &drapb.NodeUnprepareResourcesRequest{Claims: []*drapb.Claim{{Namespace: "default", Name: "a", UID: "uid-a"}}})
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(config.CDIRoot, "vgpu-hami-io-uid-a.json"))
+	assert.True(t, os.IsNotExist(err))
+	resp, err = p.NodePrepareResources(ctx, &drapb.NodePrepareResourcesRequest{Claims: []*drapb.Claim{{Namespace: "default", Name: "b", UID: "uid-b"}}})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Claims["uid-b"].Error)
+}
+
+func Test_KubeletPlugin_prepare_allocatedPods(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	pod := func(name string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Annotations: map[string]string{nvidia.AllocatedAnnos: "GPU-a,NVIDIA,8000,60:;"},
+			},
+			Spec:   corev1.PodSpec{NodeName: "node1"},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+	}
+	kubeClient := fake.NewSimpleClientset(
+		testNode(testDevice(0, nvidia.HamiCoreMode)),
+		testClaim("a", `{"memory":10000,"cores":30}`, "gpu-0-0"),
+		pod("running", corev1.PodRunning),
+		pod("succeeded", corev1.PodSucceeded),
+	)
+	config := KubeletPluginConfig{
+		NodeName:  "node1",
+		PluginDir: filepath.Join(dir, "plugin"),
+		CDIRoot:   filepath.Join(dir, "cdi"),
+		HAMiCore:  nvidia.HAMiCoreConfig{HookPath: filepath.Join(dir, "hook")},
+	}
+	p, err := NewKubeletPlugin(config, kubeClient)
+	require.NoError(t, err)
+	claims := &drapb.NodePrepareResourcesRequest{Claims: []*drapb.Claim{{Namespace: "default", Name: "a", UID: "uid-a"}}}
+
+	resp, err := p.NodePrepareResources(ctx, claims)
+	require.NoError(t, err)
+	assert.Contains(t, resp.Claims["uid-a"].Error, "not enough memory", "the running pod uses 8000MiB of the GPU")
+
+	require.NoError(t, kubeClient.CoreV1().Pods("default").Delete(ctx, "running", metav1.DeleteOptions{}))
+	resp, err = p.NodePrepareResources(ctx, claims)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Claims["uid-a"].Error, "terminated pods do not use the GPU")
+}
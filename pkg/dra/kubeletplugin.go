@@ -0,0 +1,360 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	drapb "k8s.io/kubelet/pkg/apis/dra/v1alpha4"
+	registerapi "k8s.io/kubelet/pkg/apis/pluginregistration/v1"
+	cdispec "tags.cncf.io/container-device-interface/specs-go"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/k8sutil"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+const (
+	// cdiKind is the CDI vendor and class of prepared claims.
+	cdiKind = DriverName + "/claim"
+	// supportedVersion is the version of the DRA gRPC API served to the kubelet.
+	supportedVersion = "1.0.0"
+	checkpointFile   = "checkpoint.json"
+)
+
+// KubeletPluginConfig is where the kubelet plugin serves and writes its files.
+type KubeletPluginConfig struct {
+	NodeName string
+	// PluginDir holds the plugin socket and its checkpoint, e.g. /var/lib/kubelet/plugins/vgpu.hami.io.
+	PluginDir string
+	// RegistrarDir is where the kubelet discovers plugins, e.g. /var/lib/kubelet/plugins_registry.
+	RegistrarDir string
+	// CDIRoot is the directory CDI specs of prepared claims are written to, e.g. /var/run/cdi.
+	CDIRoot  string
+	HAMiCore nvidia.HAMiCoreConfig
+}
+
+// KubeletPlugin prepares the containers of allocated ResourceClaims: every
+// claim becomes a CDI device exposing its GPUs with the hami-core limits of
+// its VGPUConfig, the same environment and mounts the device plugin's
+// Allocate sets up. Slices of a GPU share it with each other and with the
+// pods the scheduler allocated it to, so the memory and cores of the claims
+// prepared on a GPU and of those pods may not exceed it.
+type KubeletPlugin struct {
+	drapb.UnimplementedNodeServer
+	registerapi.UnimplementedRegistrationServer
+
+	config     KubeletPluginConfig
+	kubeClient kubernetes.Interface
+	// prepared are the devices of the claims prepared on this node, by claim UID.
+	prepared map[string]util.ContainerDevices
+	mutex    sync.Mutex
+}
+
+func NewKubeletPlugin(config KubeletPluginConfig, kubeClient kubernetes.Interface) (*KubeletPlugin, error) {
+	p := &KubeletPlugin{
+		config:     config,
+		kubeClient: kubeClient,
+		prepared:   make(map[string]util.ContainerDevices),
+	}
+	data, err := os.ReadFile(filepath.Join(config.PluginDir, checkpointFile))
+	if err == nil {
+		if err := json.Unmarshal(data, &p.prepared); err != nil {
+			return nil, fmt.Errorf("invalid checkpoint: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *KubeletPlugin) socketPath() string {
+	return filepath.Join(p.config.PluginDir, "dra.sock")
+}
+
+func (p *KubeletPlugin) registrationSocketPath() string {
+	return filepath.Join(p.config.RegistrarDir, DriverName+"-reg.sock")
+}
+
+func serve(ctx context.Context, socket string, register func(*grpc.Server)) error {
+	if err := os.MkdirAll(filepath.Dir(socket), 0750); err != nil {
+		return err
+	}
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	register(server)
+	go func() {
+		<-ctx.Done()
+		server.Stop()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			klog.ErrorS(err, "gRPC server stopped", "socket", socket)
+		}
+	}()
+	return nil
+}
+
+// Run serves the DRA API and registers the plugin with the kubelet until ctx is done.
+func (p *KubeletPlugin) Run(ctx context.Context) error {
+	if err := serve(ctx, p.socketPath(), func(s *grpc.Server) { drapb.RegisterNodeServer(s, p) }); err != nil {
+		return fmt.Errorf("failed to serve DRA plugin: %v", err)
+	}
+	if err := serve(ctx, p.registrationSocketPath(), func(s *grpc.Server) { registerapi.RegisterRegistrationServer(s, p) }); err != nil {
+		return fmt.Errorf("failed to serve plugin registration: %v", err)
+	}
+	klog.InfoS("DRA kubelet plugin started", "driver", DriverName, "node", p.config.NodeName, "socket", p.socketPath())
+	<-ctx.Done()
+	return nil
+}
+
+func (p *KubeletPlugin) GetInfo(_ context.Context, _ *registerapi.InfoRequest) (*registerapi.PluginInfo, error) {
+	return &registerapi.PluginInfo{
+		Type:              registerapi.DRAPlugin,
+		Name:              DriverName,
+		Endpoint:          p.socketPath(),
+		SupportedVersions: []string{supportedVersion},
+	}, nil
+}
+
+func (p *KubeletPlugin) NotifyRegistrationStatus(_ context.Context, status *registerapi.RegistrationStatus) (*registerapi.RegistrationStatusResponse, error) {
+	if !status.PluginRegistered {
+		klog.ErrorS(fmt.Errorf("%s", status.Error), "DRA plugin registration failed")
+	} else {
+		klog.InfoS("DRA plugin registered with kubelet")
+	}
+	return &registerapi.RegistrationStatusResponse{}, nil
+}
+
+func (p *KubeletPlugin) NodePrepareResources(ctx context.Context, req *drapb.NodePrepareResourcesRequest) (*drapb.NodePrepareResourcesResponse, error) {
+	resp := &drapb.NodePrepareResourcesResponse{Claims: make(map[string]*drapb.NodePrepareResourceResponse)}
+	for _, claim := range req.Claims {
+		devices, err := p.prepare(ctx, claim)
+		if err != nil {
+			klog.ErrorS(err, "Failed to prepare claim", "claim", klog.KRef(claim.Namespace, claim.Name))
+			resp.Claims[claim.UID] = &drapb.NodePrepareResourceResponse{Error: err.Error()}
+			continue
+		}
+		resp.Claims[claim.UID] = &drapb.NodePrepareResourceResponse{Devices: devices}
+	}
+	return resp, nil
+}
+
+func (p *KubeletPlugin) NodeUnprepareResources(_ context.Context, req *drapb.NodeUnprepareResourcesRequest) (*drapb.NodeUnprepareResourcesResponse, error) {
+	resp := &drapb.NodeUnprepareResourcesResponse{Claims: make(map[string]*drapb.NodeUnprepareResourceResponse)}
+	for _, claim := range req.Claims {
+		resp.Claims[claim.UID] = &drapb.NodeUnprepareResourceResponse{}
+		if err := p.unprepare(claim.UID); err != nil {
+			klog.ErrorS(err, "Failed to unprepare claim", "claim", klog.KRef(claim.Namespace, claim.Name))
+			resp.Claims[claim.UID].Error = err.Error()
+		}
+	}
+	return resp, nil
+}
+
+func (p *KubeletPlugin) prepare(ctx context.Context, ref *drapb.Claim) ([]*drapb.Device, error) {
+	claim, err := p.kubeClient.ResourceV1alpha3().ResourceClaims(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if string(claim.UID) != ref.UID {
+		return nil, fmt.Errorf("claim %s/%s was replaced", ref.Namespace, ref.Name)
+	}
+	node, err := p.kubeClient.CoreV1().Nodes().Get(ctx, p.config.NodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	nodeDevs, err := nodeDevices(node)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := claimDevices(claim, nodeDevs)
+	if err != nil {
+		return nil, err
+	}
+	allocated, err := p.podAllocations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.prepared[ref.UID]; !ok {
+		if err := p.fits(ref.UID, devices, allocated, nodeDevs); err != nil {
+			return nil, err
+		}
+		if err := p.writeCDISpec(ref.UID, devices); err != nil {
+			return nil, err
+		}
+		p.prepared[ref.UID] = devices
+		if err := p.checkpoint(); err != nil {
+			return nil, err
+		}
+		klog.InfoS("Prepared claim", "claim", klog.KObj(claim), "devices", devices)
+	}
+	res := []*drapb.Device{}
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		if result.Driver != DriverName {
+			continue
+		}
+		res = append(res, &drapb.Device{
+			RequestNames: []string{result.Request},
+			PoolName:     result.Pool,
+			DeviceName:   result.Device,
+			CDIDeviceIDs: []string{cdiKind + "=" + ref.UID},
+		})
+	}
+	return res, nil
+}
+
+// podAllocations returns the devices the scheduler allocated to the pods
+// running on this node, which share the GPUs with the claims.
+func (p *KubeletPlugin) podAllocations(ctx context.Context) (util.ContainerDevices, error) {
+	pods, err := p.kubeClient.CoreV1().Pods(corev1.NamespaceAll).List(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName=" + p.config.NodeName})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the pods of node %s: %v", p.config.NodeName, err)
+	}
+	res := util.ContainerDevices{}
+	for _, pod := range pods.Items {
+		encoded, ok := pod.Annotations[nvidia.AllocatedAnnos]
+		if !ok || k8sutil.IsPodInTerminatedState(&pod) {
+			continue
+		}
+		podDevs, err := util.DecodePodSingleDevice(encoded)
+		if err != nil {
+			klog.ErrorS(err, "Failed to decode the devices of pod", "pod", klog.KObj(&pod))
+			continue
+		}
+		for _, ctrDevs := range podDevs {
+			res = append(res, ctrDevs...)
+		}
+	}
+	return res, nil
+}
+
+// fits checks that the claims prepared on every GPU, with the new one and
+// the devices allocated to pods, do not use more memory or cores than it has.
+func (p *KubeletPlugin) fits(claimUID string, devices, allocated util.ContainerDevices, nodeDevs []*util.DeviceInfo) error {
+	for _, d := range nodeDevs {
+		mem, cores := int32(0), int32(0)
+		add := func(devs util.ContainerDevices) {
+			for _, used := range devs {
+				if used.UUID == d.ID {
+					mem += used.Usedmem
+					cores += used.Usedcores
+				}
+			}
+		}
+		for uid, prepared := range p.prepared {
+			if uid != claimUID {
+				add(prepared)
+			}
+		}
+		add(devices)
+		add(allocated)
+		if mem > d.Devmem || cores > d.Devcore {
+			return fmt.Errorf("not enough memory or cores left on %s: %dMiB/%dMiB, %d/%d cores requested in total", d.ID, mem, d.Devmem, cores, d.Devcore)
+		}
+	}
+	return nil
+}
+
+func (p *KubeletPlugin) cdiSpecPath(claimUID string) string {
+	return filepath.Join(p.config.CDIRoot, strings.ReplaceAll(DriverName, ".", "-")+"-"+claimUID+".json")
+}
+
+// writeCDISpec writes the CDI device of a claim: its GPUs, and hami-core with
+// the claim's limits.
+func (p *KubeletPlugin) writeCDISpec(claimUID string, devices util.ContainerDevices) error {
+	uuids := make([]string, 0, len(devices))
+	for _, d := range devices {
+		uuids = append(uuids, d.UUID)
+	}
+	edits := cdispec.ContainerEdits{
+		Env: []string{"NVIDIA_VISIBLE_DEVICES=" + strings.Join(uuids, ",")},
+	}
+	for k, v := range nvidia.HAMiCoreEnvs(devices, p.config.HAMiCore) {
+		edits.Env = append(edits.Env, k+"="+v)
+	}
+	cacheDir := fmt.Sprintf("%s/vgpu/claims/%s", p.config.HAMiCore.HookPath, claimUID)
+	for _, m := range nvidia.HAMiCoreMounts(cacheDir, true, p.config.HAMiCore) {
+		options := []string{"rw", "bind"}
+		if m.ReadOnly {
+			options = []string{"ro", "bind"}
+		}
+		edits.Mounts = append(edits.Mounts, &cdispec.Mount{HostPath: m.HostPath, ContainerPath: m.ContainerPath, Options: options})
+	}
+	spec := cdispec.Spec{
+		Version: cdispec.CurrentVersion,
+		Kind:    cdiKind,
+		Devices: []cdispec.Device{{Name: claimUID, ContainerEdits: edits}},
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.config.CDIRoot, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p.cdiSpecPath(claimUID), data, 0644)
+}
+
+func (p *KubeletPlugin) unprepare(claimUID string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err := os.Remove(p.cdiSpecPath(claimUID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.RemoveAll(fmt.Sprintf("%s/vgpu/claims/%s", p.config.HAMiCore.HookPath, claimUID))
+	if _, ok := p.prepared[claimUID]; !ok {
+		return nil
+	}
+	delete(p.prepared, claimUID)
+	klog.InfoS("Unprepared claim", "claimUID", claimUID)
+	return p.checkpoint()
+}
+
+// checkpoint persists the prepared claims, so that a restarted plugin still
+// accounts for them.
+func (p *KubeletPlugin) checkpoint() error {
+	data, err := json.Marshal(p.prepared)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.config.PluginDir, 0750); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(p.config.PluginDir, checkpointFile), data, 0600)
+}
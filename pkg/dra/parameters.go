@@ -0,0 +1,108 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dra
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	resourceapi "k8s.io/api/resource/v1alpha3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// VGPUConfig is the opaque configuration of the driver, in a DeviceClass or a
+// ResourceClaim. It sets the share of the GPU each allocated slice gets.
+type VGPUConfig struct {
+	metav1.TypeMeta `json:",inline"`
+	// Memory is the device memory of a slice in MiB.
+	Memory int32 `json:"memory,omitempty"`
+	// MemoryPercentage is the device memory of a slice in percent of the GPU,
+	// used if Memory is not set. Without either, the slice gets all the memory.
+	MemoryPercentage int32 `json:"memoryPercentage,omitempty"`
+	// Cores is the percentage of GPU cores of a slice, 0 is not limited.
+	Cores int32 `json:"cores,omitempty"`
+}
+
+// requestConfig returns the configuration of the driver applying to a request
+// of an allocation. Configurations from the claim override those of the class.
+func requestConfig(allocation *resourceapi.AllocationResult, request string) (VGPUConfig, error) {
+	cfg := VGPUConfig{}
+	for _, source := range []resourceapi.AllocationConfigSource{resourceapi.AllocationConfigSourceClass, resourceapi.AllocationConfigSourceClaim} {
+		for _, c := range allocation.Devices.Config {
+			if c.Source != source || c.Opaque == nil || c.Opaque.Driver != DriverName {
+				continue
+			}
+			if len(c.Requests) > 0 && !slices.Contains(c.Requests, request) {
+				continue
+			}
+			if err := json.Unmarshal(c.Opaque.Parameters.Raw, &cfg); err != nil {
+				return cfg, fmt.Errorf("invalid %s config: %v", DriverName, err)
+			}
+		}
+	}
+	if cfg.Memory < 0 || cfg.MemoryPercentage < 0 || cfg.MemoryPercentage > 100 || cfg.Cores < 0 || cfg.Cores > 100 {
+		return cfg, fmt.Errorf("invalid %s config %+v", DriverName, cfg)
+	}
+	return cfg, nil
+}
+
+// claimDevices turns the devices allocated to a claim into the devices of a
+// container, with the memory and cores set by its VGPUConfig, as the scheduler
+// does for extended resources.
+func claimDevices(claim *resourceapi.ResourceClaim, devices []*util.DeviceInfo) (util.ContainerDevices, error) {
+	allocation := claim.Status.Allocation
+	if allocation == nil {
+		return nil, fmt.Errorf("claim %s/%s is not allocated", claim.Namespace, claim.Name)
+	}
+	res := util.ContainerDevices{}
+	for _, result := range allocation.Devices.Results {
+		if result.Driver != DriverName {
+			continue
+		}
+		index, err := parseDeviceName(result.Device)
+		if err != nil {
+			return nil, err
+		}
+		idx := slices.IndexFunc(devices, func(d *util.DeviceInfo) bool { return d.Index == index })
+		if idx < 0 {
+			return nil, fmt.Errorf("device %s is not registered on the node", result.Device)
+		}
+		d := devices[idx]
+		cfg, err := requestConfig(allocation, result.Request)
+		if err != nil {
+			return nil, err
+		}
+		mem := d.Devmem
+		if cfg.Memory > 0 {
+			mem = cfg.Memory
+		} else if cfg.MemoryPercentage > 0 {
+			mem = d.Devmem * cfg.MemoryPercentage / 100
+		}
+		res = append(res, util.ContainerDevice{
+			Idx:       int(d.Index),
+			UUID:      d.ID,
+			Type:      nvidia.NvidiaGPUDevice,
+			Usedmem:   mem,
+			Usedcores: cfg.Cores,
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dra exposes HAMi vGPU slices through Kubernetes Dynamic Resource
+// Allocation: a controller publishes the devices registered by the device
+// plugin as ResourceSlices, and a kubelet plugin prepares allocated
+// ResourceClaims with the same hami-core limits the device plugin sets.
+package dra
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	resourceapi "k8s.io/api/resource/v1alpha3"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+const (
+	// DriverName is the DRA driver name of HAMi vGPUs.
+	DriverName = "vgpu.hami.io"
+
+	// Attributes and capacities of a published vGPU slice.
+	AttributeUUID    resourceapi.QualifiedName = "uuid"
+	AttributeIndex   resourceapi.QualifiedName = "index"
+	AttributeType    resourceapi.QualifiedName = "type"
+	AttributeMode    resourceapi.QualifiedName = "mode"
+	AttributeNuma    resourceapi.QualifiedName = "numa"
+	AttributeSlot    resourceapi.QualifiedName = "slot"
+	CapacityMemory   resourceapi.QualifiedName = "memory"
+	CapacityCores    resourceapi.QualifiedName = "cores"
+	deviceNamePrefix                           = "gpu-"
+)
+
+// deviceName is the name of the slot-th vGPU slice of the GPU with index.
+func deviceName(index uint, slot int32) string {
+	return fmt.Sprintf("%s%d-%d", deviceNamePrefix, index, slot)
+}
+
+// parseDeviceName returns the GPU index of a vGPU slice name.
+func parseDeviceName(name string) (uint, error) {
+	index, _, found := strings.Cut(strings.TrimPrefix(name, deviceNamePrefix), "-")
+	if !found || !strings.HasPrefix(name, deviceNamePrefix) {
+		return 0, fmt.Errorf("invalid device name %s", name)
+	}
+	i, err := strconv.ParseUint(index, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid device name %s: %v", name, err)
+	}
+	return uint(i), nil
+}
+
+// resourceSliceName is the name of the ResourceSlice of a node.
+func resourceSliceName(nodeName string) string {
+	return nodeName + "-" + strings.ReplaceAll(DriverName, ".", "-")
+}
+
+// nodeDevices returns the NVIDIA devices the device plugin registered on a node.
+func nodeDevices(node *corev1.Node) ([]*util.DeviceInfo, error) {
+	encoded, ok := node.Annotations[nvidia.RegisterAnnos]
+	if !ok {
+		return nil, nil
+	}
+	return util.DecodeNodeDevices(encoded)
+}
+
+func stringAttribute(v string) resourceapi.DeviceAttribute {
+	return resourceapi.DeviceAttribute{StringValue: &v}
+}
+
+func intAttribute(v int64) resourceapi.DeviceAttribute {
+	return resourceapi.DeviceAttribute{IntValue: &v}
+}
+
+// sliceDevices turns every healthy GPU into as many DRA devices as its split
+// count. Each slice carries the memory and cores of the whole GPU: the share a
+// claim gets is set by its VGPUConfig, and checked when it is prepared.
+// GPUs in MIG mode are not published.
+func sliceDevices(devices []*util.DeviceInfo) []resourceapi.Device {
+	res := make([]resourceapi.Device, 0)
+	for _, d := range devices {
+		if !d.Health || d.Mode == nvidia.MigMode {
+			continue
+		}
+		for slot := int32(0); slot < d.Count; slot++ {
+			res = append(res, resourceapi.Device{
+				Name: deviceName(d.Index, slot),
+				Basic: &resourceapi.BasicDevice{
+					Attributes: map[resourceapi.QualifiedName]resourceapi.DeviceAttribute{
+						AttributeUUID:  stringAttribute(d.ID),
+						AttributeIndex: intAttribute(int64(d.Index)),
+						AttributeType:  stringAttribute(d.Type),
+						AttributeMode:  stringAttribute(d.Mode),
+						AttributeNuma:  intAttribute(int64(d.Numa)),
+						AttributeSlot:  intAttribute(int64(slot)),
+					},
+					Capacity: map[resourceapi.QualifiedName]resource.Quantity{
+						CapacityMemory: *resource.NewQuantity(int64(d.Devmem)*1024*1024, resource.BinarySI),
+						CapacityCores:  *resource.NewQuantity(int64(d.Devcore), resource.DecimalSI),
+					},
+				},
+			})
+		}
+	}
+	return res
+}
+
+// BuildResourceSlice returns the ResourceSlice publishing the vGPU slices of a node.
+func BuildResourceSlice(node *corev1.Node, devices []*util.DeviceInfo, generation int64) *resourceapi.ResourceSlice {
+	return &resourceapi.ResourceSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: resourceSliceName(node.Name),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Node",
+				Name:       node.Name,
+				UID:        node.UID,
+			}},
+		},
+		Spec: resourceapi.ResourceSliceSpec{
+			Driver:   DriverName,
+			NodeName: node.Name,
+			Pool: resourceapi.ResourcePool{
+				Name:               node.Name,
+				Generation:         generation,
+				ResourceSliceCount: 1,
+			},
+			Devices: sliceDevices(devices),
+		},
+	}
+}
@@ -68,13 +68,18 @@ type UsageInfo interface {
 	SetRecentKernel(v int32)
 	GetUtilizationSwitch() int32
 	SetUtilizationSwitch(v int32)
+	GetTimeSlice() int32
+	SetTimeSlice(v int32)
 }
 
 type ContainerUsage struct {
 	PodUID        string
 	ContainerName string
-	data          []byte
-	Info          UsageInfo
+	// Weight is the share of the time of its devices the container gets
+	// relative to the containers of the same priority, see GPUWeightAnnotation.
+	Weight int
+	data   []byte
+	Info   UsageInfo
 }
 
 type ContainerLister struct {
@@ -175,6 +180,15 @@ func (l *ContainerLister) Update() error {
 		l.containers[entry.Name()] = usage
 		klog.Infof("Adding ctr dirname %s in monitorpath", dirName)
 	}
+	for _, c := range l.containers {
+		c.Weight = DefaultGPUWeight
+		for i := range pods.Items {
+			if string(pods.Items[i].UID) == c.PodUID {
+				c.Weight = ContainerWeight(&pods.Items[i], c.ContainerName)
+				break
+			}
+		}
+	}
 	return nil
 }
 
@@ -190,3 +190,11 @@ func (s Spec) GetUtilizationSwitch() int32 {
 func (s Spec) SetUtilizationSwitch(v int32) {
 	s.sr.utilizationSwitch = v
 }
+
+// GetTimeSlice returns 0, the v0 shared region has no time slice.
+func (s Spec) GetTimeSlice() int32 {
+	return 0
+}
+
+// SetTimeSlice does nothing, the v0 shared region has no time slice.
+func (s Spec) SetTimeSlice(v int32) {}
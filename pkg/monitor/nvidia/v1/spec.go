@@ -73,7 +73,9 @@ type sharedRegionT struct {
 	recentKernel      int32
 	priority          int32
 	lastKernelTime    int64
-	unused            [4]uint64
+	timeSlice         int32
+	reserved          int32
+	unused            [3]uint64
 }
 
 type Spec struct {
@@ -197,3 +199,11 @@ func (s Spec) GetUtilizationSwitch() int32 {
 func (s Spec) SetUtilizationSwitch(v int32) {
 	s.sr.utilizationSwitch = v
 }
+
+func (s Spec) GetTimeSlice() int32 {
+	return s.sr.timeSlice
+}
+
+func (s Spec) SetTimeSlice(v int32) {
+	s.sr.timeSlice = v
+}
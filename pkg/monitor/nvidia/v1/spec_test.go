@@ -1071,3 +1071,11 @@ func Test_SetUtilizationSwitch(t *testing.T) {
 		})
 	}
 }
+
+func Test_SetTimeSlice(t *testing.T) {
+	spec := Spec{sr: &sharedRegionT{}}
+	spec.SetTimeSlice(int32(25))
+	assert.Equal(t, spec.sr.timeSlice, int32(25))
+	assert.Equal(t, spec.GetTimeSlice(), int32(25))
+	assert.Equal(t, spec.sr.unused, [3]uint64{})
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nvidia
+
+import (
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// GPUWeightAnnotation sets the weights of the containers of a pod in the
+	// time sharing of their GPUs, either one weight for all of them, e.g. "2",
+	// or per container, e.g. "train:3,sidecar:1".
+	GPUWeightAnnotation = "hami.io/gpu-weight"
+	// DefaultGPUWeight is the weight of a container the annotation does not set.
+	DefaultGPUWeight = 1
+)
+
+// ContainerWeight returns the weight of a container of a pod from its
+// GPUWeightAnnotation, DefaultGPUWeight if unset or invalid.
+func ContainerWeight(pod *corev1.Pod, container string) int {
+	value, ok := pod.Annotations[GPUWeightAnnotation]
+	if !ok {
+		return DefaultGPUWeight
+	}
+	weight := DefaultGPUWeight
+	for _, entry := range strings.Split(value, ",") {
+		name, w, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if !found {
+			w = name
+		} else if name != container {
+			continue
+		}
+		parsed, err := strconv.Atoi(w)
+		if err != nil || parsed <= 0 {
+			klog.Warningf("Invalid %s %q of pod %s/%s", GPUWeightAnnotation, value, pod.Namespace, pod.Name)
+			return DefaultGPUWeight
+		}
+		weight = parsed
+		if found {
+			break
+		}
+	}
+	return weight
+}
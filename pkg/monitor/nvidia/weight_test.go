@@ -0,0 +1,75 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nvidia
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_ContainerWeight(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation *string
+		want       map[string]int
+	}{
+		{
+			name: "unset",
+			want: map[string]int{"train": 1, "sidecar": 1},
+		},
+		{
+			name:       "one weight for all containers",
+			annotation: ptr("4"),
+			want:       map[string]int{"train": 4, "sidecar": 4},
+		},
+		{
+			name:       "per container",
+			annotation: ptr("train:3, sidecar:2"),
+			want:       map[string]int{"train": 3, "sidecar": 2},
+		},
+		{
+			name:       "per container over all containers",
+			annotation: ptr("2,train:5"),
+			want:       map[string]int{"train": 5, "sidecar": 2},
+		},
+		{
+			name:       "invalid",
+			annotation: ptr("train:-1,sidecar:x"),
+			want:       map[string]int{"train": 1, "sidecar": 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "default"}}
+			if test.annotation != nil {
+				pod.Annotations = map[string]string{GPUWeightAnnotation: *test.annotation}
+			}
+			got := map[string]int{}
+			for ctr := range test.want {
+				got[ctr] = ContainerWeight(pod, ctr)
+			}
+			assert.DeepEqual(t, got, test.want)
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
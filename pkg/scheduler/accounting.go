@@ -0,0 +1,102 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/accounting"
+	"github.com/Project-HAMi/HAMi/pkg/k8sutil"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// ErrAccountingDisabled is returned for reports when accounting is not enabled.
+var ErrAccountingDisabled = errors.New("accounting is disabled")
+
+// openLedger opens the accounting ledger, before the pods are added from the
+// informer so that the allocations they held before a restart are continued.
+func (s *Scheduler) openLedger() error {
+	ledger, err := accounting.Open(config.AccountingDir, config.AccountingRetention)
+	if err != nil {
+		return err
+	}
+	s.podManager.ledger = ledger
+	return nil
+}
+
+// runLedger closes the allocations of the pods deleted while the scheduler
+// was not running, then records that the ledger is alive and compacts it
+// until stopped.
+func (s *Scheduler) runLedger() {
+	pods, err := s.podLister.List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "Failed to list pods, the allocations of deleted pods are kept open")
+	} else {
+		live := make(map[string]bool)
+		for _, pod := range pods {
+			if _, ok := pod.Annotations[util.AssignedNodeAnnotations]; ok && !k8sutil.IsPodInTerminatedState(pod) {
+				live[string(pod.UID)] = true
+			}
+		}
+		s.ledger.Sweep(func(uid string) bool { return live[uid] })
+	}
+	s.ledger.Checkpoint()
+	checkpoint := time.NewTicker(accounting.CheckpointInterval)
+	defer checkpoint.Stop()
+	compact := time.NewTicker(accounting.CompactInterval)
+	defer compact.Stop()
+	for {
+		select {
+		case <-checkpoint.C:
+			s.ledger.Checkpoint()
+		case <-compact.C:
+			if err := s.ledger.Compact(); err != nil {
+				klog.ErrorS(err, "Failed to compact the accounting ledger")
+			}
+		case <-s.stopCh:
+			if err := s.ledger.Close(); err != nil {
+				klog.ErrorS(err, "Failed to close the accounting ledger")
+			}
+			return
+		}
+	}
+}
+
+// AccountingReport integrates the devices allocated over the period of the
+// options, with the actual usage if a Prometheus server is configured.
+func (s *Scheduler) AccountingReport(ctx context.Context, opts accounting.ReportOptions) ([]accounting.Usage, error) {
+	if s.ledger == nil {
+		return nil, ErrAccountingDisabled
+	}
+	now := time.Now()
+	if err := opts.Validate(now); err != nil {
+		return nil, err
+	}
+	usage := accounting.Report(s.ledger.Records(), opts, now)
+	if config.AccountingPrometheusURL != "" {
+		if err := accounting.AddActualUsage(ctx, config.AccountingPrometheusURL, usage, opts); err != nil {
+			return nil, err
+		}
+	}
+	return usage, nil
+}
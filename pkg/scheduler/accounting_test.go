@@ -0,0 +1,65 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/accounting"
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+func Test_AccountingReport(t *testing.T) {
+	s := newTestScheduler(t)
+	_, err := s.AccountingReport(context.Background(), accounting.ReportOptions{})
+	assert.ErrorIs(t, err, ErrAccountingDisabled)
+
+	ledger, err := accounting.Open(t.TempDir(), 0)
+	require.NoError(t, err)
+	defer ledger.Close()
+	s.podManager.ledger = ledger
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "train", Namespace: "team-a", UID: "train-uid"}}
+	devices := util.PodDevices{nvidia.NvidiaGPUDevice: util.PodSingleDevice{
+		{{UUID: "gpu0", Type: nvidia.NvidiaGPUDevice, Usedmem: 4000, Usedcores: 30}},
+	}}
+	s.addPod(pod, "node1", devices)
+	records := ledger.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, "node1", records[0].Node)
+	assert.Equal(t, int64(4000), records[0].Memory)
+	assert.True(t, records[0].End.IsZero())
+
+	s.delPod(pod)
+	records = ledger.Records()
+	require.Len(t, records, 1)
+	assert.False(t, records[0].End.IsZero(), "the allocation is closed when the pod is deleted")
+
+	usage, err := s.AccountingReport(context.Background(), accounting.ReportOptions{From: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+	require.Len(t, usage, 1)
+	assert.Equal(t, "team-a", usage[0].Namespace)
+	assert.Nil(t, usage[0].ActualMemorySeconds)
+}
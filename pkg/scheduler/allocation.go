@@ -0,0 +1,367 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+
+	hamiv1alpha1 "github.com/Project-HAMi/HAMi/pkg/apis/hami/v1alpha1"
+	"github.com/Project-HAMi/HAMi/pkg/k8sutil"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+const (
+	// allocationGCInterval is how often allocations of pods that are gone are collected.
+	allocationGCInterval = time.Minute
+	// allocationGCGracePeriod keeps an allocation from being collected before
+	// the pod informer caught up with the pod it was written for.
+	allocationGCGracePeriod = time.Minute
+)
+
+var deviceAllocationResource = hamiv1alpha1.SchemeGroupVersion.WithResource("deviceallocations")
+
+// allocationStore keeps the devices reserved for each pod in a DeviceAllocation,
+// so that a restarted scheduler gets its reservations back before the pod
+// informer synced. A nil store writes nothing.
+type allocationStore struct {
+	client dynamic.Interface
+	mutex  sync.Mutex
+	// phases is the last phase of the allocations known to exist, by pod UID,
+	// so that pod events that do not change the phase cost no request.
+	phases map[k8stypes.UID]hamiv1alpha1.DeviceAllocationPhase
+}
+
+func newAllocationStore(c dynamic.Interface) *allocationStore {
+	return &allocationStore{
+		client: c,
+		phases: make(map[k8stypes.UID]hamiv1alpha1.DeviceAllocationPhase),
+	}
+}
+
+func (a *allocationStore) phase(uid k8stypes.UID) (hamiv1alpha1.DeviceAllocationPhase, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	phase, ok := a.phases[uid]
+	return phase, ok
+}
+
+func (a *allocationStore) setKnown(uid k8stypes.UID, phase hamiv1alpha1.DeviceAllocationPhase) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.phases[uid] = phase
+}
+
+func (a *allocationStore) forget(uid k8stypes.UID) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.phases, uid)
+}
+
+// reserve writes the devices reserved for a pod on a node, replacing the
+// allocation left by an earlier pod of the same name.
+func (a *allocationStore) reserve(ctx context.Context, pod *corev1.Pod, nodeID string, devices util.PodDevices, phase hamiv1alpha1.DeviceAllocationPhase) error {
+	if a == nil {
+		return nil
+	}
+	now := metav1.Now()
+	alloc := &hamiv1alpha1.DeviceAllocation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       pod.Name,
+				UID:        pod.UID,
+			}},
+		},
+		Spec: hamiv1alpha1.DeviceAllocationSpec{
+			PodName:  pod.Name,
+			PodUID:   pod.UID,
+			NodeName: nodeID,
+			Devices:  allocationDevices(devices),
+		},
+		Status: hamiv1alpha1.DeviceAllocationStatus{Phase: phase, LastTransitionTime: &now},
+	}
+	obj, err := toUnstructured(alloc)
+	if err != nil {
+		return err
+	}
+	resource := a.client.Resource(deviceAllocationResource).Namespace(pod.Namespace)
+	_, err = resource.Create(ctx, obj, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		var existing *hamiv1alpha1.DeviceAllocation
+		if existing, err = a.get(ctx, pod.Namespace, pod.Name); err != nil {
+			return err
+		}
+		existing.OwnerReferences = alloc.OwnerReferences
+		existing.Spec = alloc.Spec
+		existing.Status = alloc.Status
+		if obj, err = toUnstructured(existing); err != nil {
+			return err
+		}
+		_, err = resource.Update(ctx, obj, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	a.setKnown(pod.UID, phase)
+	return nil
+}
+
+// sync writes the allocation of a pod the scheduler assigned devices to, or
+// its phase if it changed since it was written.
+func (a *allocationStore) sync(ctx context.Context, pod *corev1.Pod, nodeID string, devices util.PodDevices) error {
+	if a == nil {
+		return nil
+	}
+	phase := allocationPhase(pod)
+	if _, ok := a.phase(pod.UID); !ok {
+		return a.reserve(ctx, pod, nodeID, devices, phase)
+	}
+	return a.setPhase(ctx, pod, phase)
+}
+
+// setPhase updates the phase of the allocation of a pod, if it is known.
+func (a *allocationStore) setPhase(ctx context.Context, pod *corev1.Pod, phase hamiv1alpha1.DeviceAllocationPhase) error {
+	if a == nil {
+		return nil
+	}
+	if current, ok := a.phase(pod.UID); !ok || current == phase {
+		return nil
+	}
+	alloc, err := a.get(ctx, pod.Namespace, pod.Name)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			a.forget(pod.UID)
+			return nil
+		}
+		return err
+	}
+	if alloc.Spec.PodUID != pod.UID {
+		a.forget(pod.UID)
+		return nil
+	}
+	patch, err := json.Marshal(map[string]any{
+		"status": hamiv1alpha1.DeviceAllocationStatus{Phase: phase, LastTransitionTime: &metav1.Time{Time: time.Now()}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = a.client.Resource(deviceAllocationResource).Namespace(pod.Namespace).Patch(ctx, pod.Name, k8stypes.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	a.setKnown(pod.UID, phase)
+	return nil
+}
+
+// release deletes the allocation of a pod, if it is known.
+func (a *allocationStore) release(ctx context.Context, pod *corev1.Pod) error {
+	if a == nil {
+		return nil
+	}
+	if _, ok := a.phase(pod.UID); !ok {
+		return nil
+	}
+	alloc, err := a.get(ctx, pod.Namespace, pod.Name)
+	if err == nil && alloc.Spec.PodUID == pod.UID {
+		err = a.delete(ctx, alloc)
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	a.forget(pod.UID)
+	return nil
+}
+
+func (a *allocationStore) delete(ctx context.Context, alloc *hamiv1alpha1.DeviceAllocation) error {
+	err := a.client.Resource(deviceAllocationResource).Namespace(alloc.Namespace).Delete(ctx, alloc.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &alloc.UID},
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	a.forget(alloc.Spec.PodUID)
+	return nil
+}
+
+func (a *allocationStore) get(ctx context.Context, namespace, name string) (*hamiv1alpha1.DeviceAllocation, error) {
+	obj, err := a.client.Resource(deviceAllocationResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	alloc := &hamiv1alpha1.DeviceAllocation{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, alloc); err != nil {
+		return nil, err
+	}
+	return alloc, nil
+}
+
+func toUnstructured(alloc *hamiv1alpha1.DeviceAllocation) (*unstructured.Unstructured, error) {
+	alloc.APIVersion = hamiv1alpha1.SchemeGroupVersion.String()
+	alloc.Kind = "DeviceAllocation"
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(alloc)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
+
+func (a *allocationStore) list(ctx context.Context) ([]hamiv1alpha1.DeviceAllocation, error) {
+	list, err := a.client.Resource(deviceAllocationResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]hamiv1alpha1.DeviceAllocation, len(list.Items))
+	for i := range list.Items {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[i].Object, &res[i]); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// allocationPhase is the phase of the allocation of a pod, from the bind phase
+// the scheduler and the device plugins write on it.
+func allocationPhase(pod *corev1.Pod) hamiv1alpha1.DeviceAllocationPhase {
+	switch pod.Annotations[util.DeviceBindPhase] {
+	case util.DeviceBindAllocating:
+		return hamiv1alpha1.DeviceAllocationAllocating
+	case util.DeviceBindSuccess:
+		return hamiv1alpha1.DeviceAllocationSucceeded
+	case util.DeviceBindFailed:
+		return hamiv1alpha1.DeviceAllocationFailed
+	default:
+		return hamiv1alpha1.DeviceAllocationReserved
+	}
+}
+
+func allocationDevices(devices util.PodDevices) []hamiv1alpha1.VendorAllocation {
+	res := make([]hamiv1alpha1.VendorAllocation, 0, len(devices))
+	for vendor, pd := range devices {
+		va := hamiv1alpha1.VendorAllocation{Vendor: vendor, Containers: make([]hamiv1alpha1.ContainerAllocation, 0, len(pd))}
+		for _, cd := range pd {
+			ca := hamiv1alpha1.ContainerAllocation{Devices: make([]hamiv1alpha1.AllocatedDevice, 0, len(cd))}
+			for _, d := range cd {
+				ca.Devices = append(ca.Devices, hamiv1alpha1.AllocatedDevice{
+					Idx:       d.Idx,
+					UUID:      d.UUID,
+					Type:      d.Type,
+					Usedmem:   d.Usedmem,
+					Usedcores: d.Usedcores,
+				})
+			}
+			va.Containers = append(va.Containers, ca)
+		}
+		res = append(res, va)
+	}
+	return res
+}
+
+func allocationPodDevices(alloc *hamiv1alpha1.DeviceAllocation) util.PodDevices {
+	res := make(util.PodDevices, len(alloc.Spec.Devices))
+	for _, va := range alloc.Spec.Devices {
+		pd := make(util.PodSingleDevice, 0, len(va.Containers))
+		for _, ca := range va.Containers {
+			cd := make(util.ContainerDevices, 0, len(ca.Devices))
+			for _, d := range ca.Devices {
+				cd = append(cd, util.ContainerDevice{
+					Idx:       d.Idx,
+					UUID:      d.UUID,
+					Type:      d.Type,
+					Usedmem:   d.Usedmem,
+					Usedcores: d.Usedcores,
+				})
+			}
+			pd = append(pd, cd)
+		}
+		res[va.Vendor] = pd
+	}
+	return res
+}
+
+// allocationPod is the pod an allocation was written for, with only what the
+// pod cache needs.
+func allocationPod(alloc *hamiv1alpha1.DeviceAllocation) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace: alloc.Namespace,
+		Name:      alloc.Spec.PodName,
+		UID:       alloc.Spec.PodUID,
+	}}
+}
+
+// restoreAllocations accounts the devices of every allocation before the pod
+// informer starts, so that no device is handed out twice while it syncs.
+func (s *Scheduler) restoreAllocations() error {
+	allocs, err := s.allocations.list(context.Background())
+	if err != nil {
+		return err
+	}
+	for i := range allocs {
+		alloc := &allocs[i]
+		s.addPod(allocationPod(alloc), alloc.Spec.NodeName, allocationPodDevices(alloc))
+		s.allocations.setKnown(alloc.Spec.PodUID, alloc.Status.Phase)
+	}
+	klog.InfoS("Restored device allocations", "count", len(allocs))
+	return nil
+}
+
+// collectAllocations deletes the allocations of pods that were deleted,
+// replaced by a pod of the same name or terminated, and releases their devices.
+func (s *Scheduler) collectAllocations() {
+	if s.allocations == nil || !s.IsLeader() {
+		return
+	}
+	ctx := context.Background()
+	allocs, err := s.allocations.list(ctx)
+	if err != nil {
+		klog.ErrorS(err, "Failed to list device allocations")
+		return
+	}
+	for i := range allocs {
+		alloc := &allocs[i]
+		if time.Since(alloc.CreationTimestamp.Time) < allocationGCGracePeriod {
+			continue
+		}
+		pod, err := s.podLister.Pods(alloc.Namespace).Get(alloc.Spec.PodName)
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.ErrorS(err, "Failed to get pod of device allocation", "allocation", klog.KObj(alloc))
+			continue
+		}
+		if err == nil && pod.UID == alloc.Spec.PodUID && !k8sutil.IsPodInTerminatedState(pod) {
+			continue
+		}
+		klog.InfoS("Collecting device allocation of a pod that is gone", "allocation", klog.KObj(alloc), "podUID", alloc.Spec.PodUID, "node", alloc.Spec.NodeName)
+		s.delPod(allocationPod(alloc))
+		if err := s.allocations.delete(ctx, alloc); err != nil {
+			klog.ErrorS(err, "Failed to delete device allocation", "allocation", klog.KObj(alloc))
+		}
+	}
+}
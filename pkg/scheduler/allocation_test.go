@@ -0,0 +1,197 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	listerscorev1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	hamiv1alpha1 "github.com/Project-HAMi/HAMi/pkg/apis/hami/v1alpha1"
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+func newTestAllocationStore(t *testing.T, allocs ...*hamiv1alpha1.DeviceAllocation) *allocationStore {
+	scheme := runtime.NewScheme()
+	require.NoError(t, hamiv1alpha1.AddToScheme(scheme))
+	objs := make([]runtime.Object, 0, len(allocs))
+	for _, alloc := range allocs {
+		obj, err := toUnstructured(alloc)
+		require.NoError(t, err)
+		objs = append(objs, obj)
+	}
+	return newAllocationStore(dynamicfake.NewSimpleDynamicClient(scheme, objs...))
+}
+
+func testAllocation(name, nodeID string, mem int32) *hamiv1alpha1.DeviceAllocation {
+	return &hamiv1alpha1.DeviceAllocation{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: k8stypes.UID(name + "-alloc-uid")},
+		Spec: hamiv1alpha1.DeviceAllocationSpec{
+			PodName:  name,
+			PodUID:   k8stypes.UID(name + "-uid"),
+			NodeName: nodeID,
+			Devices: []hamiv1alpha1.VendorAllocation{{
+				Vendor: nvidia.NvidiaGPUDevice,
+				Containers: []hamiv1alpha1.ContainerAllocation{{
+					Devices: []hamiv1alpha1.AllocatedDevice{{UUID: nodeID + "-gpu0", Type: nvidia.NvidiaGPUDevice, Usedmem: mem}},
+				}},
+			}},
+		},
+		Status: hamiv1alpha1.DeviceAllocationStatus{Phase: hamiv1alpha1.DeviceAllocationSucceeded},
+	}
+}
+
+func getAllocation(t *testing.T, s *Scheduler, name string) (*hamiv1alpha1.DeviceAllocation, error) {
+	t.Helper()
+	return s.allocations.get(context.Background(), "default", name)
+}
+
+func Test_allocationDevices(t *testing.T) {
+	devices := util.PodDevices{
+		nvidia.NvidiaGPUDevice: util.PodSingleDevice{
+			{{Idx: 1, UUID: "gpu1", Type: nvidia.NvidiaGPUDevice, Usedmem: 1000, Usedcores: 10}},
+			{},
+		},
+	}
+	alloc := &hamiv1alpha1.DeviceAllocation{Spec: hamiv1alpha1.DeviceAllocationSpec{Devices: allocationDevices(devices)}}
+	assert.Equal(t, devices, allocationPodDevices(alloc))
+}
+
+func Test_allocationPhase(t *testing.T) {
+	tests := map[string]hamiv1alpha1.DeviceAllocationPhase{
+		"":                        hamiv1alpha1.DeviceAllocationReserved,
+		util.DeviceBindAllocating: hamiv1alpha1.DeviceAllocationAllocating,
+		util.DeviceBindSuccess:    hamiv1alpha1.DeviceAllocationSucceeded,
+		util.DeviceBindFailed:     hamiv1alpha1.DeviceAllocationFailed,
+	}
+	for bindPhase, want := range tests {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{util.DeviceBindPhase: bindPhase}}}
+		assert.Equal(t, want, allocationPhase(pod), bindPhase)
+	}
+}
+
+func Test_Filter_deviceAllocation(t *testing.T) {
+	s := newTestScheduler(t)
+	s.allocations = newTestAllocationStore(t)
+	pod := explainTestPod(t, "pod1", 3000)
+
+	res, err := s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: pod, NodeNames: &[]string{"node1", "node2"}})
+	require.NoError(t, err)
+	require.NotNil(t, res.NodeNames)
+	nodeID := (*res.NodeNames)[0]
+
+	alloc, err := getAllocation(t, s, "pod1")
+	require.NoError(t, err)
+	assert.Equal(t, pod.UID, alloc.Spec.PodUID)
+	assert.Equal(t, nodeID, alloc.Spec.NodeName)
+	assert.Equal(t, hamiv1alpha1.DeviceAllocationReserved, alloc.Status.Phase)
+	require.Len(t, alloc.OwnerReferences, 1)
+	assert.Equal(t, pod.UID, alloc.OwnerReferences[0].UID)
+	devices := allocationPodDevices(alloc)
+	require.Len(t, devices[nvidia.NvidiaGPUDevice], 1)
+	assert.Equal(t, int32(3000), devices[nvidia.NvidiaGPUDevice][0][0].Usedmem)
+
+	// The device plugin reports the allocation through the bind phase.
+	assigned, err := s.kubeClient.CoreV1().Pods("default").Get(context.Background(), "pod1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assigned.Annotations[util.DeviceBindPhase] = util.DeviceBindSuccess
+	s.onUpdatePod(nil, assigned)
+	alloc, err = getAllocation(t, s, "pod1")
+	require.NoError(t, err)
+	assert.Equal(t, hamiv1alpha1.DeviceAllocationSucceeded, alloc.Status.Phase)
+
+	s.onDelPod(assigned)
+	_, err = getAllocation(t, s, "pod1")
+	assert.True(t, apierrors.IsNotFound(err), "the allocation is deleted with the pod")
+}
+
+func Test_onAddPod_deviceAllocationStandby(t *testing.T) {
+	s := newTestScheduler(t)
+	s.allocations = newTestAllocationStore(t)
+	s.leader.enabled = true
+	pod := explainTestPod(t, "pod1", 3000)
+	pod.Annotations = map[string]string{
+		util.AssignedNodeAnnotations:                "node1",
+		util.SupportDevices[nvidia.NvidiaGPUDevice]: "node1-gpu0,NVIDIA,3000,0:;",
+	}
+
+	s.onAddPod(pod)
+	pods, _ := s.ListPodsUID()
+	assert.Len(t, pods, 1, "standby replicas keep warm caches")
+	_, err := getAllocation(t, s, "pod1")
+	assert.True(t, apierrors.IsNotFound(err), "only the leader writes allocations")
+}
+
+func Test_restoreAllocations(t *testing.T) {
+	s := newTestScheduler(t)
+	s.allocations = newTestAllocationStore(t, testAllocation("pod1", "node1", 7000))
+
+	require.NoError(t, s.restoreAllocations())
+	pods := s.ListPodsInfo()
+	require.Len(t, pods, 1)
+	assert.Equal(t, "node1", pods[0].NodeID)
+	usage, _, err := s.getNodesUsage(&[]string{"node1"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(7000), (*usage)["node1"].Devices.DeviceLists[0].Device.Usedmem)
+
+	// The restored devices are not handed out again.
+	res, err := s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: explainTestPod(t, "pod2", 3000), NodeNames: &[]string{"node1", "node2"}})
+	require.NoError(t, err)
+	require.NotNil(t, res.NodeNames)
+	assert.Equal(t, []string{"node2"}, *res.NodeNames)
+}
+
+func Test_collectAllocations(t *testing.T) {
+	s := newTestScheduler(t)
+	s.allocations = newTestAllocationStore(t,
+		testAllocation("running", "node1", 1000),
+		testAllocation("replaced", "node1", 1000),
+		testAllocation("completed", "node1", 1000),
+		testAllocation("deleted", "node2", 1000),
+	)
+	require.NoError(t, s.restoreAllocations())
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, pod := range []*corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "default", UID: "running-uid"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "replaced", Namespace: "default", UID: "other-uid"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "completed", Namespace: "default", UID: "completed-uid"}, Status: corev1.PodStatus{Phase: corev1.PodSucceeded}},
+	} {
+		require.NoError(t, indexer.Add(pod))
+	}
+	s.podLister = listerscorev1.NewPodLister(indexer)
+
+	s.collectAllocations()
+	allocs, err := s.allocations.list(context.Background())
+	require.NoError(t, err)
+	require.Len(t, allocs, 1)
+	assert.Equal(t, "running", allocs[0].Name)
+	pods := s.ListPodsInfo()
+	require.Len(t, pods, 1)
+	assert.Equal(t, k8stypes.UID("running-uid"), pods[0].UID)
+}
@@ -41,9 +41,67 @@ var (
 
 	// NodeLabelSelector is scheduler filter node by node label.
 	NodeLabelSelector map[string]string
+	// NodeScorerWeights are the weights of the node scorers by name, the others keep their default weight.
+	NodeScorerWeights map[string]string
 
 	// NodeLockTimeout is the timeout for node locks.
 	NodeLockTimeout time.Duration
+	// NodeLockBackend is the node lock implementation, `annotation` or `lease`.
+	NodeLockBackend string
+	// NodeLockLeaseNamespace is the namespace of node lock Leases when NodeLockBackend is `lease`.
+	NodeLockLeaseNamespace string
+	// NodeLockLeaseDuration is how long a node lock Lease lives without being renewed.
+	NodeLockLeaseDuration time.Duration
+
+	// PodGroupTimeout is how long a pod group may wait for its members or hold reservations before it is released.
+	PodGroupTimeout time.Duration
+
+	// QuotaConfigMap is the namespace/name of the ConfigMap holding per-namespace device quotas, empty disables quotas.
+	QuotaConfigMap string
+
+	// EnablePreemption allows a pod that does not fit to evict lower-priority pods holding devices.
+	EnablePreemption bool
+	// PreemptionDryRun only reports the pods that would be preempted, without evicting them.
+	PreemptionDryRun bool
+
+	// LeaderElect runs leader election, so that several replicas can run with one active at a time.
+	LeaderElect bool
+	// LeaderElectResourceName is the name of the leader election Lease.
+	LeaderElectResourceName string
+	// LeaderElectResourceNamespace is the namespace of the leader election Lease.
+	LeaderElectResourceNamespace string
+	// LeaderElectAdvertiseAddress is the URL other replicas use to forward extender requests to this one when it leads, e.g. https://10.0.0.1:443.
+	LeaderElectAdvertiseAddress string
+
+	// ExplainBufferSize is how many recent scheduling decisions are kept for the explain endpoint, 0 keeps none.
+	ExplainBufferSize int
+
+	// EnableDeviceAllocationCRD keeps the devices reserved for each pod in a DeviceAllocation, restored on startup.
+	EnableDeviceAllocationCRD bool
+
+	// EnableMigReconfiguration plans the geometries of idle MIG GPUs for the pending pods, for device plugins to partition them ahead of allocations.
+	EnableMigReconfiguration bool
+
+	// PodDevicesEncoding is the encoding of the device annotations written on pods, legacy or json.
+	PodDevicesEncoding string
+
+	// WatchDeviceConfig reloads the device config file when it changes, with the scheduler settings in it.
+	WatchDeviceConfig bool
+
+	// InventoryTokenFile is the file holding the bearer token of the inventory endpoint, empty disables it.
+	InventoryTokenFile string
+
+	// AccountingDir is the directory of the ledger recording how long pods hold their devices, empty disables accounting.
+	AccountingDir string
+	// AccountingRetention is how long the records of released devices are kept, 0 keeps them all.
+	AccountingRetention time.Duration
+	// AccountingPrometheusURL is the Prometheus server scraping vGPUmonitor, queried for the actual usage in accounting reports if set.
+	AccountingPrometheusURL string
+
+	// FilterParallelism is how many nodes are fitted concurrently when filtering a pod.
+	FilterParallelism = 16
+	// PercentageOfNodesToScore is the percentage of the nodes that are fitted before Filter stops once enough of them fit, 0 adapts it to the size of the cluster.
+	PercentageOfNodesToScore = 100
 
 	// If set to false, When Pod.Spec.SchedulerName equals to the const DefaultSchedulerName in k8s.io/api/core/v1 package, webhook will not overwrite it, default value is true.
 	ForceOverwriteDefaultScheduler bool
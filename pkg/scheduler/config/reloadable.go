@@ -0,0 +1,88 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// Reloadable holds the scheduler settings that can be changed at runtime, from
+// the scheduler section of the device config file. Empty fields keep the value
+// of their flag.
+type Reloadable struct {
+	NodeSchedulerPolicy string            `yaml:"nodeSchedulerPolicy"`
+	GPUSchedulerPolicy  string            `yaml:"gpuSchedulerPolicy"`
+	NodeLabelSelector   map[string]string `yaml:"nodeLabelSelector"`
+	NodeLockTimeout     time.Duration     `yaml:"nodeLockTimeout"`
+}
+
+var reloaded atomic.Pointer[Reloadable]
+
+// Validate checks the policies and the node lock timeout of the settings.
+func (r *Reloadable) Validate() error {
+	switch r.NodeSchedulerPolicy {
+	case "", util.NodeSchedulerPolicyBinpack.String(), util.NodeSchedulerPolicySpread.String():
+	default:
+		return fmt.Errorf("invalid node scheduler policy %q", r.NodeSchedulerPolicy)
+	}
+	switch r.GPUSchedulerPolicy {
+	case "", util.GPUSchedulerPolicyBinpack.String(), util.GPUSchedulerPolicySpread.String(), util.GPUSchedulerPolicyTopology.String():
+	default:
+		return fmt.Errorf("invalid GPU scheduler policy %q", r.GPUSchedulerPolicy)
+	}
+	if r.NodeLockTimeout < 0 {
+		return fmt.Errorf("invalid node lock timeout %v", r.NodeLockTimeout)
+	}
+	return nil
+}
+
+// SetReloadable replaces the settings reloaded at runtime, nil reverts to the flags.
+func SetReloadable(r *Reloadable) {
+	reloaded.Store(r)
+}
+
+// Current returns the settings in effect: those reloaded at runtime, falling
+// back to the flags for the ones they leave empty.
+func Current() Reloadable {
+	cur := Reloadable{
+		NodeSchedulerPolicy: NodeSchedulerPolicy,
+		GPUSchedulerPolicy:  GPUSchedulerPolicy,
+		NodeLabelSelector:   NodeLabelSelector,
+		NodeLockTimeout:     NodeLockTimeout,
+	}
+	r := reloaded.Load()
+	if r == nil {
+		return cur
+	}
+	if r.NodeSchedulerPolicy != "" {
+		cur.NodeSchedulerPolicy = r.NodeSchedulerPolicy
+	}
+	if r.GPUSchedulerPolicy != "" {
+		cur.GPUSchedulerPolicy = r.GPUSchedulerPolicy
+	}
+	if r.NodeLabelSelector != nil {
+		cur.NodeLabelSelector = r.NodeLabelSelector
+	}
+	if r.NodeLockTimeout > 0 {
+		cur.NodeLockTimeout = r.NodeLockTimeout
+	}
+	return cur
+}
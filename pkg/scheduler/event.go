@@ -40,6 +40,13 @@ const (
 	EventReasonBindingFailed = "BindingFailed"
 	// EventReasonBindingSucceed indicates that  binding succeed.
 	EventReasonBindingSucceed = "BindingSucceed"
+
+	// EventReasonPreempting indicates that lower-priority pods are evicted to make room for the pod.
+	EventReasonPreempting = "Preempting"
+	// EventReasonPreempted indicates that the pod is evicted by a higher-priority pod.
+	EventReasonPreempted = "Preempted"
+	// EventReasonPreemptionDryRun reports the pods that would be preempted when preemption runs in dry-run mode.
+	EventReasonPreemptionDryRun = "PreemptionDryRun"
 )
 
 func (s *Scheduler) addAllEventHandlers() {
@@ -0,0 +1,308 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	"github.com/Project-HAMi/HAMi/pkg/device"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/policy"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// deviceFit is the result of a device that passes every check of its vendor.
+const deviceFit = "Fit"
+
+// Decision is the trace of a scheduling decision for a pod, returned by the
+// explain endpoint.
+type Decision struct {
+	PodUID    k8stypes.UID           `json:"podUID"`
+	Namespace string                 `json:"namespace"`
+	Name      string                 `json:"name"`
+	Time      time.Time              `json:"time"`
+	Requests  util.PodDeviceRequests `json:"requests"`
+	// Node is the node the pod was assigned to, empty if it fits nowhere.
+	Node  string         `json:"node,omitempty"`
+	Error string         `json:"error,omitempty"`
+	Nodes []NodeDecision `json:"nodes"`
+}
+
+// NodeDecision is why a pod fits on a candidate node or not.
+type NodeDecision struct {
+	Node string `json:"node"`
+	Fit  bool   `json:"fit"`
+	// Reason is why the node was rejected, as reported in events.
+	Reason string `json:"reason,omitempty"`
+	// Score, Scores and Devices are the NodeScore of a node the pod fits on.
+	Score      float32             `json:"score,omitempty"`
+	Scores     map[string]float32  `json:"scores,omitempty"`
+	Devices    util.PodDevices     `json:"devices,omitempty"`
+	Containers []ContainerDecision `json:"containers,omitempty"`
+}
+
+// ContainerDecision is the result of every device of the node for a device
+// request of a container.
+type ContainerDecision struct {
+	Container int                         `json:"container"`
+	Request   util.ContainerDeviceRequest `json:"request"`
+	Devices   []DeviceDecision            `json:"devices"`
+}
+
+// DeviceDecision is the usage of a device before the pod was placed, and the
+// result of the checks of its vendor for a single device of the request:
+// Fit, or the checks that failed.
+type DeviceDecision struct {
+	ID        string `json:"id"`
+	Index     uint   `json:"index"`
+	Type      string `json:"type"`
+	Health    bool   `json:"health"`
+	Used      int32  `json:"used"`
+	Count     int32  `json:"count"`
+	Usedmem   int32  `json:"usedmem"`
+	Totalmem  int32  `json:"totalmem"`
+	Physmem   int32  `json:"physmem"`
+	Usedcores int32  `json:"usedcores"`
+	Totalcore int32  `json:"totalcore"`
+	Result    string `json:"result"`
+}
+
+// decisionRecord keeps what a Decision is built from. The device checks are
+// only run again when the decision is explained, on the usage snapshot taken
+// before the pod was scored.
+type decisionRecord struct {
+	decision    Decision
+	pod         *corev1.Pod
+	usage       map[string]*NodeUsage
+	nodeInfos   map[string]*util.NodeInfo
+	scores      map[string]*policy.NodeScore
+	failedNodes map[string]string
+}
+
+// explainBuffer is a ring buffer of the most recent decisions. A nil buffer
+// records nothing.
+type explainBuffer struct {
+	mutex   sync.Mutex
+	records []*decisionRecord
+	next    int
+}
+
+func newExplainBuffer(size int) *explainBuffer {
+	if size <= 0 {
+		return nil
+	}
+	return &explainBuffer{records: make([]*decisionRecord, size)}
+}
+
+func (b *explainBuffer) add(r *decisionRecord) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.records[b.next] = r
+	b.next = (b.next + 1) % len(b.records)
+}
+
+// find returns the most recent record for a pod, the mutex must be held.
+func (b *explainBuffer) find(uid k8stypes.UID) *decisionRecord {
+	for i := 1; i <= len(b.records); i++ {
+		r := b.records[(b.next-i+len(b.records))%len(b.records)]
+		if r != nil && r.decision.PodUID == uid {
+			return r
+		}
+	}
+	return nil
+}
+
+// get returns the most recent record for a pod, with a copy of its decision.
+func (b *explainBuffer) get(uid k8stypes.UID) (*decisionRecord, Decision) {
+	if b == nil {
+		return nil, Decision{}
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	r := b.find(uid)
+	if r == nil {
+		return nil, Decision{}
+	}
+	return r, r.decision
+}
+
+// assign sets the node of the most recent decision for a pod, once the
+// framework plugin reserved it.
+func (b *explainBuffer) assign(uid k8stypes.UID, nodeID string) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if r := b.find(uid); r != nil {
+		r.decision.Node = nodeID
+	}
+}
+
+// snapshotUsage returns the node usage a pod is fitted on, if decisions are
+// recorded. It is kept as is, calcScore copies the usage it modifies.
+func (b *explainBuffer) snapshotUsage(nodes map[string]*NodeUsage) map[string]*NodeUsage {
+	if b == nil {
+		return nil
+	}
+	return nodes
+}
+
+// recordDecision keeps the outcome of fitting pod on the nodes of usage, a
+// snapshot from snapshotUsage.
+func (s *Scheduler) recordDecision(pod *corev1.Pod, resourceReqs util.PodDeviceRequests, usage map[string]*NodeUsage, scores *policy.NodeScoreList, failedNodes map[string]string, nodeID string, err error) {
+	if s.explain == nil || usage == nil {
+		return
+	}
+	r := &decisionRecord{
+		decision: Decision{
+			PodUID:    pod.UID,
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			Time:      time.Now(),
+			Requests:  resourceReqs,
+			Node:      nodeID,
+		},
+		pod:         pod.DeepCopy(),
+		usage:       usage,
+		nodeInfos:   make(map[string]*util.NodeInfo, len(usage)),
+		scores:      make(map[string]*policy.NodeScore),
+		failedNodes: make(map[string]string, len(failedNodes)),
+	}
+	if err != nil {
+		r.decision.Error = err.Error()
+	}
+	for id := range usage {
+		if nodeInfo, err := s.GetNode(id); err == nil {
+			r.nodeInfos[id] = nodeInfo
+		}
+	}
+	if scores != nil {
+		for _, score := range scores.NodeList {
+			r.scores[score.NodeID] = score
+		}
+	}
+	for id, reason := range failedNodes {
+		r.failedNodes[id] = reason
+	}
+	s.explain.add(r)
+}
+
+// Explain returns the trace of the most recent scheduling decision for a pod,
+// or nil if it is not kept anymore.
+func (s *Scheduler) Explain(uid k8stypes.UID) *Decision {
+	r, d := s.explain.get(uid)
+	if r == nil {
+		return nil
+	}
+	nodeIDs := make([]string, 0, len(r.usage)+len(r.failedNodes))
+	for id := range r.usage {
+		nodeIDs = append(nodeIDs, id)
+	}
+	for id := range r.failedNodes {
+		if _, ok := r.usage[id]; !ok {
+			nodeIDs = append(nodeIDs, id)
+		}
+	}
+	sort.Strings(nodeIDs)
+	d.Nodes = make([]NodeDecision, 0, len(nodeIDs))
+	for _, id := range nodeIDs {
+		nd := NodeDecision{Node: id, Reason: r.failedNodes[id]}
+		if score, ok := r.scores[id]; ok {
+			nd.Fit = true
+			nd.Score = score.Score
+			nd.Scores = score.Scores
+			nd.Devices = score.Devices
+		}
+		if usage, ok := r.usage[id]; ok {
+			nd.Containers = explainNode(r.pod, d.Requests, usage, r.nodeInfos[id])
+		}
+		d.Nodes = append(d.Nodes, nd)
+	}
+	return &d
+}
+
+// explainNode runs the Fit of each device of a node on its own, for one
+// device of every request of the pod.
+func explainNode(pod *corev1.Pod, resourceReqs util.PodDeviceRequests, usage *NodeUsage, nodeInfo *util.NodeInfo) []ContainerDecision {
+	res := make([]ContainerDecision, 0)
+	for ctrid, requests := range resourceReqs {
+		types := make([]string, 0, len(requests))
+		for t := range requests {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			req := requests[t]
+			if req.Nums == 0 {
+				continue
+			}
+			cd := ContainerDecision{Container: ctrid, Request: req, Devices: make([]DeviceDecision, 0)}
+			for _, dev := range getNodeResources(*usage, t) {
+				cd.Devices = append(cd.Devices, DeviceDecision{
+					ID:        dev.ID,
+					Index:     dev.Index,
+					Type:      dev.Type,
+					Health:    dev.Health,
+					Used:      dev.Used,
+					Count:     dev.Count,
+					Usedmem:   dev.Usedmem,
+					Totalmem:  dev.Totalmem,
+					Physmem:   dev.Physmem,
+					Usedcores: dev.Usedcores,
+					Totalcore: dev.Totalcore,
+					Result:    explainDevice(pod, req, dev, nodeInfo),
+				})
+			}
+			res = append(res, cd)
+		}
+	}
+	return res
+}
+
+// explainDevice returns Fit if a single device of req fits on dev, or the
+// checks that failed, without the counts of common.GenReason.
+func explainDevice(pod *corev1.Pod, req util.ContainerDeviceRequest, dev *util.DeviceUsage, nodeInfo *util.NodeInfo) string {
+	vendor, ok := device.GetDevices()[req.Type]
+	if !ok {
+		return "Device type not found"
+	}
+	if nodeInfo == nil {
+		nodeInfo = &util.NodeInfo{}
+	}
+	single := req
+	single.Nums = 1
+	fit, _, reason := vendor.Fit([]*util.DeviceUsage{cloneDeviceUsage(dev)}, single, pod.Annotations, pod, nodeInfo, &util.PodDevices{})
+	if fit {
+		return deviceFit
+	}
+	checks := make([]string, 0)
+	for r := range strings.SplitSeq(reason, ", ") {
+		if _, check, found := strings.Cut(r, " "); found {
+			checks = append(checks, check)
+		} else if r != "" {
+			checks = append(checks, r)
+		}
+	}
+	sort.Strings(checks)
+	return strings.Join(checks, ", ")
+}
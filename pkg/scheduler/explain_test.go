@@ -0,0 +1,116 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/common"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/policy"
+	"github.com/Project-HAMi/HAMi/pkg/util/client"
+)
+
+func explainTestPod(t testing.TB, name string, mem int64) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: k8stypes.UID(name + "-uid")},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "main",
+				Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{
+						"hami.io/gpu":    *resource.NewQuantity(1, resource.BinarySI),
+						"hami.io/gpumem": *resource.NewQuantity(mem, resource.BinarySI),
+					},
+				},
+			}},
+		},
+	}
+	_, err := client.KubeClient.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	require.NoError(t, err)
+	return pod
+}
+
+func Test_Explain(t *testing.T) {
+	s := newTestScheduler(t)
+	s.explain = newExplainBuffer(2)
+	nodeNames := &[]string{"node1", "node2", "node3"}
+
+	fits := explainTestPod(t, "fits", 3000)
+	res, err := s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: fits, NodeNames: nodeNames})
+	require.NoError(t, err)
+	require.NotNil(t, res.NodeNames)
+
+	d := s.Explain(fits.UID)
+	require.NotNil(t, d)
+	assert.Equal(t, "fits", d.Name)
+	assert.Equal(t, (*res.NodeNames)[0], d.Node)
+	assert.Empty(t, d.Error)
+	require.Len(t, d.Nodes, 3, "unregistered nodes are explained too")
+	assert.Equal(t, "node unregistered", d.Nodes[2].Reason)
+	for _, n := range d.Nodes[:2] {
+		assert.True(t, n.Fit, n.Node)
+		assert.Contains(t, n.Scores, policy.UsageScorer, n.Node)
+		require.Len(t, n.Containers, 1)
+		require.Len(t, n.Containers[0].Devices, 1)
+		dev := n.Containers[0].Devices[0]
+		assert.Equal(t, deviceFit, dev.Result)
+		assert.Equal(t, int32(0), dev.Usedmem, "the usage is the one before the pod was placed")
+		assert.Equal(t, int32(8000), dev.Totalmem)
+	}
+	pods := s.ListPodsInfo()
+	require.Len(t, pods, 1)
+	assert.Equal(t, d.Nodes[slices.IndexFunc(d.Nodes, func(n NodeDecision) bool { return n.Node == d.Node })].Scores, pods[0].Scores, "the scores of the node are kept with the pod")
+
+	tooBig := explainTestPod(t, "too-big", 9000)
+	res, err = s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: tooBig, NodeNames: &[]string{"node1", "node2"}})
+	require.NoError(t, err)
+	assert.Nil(t, res.NodeNames)
+	d = s.Explain(tooBig.UID)
+	require.NotNil(t, d)
+	assert.Empty(t, d.Node)
+	assert.Contains(t, d.Error, "no available node")
+	for _, n := range d.Nodes {
+		assert.False(t, n.Fit)
+		assert.Equal(t, nodeUnfitPod, n.Reason)
+		assert.Equal(t, common.CardInsufficientMemory, n.Containers[0].Devices[0].Result, n.Node)
+	}
+
+	// The buffer keeps the last two decisions only.
+	_, err = s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: explainTestPod(t, "third", 1000), NodeNames: nodeNames})
+	require.NoError(t, err)
+	assert.Nil(t, s.Explain(fits.UID))
+	assert.NotNil(t, s.Explain(tooBig.UID))
+}
+
+func Test_Explain_disabled(t *testing.T) {
+	s := newTestScheduler(t)
+	s.explain = newExplainBuffer(0)
+	pod := explainTestPod(t, "pod", 1000)
+	_, err := s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: pod, NodeNames: &[]string{"node1"}})
+	require.NoError(t, err)
+	assert.Nil(t, s.Explain(pod.UID))
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	hamiv1alpha1 "github.com/Project-HAMi/HAMi/pkg/apis/hami/v1alpha1"
+	"github.com/Project-HAMi/HAMi/pkg/device"
+	"github.com/Project-HAMi/HAMi/pkg/k8sutil"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/policy"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// The methods below split Filter and Bind into the phases of the
+// kube-scheduler framework, for the plugin in pkg/scheduler/plugin: devices are
+// only reserved once a node is chosen, and released again if binding fails.
+
+// FitPod fits a pod on every registered node. It returns the nodes the pod fits
+// on with their scores and allocated devices, and the reason each other node
+// was rejected. If the pod fits nowhere, preemption is tried and the returned
+// error tells why the pod is unschedulable.
+func (s *Scheduler) FitPod(ctx context.Context, pod *corev1.Pod) (map[string]*policy.NodeScore, map[string]string, error) {
+	resourceReqs := k8sutil.Resourcereqs(pod)
+	// A pod retried after Unreserve is accounted again from scratch.
+	s.delPod(pod)
+	allNodes, err := s.ListNodes()
+	if err != nil {
+		return nil, nil, err
+	}
+	nodeNames := make([]string, 0, len(allNodes))
+	for nodeID := range allNodes {
+		nodeNames = append(nodeNames, nodeID)
+	}
+	start := time.Now()
+	nodeUsage, failedNodes := s.sharedNodesUsage(nodeNames)
+	s.filterLatency.observe(filterStageUsage, start)
+	snapshot := s.explain.snapshotUsage(nodeUsage)
+	// The framework samples the nodes itself, the pod is fitted on all of them.
+	start = time.Now()
+	nodeScores, err := s.calcScore(ctx, &nodeUsage, resourceReqs, pod.Annotations, pod, failedNodes, 0)
+	s.filterLatency.observe(filterStageFit, start)
+	if err != nil {
+		err = fmt.Errorf("calcScore failed %v for pod %v", err, pod.Name)
+		s.recordDecision(pod, resourceReqs, snapshot, nil, failedNodes, "", err)
+		return nil, nil, err
+	}
+	if len(nodeScores.NodeList) == 0 {
+		start = time.Now()
+		err := s.noAvailableNode(pod, resourceReqs, failedNodes, len(nodeNames))
+		s.filterLatency.observe(filterStagePreempt, start)
+		s.recordDecision(pod, resourceReqs, snapshot, nodeScores, failedNodes, "", err)
+		return nil, failedNodes, err
+	}
+	// The node is only known once the framework reserves it, see ReservePod.
+	s.recordDecision(pod, resourceReqs, snapshot, nodeScores, failedNodes, "", nil)
+	fits := make(map[string]*policy.NodeScore, len(nodeScores.NodeList))
+	for _, score := range nodeScores.NodeList {
+		fits[score.NodeID] = score
+	}
+	return fits, failedNodes, nil
+}
+
+// ReservePod assigns the devices chosen by FitPod on the selected node to the
+// pod, with the scores of the node.
+func (s *Scheduler) ReservePod(pod *corev1.Pod, nodeID string, devices util.PodDevices, scores map[string]float32) error {
+	klog.InfoS("Reserving devices for pod", "pod", klog.KObj(pod), "nodeID", nodeID, "devices", devices)
+	start := time.Now()
+	err := s.assignPod(pod, nodeID, devices, scores)
+	s.filterLatency.observe(filterStageAssign, start)
+	if err != nil {
+		s.recordScheduleFilterResultEvent(pod, EventReasonFilteringFailed, "", err)
+		return err
+	}
+	s.recordScheduleFilterResultEvent(pod, EventReasonFilteringSucceed, fmt.Sprintf("find fit node(%s)", nodeID), nil)
+	s.explain.assign(pod.UID, nodeID)
+	return nil
+}
+
+// UnreservePod rolls back ReservePod and PreBindPod after a later phase failed:
+// the devices are released and the node lock, if taken, is freed.
+func (s *Scheduler) UnreservePod(ctx context.Context, pod *corev1.Pod, nodeID string) {
+	klog.InfoS("Unreserving devices for pod", "pod", klog.KObj(pod), "nodeID", nodeID)
+	s.delPod(pod)
+	s.releaseAllocation(pod)
+	if err := removePodAssignment(pod); err != nil {
+		klog.ErrorS(err, "Failed to remove pod assignment", "pod", klog.KObj(pod))
+	}
+	node, err := s.kubeClient.CoreV1().Nodes().Get(ctx, nodeID, metav1.GetOptions{})
+	if err != nil {
+		klog.ErrorS(err, "Failed to get node", "node", nodeID)
+		return
+	}
+	for _, val := range device.GetDevices() {
+		val.ReleaseNodeLock(node, pod)
+	}
+}
+
+// PreBindPod locks the node and marks the pod as allocating, like Bind does
+// before binding, so that the device plugin picks the pod up.
+func (s *Scheduler) PreBindPod(ctx context.Context, pod *corev1.Pod, nodeID string) error {
+	node, err := s.kubeClient.CoreV1().Nodes().Get(ctx, nodeID, metav1.GetOptions{})
+	if err != nil {
+		s.recordScheduleBindingResultEvent(pod, EventReasonBindingFailed, []string{}, fmt.Errorf("failed to get node %s", nodeID))
+		return err
+	}
+	for _, val := range device.GetDevices() {
+		if err := val.LockNode(node, pod); err != nil {
+			klog.ErrorS(err, "Failed to lock node", "node", nodeID, "device", val)
+			s.recordScheduleBindingResultEvent(pod, EventReasonBindingFailed, []string{}, err)
+			return err
+		}
+	}
+	err = util.PatchPodAnnotations(pod, map[string]string{
+		util.DeviceBindPhase:     "allocating",
+		util.BindTimeAnnotations: strconv.FormatInt(time.Now().Unix(), 10),
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.allocations.setPhase(ctx, pod, hamiv1alpha1.DeviceAllocationAllocating); err != nil {
+		klog.ErrorS(err, "Failed to update device allocation", "pod", klog.KObj(pod))
+	}
+	return nil
+}
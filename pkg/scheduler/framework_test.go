@@ -0,0 +1,101 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/util/client"
+	"github.com/Project-HAMi/HAMi/pkg/util/nodelock"
+)
+
+func frameworkTestPod(t *testing.T, name string) *corev1.Pod {
+	pod := gangTestPod(name, "1")
+	pod.Annotations = map[string]string{}
+	pod, err := client.KubeClient.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	require.NoError(t, err)
+	return pod
+}
+
+func Test_FitPod(t *testing.T) {
+	s := newTestScheduler(t)
+	pod := frameworkTestPod(t, "pod1")
+
+	fits, _, err := s.FitPod(context.Background(), pod)
+	require.NoError(t, err)
+	assert.Len(t, fits, 2)
+	require.Contains(t, fits, "node1")
+	assert.Equal(t, "node1-gpu0", fits["node1"].Devices[nvidia.NvidiaGPUDevice][0][0].UUID)
+
+	// Reserving an exclusive GPU on both nodes leaves no room for another pod.
+	require.NoError(t, s.ReservePod(pod, "node1", fits["node1"].Devices, fits["node1"].Scores))
+	other := frameworkTestPod(t, "pod2")
+	fits, _, err = s.FitPod(context.Background(), other)
+	require.NoError(t, err)
+	require.NoError(t, s.ReservePod(other, "node2", fits["node2"].Devices, fits["node2"].Scores))
+	fits, failedNodes, err := s.FitPod(context.Background(), frameworkTestPod(t, "pod3"))
+	assert.Error(t, err)
+	assert.Empty(t, fits)
+	assert.Equal(t, nodeUnfitPod, failedNodes["node1"])
+}
+
+func Test_ReservePod_rollback(t *testing.T) {
+	s := newTestScheduler(t)
+	ctx := context.Background()
+	_, err := client.KubeClient.CoreV1().Nodes().Create(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	pod := frameworkTestPod(t, "pod1")
+
+	fits, _, err := s.FitPod(context.Background(), pod)
+	require.NoError(t, err)
+	require.NoError(t, s.ReservePod(pod, "node1", fits["node1"].Devices, fits["node1"].Scores))
+	current, err := client.KubeClient.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "node1", current.Annotations[util.AssignedNodeAnnotations])
+
+	require.NoError(t, s.PreBindPod(ctx, pod, "node1"))
+	current, err = client.KubeClient.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "allocating", current.Annotations[util.DeviceBindPhase])
+	ns, name, err := nodelock.GetNodeLockHolder(ctx, "node1", nodelock.NodeLockKey)
+	require.NoError(t, err)
+	assert.Equal(t, pod.Namespace+"/"+pod.Name, ns+"/"+name)
+
+	// Binding failed: the devices and the node lock are released.
+	s.UnreservePod(ctx, pod, "node1")
+	pods, _ := s.ListPodsUID()
+	assert.Empty(t, pods)
+	current, err = client.KubeClient.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, current.Annotations, util.AssignedNodeAnnotations)
+	ns, name, err = nodelock.GetNodeLockHolder(ctx, "node1", nodelock.NodeLockKey)
+	require.NoError(t, err)
+	assert.Empty(t, ns+name)
+}
+
+func Test_NodeSchedulerPolicy(t *testing.T) {
+	assert.Equal(t, util.NodeSchedulerPolicyBinpack.String(), NodeSchedulerPolicy(nil), "the configured policy by default")
+	assert.Equal(t, util.NodeSchedulerPolicySpread.String(), NodeSchedulerPolicy(map[string]string{util.NodeSchedulerPolicyAnnotationKey: "spread"}))
+}
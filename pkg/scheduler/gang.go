@@ -0,0 +1,437 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/k8sutil"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/util/client"
+)
+
+const (
+	podGroupCheckInterval = 10 * time.Second
+
+	podGroupWaiting  = "PodGroupWaiting"
+	podGroupUnfit    = "PodGroupUnfit"
+	podGroupReserved = "PodGroupReserved"
+)
+
+type gangMember struct {
+	pod       *corev1.Pod
+	nodeNames []string
+	nodeID    string
+	devices   util.PodDevices
+	scores    map[string]float32
+	bound     bool
+}
+
+// podGroup is a set of pods that are scheduled all-or-nothing. Devices are
+// reserved for every member at once, when at least minMember pods are known
+// and all of them fit.
+type podGroup struct {
+	key       string
+	minMember int
+	members   map[k8stypes.UID]*gangMember
+	createdAt time.Time
+	// placing is set while the members are placed and reserved.
+	placing    bool
+	reserved   bool
+	reservedAt time.Time
+}
+
+type gangManager struct {
+	groups map[string]*podGroup
+	mutex  sync.Mutex
+}
+
+func newGangManager() *gangManager {
+	return &gangManager{
+		groups: make(map[string]*podGroup),
+	}
+}
+
+// podGroupOf returns the pod group key and min member of a pod, ok is false if
+// the pod does not belong to a group.
+func podGroupOf(pod *corev1.Pod) (key string, minMember int, ok bool) {
+	name, found := pod.Annotations[util.PodGroupAnnotation]
+	if !found || name == "" {
+		return "", 0, false
+	}
+	minMember = 1
+	if v, found := pod.Annotations[util.PodGroupMinMemberAnnotation]; found {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			klog.ErrorS(err, "Invalid pod group min member, using 1", "pod", klog.KObj(pod), "value", v)
+		} else {
+			minMember = n
+		}
+	}
+	return pod.Namespace + "/" + name, minMember, true
+}
+
+func (m *gangManager) getOrCreate(key string, minMember int) *podGroup {
+	g, ok := m.groups[key]
+	if !ok {
+		g = &podGroup{
+			key:       key,
+			minMember: minMember,
+			members:   make(map[k8stypes.UID]*gangMember),
+			createdAt: time.Now(),
+		}
+		m.groups[key] = g
+		klog.InfoS("Pod group created", "podGroup", key, "minMember", minMember)
+	}
+	return g
+}
+
+// sortedMembers returns the members ordered by pod name, so placements are deterministic.
+func (g *podGroup) sortedMembers() []*gangMember {
+	res := make([]*gangMember, 0, len(g.members))
+	for _, member := range g.members {
+		res = append(res, member)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].pod.Name < res[j].pod.Name
+	})
+	return res
+}
+
+// markBound records a successful binding, and forgets the group once every member is bound.
+func (m *gangManager) markBound(pod *corev1.Pod) {
+	key, _, ok := podGroupOf(pod)
+	if !ok || m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	g, ok := m.groups[key]
+	if !ok {
+		return
+	}
+	member, ok := g.members[pod.UID]
+	if !ok {
+		return
+	}
+	member.bound = true
+	for _, val := range g.members {
+		if !val.bound {
+			return
+		}
+	}
+	klog.InfoS("All pod group members bound", "podGroup", key, "members", len(g.members))
+	delete(m.groups, key)
+}
+
+// removeMember drops a deleted pod from its group.
+func (m *gangManager) removeMember(pod *corev1.Pod) {
+	key, _, ok := podGroupOf(pod)
+	if !ok || m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	g, ok := m.groups[key]
+	if !ok {
+		return
+	}
+	delete(g.members, pod.UID)
+	if len(g.members) == 0 {
+		delete(m.groups, key)
+	}
+}
+
+// expire removes the groups that are not fully bound timeout after they were
+// reserved, or created if they are still waiting for members, and returns the
+// members whose reservations have to be released.
+func (m *gangManager) expire(now time.Time, timeout time.Duration) []*gangMember {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var released []*gangMember
+	for key, g := range m.groups {
+		since := g.createdAt
+		if g.reserved {
+			since = g.reservedAt
+		}
+		if g.placing || now.Sub(since) < timeout {
+			continue
+		}
+		for _, member := range g.members {
+			if g.reserved && !member.bound {
+				released = append(released, member)
+			}
+		}
+		klog.InfoS("Pod group timed out", "podGroup", key, "members", len(g.members), "minMember", g.minMember, "reserved", g.reserved)
+		delete(m.groups, key)
+	}
+	return released
+}
+
+func (s *Scheduler) releaseExpiredPodGroups() {
+	if !s.IsLeader() {
+		return
+	}
+	for _, member := range s.gang.expire(time.Now(), config.PodGroupTimeout) {
+		klog.InfoS("Releasing pod group reservation", "pod", klog.KObj(member.pod), "node", member.nodeID)
+		s.delPod(member.pod)
+		s.releaseAllocation(member.pod)
+		if err := removePodAssignment(member.pod); err != nil {
+			klog.ErrorS(err, "Failed to remove pod assignment", "pod", klog.KObj(member.pod))
+		}
+		s.recordScheduleFilterResultEvent(member.pod, EventReasonFilteringFailed, "", fmt.Errorf("pod group reservation timed out after %v", config.PodGroupTimeout))
+	}
+}
+
+// removePodAssignment deletes the annotations and label written by assignPod.
+func removePodAssignment(pod *corev1.Pod) error {
+	annotations := map[string]any{
+		util.AssignedNodeAnnotations: nil,
+		util.AssignedTimeAnnotations: nil,
+	}
+	for _, val := range util.InRequestDevices {
+		annotations[val] = nil
+	}
+	for _, val := range util.SupportDevices {
+		annotations[val] = nil
+	}
+	patch := map[string]any{
+		"metadata": map[string]any{
+			"annotations": annotations,
+			"labels":      map[string]any{util.AssignedNodeAnnotations: nil},
+		},
+	}
+	bytes, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	_, err = client.GetClient().CoreV1().Pods(pod.Namespace).Patch(context.Background(), pod.Name, k8stypes.MergePatchType, bytes, metav1.PatchOptions{})
+	return err
+}
+
+func waitingResult(nodeNames *[]string, reason string) *extenderv1.ExtenderFilterResult {
+	failedNodes := make(extenderv1.FailedNodesMap)
+	if nodeNames != nil {
+		for _, nodeID := range *nodeNames {
+			failedNodes[nodeID] = reason
+		}
+	}
+	return &extenderv1.ExtenderFilterResult{FailedNodes: failedNodes}
+}
+
+// gangFilter handles Filter for a pod that belongs to a pod group. A member is
+// only given a node once the whole group has been placed on one snapshot of the
+// cluster; until then it is reported unschedulable and retried by kube-scheduler.
+// The members are placed and reserved without holding the gang lock, the group
+// is marked placing meanwhile so that it is placed once.
+// handled is false if the pod has to go through the regular Filter.
+func (s *Scheduler) gangFilter(ctx context.Context, args extenderv1.ExtenderArgs, key string, minMember int) (res *extenderv1.ExtenderFilterResult, handled bool, err error) {
+	s.gang.mutex.Lock()
+	g := s.gang.getOrCreate(key, minMember)
+	if member, ok := g.members[args.Pod.UID]; ok && g.reserved {
+		s.gang.mutex.Unlock()
+		klog.InfoS("Pod group member already reserved", "pod", klog.KObj(args.Pod), "podGroup", key, "node", member.nodeID)
+		return &extenderv1.ExtenderFilterResult{NodeNames: &[]string{member.nodeID}}, true, nil
+	}
+	if g.reserved {
+		s.gang.mutex.Unlock()
+		// The group was reserved without this pod, it is scheduled on its own.
+		klog.InfoS("Pod group already reserved, scheduling extra member alone", "pod", klog.KObj(args.Pod), "podGroup", key)
+		return nil, false, nil
+	}
+	if g.placing {
+		s.gang.mutex.Unlock()
+		// The pod is retried, and scheduled on its own if it was not placed with the group.
+		reason := fmt.Sprintf("%s %s: placing the members", podGroupWaiting, key)
+		klog.InfoS(podGroupWaiting, "pod", klog.KObj(args.Pod), "podGroup", key, "reason", "placing the members")
+		return waitingResult(args.NodeNames, reason), true, nil
+	}
+	nodeNames := []string{}
+	if args.NodeNames != nil {
+		nodeNames = *args.NodeNames
+	}
+	g.members[args.Pod.UID] = &gangMember{pod: args.Pod, nodeNames: nodeNames}
+	if len(g.members) < g.minMember {
+		members := len(g.members)
+		s.gang.mutex.Unlock()
+		reason := fmt.Sprintf("%s %s: %d/%d members", podGroupWaiting, key, members, g.minMember)
+		klog.InfoS(podGroupWaiting, "pod", klog.KObj(args.Pod), "podGroup", key, "members", members, "minMember", g.minMember)
+		s.recordScheduleFilterResultEvent(args.Pod, EventReasonFilteringFailed, "", fmt.Errorf("%s", reason))
+		return waitingResult(args.NodeNames, reason), true, nil
+	}
+	g.placing = true
+	members := g.snapshotMembers()
+	s.gang.mutex.Unlock()
+
+	if err := s.placePodGroup(ctx, members); err != nil {
+		s.gang.donePlacing(g)
+		reason := fmt.Sprintf("%s %s: %v", podGroupUnfit, key, err)
+		klog.InfoS(podGroupUnfit, "pod", klog.KObj(args.Pod), "podGroup", key, "reason", err)
+		s.recordScheduleFilterResultEvent(args.Pod, EventReasonFilteringFailed, "", fmt.Errorf("%s", reason))
+		return waitingResult(args.NodeNames, reason), true, nil
+	}
+	if err := s.assignPodGroup(members); err != nil {
+		s.gang.donePlacing(g)
+		klog.ErrorS(err, "Failed to reserve pod group", "podGroup", key)
+		s.recordScheduleFilterResultEvent(args.Pod, EventReasonFilteringFailed, "", err)
+		return nil, true, err
+	}
+	if !s.gang.reserve(g, members, time.Now()) {
+		err := fmt.Errorf("pod group %s changed while its members were placed", key)
+		klog.ErrorS(err, "Rolling back pod group reservation", "podGroup", key)
+		s.rollbackPodGroup(members)
+		s.recordScheduleFilterResultEvent(args.Pod, EventReasonFilteringFailed, "", err)
+		return nil, true, err
+	}
+	klog.InfoS(podGroupReserved, "podGroup", key, "members", len(members))
+	var nodeID string
+	for _, member := range members {
+		s.recordScheduleFilterResultEvent(member.pod, EventReasonFilteringSucceed, fmt.Sprintf("%s %s, node(%s)", podGroupReserved, key, member.nodeID), nil)
+		if member.pod.UID == args.Pod.UID {
+			nodeID = member.nodeID
+		}
+	}
+	return &extenderv1.ExtenderFilterResult{NodeNames: &[]string{nodeID}}, true, nil
+}
+
+// snapshotMembers returns copies of the members ordered by pod name, to be
+// placed without holding the gang lock.
+func (g *podGroup) snapshotMembers() []*gangMember {
+	res := make([]*gangMember, 0, len(g.members))
+	for _, member := range g.sortedMembers() {
+		c := *member
+		res = append(res, &c)
+	}
+	return res
+}
+
+// donePlacing lets the members of a group that could not be reserved place it again.
+func (m *gangManager) donePlacing(g *podGroup) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	g.placing = false
+}
+
+// reserve records the placed members of a group. It returns false if the
+// group timed out or lost members while they were placed, the reservations
+// have to be rolled back then.
+func (m *gangManager) reserve(g *podGroup, members []*gangMember, now time.Time) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	g.placing = false
+	if m.groups[g.key] != g || len(g.members) != len(members) {
+		return false
+	}
+	for _, member := range members {
+		if _, ok := g.members[member.pod.UID]; !ok {
+			return false
+		}
+	}
+	for _, member := range members {
+		g.members[member.pod.UID] = member
+	}
+	g.reserved = true
+	g.reservedAt = now
+	return true
+}
+
+// assignPodGroup reserves the devices of every placed member, and rolls back
+// those already reserved if one of them fails.
+func (s *Scheduler) assignPodGroup(members []*gangMember) error {
+	for idx, member := range members {
+		if err := s.assignPod(member.pod, member.nodeID, member.devices, member.scores); err != nil {
+			klog.ErrorS(err, "Failed to reserve pod group member, rolling back", "pod", klog.KObj(member.pod))
+			s.rollbackPodGroup(members[:idx])
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackPodGroup releases the reservations of members.
+func (s *Scheduler) rollbackPodGroup(members []*gangMember) {
+	for _, member := range members {
+		s.delPod(member.pod)
+		s.releaseAllocation(member.pod)
+		if err := removePodAssignment(member.pod); err != nil {
+			klog.ErrorS(err, "Failed to remove pod assignment", "pod", klog.KObj(member.pod))
+		}
+	}
+}
+
+// placePodGroup finds a node and devices for every member. Members are placed
+// one after another on the same usage snapshot, so each one sees the devices
+// taken by those placed before it. Nothing is reserved in podManager here.
+func (s *Scheduler) placePodGroup(ctx context.Context, members []*gangMember) error {
+	for idx, member := range members {
+		nodeUsage, failedNodes, err := s.getNodesUsage(&member.nodeNames, member.pod)
+		if err != nil {
+			return err
+		}
+		for _, placed := range members[:idx] {
+			if node, ok := (*nodeUsage)[placed.nodeID]; ok {
+				addNodeDevicesUsage(node, placed.devices, util.ToleratesMemoryOversubscription(placed.pod))
+			}
+		}
+		nodeScores, err := s.calcScore(ctx, nodeUsage, k8sutil.Resourcereqs(member.pod), member.pod.Annotations, member.pod, failedNodes, 0)
+		if err != nil {
+			return err
+		}
+		if len(nodeScores.NodeList) == 0 {
+			return fmt.Errorf("member %s does not fit in %d nodes", member.pod.Name, len(member.nodeNames))
+		}
+		sort.Sort(nodeScores)
+		m := nodeScores.NodeList[len(nodeScores.NodeList)-1]
+		member.nodeID = m.NodeID
+		member.devices = m.Devices
+		member.scores = m.Scores
+		klog.V(4).InfoS("Pod group member placed", "pod", klog.KObj(member.pod), "node", m.NodeID, "devices", m.Devices)
+	}
+	return s.fitPodGroupQuota(members)
+}
+
+// fitPodGroupQuota checks the devices of all the placed members against the
+// quota of their namespace at once: calcScore checks every member alone, so
+// that the members would otherwise fit one by one in what is left for one.
+func (s *Scheduler) fitPodGroupQuota(members []*gangMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+	devices := util.PodDevices{}
+	for _, member := range members {
+		for vendor, podSingle := range member.devices {
+			devices[vendor] = append(devices[vendor], podSingle...)
+		}
+	}
+	if ok, reason := s.quota.fitQuota(members[0].pod.Namespace, devices); !ok {
+		return fmt.Errorf("%s", reason)
+	}
+	return nil
+}
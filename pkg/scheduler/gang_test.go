@@ -0,0 +1,250 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/device"
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/util/client"
+)
+
+func gangTestPod(name string, minMember string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       k8stypes.UID(name + "-uid"),
+			Annotations: map[string]string{
+				util.PodGroupAnnotation:          "job1",
+				util.PodGroupMinMemberAnnotation: minMember,
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "worker",
+					Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{
+							"hami.io/gpu":      *resource.NewQuantity(1, resource.BinarySI),
+							"hami.io/gpucores": *resource.NewQuantity(100, resource.BinarySI),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func newTestScheduler(t testing.TB) *Scheduler {
+	s := NewScheduler()
+	client.KubeClient = fake.NewSimpleClientset()
+	s.kubeClient = client.KubeClient
+	s.addAllEventHandlers()
+	err := device.InitDevicesWithConfig(&device.Config{
+		NvidiaConfig: nvidia.NvidiaConfig{
+			ResourceCountName:            "hami.io/gpu",
+			ResourceMemoryName:           "hami.io/gpumem",
+			ResourceMemoryPercentageName: "hami.io/gpumem-percentage",
+			ResourceCoreName:             "hami.io/gpucores",
+			DefaultGPUNum:                1,
+		},
+	})
+	require.NoError(t, err)
+	for _, nodeID := range []string{"node1", "node2"} {
+		s.addNode(nodeID, &util.NodeInfo{
+			ID:   nodeID,
+			Node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeID}},
+			Devices: []util.DeviceInfo{{
+				ID:           nodeID + "-gpu0",
+				Count:        10,
+				Devmem:       8000,
+				Devcore:      100,
+				Mode:         "hami-core",
+				Type:         nvidia.NvidiaGPUDevice,
+				Health:       true,
+				DeviceVendor: nvidia.NvidiaGPUDevice,
+			}},
+		})
+	}
+	return s
+}
+
+func gangFilterArgs(t *testing.T, pod *corev1.Pod) extenderv1.ExtenderArgs {
+	_, err := client.KubeClient.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	require.NoError(t, err)
+	return extenderv1.ExtenderArgs{Pod: pod, NodeNames: &[]string{"node1", "node2"}}
+}
+
+func Test_gangFilter(t *testing.T) {
+	s := newTestScheduler(t)
+	worker0 := gangTestPod("worker-0", "2")
+	worker1 := gangTestPod("worker-1", "2")
+
+	res, err := s.Filter(context.Background(), gangFilterArgs(t, worker0))
+	require.NoError(t, err)
+	assert.Nil(t, res.NodeNames)
+	assert.Contains(t, res.FailedNodes["node1"], podGroupWaiting)
+	pods, _ := s.ListPodsUID()
+	assert.Empty(t, pods, "nothing is reserved before the group is complete")
+
+	res, err = s.Filter(context.Background(), gangFilterArgs(t, worker1))
+	require.NoError(t, err)
+	require.NotNil(t, res.NodeNames)
+	worker1Node := (*res.NodeNames)[0]
+	pods, _ = s.ListPodsUID()
+	assert.Len(t, pods, 2, "every member is reserved at once")
+
+	// The first member gets its reservation when it is retried.
+	res, err = s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: worker0, NodeNames: &[]string{"node1", "node2"}})
+	require.NoError(t, err)
+	require.NotNil(t, res.NodeNames)
+	assert.NotEqual(t, worker1Node, (*res.NodeNames)[0], "exclusive members cannot share a GPU")
+
+	s.gang.markBound(worker0)
+	s.gang.markBound(worker1)
+	assert.Empty(t, s.gang.groups)
+}
+
+func Test_gangFilter_unfit(t *testing.T) {
+	s := newTestScheduler(t)
+	for _, name := range []string{"worker-0", "worker-1"} {
+		res, err := s.Filter(context.Background(), gangFilterArgs(t, gangTestPod(name, "3")))
+		require.NoError(t, err)
+		assert.Contains(t, res.FailedNodes["node1"], podGroupWaiting)
+	}
+	res, err := s.Filter(context.Background(), gangFilterArgs(t, gangTestPod("worker-2", "3")))
+	require.NoError(t, err)
+	assert.Nil(t, res.NodeNames)
+	assert.Contains(t, res.FailedNodes["node1"], podGroupUnfit)
+	pods, _ := s.ListPodsUID()
+	assert.Empty(t, pods, "no member is reserved if the group does not fit")
+}
+
+func Test_gangFilter_quota(t *testing.T) {
+	s := newTestScheduler(t)
+	s.quota.setLimits(map[string]map[string]QuotaLimit{
+		"default": {nvidia.NvidiaGPUDevice: {Count: 1}},
+	})
+	res, err := s.Filter(context.Background(), gangFilterArgs(t, gangTestPod("worker-0", "2")))
+	require.NoError(t, err)
+	assert.Contains(t, res.FailedNodes["node1"], podGroupWaiting)
+
+	res, err = s.Filter(context.Background(), gangFilterArgs(t, gangTestPod("worker-1", "2")))
+	require.NoError(t, err)
+	assert.Nil(t, res.NodeNames)
+	assert.Contains(t, res.FailedNodes["node1"], podGroupUnfit)
+	assert.Contains(t, res.FailedNodes["node1"], namespaceQuotaExceeded, "every member fits the quota alone, the group does not")
+	pods, _ := s.ListPodsUID()
+	assert.Empty(t, pods)
+}
+
+func Test_gangManager_expire(t *testing.T) {
+	m := newGangManager()
+	now := time.Now()
+
+	waiting := m.getOrCreate("default/waiting", 2)
+	waiting.members["a"] = &gangMember{pod: gangTestPod("a", "2")}
+
+	reserved := m.getOrCreate("default/job1", 2)
+	reserved.createdAt = now.Add(-time.Hour)
+	reserved.reserved = true
+	reserved.reservedAt = now
+	reserved.members["b"] = &gangMember{pod: gangTestPod("b", "2"), nodeID: "node1", bound: true}
+	reserved.members["c"] = &gangMember{pod: gangTestPod("c", "2"), nodeID: "node2"}
+
+	placing := m.getOrCreate("default/placing", 1)
+	placing.createdAt = now.Add(-time.Hour)
+	placing.placing = true
+
+	assert.Empty(t, m.expire(now, time.Minute), "a group times out after it was reserved, not after it was created")
+	assert.Len(t, m.groups, 3)
+
+	released := m.expire(now.Add(2*time.Minute), time.Minute)
+	require.Len(t, released, 1)
+	assert.Equal(t, "c", released[0].pod.Name)
+	assert.Equal(t, []string{"default/placing"}, slices.Collect(maps.Keys(m.groups)), "a group being placed does not time out")
+}
+
+func Test_gangFilter_placing(t *testing.T) {
+	s := newTestScheduler(t)
+	g := s.gang.getOrCreate("default/job1", 2)
+	g.placing = true
+
+	res, err := s.Filter(context.Background(), gangFilterArgs(t, gangTestPod("worker-0", "2")))
+	require.NoError(t, err)
+	assert.Contains(t, res.FailedNodes["node1"], podGroupWaiting)
+	assert.Empty(t, g.members, "a pod is not added to a group being placed")
+}
+
+func Test_gangManager_reserve(t *testing.T) {
+	m := newGangManager()
+	now := time.Now()
+	a, b := gangTestPod("a", "2"), gangTestPod("b", "2")
+	g := m.getOrCreate("default/job1", 2)
+	g.members[a.UID] = &gangMember{pod: a}
+	g.members[b.UID] = &gangMember{pod: b}
+	g.placing = true
+	members := g.snapshotMembers()
+	for _, member := range members {
+		member.nodeID = "node1"
+	}
+
+	delete(g.members, b.UID)
+	assert.False(t, m.reserve(g, members, now), "a member was deleted while the group was placed")
+	assert.False(t, g.placing)
+	assert.False(t, g.reserved)
+	assert.Empty(t, g.members[a.UID].nodeID)
+
+	g.members[b.UID] = &gangMember{pod: b}
+	require.True(t, m.reserve(g, members, now))
+	assert.True(t, g.reserved)
+	assert.Equal(t, now, g.reservedAt)
+	assert.Equal(t, "node1", g.members[b.UID].nodeID)
+
+	delete(m.groups, g.key)
+	assert.False(t, m.reserve(g, members, now), "the group timed out while it was placed")
+}
+
+func Test_podGroupOf(t *testing.T) {
+	key, minMember, ok := podGroupOf(gangTestPod("a", "4"))
+	assert.True(t, ok)
+	assert.Equal(t, "default/job1", key)
+	assert.Equal(t, 4, minMember)
+
+	_, minMember, ok = podGroupOf(gangTestPod("a", "zero"))
+	assert.True(t, ok)
+	assert.Equal(t, 1, minMember)
+
+	_, _, ok = podGroupOf(&corev1.Pod{})
+	assert.False(t, ok)
+}
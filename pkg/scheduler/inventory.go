@@ -0,0 +1,141 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	"github.com/Project-HAMi/HAMi/pkg/k8sutil"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// InventoryDevice is a registered device and its usage.
+type InventoryDevice struct {
+	util.DeviceInfo
+	Used      int32 `json:"used"`
+	Usedmem   int32 `json:"usedmem"`
+	Usedcores int32 `json:"usedcores"`
+}
+
+// InventoryAllocation is the devices allocated to a pod.
+type InventoryAllocation struct {
+	Devices                  util.PodDevices `json:"devices"`
+	TolerateOversubscription bool            `json:"tolerateOversubscription,omitempty"`
+}
+
+// InventoryNode is a node with registered devices.
+type InventoryNode struct {
+	Name    string            `json:"name"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Devices []InventoryDevice `json:"devices"`
+	// Allocations are the devices of the pods assigned to the node, from
+	// which its usage is rebuilt to fit pods on it.
+	Allocations []InventoryAllocation `json:"allocations,omitempty"`
+}
+
+// Inventory is the usage of the devices of a cluster, as InspectAllNodesUsage
+// reports it.
+type Inventory struct {
+	Nodes []InventoryNode `json:"nodes"`
+}
+
+// Inventory returns the devices of every registered node and their usage.
+func (s *Scheduler) Inventory() *Inventory {
+	nodes, _ := s.ListNodes()
+	nodeNames := slices.Sorted(maps.Keys(nodes))
+	usage, _, _ := s.getNodesUsage(&nodeNames, nil)
+	allocations := make(map[string][]InventoryAllocation)
+	for _, p := range s.ListPodsInfo() {
+		allocations[p.NodeID] = append(allocations[p.NodeID], InventoryAllocation{
+			Devices:                  p.Devices,
+			TolerateOversubscription: p.TolerateOversubscription,
+		})
+	}
+	inv := &Inventory{Nodes: make([]InventoryNode, 0, len(nodeNames))}
+	for _, nodeID := range nodeNames {
+		node, err := s.GetNode(nodeID)
+		if err != nil {
+			continue
+		}
+		n := InventoryNode{Name: nodeID, Devices: make([]InventoryDevice, 0, len(node.Devices)), Allocations: allocations[nodeID]}
+		if node.Node != nil {
+			n.Labels = node.Node.Labels
+		}
+		used := make(map[string]*util.DeviceUsage)
+		if nodeUsage, ok := (*usage)[nodeID]; ok {
+			for _, d := range nodeUsage.Devices.DeviceLists {
+				used[d.Device.ID] = d.Device
+			}
+		}
+		for _, d := range node.Devices {
+			dev := InventoryDevice{DeviceInfo: d}
+			if u, ok := used[d.ID]; ok {
+				dev.Used, dev.Usedmem, dev.Usedcores = u.Used, u.Usedmem, u.Usedcores
+			}
+			n.Devices = append(n.Devices, dev)
+		}
+		inv.Nodes = append(inv.Nodes, n)
+	}
+	return inv
+}
+
+// FitInventory places a pod on the nodes of an inventory as Filter would,
+// with their usage rebuilt from their allocations, and the current scheduler
+// config. Only the node labels are known, so node selectors are honored but
+// not taints or affinities. Devices must have been initialized.
+func FitInventory(inv *Inventory, pod *corev1.Pod) PodPlacement {
+	s := NewScheduler()
+	nodeNames := make([]string, 0, len(inv.Nodes))
+	for _, n := range inv.Nodes {
+		devices := make([]util.DeviceInfo, 0, len(n.Devices))
+		for _, d := range n.Devices {
+			devices = append(devices, d.DeviceInfo)
+		}
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: n.Name, Labels: n.Labels}}
+		s.addNode(n.Name, &util.NodeInfo{ID: n.Name, Node: node, Devices: devices})
+		for i, alloc := range n.Allocations {
+			p := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-%d", n.Name, i),
+				Namespace: "inventory",
+				UID:       k8stypes.UID(fmt.Sprintf("inventory/%s/%d", n.Name, i)),
+			}}
+			if alloc.TolerateOversubscription {
+				p.Annotations = map[string]string{util.MemoryOversubscriptionAnnotation: "true"}
+			}
+			s.addPod(p, n.Name, alloc.Devices)
+		}
+		if labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(n.Labels)) {
+			nodeNames = append(nodeNames, n.Name)
+		}
+	}
+	pod = pod.DeepCopy()
+	if pod.UID == "" {
+		pod.UID = k8stypes.UID(pod.Namespace + "/" + pod.Name)
+	}
+	resourceReqs := k8sutil.Resourcereqs(pod)
+	if !RequestsDevices(resourceReqs) {
+		return PodPlacement{Namespace: pod.Namespace, Name: pod.Name, Reason: "pod requests no devices"}
+	}
+	return s.simulatePod(pod, resourceReqs, nodeNames)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+func Test_Inventory(t *testing.T) {
+	s := newTestScheduler(t)
+	s.addPod(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "default", UID: "running-uid"}}, "node1",
+		util.PodDevices{nvidia.NvidiaGPUDevice: util.PodSingleDevice{
+			{{UUID: "node1-gpu0", Type: nvidia.NvidiaGPUDevice, Usedmem: 5000, Usedcores: 30}},
+		}})
+
+	inv := s.Inventory()
+	require.Len(t, inv.Nodes, 2)
+	assert.Equal(t, "node1", inv.Nodes[0].Name)
+	require.Len(t, inv.Nodes[0].Devices, 1)
+	dev := inv.Nodes[0].Devices[0]
+	assert.Equal(t, "node1-gpu0", dev.ID)
+	assert.Equal(t, int32(8000), dev.Devmem)
+	assert.Equal(t, [3]int32{1, 5000, 30}, [3]int32{dev.Used, dev.Usedmem, dev.Usedcores})
+	assert.Len(t, inv.Nodes[0].Allocations, 1)
+	assert.Empty(t, inv.Nodes[1].Allocations)
+
+	// The inventory is fitted as the advisor gets it.
+	raw, err := json.Marshal(inv)
+	require.NoError(t, err)
+	decoded := &Inventory{}
+	require.NoError(t, json.Unmarshal(raw, decoded))
+
+	placement := FitInventory(decoded, explainTestPod(t, "fits-node2", 4000))
+	assert.Equal(t, "node2", placement.Node, placement.Reason)
+
+	placement = FitInventory(decoded, explainTestPod(t, "too-large", 9000))
+	assert.Empty(t, placement.Node)
+	assert.Len(t, placement.FailedNodes, 2)
+
+	pod := explainTestPod(t, "unmatched-selector", 1000)
+	pod.Spec.NodeSelector = map[string]string{"gpu": "a100"}
+	placement = FitInventory(decoded, pod)
+	assert.Empty(t, placement.Node, "nodes not matching the node selector are skipped")
+}
+
+func Test_Inventory_registeredNode(t *testing.T) {
+	s, indexer := newRegistryTestScheduler(t)
+	addRegistryTestNode(t, indexer, simTestNode("node1", 2))
+	s.syncNode("node1")
+
+	inv := s.Inventory()
+	require.Len(t, inv.Nodes, 1)
+	require.Len(t, inv.Nodes[0].Devices, 2)
+	for _, d := range inv.Nodes[0].Devices {
+		assert.Equal(t, nvidia.NvidiaGPUDevice, d.DeviceVendor, "the devices are reported under the vendor that registered them")
+	}
+}
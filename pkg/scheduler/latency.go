@@ -0,0 +1,96 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// The stages of filtering a pod whose latency is recorded.
+const (
+	// filterStageUsage gets the usage of the nodes.
+	filterStageUsage = "usage"
+	// filterStageFit fits the pod on the nodes and scores them, see calcScore.
+	filterStageFit = "fit"
+	// filterStagePreempt looks for victims when the pod fits nowhere.
+	filterStagePreempt = "preempt"
+	// filterStageAssign assigns the devices of the chosen node to the pod.
+	filterStageAssign = "assign"
+)
+
+// latencyBuckets are the upper bounds of the buckets of the latency
+// histograms, in seconds.
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// LatencyHistogram is a cumulative histogram of latencies in seconds.
+type LatencyHistogram struct {
+	Count uint64
+	Sum   float64
+	// Buckets are the number of latencies up to each upper bound.
+	Buckets map[float64]uint64
+}
+
+// latencyRecorder records the latency of stages in histograms. Its zero value
+// is ready to use.
+type latencyRecorder struct {
+	mutex  sync.Mutex
+	stages map[string]*LatencyHistogram
+}
+
+// observe records the time elapsed since start in the histogram of a stage.
+func (r *latencyRecorder) observe(stage string, start time.Time) {
+	seconds := time.Since(start).Seconds()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.stages == nil {
+		r.stages = make(map[string]*LatencyHistogram)
+	}
+	h, ok := r.stages[stage]
+	if !ok {
+		h = &LatencyHistogram{Buckets: make(map[float64]uint64, len(latencyBuckets))}
+		for _, bound := range latencyBuckets {
+			h.Buckets[bound] = 0
+		}
+		r.stages[stage] = h
+	}
+	h.Count++
+	h.Sum += seconds
+	for _, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.Buckets[bound]++
+		}
+	}
+}
+
+// histograms returns a copy of the histogram of each stage.
+func (r *latencyRecorder) histograms() map[string]LatencyHistogram {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	res := make(map[string]LatencyHistogram, len(r.stages))
+	for stage, h := range r.stages {
+		res[stage] = LatencyHistogram{Count: h.Count, Sum: h.Sum, Buckets: maps.Clone(h.Buckets)}
+	}
+	return res
+}
+
+// FilterLatency returns the latency histograms of the stages of filtering
+// pods, by stage.
+func (s *Scheduler) FilterLatency() map[string]LatencyHistogram {
+	return s.filterLatency.histograms()
+}
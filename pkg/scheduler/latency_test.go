@@ -0,0 +1,59 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+func Test_latencyRecorder(t *testing.T) {
+	var r latencyRecorder
+	assert.Empty(t, r.histograms())
+
+	r.observe(filterStageFit, time.Now().Add(-30*time.Millisecond))
+	r.observe(filterStageFit, time.Now())
+	h := r.histograms()[filterStageFit]
+	assert.Equal(t, uint64(2), h.Count)
+	assert.InDelta(t, 0.03, h.Sum, 0.02)
+	assert.Equal(t, uint64(1), h.Buckets[0.001])
+	assert.Equal(t, uint64(1), h.Buckets[0.025])
+	assert.Equal(t, uint64(2), h.Buckets[0.05])
+	assert.Len(t, h.Buckets, len(latencyBuckets))
+
+	h.Buckets[0.001] = 10
+	assert.Equal(t, uint64(1), r.histograms()[filterStageFit].Buckets[0.001], "the histograms are copied")
+}
+
+func Test_Filter_latency(t *testing.T) {
+	s := newTestScheduler(t)
+	_, err := s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: explainTestPod(t, "fits", 1000), NodeNames: &[]string{"node1", "node2"}})
+	require.NoError(t, err)
+	_, err = s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: explainTestPod(t, "too-big", 9000), NodeNames: &[]string{"node1", "node2"}})
+	require.NoError(t, err)
+
+	latency := s.FilterLatency()
+	assert.Equal(t, uint64(2), latency[filterStageUsage].Count)
+	assert.Equal(t, uint64(2), latency[filterStageFit].Count)
+	assert.Equal(t, uint64(1), latency[filterStagePreempt].Count)
+	assert.Equal(t, uint64(1), latency[filterStageAssign].Count)
+}
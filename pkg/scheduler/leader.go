@@ -0,0 +1,138 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
+)
+
+const (
+	leaderElectionLeaseDuration = 15 * time.Second
+	leaderElectionRenewDeadline = 10 * time.Second
+	leaderElectionRetryPeriod   = 2 * time.Second
+)
+
+// leaderState tracks whether this replica is the active scheduler. Standby
+// replicas keep their node and pod caches warm from the informers, but leave
+// Filter, Bind and every write to the leader. Without leader election, the
+// only replica is always the leader.
+type leaderState struct {
+	enabled  bool
+	identity string
+	leading  atomic.Bool
+	current  string
+	mutex    sync.RWMutex
+}
+
+func newLeaderState(enabled bool, identity string) *leaderState {
+	return &leaderState{enabled: enabled, identity: identity}
+}
+
+// IsLeader reports whether this replica serves Filter and Bind.
+func (s *Scheduler) IsLeader() bool {
+	return !s.leader.enabled || s.leader.leading.Load()
+}
+
+// Identity returns the leader election identity of this replica.
+func (s *Scheduler) Identity() string {
+	return s.leader.identity
+}
+
+// Leader returns the identity of the current leader, empty if unknown.
+func (s *Scheduler) Leader() string {
+	if !s.leader.enabled {
+		return s.leader.identity
+	}
+	s.leader.mutex.RLock()
+	defer s.leader.mutex.RUnlock()
+	return s.leader.current
+}
+
+// leaderElectionIdentity is the advertised address of this replica when set,
+// so that standbys can forward extender requests to the leader, or the hostname.
+func leaderElectionIdentity() string {
+	if config.LeaderElectAdvertiseAddress != "" {
+		return config.LeaderElectAdvertiseAddress
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		klog.ErrorS(err, "Failed to get hostname for leader election identity")
+		return "unknown"
+	}
+	return hostname
+}
+
+// RunLeaderElection campaigns for leadership until ctx is done. A replica that
+// loses leadership goes back to standby and campaigns again.
+func (s *Scheduler) RunLeaderElection(ctx context.Context) error {
+	if !s.leader.enabled {
+		return nil
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      config.LeaderElectResourceName,
+			Namespace: config.LeaderElectResourceNamespace,
+		},
+		Client:     s.kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: s.leader.identity},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaderElectionLeaseDuration,
+		RenewDeadline:   leaderElectionRenewDeadline,
+		RetryPeriod:     leaderElectionRetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            config.LeaderElectResourceName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(_ context.Context) {
+				klog.InfoS("Started leading", "identity", s.leader.identity)
+				s.leader.leading.Store(true)
+				// Refresh node handshakes right away, standbys did not write them.
+				s.doNodeNotify()
+			},
+			OnStoppedLeading: func() {
+				klog.InfoS("Stopped leading, back to standby", "identity", s.leader.identity)
+				s.leader.leading.Store(false)
+			},
+			OnNewLeader: func(identity string) {
+				klog.InfoS("New leader elected", "leader", identity)
+				s.leader.mutex.Lock()
+				s.leader.current = identity
+				s.leader.mutex.Unlock()
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	klog.InfoS("Starting leader election", "identity", s.leader.identity, "lease", klog.KRef(config.LeaderElectResourceNamespace, config.LeaderElectResourceName))
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
+)
+
+func Test_leaderState_disabled(t *testing.T) {
+	s := newTestScheduler(t)
+	s.leader = newLeaderState(false, "https://10.0.0.1:443")
+	assert.True(t, s.IsLeader(), "without leader election the only replica leads")
+	assert.Equal(t, "https://10.0.0.1:443", s.Leader())
+	require.NoError(t, s.RunLeaderElection(context.Background()))
+}
+
+func Test_RunLeaderElection(t *testing.T) {
+	config.LeaderElectResourceName = "hami-scheduler-extender"
+	config.LeaderElectResourceNamespace = "kube-system"
+	first := newTestScheduler(t)
+	first.leader = newLeaderState(true, "https://10.0.0.1:443")
+	second := newTestScheduler(t)
+	second.kubeClient = first.kubeClient
+	second.leader = newLeaderState(true, "https://10.0.0.2:443")
+	assert.False(t, first.IsLeader(), "a replica is standby until elected")
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	defer stopFirst()
+	go first.RunLeaderElection(firstCtx)
+	require.Eventually(t, first.IsLeader, 5*time.Second, 50*time.Millisecond)
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	go second.RunLeaderElection(secondCtx)
+	require.Eventually(t, func() bool { return second.Leader() == "https://10.0.0.1:443" }, 5*time.Second, 50*time.Millisecond)
+	assert.False(t, second.IsLeader())
+
+	// The leader releases its lease on shutdown and the standby takes over.
+	stopFirst()
+	require.Eventually(t, second.IsLeader, 10*time.Second, 50*time.Millisecond)
+	assert.Equal(t, "https://10.0.0.2:443", second.Leader())
+}
+
+func Test_releaseExpiredPodGroups_standby(t *testing.T) {
+	s := newTestScheduler(t)
+	s.leader = newLeaderState(true, "https://10.0.0.1:443")
+	s.gang.groups["default/group1"] = &podGroup{key: "default/group1", minMember: 2, createdAt: time.Now().Add(-2 * config.PodGroupTimeout)}
+	s.releaseExpiredPodGroups()
+	assert.Contains(t, s.gang.groups, "default/group1", "only the leader releases pod groups")
+}
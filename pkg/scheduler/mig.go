@@ -0,0 +1,240 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"maps"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/k8sutil"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// migReconfigureInterval is how often the geometries of idle MIG GPUs are
+// planned for the pending pods.
+const migReconfigureInterval = 30 * time.Second
+
+// reconcileMigGeometries plans which idle MIG GPUs the pending pods should be
+// placed on, and the geometry each of them should be partitioned to. The plan
+// is written on the nodes for their device plugins to partition the GPUs
+// before the pods are allocated, instead of in Allocate.
+func (s *Scheduler) reconcileMigGeometries() {
+	if !s.IsLeader() {
+		return
+	}
+	nodes, _ := s.ListNodes()
+	nodeIDs := make([]string, 0)
+	for nodeID, nodeInfo := range nodes {
+		for _, d := range nodeInfo.Devices {
+			if d.Mode == nvidia.MigMode {
+				nodeIDs = append(nodeIDs, nodeID)
+				break
+			}
+		}
+	}
+	if len(nodeIDs) == 0 {
+		return
+	}
+	sort.Strings(nodeIDs)
+	demand, err := s.pendingMigDemand()
+	if err != nil {
+		klog.ErrorS(err, "Failed to list pending pods for MIG geometries")
+		return
+	}
+	usage, _, err := s.getNodesUsage(&nodeIDs, nil)
+	if err != nil {
+		klog.ErrorS(err, "Failed to get node usage for MIG geometries")
+		return
+	}
+	plan := planMigGeometries(*usage, nodeIDs, demand)
+	for _, nodeID := range nodeIDs {
+		nodeInfo, err := s.GetNode(nodeID)
+		if err != nil || nodeInfo.Node == nil {
+			continue
+		}
+		desired := nvidia.EncodeMigGeometries(plan[nodeID])
+		if nodeInfo.Node.Annotations[nvidia.MigDesiredGeometriesAnnos] == desired {
+			continue
+		}
+		if err := util.PatchNodeAnnotations(nodeInfo.Node, map[string]string{nvidia.MigDesiredGeometriesAnnos: desired}); err != nil {
+			klog.ErrorS(err, "Failed to set desired MIG geometries", "node", nodeID)
+			continue
+		}
+		klog.InfoS("Set desired MIG geometries", "node", nodeID, "geometries", desired)
+	}
+}
+
+// pendingMigDemand returns a request for every device the pods waiting to be
+// scheduled ask for, largest first.
+func (s *Scheduler) pendingMigDemand() ([]util.ContainerDeviceRequest, error) {
+	pods, err := s.podLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	demand := make([]util.ContainerDeviceRequest, 0)
+	for _, pod := range pods {
+		if pod.Spec.NodeName != "" || k8sutil.IsPodInTerminatedState(pod) {
+			continue
+		}
+		if _, ok := pod.Annotations[util.AssignedNodeAnnotations]; ok {
+			continue
+		}
+		for _, ctr := range k8sutil.Resourcereqs(pod) {
+			req, ok := ctr[nvidia.NvidiaGPUDevice]
+			if !ok {
+				continue
+			}
+			single := req
+			single.Nums = 1
+			for range req.Nums {
+				demand = append(demand, single)
+			}
+		}
+	}
+	sort.SliceStable(demand, func(i, j int) bool {
+		if demand[i].Memreq != demand[j].Memreq {
+			return demand[i].Memreq > demand[j].Memreq
+		}
+		return demand[i].MemPercentagereq > demand[j].MemPercentagereq
+	})
+	return demand, nil
+}
+
+// planMigGeometries returns the geometry index idle MIG GPUs should be
+// partitioned to, by node and UUID. Free instances of GPUs in use are handed
+// out first, then each idle GPU in turn takes the geometry that fits the most
+// of the remaining demand, keeping its current one on a tie. GPUs that fit
+// none of it are left out of the plan.
+func planMigGeometries(usage map[string]*NodeUsage, nodeIDs []string, demand []util.ContainerDeviceRequest) map[string]map[string]int32 {
+	served := make([]bool, len(demand))
+	type idleDevice struct {
+		nodeID string
+		dev    *util.DeviceUsage
+	}
+	idle := make([]idleDevice, 0)
+	for _, nodeID := range nodeIDs {
+		node, ok := usage[nodeID]
+		if !ok {
+			continue
+		}
+		for _, d := range node.Devices.DeviceLists {
+			dev := d.Device
+			if dev.Mode != nvidia.MigMode || !dev.Health || len(dev.MigTemplate) == 0 {
+				continue
+			}
+			if dev.Used == 0 && nvidia.MigDeviceIdle(dev) {
+				idle = append(idle, idleDevice{nodeID: nodeID, dev: dev})
+				continue
+			}
+			for _, i := range matchMigDemand(demand, served, dev.MigUsage.UsageList, dev) {
+				served[i] = true
+			}
+		}
+	}
+
+	plan := make(map[string]map[string]int32)
+	for _, c := range idle {
+		best := make([]int, 0)
+		bestIdx := -1
+		for tidx := range c.dev.MigTemplate {
+			layout := util.MigInUse{}
+			util.PlatternMIG(&layout, c.dev.MigTemplate, tidx)
+			matched := matchMigDemand(demand, served, layout.UsageList, c.dev)
+			current := len(c.dev.MigUsage.UsageList) > 0 && int(c.dev.MigUsage.Index) == tidx
+			if len(matched) > len(best) || len(matched) > 0 && len(matched) == len(best) && current {
+				best, bestIdx = matched, tidx
+			}
+		}
+		if bestIdx < 0 {
+			continue
+		}
+		for _, i := range best {
+			served[i] = true
+		}
+		if _, ok := plan[c.nodeID]; !ok {
+			plan[c.nodeID] = make(map[string]int32)
+		}
+		plan[c.nodeID][c.dev.ID] = int32(bestIdx)
+	}
+	return plan
+}
+
+// matchMigDemand returns the demand not served yet that the free instances of
+// a geometry take, each request the smallest instance it fits in.
+func matchMigDemand(demand []util.ContainerDeviceRequest, served []bool, instances util.MIGS, dev *util.DeviceUsage) []int {
+	taken := make([]bool, len(instances))
+	matched := make([]int, 0)
+	for i, req := range demand {
+		if served[i] {
+			continue
+		}
+		memreq := req.Memreq
+		if memreq == 0 {
+			memreq = dev.Totalmem * req.MemPercentagereq / 100
+		}
+		slot := -1
+		for j, instance := range instances {
+			if instance.InUse || taken[j] || instance.Memory < memreq {
+				continue
+			}
+			if slot < 0 || instance.Memory < instances[slot].Memory {
+				slot = j
+			}
+		}
+		if slot >= 0 {
+			taken[slot] = true
+			matched = append(matched, i)
+		}
+	}
+	return matched
+}
+
+// presetMigGeometries sets the idle MIG GPUs of a node to the geometry they
+// are planned to, or were last partitioned to ahead of an allocation, so that
+// pods fitting it are placed without partitioning the GPU again.
+func presetMigGeometries(node *corev1.Node, usage *NodeUsage) {
+	if node == nil {
+		return
+	}
+	geometries := migGeometries(node, nvidia.MigAppliedGeometriesAnnos)
+	maps.Copy(geometries, migGeometries(node, nvidia.MigDesiredGeometriesAnnos))
+	if len(geometries) == 0 {
+		return
+	}
+	for _, d := range usage.Devices.DeviceLists {
+		tidx, ok := geometries[d.Device.ID]
+		if !ok || d.Device.Mode != nvidia.MigMode || int(tidx) >= len(d.Device.MigTemplate) {
+			continue
+		}
+		util.PlatternMIG(&d.Device.MigUsage, d.Device.MigTemplate, int(tidx))
+	}
+}
+
+func migGeometries(node *corev1.Node, annotation string) map[string]int32 {
+	geometries, err := nvidia.DecodeMigGeometries(node.Annotations[annotation])
+	if err != nil {
+		klog.ErrorS(err, "Invalid MIG geometries", "node", node.Name, "annotation", annotation)
+		return make(map[string]int32)
+	}
+	return geometries
+}
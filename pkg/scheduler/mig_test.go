@@ -0,0 +1,166 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	listerscorev1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/util/client"
+)
+
+// addMigTestNode registers mig1 with two idle A100s in MIG mode.
+func addMigTestNode(t *testing.T, s *Scheduler, annotations map[string]string) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "mig1", Annotations: annotations}}
+	_, err := client.KubeClient.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{})
+	require.NoError(t, err)
+	devices := make([]util.DeviceInfo, 0)
+	for _, id := range []string{"mig1-gpu0", "mig1-gpu1"} {
+		devices = append(devices, util.DeviceInfo{
+			ID:      id,
+			Count:   10,
+			Devmem:  40960,
+			Devcore: 100,
+			Mode:    nvidia.MigMode,
+			Type:    "NVIDIA-A100-SXM4-40GB",
+			Health:  true,
+			MIGTemplate: []util.Geometry{
+				{{Name: "1g.5gb", Memory: 5120, Count: 7}},
+				{{Name: "3g.20gb", Memory: 20480, Count: 2}},
+			},
+			DeviceVendor: nvidia.NvidiaGPUDevice,
+		})
+	}
+	s.addNode("mig1", &util.NodeInfo{ID: "mig1", Node: node, Devices: devices})
+}
+
+func migDemand(mems ...int32) []util.ContainerDeviceRequest {
+	demand := make([]util.ContainerDeviceRequest, 0, len(mems))
+	for _, mem := range mems {
+		demand = append(demand, util.ContainerDeviceRequest{Nums: 1, Type: nvidia.NvidiaGPUDevice, Memreq: mem})
+	}
+	return demand
+}
+
+func Test_planMigGeometries(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		assigned    util.PodDevices
+		demand      []util.ContainerDeviceRequest
+		want        map[string]map[string]int32
+	}{
+		{
+			name:   "no pending pods",
+			demand: migDemand(),
+			want:   map[string]map[string]int32{},
+		},
+		{
+			name:   "geometry fitting the most pods",
+			demand: migDemand(15000, 15000, 4000),
+			want:   map[string]map[string]int32{"mig1": {"mig1-gpu0": 1, "mig1-gpu1": 0}},
+		},
+		{
+			name: "free instances are used first",
+			assigned: util.PodDevices{nvidia.NvidiaGPUDevice: util.PodSingleDevice{
+				{{UUID: "mig1-gpu0[1-0]", Type: nvidia.NvidiaGPUDevice, Usedmem: 20480}},
+			}},
+			demand: migDemand(15000, 15000, 4000),
+			want:   map[string]map[string]int32{"mig1": {"mig1-gpu1": 1}},
+		},
+		{
+			name:        "current geometry kept on a tie",
+			annotations: map[string]string{nvidia.MigDesiredGeometriesAnnos: "mig1-gpu0:1"},
+			demand:      migDemand(4000),
+			want:        map[string]map[string]int32{"mig1": {"mig1-gpu0": 1}},
+		},
+		{
+			name:   "requests no geometry fits",
+			demand: migDemand(50000),
+			want:   map[string]map[string]int32{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestScheduler(t)
+			addMigTestNode(t, s, test.annotations)
+			if test.assigned != nil {
+				s.addPod(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "default", UID: "running-uid"}}, "mig1", test.assigned)
+			}
+			nodeIDs := []string{"mig1"}
+			usage, _, err := s.getNodesUsage(&nodeIDs, nil)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, planMigGeometries(*usage, nodeIDs, test.demand))
+		})
+	}
+}
+
+func Test_reconcileMigGeometries(t *testing.T) {
+	s := newTestScheduler(t)
+	addMigTestNode(t, s, nil)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, pod := range []*corev1.Pod{
+		explainTestPod(t, "pending1", 15000),
+		explainTestPod(t, "pending2", 15000),
+	} {
+		require.NoError(t, indexer.Add(pod))
+	}
+	bound := explainTestPod(t, "bound", 4000)
+	bound.Spec.NodeName = "node1"
+	require.NoError(t, indexer.Add(bound))
+	s.podLister = listerscorev1.NewPodLister(indexer)
+
+	s.reconcileMigGeometries()
+	node, err := client.KubeClient.CoreV1().Nodes().Get(context.Background(), "mig1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "mig1-gpu0:1", node.Annotations[nvidia.MigDesiredGeometriesAnnos])
+
+	// Pods are placed on the geometry planned for the device, even if a
+	// smaller one fits them.
+	nodeInfo, err := s.GetNode("mig1")
+	require.NoError(t, err)
+	s.addNode("mig1", &util.NodeInfo{ID: "mig1", Node: node, Devices: nodeInfo.Devices})
+	small := explainTestPod(t, "small", 4000)
+	small.Annotations = map[string]string{nvidia.GPUUseUUID: "mig1-gpu0"}
+	res, err := s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: small, NodeNames: &[]string{"mig1"}})
+	require.NoError(t, err)
+	require.NotNil(t, res.NodeNames)
+	pods := s.ListPodsInfo()
+	require.Len(t, pods, 1)
+	assert.Equal(t, "mig1-gpu0[1-0]", pods[0].Devices[nvidia.NvidiaGPUDevice][0][0].UUID)
+}
+
+func Test_reconcileMigGeometries_standby(t *testing.T) {
+	s := newTestScheduler(t)
+	s.leader.enabled = true
+	addMigTestNode(t, s, nil)
+
+	s.reconcileMigGeometries()
+	node, err := client.KubeClient.CoreV1().Nodes().Get(context.Background(), "mig1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, node.Annotations, nvidia.MigDesiredGeometriesAnnos, "only the leader plans geometries")
+}
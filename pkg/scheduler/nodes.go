@@ -18,6 +18,7 @@ package scheduler
 
 import (
 	"fmt"
+	"maps"
 	"strings"
 	"sync"
 
@@ -36,7 +37,11 @@ type NodeUsage struct {
 
 type nodeManager struct {
 	nodes map[string]*util.NodeInfo
-	mutex sync.RWMutex
+	// versions is the version of each node, the value of version when its
+	// devices last changed.
+	versions map[string]uint64
+	version  uint64
+	mutex    sync.RWMutex
 }
 
 func newNodeManager() *nodeManager {
@@ -51,8 +56,10 @@ func (m *nodeManager) addNode(nodeID string, nodeInfo *util.NodeInfo) {
 	}
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	_, ok := m.nodes[nodeID]
+	existing, ok := m.nodes[nodeID]
 	if ok {
+		// The node info is replaced, not updated, as it is read without the lock.
+		updated := *existing
 		if len(nodeInfo.Devices) > 0 {
 			tmp := make([]util.DeviceInfo, 0, len(nodeInfo.Devices))
 			devices := device.GetDevices()
@@ -62,18 +69,28 @@ func (m *nodeManager) addNode(nodeID string, nodeInfo *util.NodeInfo) {
 					deviceType = val.CommonWord()
 				}
 			}
-			for _, val := range m.nodes[nodeID].Devices {
+			for _, val := range existing.Devices {
 				if !strings.Contains(val.Type, deviceType) {
 					tmp = append(tmp, val)
 				}
 			}
-			m.nodes[nodeID].Devices = tmp
-			m.nodes[nodeID].Devices = append(m.nodes[nodeID].Devices, nodeInfo.Devices...)
+			updated.Devices = append(tmp, nodeInfo.Devices...)
 		}
-		m.nodes[nodeID].Node = nodeInfo.Node
+		updated.Node = nodeInfo.Node
+		m.nodes[nodeID] = &updated
 	} else {
 		m.nodes[nodeID] = nodeInfo
 	}
+	m.touch(nodeID)
+}
+
+// touch records a change of a node's devices. The caller holds the lock.
+func (m *nodeManager) touch(nodeID string) {
+	if m.versions == nil {
+		m.versions = make(map[string]uint64)
+	}
+	m.version++
+	m.versions[nodeID] = m.version
 }
 
 func (m *nodeManager) rmNodeDevices(nodeID string, deviceVendor string) {
@@ -94,12 +111,31 @@ func (m *nodeManager) rmNodeDevices(nodeID string, deviceVendor string) {
 
 	if len(devices) == 0 {
 		delete(m.nodes, nodeID)
+		delete(m.versions, nodeID)
+		m.version++
 	} else {
-		nodeInfo.Devices = devices
+		updated := *nodeInfo
+		updated.Devices = devices
+		m.nodes[nodeID] = &updated
+		m.touch(nodeID)
 	}
 	klog.InfoS("Removing device from node", "nodeName", nodeID, "deviceVendor", deviceVendor, "remainingDevices", devices)
 }
 
+// rmNode forgets a node and all of its devices.
+func (m *nodeManager) rmNode(nodeID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.nodes[nodeID]; !ok {
+		return
+	}
+	delete(m.nodes, nodeID)
+	delete(m.versions, nodeID)
+	m.version++
+	klog.InfoS("Removing node", "nodeName", nodeID)
+}
+
 func (m *nodeManager) GetNode(nodeID string) (*util.NodeInfo, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
@@ -109,8 +145,10 @@ func (m *nodeManager) GetNode(nodeID string) (*util.NodeInfo, error) {
 	return &util.NodeInfo{}, fmt.Errorf("node %v not found", nodeID)
 }
 
+// ListNodes returns a copy of the registered nodes, whose node infos must not
+// be modified.
 func (m *nodeManager) ListNodes() (map[string]*util.NodeInfo, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
-	return m.nodes, nil
+	return maps.Clone(m.nodes), nil
 }
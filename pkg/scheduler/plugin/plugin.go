@@ -0,0 +1,261 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plugin runs the HAMi scheduler as a kube-scheduler framework plugin,
+// as an alternative to the HTTP extender in pkg/scheduler/routes.
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+
+	"github.com/Project-HAMi/HAMi/pkg/device"
+	"github.com/Project-HAMi/HAMi/pkg/k8sutil"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/policy"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/util/client"
+)
+
+// Name is the name of the plugin in the kube-scheduler configuration.
+const Name = "HAMi"
+
+const stateKey framework.StateKey = Name
+
+// Args are the plugin arguments in the kube-scheduler configuration.
+type Args struct {
+	// DeviceConfigFile is the path to the device config, as --device-config-file of the extender.
+	DeviceConfigFile string `json:"deviceConfigFile"`
+	// NodeSchedulerPolicy is `binpack` or `spread`, binpack if empty.
+	NodeSchedulerPolicy string `json:"nodeSchedulerPolicy,omitempty"`
+	// GPUSchedulerPolicy is `binpack` or `spread`, spread if empty.
+	GPUSchedulerPolicy string `json:"gpuSchedulerPolicy,omitempty"`
+	// PodDevicesEncoding is `legacy` or `json`, as --pod-devices-encoding of the extender, legacy if empty.
+	PodDevicesEncoding string `json:"podDevicesEncoding,omitempty"`
+	// NodeScorerWeights are the weights of the node scorers by name, as --node-scorer-weights of the extender.
+	NodeScorerWeights map[string]float32 `json:"nodeScorerWeights,omitempty"`
+}
+
+// Plugin places pods requesting devices with the same Scheduler the extender
+// uses. Devices are fitted in PreFilter, reserved in Reserve, the node is
+// locked in PreBind and everything is rolled back in Unreserve.
+type Plugin struct {
+	sher *scheduler.Scheduler
+}
+
+var (
+	_ framework.PreFilterPlugin = &Plugin{}
+	_ framework.FilterPlugin    = &Plugin{}
+	_ framework.ScorePlugin     = &Plugin{}
+	_ framework.ReservePlugin   = &Plugin{}
+	_ framework.PreBindPlugin   = &Plugin{}
+)
+
+// fitState is what PreFilter found for a pod, shared by the later phases. It
+// is missing for pods without device requests, which every phase then ignores.
+type fitState struct {
+	scores      map[string]int64
+	devices     map[string]util.PodDevices
+	scorers     map[string]map[string]float32
+	failedNodes map[string]string
+}
+
+// Clone returns the state itself, it is never modified after PreFilter.
+func (s *fitState) Clone() framework.StateData {
+	return s
+}
+
+// New builds the plugin, and starts the Scheduler watching nodes and pods.
+func New(ctx context.Context, obj runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+	args := Args{}
+	if err := frameworkruntime.DecodeInto(obj, &args); err != nil {
+		return nil, err
+	}
+	if args.NodeSchedulerPolicy != "" {
+		config.NodeSchedulerPolicy = args.NodeSchedulerPolicy
+	}
+	if args.GPUSchedulerPolicy != "" {
+		config.GPUSchedulerPolicy = args.GPUSchedulerPolicy
+	}
+	if args.PodDevicesEncoding != "" {
+		if err := util.ValidatePodDevicesEncoding(args.PodDevicesEncoding); err != nil {
+			return nil, err
+		}
+		util.PodDevicesEncoding = args.PodDevicesEncoding
+	}
+	if err := policy.SetScorerWeights(args.NodeScorerWeights); err != nil {
+		return nil, err
+	}
+	deviceConfig, err := device.LoadConfig(args.DeviceConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load device config file %s: %v", args.DeviceConfigFile, err)
+	}
+	if err := device.InitDevicesWithConfig(deviceConfig); err != nil {
+		return nil, err
+	}
+	client.KubeClient = handle.ClientSet()
+
+	sher := scheduler.NewScheduler()
+	sher.Start()
+	go sher.RegisterFromNodeAnnotations()
+	go func() {
+		<-ctx.Done()
+		sher.Stop()
+	}()
+	klog.InfoS("HAMi scheduler plugin started", "deviceConfigFile", args.DeviceConfigFile)
+	return &Plugin{sher: sher}, nil
+}
+
+func (p *Plugin) Name() string {
+	return Name
+}
+
+func getFitState(state *framework.CycleState) (*fitState, error) {
+	data, err := state.Read(stateKey)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := data.(*fitState)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s state %T", Name, data)
+	}
+	return s, nil
+}
+
+func (p *Plugin) PreFilter(ctx context.Context, state *framework.CycleState, pod *corev1.Pod) (*framework.PreFilterResult, *framework.Status) {
+	if !scheduler.RequestsDevices(k8sutil.Resourcereqs(pod)) {
+		return nil, framework.NewStatus(framework.Skip)
+	}
+	if _, ok := pod.Annotations[util.PodGroupAnnotation]; ok {
+		return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable, "pod groups are only supported by the scheduler extender")
+	}
+	fits, failedNodes, err := p.sher.FitPod(ctx, pod)
+	if err != nil {
+		if failedNodes != nil {
+			return nil, framework.NewStatus(framework.Unschedulable, err.Error())
+		}
+		return nil, framework.AsStatus(err)
+	}
+	s := &fitState{
+		devices:     make(map[string]util.PodDevices, len(fits)),
+		scorers:     make(map[string]map[string]float32, len(fits)),
+		failedNodes: failedNodes,
+	}
+	nodeNames := make([]string, 0, len(fits))
+	for nodeID, fit := range fits {
+		nodeNames = append(nodeNames, nodeID)
+		s.devices[nodeID] = fit.Devices
+		s.scorers[nodeID] = fit.Scores
+	}
+	s.scores = frameworkScores(fits, scheduler.NodeSchedulerPolicy(pod.Annotations))
+	state.Write(stateKey, s)
+	return &framework.PreFilterResult{NodeNames: sets.New(nodeNames...)}, nil
+}
+
+// frameworkScores scales the scores of the nodes to the framework's range,
+// where the highest score wins. Under the spread node policy the lowest HAMi
+// score wins, as in the extender, so the scale is reversed.
+func frameworkScores(fits map[string]*policy.NodeScore, nodePolicy string) map[string]int64 {
+	maxScore := float32(0)
+	for _, fit := range fits {
+		maxScore = max(maxScore, fit.Score)
+	}
+	scores := make(map[string]int64, len(fits))
+	for nodeID, fit := range fits {
+		normalized := int64(0)
+		if maxScore > 0 {
+			normalized = int64(fit.Score / maxScore * float32(framework.MaxNodeScore))
+		}
+		if nodePolicy == util.NodeSchedulerPolicySpread.String() {
+			normalized = framework.MaxNodeScore - normalized
+		}
+		scores[nodeID] = normalized
+	}
+	return scores
+}
+
+func (p *Plugin) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+func (p *Plugin) Filter(_ context.Context, state *framework.CycleState, _ *corev1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	s, err := getFitState(state)
+	if err != nil {
+		return framework.AsStatus(err)
+	}
+	nodeName := nodeInfo.Node().Name
+	if _, ok := s.devices[nodeName]; ok {
+		return nil
+	}
+	reason, ok := s.failedNodes[nodeName]
+	if !ok {
+		reason = "node unregistered"
+	}
+	return framework.NewStatus(framework.Unschedulable, reason)
+}
+
+func (p *Plugin) Score(_ context.Context, state *framework.CycleState, _ *corev1.Pod, nodeName string) (int64, *framework.Status) {
+	s, err := getFitState(state)
+	if err != nil {
+		// PreFilter skipped the pod, it does not request devices.
+		return 0, nil
+	}
+	return s.scores[nodeName], nil
+}
+
+func (p *Plugin) ScoreExtensions() framework.ScoreExtensions {
+	return nil
+}
+
+func (p *Plugin) Reserve(_ context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) *framework.Status {
+	s, err := getFitState(state)
+	if err != nil {
+		return nil
+	}
+	devices, ok := s.devices[nodeName]
+	if !ok {
+		return framework.NewStatus(framework.Error, fmt.Sprintf("pod does not fit on node %s", nodeName))
+	}
+	if err := p.sher.ReservePod(pod, nodeName, devices, s.scorers[nodeName]); err != nil {
+		return framework.AsStatus(err)
+	}
+	return nil
+}
+
+func (p *Plugin) Unreserve(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) {
+	if _, err := getFitState(state); err != nil {
+		return
+	}
+	p.sher.UnreservePod(ctx, pod, nodeName)
+}
+
+func (p *Plugin) PreBind(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) *framework.Status {
+	if _, err := getFitState(state); err != nil {
+		return nil
+	}
+	if err := p.sher.PreBindPod(ctx, pod, nodeName); err != nil {
+		return framework.AsStatus(err)
+	}
+	return nil
+}
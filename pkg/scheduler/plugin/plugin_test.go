@@ -0,0 +1,89 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/policy"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+func testNodeInfo(name string) *framework.NodeInfo {
+	nodeInfo := framework.NewNodeInfo()
+	nodeInfo.SetNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	return nodeInfo
+}
+
+func Test_PreFilter_skip(t *testing.T) {
+	p := &Plugin{}
+	state := framework.NewCycleState()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod1"}, Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "ctr"}}}}
+
+	_, status := p.PreFilter(context.Background(), state, pod)
+	assert.True(t, status.IsSkip())
+	score, status := p.Score(context.Background(), state, pod, "node1")
+	assert.True(t, status.IsSuccess())
+	assert.Zero(t, score)
+	assert.True(t, p.Reserve(context.Background(), state, pod, "node1").IsSuccess())
+	assert.True(t, p.PreBind(context.Background(), state, pod, "node1").IsSuccess())
+}
+
+func Test_Filter(t *testing.T) {
+	p := &Plugin{}
+	state := framework.NewCycleState()
+	state.Write(stateKey, &fitState{
+		scores:      map[string]int64{"node1": 100},
+		devices:     map[string]util.PodDevices{"node1": {}},
+		failedNodes: map[string]string{"node2": "NodeUnfitPod"},
+	})
+
+	assert.True(t, p.Filter(context.Background(), state, nil, testNodeInfo("node1")).IsSuccess())
+	status := p.Filter(context.Background(), state, nil, testNodeInfo("node2"))
+	assert.Equal(t, framework.Unschedulable, status.Code())
+	assert.Equal(t, "NodeUnfitPod", status.Message())
+	status = p.Filter(context.Background(), state, nil, testNodeInfo("node3"))
+	assert.Equal(t, "node unregistered", status.Message())
+
+	score, status := p.Score(context.Background(), state, nil, "node1")
+	assert.True(t, status.IsSuccess())
+	assert.Equal(t, int64(100), score)
+	assert.Equal(t, framework.Error, p.Reserve(context.Background(), state, nil, "node2").Code())
+}
+
+func Test_frameworkScores(t *testing.T) {
+	fits := map[string]*policy.NodeScore{
+		"node1": {NodeID: "node1", Score: 10},
+		"node2": {NodeID: "node2", Score: 50},
+		"node3": {NodeID: "node3", Score: 100},
+	}
+
+	binpack := frameworkScores(fits, util.NodeSchedulerPolicyBinpack.String())
+	assert.Equal(t, framework.MaxNodeScore, binpack["node3"], "the highest HAMi score wins under binpack")
+	assert.Less(t, binpack["node1"], binpack["node2"])
+
+	spread := frameworkScores(fits, util.NodeSchedulerPolicySpread.String())
+	assert.Equal(t, framework.MaxNodeScore-10, spread["node1"], "the lowest HAMi score wins under spread")
+	assert.Greater(t, spread["node1"], spread["node2"])
+	assert.Zero(t, spread["node3"])
+}
@@ -17,8 +17,10 @@ limitations under the License.
 package scheduler
 
 import (
+	"maps"
 	"sync"
 
+	"github.com/Project-HAMi/HAMi/pkg/accounting"
 	"github.com/Project-HAMi/HAMi/pkg/util"
 
 	corev1 "k8s.io/api/core/v1"
@@ -34,6 +36,12 @@ type podInfo struct {
 	NodeID    string
 	Devices   util.PodDevices
 	CtrIDs    []string
+	// Priority is the pod's resolved PriorityClass value, used to pick preemption victims.
+	Priority int32
+	// TolerateOversubscription is set if the pod may use memory beyond the physical memory of its devices.
+	TolerateOversubscription bool
+	// Scores is the score of each scorer on the node the pod was assigned to by this scheduler.
+	Scores map[string]float32
 }
 
 // PodUseDeviceStat counts pod use device info.
@@ -43,13 +51,23 @@ type PodUseDeviceStat struct {
 }
 
 type podManager struct {
-	pods  map[k8stypes.UID]*podInfo
-	mutex sync.RWMutex
+	pods map[k8stypes.UID]*podInfo
+	// nodePods indexes the pods by the node they are assigned to.
+	nodePods map[string]map[k8stypes.UID]*podInfo
+	// nodeVersions is the version of the pods of each node, the value of
+	// version when one of them was last added, updated or deleted.
+	nodeVersions map[string]uint64
+	version      uint64
+	quota        *quotaManager
+	// ledger is nil unless accounting is enabled.
+	ledger *accounting.Ledger
+	mutex  sync.RWMutex
 }
 
 func newPodManager() *podManager {
 	pm := &podManager{
-		pods: make(map[k8stypes.UID]*podInfo),
+		pods:  make(map[k8stypes.UID]*podInfo),
+		quota: newQuotaManager(),
 	}
 	klog.InfoS("Pod manager initialized", "podCount", len(pm.pods))
 	return pm
@@ -62,25 +80,76 @@ func (m *podManager) addPod(pod *corev1.Pod, nodeID string, devices util.PodDevi
 	_, exists := m.pods[pod.UID]
 	if !exists {
 		pi := &podInfo{
-			Name:      pod.Name,
-			UID:       pod.UID,
-			Namespace: pod.Namespace,
-			NodeID:    nodeID,
-			Devices:   devices,
+			Name:                     pod.Name,
+			UID:                      pod.UID,
+			Namespace:                pod.Namespace,
+			NodeID:                   nodeID,
+			Devices:                  devices,
+			Priority:                 podPriority(pod),
+			TolerateOversubscription: util.ToleratesMemoryOversubscription(pod),
 		}
 		m.pods[pod.UID] = pi
+		m.index(pi)
+		m.quota.addUsage(pod.Namespace, devices)
 		klog.InfoS("Pod added",
 			"pod", klog.KRef(pod.Namespace, pod.Name),
 			"nodeID", nodeID,
 			"devices", devices,
 		)
 	} else {
-		m.pods[pod.UID].Devices = devices
+		// The pod info is replaced, not updated, as it is read without the lock.
+		pi := *m.pods[pod.UID]
+		m.quota.rmUsage(pod.Namespace, pi.Devices)
+		pi.Devices = devices
+		pi.Priority = podPriority(pod)
+		pi.TolerateOversubscription = util.ToleratesMemoryOversubscription(pod)
+		m.pods[pod.UID] = &pi
+		m.index(&pi)
+		m.quota.addUsage(pod.Namespace, devices)
 		klog.InfoS("Pod devices updated",
 			"pod", klog.KRef(pod.Namespace, pod.Name),
 			"devices", devices,
 		)
 	}
+	if m.ledger != nil {
+		m.ledger.Start(string(pod.UID), pod.Namespace, pod.Name, nodeID, devices)
+	}
+}
+
+// index adds a pod to the pods of its node. The caller holds the lock.
+func (m *podManager) index(pi *podInfo) {
+	if m.nodePods == nil {
+		m.nodePods = make(map[string]map[k8stypes.UID]*podInfo)
+	}
+	if m.nodePods[pi.NodeID] == nil {
+		m.nodePods[pi.NodeID] = make(map[k8stypes.UID]*podInfo)
+	}
+	m.nodePods[pi.NodeID][pi.UID] = pi
+	m.touch(pi.NodeID)
+}
+
+// touch records a change of the pods of a node. The caller holds the lock.
+func (m *podManager) touch(nodeID string) {
+	if m.nodeVersions == nil {
+		m.nodeVersions = make(map[string]uint64)
+	}
+	m.version++
+	m.nodeVersions[nodeID] = m.version
+}
+
+// setScores keeps the scores of the node a pod was assigned to.
+func (m *podManager) setScores(uid k8stypes.UID, scores map[string]float32) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if pi, ok := m.pods[uid]; ok {
+		updated := *pi
+		updated.Scores = scores
+		m.pods[uid] = &updated
+		if pods, ok := m.nodePods[pi.NodeID]; ok {
+			pods[uid] = &updated
+		}
+	}
 }
 
 func (m *podManager) delPod(pod *corev1.Pod) {
@@ -93,7 +162,16 @@ func (m *podManager) delPod(pod *corev1.Pod) {
 			"pod", klog.KRef(pod.Namespace, pod.Name),
 			"nodeID", pi.NodeID,
 		)
+		m.quota.rmUsage(pi.Namespace, pi.Devices)
 		delete(m.pods, pod.UID)
+		delete(m.nodePods[pi.NodeID], pod.UID)
+		if len(m.nodePods[pi.NodeID]) == 0 {
+			delete(m.nodePods, pi.NodeID)
+		}
+		m.touch(pi.NodeID)
+		if m.ledger != nil {
+			m.ledger.Stop(string(pod.UID))
+		}
 	} else {
 		klog.InfoS("Pod not found for deletion",
 			"pod", klog.KRef(pod.Namespace, pod.Name),
@@ -146,5 +224,5 @@ func (m *podManager) GetScheduledPods() (map[k8stypes.UID]*podInfo, error) {
 	klog.InfoS("Retrieved scheduled pods",
 		"podCount", podCount,
 	)
-	return m.pods, nil
+	return maps.Clone(m.pods), nil
 }
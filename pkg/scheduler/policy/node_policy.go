@@ -30,6 +30,8 @@ type NodeScore struct {
 	Devices util.PodDevices
 	// Score recode every node all device user/allocate score
 	Score float32
+	// Scores is the score of each scorer with a weight, before the weight.
+	Scores map[string]float32
 }
 
 type NodeScoreList struct {
@@ -73,23 +75,32 @@ func (ns *NodeScore) SnapshotDevice(devices DeviceUsageList) []*util.DeviceUsage
 }
 
 func (ns *NodeScore) ComputeDefaultScore(devices DeviceUsageList) {
-	used, usedCore, usedMem := int32(0), int32(0), int32(0)
+	usage := make([]*util.DeviceUsage, 0, len(devices.DeviceLists))
 	for _, device := range devices.DeviceLists {
-		used += device.Device.Used
-		usedCore += device.Device.Usedcores
-		usedMem += device.Device.Usedmem
+		usage = append(usage, device.Device)
 	}
-	klog.V(2).Infof("node %s used %d, usedCore %d, usedMem %d,", ns.NodeID, used, usedCore, usedMem)
+	ns.Score = float32(Weight) * usageScore(ns.NodeID, usage)
+	klog.V(2).Infof("node %s computer default score is %f", ns.NodeID, ns.Score)
+}
+
+// usageScore is the sum of the ratios of the devices, cores and memory used on a node.
+func usageScore(nodeID string, devices []*util.DeviceUsage) float32 {
+	used, usedCore, usedMem := int32(0), int32(0), int32(0)
+	for _, device := range devices {
+		used += device.Used
+		usedCore += device.Usedcores
+		usedMem += device.Usedmem
+	}
+	klog.V(2).Infof("node %s used %d, usedCore %d, usedMem %d,", nodeID, used, usedCore, usedMem)
 
 	total, totalCore, totalMem := int32(0), int32(0), int32(0)
-	for _, deviceLists := range devices.DeviceLists {
-		total += deviceLists.Device.Count
-		totalCore += deviceLists.Device.Totalcore
-		totalMem += deviceLists.Device.Totalmem
+	for _, device := range devices {
+		total += device.Count
+		totalCore += device.Totalcore
+		totalMem += device.Totalmem
 	}
 	useScore := float32(used) / float32(total)
 	coreScore := float32(usedCore) / float32(totalCore)
 	memScore := float32(usedMem) / float32(totalMem)
-	ns.Score = float32(Weight) * (useScore + coreScore + memScore)
-	klog.V(2).Infof("node %s computer default score is %f", ns.NodeID, ns.Score)
+	return useScore + coreScore + memScore
 }
@@ -0,0 +1,135 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// ScoreArgs is what a Scorer scores the placement of a pod on a node from.
+type ScoreArgs struct {
+	// Node holds the devices allocated to the pod on the node.
+	Node     *NodeScore
+	NodeInfo *util.NodeInfo
+	// Previous is the usage of the devices of the node before the pod.
+	Previous []*util.DeviceUsage
+	// Pods is the number of pods holding devices on the node.
+	Pods int
+	// Policy is the node scheduler policy of the pod.
+	Policy string
+}
+
+// Scorer scores the placement of a pod on a node. The score of a node is the
+// sum of the scores of the scorers times their weights. Like the score, the
+// node with the highest score is picked under the binpack policy, and the one
+// with the lowest under spread: a scorer preferring nodes whatever the policy
+// returns its preference through Preference.
+type Scorer interface {
+	// Name is the name the scorer is weighted by in the scheduler config.
+	Name() string
+	Score(args ScoreArgs) float32
+}
+
+var (
+	scorers = map[string]Scorer{}
+	// defaultWeights are the weights of the scorers the config does not set.
+	defaultWeights = map[string]float32{}
+	scorerWeights  = map[string]float32{}
+)
+
+// RegisterScorer adds a scorer, with the weight it has unless set by the
+// scheduler config.
+func RegisterScorer(s Scorer, weight float32) {
+	scorers[s.Name()] = s
+	defaultWeights[s.Name()] = weight
+	scorerWeights[s.Name()] = weight
+}
+
+// ParseScorerWeights parses the weights of scorers by name given as strings.
+func ParseScorerWeights(values map[string]string) (map[string]float32, error) {
+	weights := make(map[string]float32, len(values))
+	for name, value := range values {
+		weight, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight %q of scorer %s", value, name)
+		}
+		weights[name] = float32(weight)
+	}
+	return weights, nil
+}
+
+// SetScorerWeights sets the weights of scorers by name, the others keep their
+// default weight. A weight of 0 disables a scorer.
+func SetScorerWeights(weights map[string]float32) error {
+	res := make(map[string]float32, len(scorers))
+	for name, weight := range defaultWeights {
+		res[name] = weight
+	}
+	for name, weight := range weights {
+		if _, ok := scorers[name]; !ok {
+			return fmt.Errorf("unknown scorer %q", name)
+		}
+		if weight < 0 {
+			return fmt.Errorf("invalid weight %v of scorer %s", weight, name)
+		}
+		res[name] = weight
+	}
+	scorerWeights = res
+	return nil
+}
+
+// ScorerWeights returns the weight of every registered scorer.
+func ScorerWeights() map[string]float32 {
+	res := make(map[string]float32, len(scorerWeights))
+	for name, weight := range scorerWeights {
+		res[name] = weight
+	}
+	return res
+}
+
+// Preference orients a preference from 0 to 1, higher is better, to the
+// policy: it is kept under binpack, and inverted under spread.
+func Preference(policy string, v float32) float32 {
+	if policy == util.NodeSchedulerPolicySpread.String() {
+		return 1 - v
+	}
+	return v
+}
+
+// ComputeScore sets the score of the node to the weighted sum of the scores of
+// the scorers, keeping the score of each scorer with a weight in Scores.
+func (ns *NodeScore) ComputeScore(args ScoreArgs) {
+	args.Node = ns
+	names := make([]string, 0, len(scorerWeights))
+	for name, weight := range scorerWeights {
+		if weight != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	ns.Score = 0
+	ns.Scores = make(map[string]float32, len(names))
+	for _, name := range names {
+		score := scorers[name].Score(args)
+		ns.Scores[name] = score
+		ns.Score += scorerWeights[name] * score
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+func scorerTestDevices() []*util.DeviceUsage {
+	return []*util.DeviceUsage{
+		{ID: "gpu0", Count: 10, Used: 1, Totalmem: 1000, Usedmem: 500, Totalcore: 100, Numa: 0},
+		{ID: "gpu1", Count: 10, Totalmem: 1000, Totalcore: 100, Numa: 0},
+		{ID: "gpu2", Count: 10, Totalmem: 1000, Totalcore: 100, Numa: 1},
+	}
+}
+
+func scorerTestNode(ctrs ...util.ContainerDevices) *NodeScore {
+	return &NodeScore{NodeID: "node1", Devices: util.PodDevices{"NVIDIA": util.PodSingleDevice(ctrs)}}
+}
+
+func TestScorers(t *testing.T) {
+	nodeInfo := &util.NodeInfo{
+		Node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			util.NodeDeviceTemperatureAnnotation: `{"gpu0":40,"gpu1":80}`,
+		}}},
+		Devices: []util.DeviceInfo{
+			{ID: "gpu0", DevicePairScore: util.DevicePairScore{Scores: map[string]int{"gpu1": 200, "gpu2": 10}}},
+			{ID: "gpu1", DevicePairScore: util.DevicePairScore{Scores: map[string]int{"gpu0": 200, "gpu2": 10}}},
+			{ID: "gpu2", DevicePairScore: util.DevicePairScore{Scores: map[string]int{"gpu0": 10, "gpu1": 10}}},
+		},
+	}
+	tests := []struct {
+		name   string
+		scorer Scorer
+		node   *NodeScore
+		pods   int
+		policy string
+		want   float32
+	}{
+		{
+			name:   "usage",
+			scorer: usageScorer{},
+			node:   scorerTestNode(),
+			want:   float32(1)/30 + float32(500)/3000,
+		},
+		{
+			name:   "fragmentation of a tight fit",
+			scorer: fragmentationScorer{},
+			node:   scorerTestNode(util.ContainerDevices{{UUID: "gpu0", Usedmem: 500}}),
+			want:   1,
+		},
+		{
+			name:   "fragmentation of a loose fit",
+			scorer: fragmentationScorer{},
+			node:   scorerTestNode(util.ContainerDevices{{UUID: "gpu1", Usedmem: 250}}),
+			want:   0.25,
+		},
+		{
+			name:   "fragmentation under spread",
+			scorer: fragmentationScorer{},
+			node:   scorerTestNode(util.ContainerDevices{{UUID: "gpu1", Usedmem: 250}}),
+			policy: util.NodeSchedulerPolicySpread.String(),
+			want:   0.75,
+		},
+		{
+			name:   "numa aligned",
+			scorer: numaScorer{},
+			node:   scorerTestNode(util.ContainerDevices{{UUID: "gpu0"}, {UUID: "gpu1"}}),
+			want:   1,
+		},
+		{
+			name:   "numa split",
+			scorer: numaScorer{},
+			node:   scorerTestNode(util.ContainerDevices{{UUID: "gpu0"}, {UUID: "gpu1"}}, util.ContainerDevices{{UUID: "gpu1"}, {UUID: "gpu2"}}),
+			want:   0.5,
+		},
+		{
+			name:   "topology best pair",
+			scorer: topologyScorer{},
+			node:   scorerTestNode(util.ContainerDevices{{UUID: "gpu0"}, {UUID: "gpu1"}}),
+			want:   1,
+		},
+		{
+			name:   "topology worst pair",
+			scorer: topologyScorer{},
+			node:   scorerTestNode(util.ContainerDevices{{UUID: "gpu1"}, {UUID: "gpu2"}}),
+			want:   0.05,
+		},
+		{
+			name:   "pod count",
+			scorer: podCountScorer{},
+			node:   scorerTestNode(),
+			pods:   3,
+			want:   0.25,
+		},
+		{
+			name:   "temperature",
+			scorer: temperatureScorer{},
+			node:   scorerTestNode(util.ContainerDevices{{UUID: "gpu0"}, {UUID: "gpu1"}}),
+			want:   1 - float32(120)/2/MaxDeviceTemperature,
+		},
+		{
+			name:   "temperature under spread",
+			scorer: temperatureScorer{},
+			node:   scorerTestNode(util.ContainerDevices{{UUID: "gpu0"}}),
+			policy: util.NodeSchedulerPolicySpread.String(),
+			want:   1 - (1 - float32(40)/MaxDeviceTemperature),
+		},
+		{
+			name:   "temperature not reported",
+			scorer: temperatureScorer{},
+			node:   scorerTestNode(util.ContainerDevices{{UUID: "gpu2"}}),
+			want:   0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := test.policy
+			if policy == "" {
+				policy = util.NodeSchedulerPolicyBinpack.String()
+			}
+			got := test.scorer.Score(ScoreArgs{Node: test.node, NodeInfo: nodeInfo, Previous: scorerTestDevices(), Pods: test.pods, Policy: policy})
+			assert.Equal(t, got, test.want)
+		})
+	}
+}
+
+func TestSetScorerWeights(t *testing.T) {
+	t.Cleanup(func() { assert.NilError(t, SetScorerWeights(nil)) })
+
+	assert.ErrorContains(t, SetScorerWeights(map[string]float32{"unknown": 1}), "unknown scorer")
+	assert.ErrorContains(t, SetScorerWeights(map[string]float32{FragmentationScorer: -1}), "invalid weight")
+	_, err := ParseScorerWeights(map[string]string{FragmentationScorer: "x"})
+	assert.ErrorContains(t, err, "invalid weight")
+
+	weights, err := ParseScorerWeights(map[string]string{FragmentationScorer: "5"})
+	assert.NilError(t, err)
+	assert.NilError(t, SetScorerWeights(weights))
+	assert.DeepEqual(t, ScorerWeights(), map[string]float32{
+		UsageScorer:         10,
+		FragmentationScorer: 5,
+		NumaScorer:          0,
+		TopologyScorer:      0,
+		PodCountScorer:      0,
+		TemperatureScorer:   0,
+	})
+
+	ns := scorerTestNode(util.ContainerDevices{{UUID: "gpu1", Usedmem: 250}})
+	ns.ComputeScore(ScoreArgs{Previous: scorerTestDevices(), Policy: util.NodeSchedulerPolicyBinpack.String()})
+	usage := float32(1)/30 + float32(500)/3000
+	assert.DeepEqual(t, ns.Scores, map[string]float32{UsageScorer: usage, FragmentationScorer: 0.25})
+	assert.Equal(t, ns.Score, 10*usage+5*0.25)
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+const (
+	UsageScorer         = "usage"
+	FragmentationScorer = "fragmentation"
+	NumaScorer          = "numa"
+	TopologyScorer      = "topology"
+	PodCountScorer      = "podcount"
+	TemperatureScorer   = "temperature"
+)
+
+// MaxDeviceTemperature is the temperature in degrees Celsius at and above
+// which a device is not preferred at all by the temperature scorer.
+const MaxDeviceTemperature = 100
+
+func init() {
+	RegisterScorer(usageScorer{}, float32(Weight))
+	RegisterScorer(fragmentationScorer{}, 0)
+	RegisterScorer(numaScorer{}, 0)
+	RegisterScorer(topologyScorer{}, 0)
+	RegisterScorer(podCountScorer{}, 0)
+	RegisterScorer(temperatureScorer{}, 0)
+}
+
+// deviceID returns the ID of the device a container device is allocated on,
+// without the MIG instance.
+func deviceID(uuid string) string {
+	id, _, _ := strings.Cut(uuid, "[")
+	return id
+}
+
+// containerDevices returns the devices allocated to each container of the pod.
+func containerDevices(ns *NodeScore) []util.ContainerDevices {
+	res := make([]util.ContainerDevices, 0)
+	for _, single := range ns.Devices {
+		for _, ctr := range single {
+			if len(ctr) > 0 {
+				res = append(res, ctr)
+			}
+		}
+	}
+	return res
+}
+
+// usageScorer is the ratio of the devices, cores and memory used on the node
+// before the pod, summed.
+type usageScorer struct{}
+
+func (usageScorer) Name() string { return UsageScorer }
+
+func (usageScorer) Score(args ScoreArgs) float32 {
+	return usageScore(args.Node.NodeID, args.Previous)
+}
+
+// fragmentationScorer prefers the devices whose memory left free after the
+// pod is the smallest, leaving larger free devices for larger pods.
+type fragmentationScorer struct{}
+
+func (fragmentationScorer) Name() string { return FragmentationScorer }
+
+func (fragmentationScorer) Score(args ScoreArgs) float32 {
+	allocated := map[string]int32{}
+	for _, ctr := range containerDevices(args.Node) {
+		for _, dev := range ctr {
+			allocated[deviceID(dev.UUID)] += dev.Usedmem
+		}
+	}
+	free, count := float32(0), 0
+	for _, dev := range args.Previous {
+		mem, ok := allocated[dev.ID]
+		if !ok || dev.Totalmem <= 0 {
+			continue
+		}
+		free += float32(max(dev.Totalmem-dev.Usedmem-mem, 0)) / float32(dev.Totalmem)
+		count++
+	}
+	if count == 0 {
+		return Preference(args.Policy, 0)
+	}
+	return Preference(args.Policy, 1-free/float32(count))
+}
+
+// numaScorer prefers the nodes where the devices of each container are on a
+// single NUMA node.
+type numaScorer struct{}
+
+func (numaScorer) Name() string { return NumaScorer }
+
+func (numaScorer) Score(args ScoreArgs) float32 {
+	numa := make(map[string]int, len(args.Previous))
+	for _, dev := range args.Previous {
+		numa[dev.ID] = dev.Numa
+	}
+	aligned, total := 0, 0
+	for _, ctr := range containerDevices(args.Node) {
+		if len(ctr) < 2 {
+			continue
+		}
+		total++
+		nodes := map[int]bool{}
+		for _, dev := range ctr {
+			nodes[numa[deviceID(dev.UUID)]] = true
+		}
+		if len(nodes) == 1 {
+			aligned++
+		}
+	}
+	if total == 0 {
+		return Preference(args.Policy, 1)
+	}
+	return Preference(args.Policy, float32(aligned)/float32(total))
+}
+
+// topologyScorer prefers the nodes where the devices of each container have
+// the highest pair scores, relative to the highest pair score of the node.
+type topologyScorer struct{}
+
+func (topologyScorer) Name() string { return TopologyScorer }
+
+func (topologyScorer) Score(args ScoreArgs) float32 {
+	if args.NodeInfo == nil {
+		return Preference(args.Policy, 0)
+	}
+	pairs := map[string]map[string]int{}
+	best := 0
+	for _, dev := range args.NodeInfo.Devices {
+		pairs[dev.ID] = dev.DevicePairScore.Scores
+		for _, score := range dev.DevicePairScore.Scores {
+			best = max(best, score)
+		}
+	}
+	sum, count := 0, 0
+	for _, ctr := range containerDevices(args.Node) {
+		for i := range ctr {
+			for j := i + 1; j < len(ctr); j++ {
+				sum += pairs[deviceID(ctr[i].UUID)][deviceID(ctr[j].UUID)]
+				count++
+			}
+		}
+	}
+	if count == 0 || best == 0 {
+		return Preference(args.Policy, 0)
+	}
+	return Preference(args.Policy, float32(sum)/float32(count)/float32(best))
+}
+
+// podCountScorer prefers the nodes with the fewest pods holding devices.
+type podCountScorer struct{}
+
+func (podCountScorer) Name() string { return PodCountScorer }
+
+func (podCountScorer) Score(args ScoreArgs) float32 {
+	return Preference(args.Policy, 1/float32(1+args.Pods))
+}
+
+// temperatureScorer prefers the nodes where the devices allocated to the pod
+// are the coolest, as last reported by the device plugin in the
+// NodeDeviceTemperatureAnnotation of the node.
+type temperatureScorer struct{}
+
+func (temperatureScorer) Name() string { return TemperatureScorer }
+
+func (temperatureScorer) Score(args ScoreArgs) float32 {
+	if args.NodeInfo == nil || args.NodeInfo.Node == nil {
+		return Preference(args.Policy, 0)
+	}
+	value, ok := args.NodeInfo.Node.Annotations[util.NodeDeviceTemperatureAnnotation]
+	if !ok {
+		return Preference(args.Policy, 0)
+	}
+	temperatures := map[string]int{}
+	if err := json.Unmarshal([]byte(value), &temperatures); err != nil {
+		return Preference(args.Policy, 0)
+	}
+	sum, count := 0, 0
+	for _, ctr := range containerDevices(args.Node) {
+		for _, dev := range ctr {
+			if t, ok := temperatures[deviceID(dev.UUID)]; ok {
+				sum += min(max(t, 0), MaxDeviceTemperature)
+				count++
+			}
+		}
+	}
+	if count == 0 {
+		return Preference(args.Policy, 0)
+	}
+	return Preference(args.Policy, 1-float32(sum)/float32(count)/MaxDeviceTemperature)
+}
@@ -0,0 +1,328 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/util/client"
+)
+
+const (
+	preemptionInProgress = "PreemptionInProgress"
+
+	// maxExactPreemptionCandidates bounds the exhaustive search for the smallest
+	// victim set on a node. Nodes with more candidates are reduced greedily.
+	maxExactPreemptionCandidates = 10
+)
+
+// preemptionCandidate is a node where evicting victims lets the preemptor fit.
+type preemptionCandidate struct {
+	nodeID  string
+	victims []*podInfo
+}
+
+// maxPriority returns the highest priority among the victims.
+func (c *preemptionCandidate) maxPriority() int32 {
+	res := int32(0)
+	for idx, victim := range c.victims {
+		if idx == 0 || victim.Priority > res {
+			res = victim.Priority
+		}
+	}
+	return res
+}
+
+func (c *preemptionCandidate) String() string {
+	victims := make([]string, 0, len(c.victims))
+	for _, victim := range c.victims {
+		victims = append(victims, fmt.Sprintf("%s/%s(priority %d)", victim.Namespace, victim.Name, victim.Priority))
+	}
+	return fmt.Sprintf("node %s, victims %s", c.nodeID, strings.Join(victims, ","))
+}
+
+// preemptionManager remembers the victims evicted for each preemptor, so that a
+// retried preemptor waits for them to go away instead of evicting more pods.
+type preemptionManager struct {
+	nominated map[k8stypes.UID]*preemptionCandidate
+	mutex     sync.Mutex
+}
+
+func newPreemptionManager() *preemptionManager {
+	return &preemptionManager{
+		nominated: make(map[k8stypes.UID]*preemptionCandidate),
+	}
+}
+
+// podPriority returns the priority of a pod. The Priority admission plugin
+// resolves spec.priorityClassName into spec.priority, pods without one get 0.
+func podPriority(pod *corev1.Pod) int32 {
+	if pod == nil || pod.Spec.Priority == nil {
+		return 0
+	}
+	return *pod.Spec.Priority
+}
+
+// pendingPreemption returns the victims still alive from an earlier preemption by pod.
+func (s *Scheduler) pendingPreemption(pod *corev1.Pod) *preemptionCandidate {
+	s.preemption.mutex.Lock()
+	defer s.preemption.mutex.Unlock()
+	c, ok := s.preemption.nominated[pod.UID]
+	if !ok {
+		return nil
+	}
+	alive := make(map[k8stypes.UID]bool)
+	for _, p := range s.ListPodsInfo() {
+		alive[p.UID] = true
+	}
+	for _, victim := range c.victims {
+		if alive[victim.UID] {
+			return c
+		}
+	}
+	delete(s.preemption.nominated, pod.UID)
+	return nil
+}
+
+// preempt looks for the node where evicting the fewest lower-priority pods lets
+// pod fit, and evicts them unless preemption runs in dry-run mode. The pod
+// itself stays unschedulable until the victims release their devices. It
+// returns a message describing the decision, or "" if nothing was preempted.
+func (s *Scheduler) preempt(pod *corev1.Pod, resourceReqs util.PodDeviceRequests, failedNodes map[string]string) string {
+	if !config.EnablePreemption {
+		return ""
+	}
+	if pod.Spec.PreemptionPolicy != nil && *pod.Spec.PreemptionPolicy == corev1.PreemptNever {
+		klog.V(4).InfoS("Pod is not allowed to preempt", "pod", klog.KObj(pod))
+		return ""
+	}
+	if c := s.pendingPreemption(pod); c != nil {
+		msg := fmt.Sprintf("%s waiting for %s", preemptionInProgress, c)
+		failedNodes[c.nodeID] = msg
+		return msg
+	}
+
+	priority := podPriority(pod)
+	podsByNode := make(map[string][]*podInfo)
+	for _, p := range s.ListPodsInfo() {
+		if p.UID == pod.UID {
+			continue
+		}
+		podsByNode[p.NodeID] = append(podsByNode[p.NodeID], p)
+	}
+	var best *preemptionCandidate
+	for nodeID, reason := range failedNodes {
+		// Only nodes without enough free devices can be helped by evicting pods.
+		if reason != nodeUnfitPod {
+			continue
+		}
+		nodeInfo, err := s.GetNode(nodeID)
+		if err != nil {
+			continue
+		}
+		victims := s.selectVictims(nodeInfo, pod, priority, resourceReqs, podsByNode[nodeID])
+		if victims == nil {
+			continue
+		}
+		c := &preemptionCandidate{nodeID: nodeID, victims: victims}
+		if best == nil || lessPreemptionCandidate(c, best) {
+			best = c
+		}
+	}
+	if best == nil {
+		klog.V(4).InfoS("No preemption candidate found", "pod", klog.KObj(pod), "priority", priority)
+		return ""
+	}
+
+	if config.PreemptionDryRun {
+		msg := fmt.Sprintf("%s would preempt on %s", EventReasonPreemptionDryRun, best)
+		klog.InfoS("Preemption dry-run", "pod", klog.KObj(pod), "priority", priority, "node", best.nodeID, "victims", best.String())
+		s.eventRecorder.Event(pod, corev1.EventTypeNormal, EventReasonPreemptionDryRun, msg)
+		failedNodes[best.nodeID] = msg
+		return msg
+	}
+
+	evicted := make([]*podInfo, 0, len(best.victims))
+	for _, victim := range best.victims {
+		if err := evictPod(victim); err != nil && !apierrors.IsNotFound(err) {
+			klog.ErrorS(err, "Failed to evict preemption victim", "pod", klog.KObj(pod), "victim", klog.KRef(victim.Namespace, victim.Name))
+			s.eventRecorder.Event(pod, corev1.EventTypeWarning, EventReasonPreempting, fmt.Sprintf("failed to evict %s/%s: %v", victim.Namespace, victim.Name, err))
+			break
+		}
+		evicted = append(evicted, victim)
+		s.eventRecorder.Event(victimRef(victim), corev1.EventTypeNormal, EventReasonPreempted,
+			fmt.Sprintf("Preempted by %s/%s with priority %d on node %s", pod.Namespace, pod.Name, priority, best.nodeID))
+	}
+	if len(evicted) == 0 {
+		return ""
+	}
+	s.preemption.mutex.Lock()
+	s.preemption.nominated[pod.UID] = &preemptionCandidate{nodeID: best.nodeID, victims: evicted}
+	s.preemption.mutex.Unlock()
+
+	msg := fmt.Sprintf("%s %d lower priority pod(s) on %s", EventReasonPreempting, len(evicted), best)
+	klog.InfoS("Preempting pods", "pod", klog.KObj(pod), "priority", priority, "node", best.nodeID, "victims", best.String())
+	s.eventRecorder.Event(pod, corev1.EventTypeNormal, EventReasonPreempting, msg)
+	failedNodes[best.nodeID] = msg
+	return msg
+}
+
+// lessPreemptionCandidate prefers the node whose most important victim has the
+// lowest priority, then the one with fewer victims.
+func lessPreemptionCandidate(a, b *preemptionCandidate) bool {
+	if a.maxPriority() != b.maxPriority() {
+		return a.maxPriority() < b.maxPriority()
+	}
+	if len(a.victims) != len(b.victims) {
+		return len(a.victims) < len(b.victims)
+	}
+	return a.nodeID < b.nodeID
+}
+
+// selectVictims returns the smallest set of pods with lower priority than the
+// preemptor whose devices, once freed, let it fit on the node. It returns nil
+// if no such set exists.
+func (s *Scheduler) selectVictims(nodeInfo *util.NodeInfo, pod *corev1.Pod, priority int32, resourceReqs util.PodDeviceRequests, onNode []*podInfo) []*podInfo {
+	candidates := make([]*podInfo, 0, len(onNode))
+	for _, p := range onNode {
+		if p.Priority < priority {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Priority < candidates[j].Priority
+	})
+
+	fits := func(victims []*podInfo) bool {
+		skip := make(map[k8stypes.UID]bool, len(victims))
+		released := make(util.PodDevices)
+		for _, victim := range victims {
+			skip[victim.UID] = true
+			if victim.Namespace == pod.Namespace {
+				for vendor, podSingle := range victim.Devices {
+					released[vendor] = append(released[vendor], podSingle...)
+				}
+			}
+		}
+		usage := newNodeUsage(nodeInfo, pod)
+		for _, p := range onNode {
+			if !skip[p.UID] {
+				addNodeDevicesUsage(usage, p.Devices, p.TolerateOversubscription)
+			}
+		}
+		devices := make(util.PodDevices)
+		fit, _ := fitInNode(usage, resourceReqs, pod.Annotations, pod, nodeInfo, &devices)
+		if !fit {
+			return false
+		}
+		fit, _ = s.quota.fitQuotaReleasing(pod.Namespace, devices, released)
+		return fit
+	}
+
+	if !fits(candidates) {
+		return nil
+	}
+	if len(candidates) <= maxExactPreemptionCandidates {
+		for k := 1; k <= len(candidates); k++ {
+			var res []*podInfo
+			forEachCombination(len(candidates), k, func(idx []int) bool {
+				victims := make([]*podInfo, 0, k)
+				for _, i := range idx {
+					victims = append(victims, candidates[i])
+				}
+				if fits(victims) {
+					res = victims
+					return false
+				}
+				return true
+			})
+			if res != nil {
+				return res
+			}
+		}
+		return candidates
+	}
+
+	// Too many candidates to search exhaustively: evict the lowest priorities
+	// first until the pod fits, then give back every victim that is not needed.
+	victims := make([]*podInfo, 0)
+	for _, p := range candidates {
+		victims = append(victims, p)
+		if fits(victims) {
+			break
+		}
+	}
+	for i := len(victims) - 1; i >= 0; i-- {
+		reprieved := append(append([]*podInfo{}, victims[:i]...), victims[i+1:]...)
+		if fits(reprieved) {
+			victims = reprieved
+		}
+	}
+	return victims
+}
+
+// forEachCombination calls fn with every k-combination of [0, n) in
+// lexicographic order, until fn returns false.
+func forEachCombination(n, k int, fn func(idx []int) bool) {
+	idx := make([]int, k)
+	for i := range idx {
+		idx[i] = i
+	}
+	for {
+		if !fn(idx) {
+			return
+		}
+		i := k - 1
+		for i >= 0 && idx[i] == n-k+i {
+			i--
+		}
+		if i < 0 {
+			return
+		}
+		idx[i]++
+		for j := i + 1; j < k; j++ {
+			idx[j] = idx[j-1] + 1
+		}
+	}
+}
+
+func victimRef(victim *podInfo) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: victim.Name, Namespace: victim.Namespace, UID: victim.UID}}
+}
+
+// evictPod evicts a victim through the Eviction API, so PodDisruptionBudgets are respected.
+func evictPod(victim *podInfo) error {
+	return client.GetClient().PolicyV1().Evictions(victim.Namespace).Evict(context.Background(), &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: victim.Name, Namespace: victim.Namespace},
+	})
+}
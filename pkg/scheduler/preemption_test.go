@@ -0,0 +1,215 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/k8sutil"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+	"github.com/Project-HAMi/HAMi/pkg/util/client"
+)
+
+func preemptionTestPod(name string, priority int32, mem int64) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: k8stypes.UID(name + "-uid")},
+		Spec: corev1.PodSpec{
+			Priority: &priority,
+			Containers: []corev1.Container{{
+				Name: "main",
+				Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{
+						"hami.io/gpu":    *resource.NewQuantity(1, resource.BinarySI),
+						"hami.io/gpumem": *resource.NewQuantity(mem, resource.BinarySI),
+					},
+				},
+			}},
+		},
+	}
+}
+
+// addRunningPod records a pod holding mem MiB of the GPU on nodeID.
+func addRunningPod(t *testing.T, s *Scheduler, name, nodeID string, priority int32, mem int32) {
+	pod := preemptionTestPod(name, priority, int64(mem))
+	_, err := client.KubeClient.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	require.NoError(t, err)
+	devices := util.PodDevices{nvidia.NvidiaGPUDevice: util.PodSingleDevice{util.ContainerDevices{{
+		UUID: nodeID + "-gpu0", Type: nvidia.NvidiaGPUDevice, Usedmem: mem,
+	}}}}
+	s.addPod(pod, nodeID, devices)
+}
+
+func evictedPods(t *testing.T) []string {
+	var res []string
+	for _, action := range client.KubeClient.(*fake.Clientset).Actions() {
+		if action.GetSubresource() != "eviction" {
+			continue
+		}
+		res = append(res, action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction).Name)
+	}
+	return res
+}
+
+func enablePreemption(t *testing.T, dryRun bool) {
+	config.EnablePreemption = true
+	config.PreemptionDryRun = dryRun
+	t.Cleanup(func() {
+		config.EnablePreemption = false
+		config.PreemptionDryRun = false
+	})
+}
+
+func Test_Filter_preemption(t *testing.T) {
+	tests := []struct {
+		name        string
+		dryRun      bool
+		wantEvicted []string
+		wantReason  string
+	}{
+		{name: "evict victims", wantEvicted: []string{"low-a"}, wantReason: EventReasonPreempting},
+		{name: "dry run", dryRun: true, wantReason: EventReasonPreemptionDryRun},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enablePreemption(t, tt.dryRun)
+			s := newTestScheduler(t)
+			addRunningPod(t, s, "low-a", "node1", 0, 6000)
+			addRunningPod(t, s, "low-b", "node1", 0, 1000)
+			addRunningPod(t, s, "high", "node2", 2000, 8000)
+
+			pod := preemptionTestPod("inference", 1000, 4000)
+			args := extenderv1.ExtenderArgs{Pod: pod, NodeNames: &[]string{"node1", "node2"}}
+			res, err := s.Filter(context.Background(), args)
+			require.NoError(t, err)
+			assert.Nil(t, res.NodeNames, "the preemptor waits for its victims to terminate")
+			assert.Contains(t, res.FailedNodes["node1"], tt.wantReason)
+			assert.Contains(t, res.FailedNodes["node1"], "default/low-a(priority 0)")
+			assert.NotContains(t, res.FailedNodes["node1"], "low-b")
+			assert.Equal(t, nodeUnfitPod, res.FailedNodes["node2"], "higher priority pods are never victims")
+			assert.Equal(t, tt.wantEvicted, evictedPods(t))
+
+			// A retried preemptor does not evict more pods while its victims are alive.
+			res, err = s.Filter(context.Background(), args)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantEvicted, evictedPods(t))
+			if !tt.dryRun {
+				assert.Contains(t, res.FailedNodes["node1"], preemptionInProgress)
+			}
+		})
+	}
+}
+
+func Test_Filter_preemptionDisabled(t *testing.T) {
+	s := newTestScheduler(t)
+	addRunningPod(t, s, "low-a", "node1", 0, 8000)
+	addRunningPod(t, s, "low-b", "node2", 0, 8000)
+
+	res, err := s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: preemptionTestPod("inference", 1000, 4000), NodeNames: &[]string{"node1", "node2"}})
+	require.NoError(t, err)
+	assert.Equal(t, nodeUnfitPod, res.FailedNodes["node1"])
+	assert.Empty(t, evictedPods(t))
+}
+
+func Test_selectVictims(t *testing.T) {
+	s := newTestScheduler(t)
+	nodeInfo, err := s.GetNode("node1")
+	require.NoError(t, err)
+	pod := preemptionTestPod("inference", 1000, 5000)
+
+	tests := []struct {
+		name   string
+		onNode []*podInfo
+		want   []string
+	}{
+		{
+			name: "fewest victims wins over lowest priority",
+			onNode: []*podInfo{
+				{Name: "a", UID: "a", Priority: 0, Devices: quotaTestPodDevices(1000, 0, 1)},
+				{Name: "b", UID: "b", Priority: 0, Devices: quotaTestPodDevices(1000, 0, 1)},
+				{Name: "c", UID: "c", Priority: 500, Devices: quotaTestPodDevices(5000, 0, 1)},
+			},
+			want: []string{"c"},
+		},
+		{
+			name: "several victims",
+			onNode: []*podInfo{
+				{Name: "a", UID: "a", Priority: 0, Devices: quotaTestPodDevices(3000, 0, 1)},
+				{Name: "b", UID: "b", Priority: 10, Devices: quotaTestPodDevices(3000, 0, 1)},
+				{Name: "c", UID: "c", Priority: 20, Devices: quotaTestPodDevices(2000, 0, 1)},
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "not enough lower priority pods",
+			onNode: []*podInfo{
+				{Name: "a", UID: "a", Priority: 0, Devices: quotaTestPodDevices(1000, 0, 1)},
+				{Name: "b", UID: "b", Priority: 2000, Devices: quotaTestPodDevices(7000, 0, 1)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, p := range tt.onNode {
+				p.Devices[nvidia.NvidiaGPUDevice][0][0].UUID = "node1-gpu0"
+			}
+			victims := s.selectVictims(nodeInfo, pod, podPriority(pod), k8sutil.Resourcereqs(pod), tt.onNode)
+			var got []string
+			for _, victim := range victims {
+				got = append(got, victim.Name)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_selectVictims_quota(t *testing.T) {
+	s := newTestScheduler(t)
+	s.quota.setLimits(map[string]map[string]QuotaLimit{
+		"default": {nvidia.NvidiaGPUDevice: {Memory: 6000}},
+	})
+	addRunningPod(t, s, "low", "node1", 0, 4000)
+	nodeInfo, err := s.GetNode("node1")
+	require.NoError(t, err)
+	pod := preemptionTestPod("inference", 1000, 5000)
+
+	victims := s.selectVictims(nodeInfo, pod, podPriority(pod), k8sutil.Resourcereqs(pod), s.ListPodsInfo())
+	require.Len(t, victims, 1)
+	assert.Equal(t, "low", victims[0].Name, "the quota used by a victim of the namespace is released")
+}
+
+func Test_forEachCombination(t *testing.T) {
+	var got [][]int
+	forEachCombination(4, 2, func(idx []int) bool {
+		got = append(got, append([]int{}, idx...))
+		return true
+	})
+	assert.Equal(t, [][]int{{0, 1}, {0, 2}, {0, 3}, {1, 2}, {1, 3}, {2, 3}}, got)
+}
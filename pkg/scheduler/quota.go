@@ -0,0 +1,249 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"fmt"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+const namespaceQuotaExceeded = "NamespaceQuotaExceeded"
+
+// QuotaLimit bounds what a namespace may allocate from a single device vendor.
+// A zero value means the resource is not limited.
+type QuotaLimit struct {
+	// Memory is the device memory in MiB, summed over all allocated devices.
+	Memory int64 `yaml:"memory" json:"memory"`
+	// Cores is the core percentage, summed over all allocated devices.
+	Cores int64 `yaml:"cores" json:"cores"`
+	// Count is the number of devices (vGPU slices) allocated.
+	Count int64 `yaml:"count" json:"count"`
+}
+
+// QuotaUsage is the amount of a vendor's devices held by a namespace.
+type QuotaUsage struct {
+	Memory int64
+	Cores  int64
+	Count  int64
+}
+
+// NamespaceQuota is a snapshot of the limit and usage for one namespace and vendor.
+type NamespaceQuota struct {
+	Namespace    string
+	DeviceVendor string
+	Limit        QuotaLimit
+	Used         QuotaUsage
+}
+
+// quotaManager keeps per-namespace limits, read from the quota ConfigMap, and
+// usage, accounted by podManager whenever a pod's devices change.
+type quotaManager struct {
+	// limits is namespace -> device vendor -> limit.
+	limits map[string]map[string]QuotaLimit
+	// usage is namespace -> device vendor -> usage.
+	usage map[string]map[string]*QuotaUsage
+	mutex sync.RWMutex
+}
+
+func newQuotaManager() *quotaManager {
+	return &quotaManager{
+		limits: make(map[string]map[string]QuotaLimit),
+		usage:  make(map[string]map[string]*QuotaUsage),
+	}
+}
+
+// parseQuotaConfigMap reads quota limits from a ConfigMap. Every data key is a
+// namespace, and its value is a YAML map from device vendor to QuotaLimit:
+//
+//	team-a: |
+//	  NVIDIA:
+//	    memory: 40960
+//	    cores: 200
+//	    count: 4
+func parseQuotaConfigMap(cm *corev1.ConfigMap) (map[string]map[string]QuotaLimit, error) {
+	limits := make(map[string]map[string]QuotaLimit)
+	if cm == nil {
+		return limits, nil
+	}
+	for ns, data := range cm.Data {
+		vendorLimits := make(map[string]QuotaLimit)
+		if err := yaml.Unmarshal([]byte(data), &vendorLimits); err != nil {
+			return nil, fmt.Errorf("failed to parse quota for namespace %s: %v", ns, err)
+		}
+		for vendor, limit := range vendorLimits {
+			if limit.Memory < 0 || limit.Cores < 0 || limit.Count < 0 {
+				return nil, fmt.Errorf("negative quota for namespace %s, vendor %s", ns, vendor)
+			}
+		}
+		limits[ns] = vendorLimits
+	}
+	return limits, nil
+}
+
+func (m *quotaManager) setLimits(limits map[string]map[string]QuotaLimit) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.limits = limits
+	klog.InfoS("Namespace quotas updated", "namespaceCount", len(limits))
+}
+
+func (m *quotaManager) onQuotaConfigMapChange(obj any) {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		klog.ErrorS(fmt.Errorf("invalid configmap object"), "Failed to process quota configmap")
+		return
+	}
+	limits, err := parseQuotaConfigMap(cm)
+	if err != nil {
+		klog.ErrorS(err, "Ignoring invalid quota configmap", "configmap", klog.KObj(cm))
+		return
+	}
+	m.setLimits(limits)
+}
+
+func (m *quotaManager) onQuotaConfigMapDelete(_ any) {
+	klog.InfoS("Quota configmap deleted, namespace quotas disabled")
+	m.setLimits(make(map[string]map[string]QuotaLimit))
+}
+
+// podDevicesUsage sums the devices in pd per device vendor.
+func podDevicesUsage(pd util.PodDevices) map[string]QuotaUsage {
+	res := make(map[string]QuotaUsage)
+	for vendor, podSingle := range pd {
+		u := res[vendor]
+		for _, ctrdevs := range podSingle {
+			for _, dev := range ctrdevs {
+				u.Count++
+				u.Memory += int64(dev.Usedmem)
+				u.Cores += int64(dev.Usedcores)
+			}
+		}
+		res[vendor] = u
+	}
+	return res
+}
+
+func (m *quotaManager) addUsage(ns string, pd util.PodDevices) {
+	m.changeUsage(ns, pd, 1)
+}
+
+func (m *quotaManager) rmUsage(ns string, pd util.PodDevices) {
+	m.changeUsage(ns, pd, -1)
+}
+
+func (m *quotaManager) changeUsage(ns string, pd util.PodDevices, sign int64) {
+	if m == nil || len(pd) == 0 {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.usage[ns]; !ok {
+		m.usage[ns] = make(map[string]*QuotaUsage)
+	}
+	for vendor, u := range podDevicesUsage(pd) {
+		cur, ok := m.usage[ns][vendor]
+		if !ok {
+			cur = &QuotaUsage{}
+			m.usage[ns][vendor] = cur
+		}
+		cur.Count += sign * u.Count
+		cur.Memory += sign * u.Memory
+		cur.Cores += sign * u.Cores
+	}
+}
+
+// fitQuota checks whether allocating pd in namespace ns keeps every vendor
+// within its limit. On failure it returns a human readable reason.
+func (m *quotaManager) fitQuota(ns string, pd util.PodDevices) (bool, string) {
+	return m.fitQuotaReleasing(ns, pd, nil)
+}
+
+// fitQuotaReleasing is fitQuota once the devices of released, pods of ns about
+// to be evicted, are no longer used.
+func (m *quotaManager) fitQuotaReleasing(ns string, pd util.PodDevices, released util.PodDevices) (bool, string) {
+	if m == nil {
+		return true, ""
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	nsLimits, ok := m.limits[ns]
+	if !ok {
+		return true, ""
+	}
+	freed := podDevicesUsage(released)
+	for vendor, req := range podDevicesUsage(pd) {
+		limit, ok := nsLimits[vendor]
+		if !ok {
+			continue
+		}
+		used := QuotaUsage{}
+		if cur, ok := m.usage[ns][vendor]; ok {
+			used = *cur
+		}
+		used.Count -= freed[vendor].Count
+		used.Memory -= freed[vendor].Memory
+		used.Cores -= freed[vendor].Cores
+		if limit.Count > 0 && used.Count+req.Count > limit.Count {
+			return false, fmt.Sprintf("%s %s count used %d, request %d, limit %d", namespaceQuotaExceeded, vendor, used.Count, req.Count, limit.Count)
+		}
+		if limit.Memory > 0 && used.Memory+req.Memory > limit.Memory {
+			return false, fmt.Sprintf("%s %s memory used %d, request %d, limit %d", namespaceQuotaExceeded, vendor, used.Memory, req.Memory, limit.Memory)
+		}
+		if limit.Cores > 0 && used.Cores+req.Cores > limit.Cores {
+			return false, fmt.Sprintf("%s %s cores used %d, request %d, limit %d", namespaceQuotaExceeded, vendor, used.Cores, req.Cores, limit.Cores)
+		}
+	}
+	return true, ""
+}
+
+// listQuotas returns a snapshot of every namespace and vendor that has a limit.
+func (m *quotaManager) listQuotas() []NamespaceQuota {
+	if m == nil {
+		return nil
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	res := make([]NamespaceQuota, 0)
+	for ns, nsLimits := range m.limits {
+		for vendor, limit := range nsLimits {
+			q := NamespaceQuota{
+				Namespace:    ns,
+				DeviceVendor: vendor,
+				Limit:        limit,
+			}
+			if cur, ok := m.usage[ns][vendor]; ok {
+				q.Used = *cur
+			}
+			res = append(res, q)
+		}
+	}
+	return res
+}
+
+// InspectNamespaceQuotas is used by metrics monitor.
+func (s *Scheduler) InspectNamespaceQuotas() []NamespaceQuota {
+	return s.quota.listQuotas()
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+func quotaTestPodDevices(mem, cores int32, nums int) util.PodDevices {
+	ctr := util.ContainerDevices{}
+	for range nums {
+		ctr = append(ctr, util.ContainerDevice{UUID: "GPU0", Type: "NVIDIA", Usedmem: mem, Usedcores: cores})
+	}
+	return util.PodDevices{"NVIDIA": util.PodSingleDevice{ctr}}
+}
+
+func Test_parseQuotaConfigMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]string
+		want    map[string]map[string]QuotaLimit
+		wantErr bool
+	}{
+		{
+			name: "valid quota",
+			data: map[string]string{
+				"team-a": "NVIDIA:\n  memory: 4096\n  cores: 200\n  count: 2\n",
+				"team-b": "MLU:\n  count: 1\n",
+			},
+			want: map[string]map[string]QuotaLimit{
+				"team-a": {"NVIDIA": {Memory: 4096, Cores: 200, Count: 2}},
+				"team-b": {"MLU": {Count: 1}},
+			},
+		},
+		{
+			name:    "invalid yaml",
+			data:    map[string]string{"team-a": "NVIDIA: [1"},
+			wantErr: true,
+		},
+		{
+			name:    "negative limit",
+			data:    map[string]string{"team-a": "NVIDIA:\n  memory: -1\n"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseQuotaConfigMap(&corev1.ConfigMap{Data: tt.data})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_quotaManager_fitQuota(t *testing.T) {
+	pm := newPodManager()
+	pm.quota.setLimits(map[string]map[string]QuotaLimit{
+		"team-a": {"NVIDIA": {Memory: 4000, Cores: 100, Count: 3}},
+	})
+	pm.addPod(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{UID: "uid1", Name: "p1", Namespace: "team-a"}}, "node1", quotaTestPodDevices(1000, 30, 2))
+	// Pods in other namespaces do not count.
+	pm.addPod(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{UID: "uid2", Name: "p2", Namespace: "team-b"}}, "node1", quotaTestPodDevices(8000, 100, 4))
+
+	tests := []struct {
+		name   string
+		ns     string
+		pd     util.PodDevices
+		fit    bool
+		reason string
+	}{
+		{name: "within quota", ns: "team-a", pd: quotaTestPodDevices(2000, 40, 1), fit: true},
+		{name: "memory exceeded", ns: "team-a", pd: quotaTestPodDevices(2001, 0, 1), reason: "memory used 2000, request 2001, limit 4000"},
+		{name: "cores exceeded", ns: "team-a", pd: quotaTestPodDevices(10, 41, 1), reason: "cores used 60, request 41, limit 100"},
+		{name: "count exceeded", ns: "team-a", pd: quotaTestPodDevices(10, 0, 2), reason: "count used 2, request 2, limit 3"},
+		{name: "namespace without quota", ns: "team-b", pd: quotaTestPodDevices(100000, 100, 8), fit: true},
+		{name: "vendor without quota", ns: "team-a", pd: util.PodDevices{"MLU": {{{UUID: "MLU0", Usedmem: 100000}}}}, fit: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fit, reason := pm.quota.fitQuota(tt.ns, tt.pd)
+			assert.Equal(t, tt.fit, fit)
+			if !tt.fit {
+				assert.True(t, strings.HasPrefix(reason, namespaceQuotaExceeded), reason)
+				assert.Contains(t, reason, tt.reason)
+			}
+		})
+	}
+}
+
+func Test_quotaManager_usage(t *testing.T) {
+	pm := newPodManager()
+	pm.quota.setLimits(map[string]map[string]QuotaLimit{
+		"team-a": {"NVIDIA": {Memory: 4000}},
+	})
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{UID: "uid1", Name: "p1", Namespace: "team-a"}}
+
+	pm.addPod(pod, "node1", quotaTestPodDevices(1000, 30, 2))
+	assert.Equal(t, []NamespaceQuota{{
+		Namespace:    "team-a",
+		DeviceVendor: "NVIDIA",
+		Limit:        QuotaLimit{Memory: 4000},
+		Used:         QuotaUsage{Memory: 2000, Cores: 60, Count: 2},
+	}}, pm.quota.listQuotas())
+
+	// Updating the devices of a known pod replaces its usage.
+	pm.addPod(pod, "node1", quotaTestPodDevices(500, 10, 1))
+	assert.Equal(t, QuotaUsage{Memory: 500, Cores: 10, Count: 1}, pm.quota.listQuotas()[0].Used)
+
+	pm.delPod(pod)
+	assert.Equal(t, QuotaUsage{}, pm.quota.listQuotas()[0].Used)
+
+	pm.quota.onQuotaConfigMapDelete(nil)
+	assert.Empty(t, pm.quota.listQuotas())
+}
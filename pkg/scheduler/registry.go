@@ -0,0 +1,295 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"maps"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/device"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+const (
+	// nodeSyncWorkers is the number of nodes registered concurrently.
+	nodeSyncWorkers = 4
+	// configCheckInterval is how often the device config and the node label
+	// selector are checked for a reload, which registers every node again.
+	configCheckInterval = 15 * time.Second
+	// handshakeTimeout is how long a device plugin has to answer a handshake
+	// request before its devices are considered unhealthy, see util.CheckHealth.
+	handshakeTimeout = 60 * time.Second
+)
+
+func newNodeQueue() workqueue.TypedDelayingInterface[string] {
+	return workqueue.NewTypedDelayingQueueWithConfig(workqueue.TypedDelayingQueueConfig[string]{Name: "node-registry"})
+}
+
+func (s *Scheduler) onAddNode(obj any) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		klog.ErrorS(nil, "Invalid node object", "object", obj)
+		return
+	}
+	s.nodeQueue.Add(node.Name)
+}
+
+// onUpdateNode registers a node again if the fields devices are registered
+// from changed. Status heartbeats are ignored, informer resyncs are not.
+func (s *Scheduler) onUpdateNode(oldObj, newObj any) {
+	oldNode, ok := oldObj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	newNode, ok := newObj.(*corev1.Node)
+	if !ok {
+		klog.ErrorS(nil, "Invalid node object", "object", newObj)
+		return
+	}
+	if oldNode.ResourceVersion != newNode.ResourceVersion && !nodeRegistrationChanged(oldNode, newNode) {
+		return
+	}
+	s.nodeQueue.Add(newNode.Name)
+}
+
+func (s *Scheduler) onDelNode(obj any) {
+	name, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		klog.ErrorS(err, "Invalid node object", "object", obj)
+		return
+	}
+	s.nodeQueue.Add(name)
+}
+
+// nodeRegistrationChanged reports whether the devices of a node may have
+// changed: they are registered from its annotations and its capacity, and
+// its labels select it.
+func nodeRegistrationChanged(oldNode, newNode *corev1.Node) bool {
+	return !maps.Equal(oldNode.Annotations, newNode.Annotations) ||
+		!maps.Equal(oldNode.Labels, newNode.Labels) ||
+		!apiequality.Semantic.DeepEqual(oldNode.Status.Capacity, newNode.Status.Capacity) ||
+		!apiequality.Semantic.DeepEqual(oldNode.Status.Allocatable, newNode.Status.Allocatable)
+}
+
+// RegisterFromNodeAnnotations registers the devices of the nodes as they
+// change, until the scheduler stops. Every node is registered on start, on a
+// node notification and when the device config or the node label selector is
+// reloaded.
+func (s *Scheduler) RegisterFromNodeAnnotations() {
+	klog.InfoS("Entering RegisterFromNodeAnnotations")
+	defer klog.InfoS("Exiting RegisterFromNodeAnnotations")
+
+	for i := 0; i < nodeSyncWorkers; i++ {
+		go wait.Until(s.runNodeWorker, time.Second, s.stopCh)
+	}
+	ticker := time.NewTicker(configCheckInterval)
+	defer ticker.Stop()
+	configVersion, selector := device.ConfigVersion(), nodeSelector()
+	s.enqueueAllNodes(selector)
+	for {
+		select {
+		case <-s.nodeNotify:
+			klog.V(5).InfoS("Received node notification")
+			selector = nodeSelector()
+			s.enqueueAllNodes(selector)
+		case <-ticker.C:
+			if version, current := device.ConfigVersion(), nodeSelector(); version != configVersion || current.String() != selector.String() {
+				klog.InfoS("Device config or node selector changed, registering all nodes", "selector", current.String())
+				configVersion, selector = version, current
+				s.enqueueAllNodes(selector)
+			}
+		case <-s.stopCh:
+			klog.InfoS("Received stop signal, exiting RegisterFromNodeAnnotations")
+			s.nodeQueue.ShutDown()
+			return
+		}
+	}
+}
+
+// nodeSelector returns the selector of the nodes to register, read on every
+// use as it may be reloaded at runtime.
+func nodeSelector() labels.Selector {
+	return labels.Set(config.Current().NodeLabelSelector).AsSelector()
+}
+
+// enqueueAllNodes queues the nodes selected and those registered, which are
+// forgotten if they are no longer selected.
+func (s *Scheduler) enqueueAllNodes(selector labels.Selector) {
+	rawNodes, err := s.nodeLister.List(selector)
+	if err != nil {
+		klog.ErrorS(err, "Failed to list nodes with selector", "selector", selector.String())
+		return
+	}
+	klog.V(5).InfoS("Listed nodes", "nodeCount", len(rawNodes))
+	for _, node := range rawNodes {
+		s.nodeQueue.Add(node.Name)
+	}
+	s.nodeManager.mutex.RLock()
+	names := make([]string, 0, len(s.nodes))
+	for name := range s.nodes {
+		names = append(names, name)
+	}
+	s.nodeManager.mutex.RUnlock()
+	for _, name := range names {
+		s.nodeQueue.Add(name)
+	}
+}
+
+func (s *Scheduler) runNodeWorker() {
+	for s.processNextNode() {
+	}
+}
+
+func (s *Scheduler) processNextNode() bool {
+	name, shutdown := s.nodeQueue.Get()
+	if shutdown {
+		return false
+	}
+	defer s.nodeQueue.Done(name)
+	s.syncNode(name)
+	return true
+}
+
+// syncNode registers the devices of a node, or forgets the node if it was
+// deleted or is no longer selected.
+func (s *Scheduler) syncNode(name string) {
+	node, err := s.nodeLister.Get(name)
+	if apierrors.IsNotFound(err) {
+		s.rmNode(name)
+		return
+	}
+	if err != nil {
+		klog.ErrorS(err, "Failed to get node", "nodeName", name)
+		return
+	}
+	if selector := nodeSelector(); !selector.Matches(labels.Set(node.Labels)) {
+		klog.V(5).InfoS("Node not selected", "nodeName", name, "selector", selector.String())
+		s.rmNode(name)
+		return
+	}
+	klog.V(5).InfoS("Processing node", "nodeName", name)
+	for devhandsk, devInstance := range device.GetDevices() {
+		s.registerNodeDevices(node, devhandsk, devInstance)
+	}
+	s.recheckHandshakes(node)
+}
+
+// registerNodeDevices registers the devices of a vendor on a node, or removes
+// them if they are unhealthy.
+func (s *Scheduler) registerNodeDevices(node *corev1.Node, devhandsk string, devInstance device.Devices) {
+	klog.V(5).InfoS("Checking device health", "nodeName", node.Name, "deviceVendor", devhandsk)
+
+	nodedevices, err := devInstance.GetNodeDevices(*node)
+	if err != nil {
+		klog.V(5).InfoS("Failed to get node devices", "nodeName", node.Name, "deviceVendor", devhandsk)
+		return
+	}
+
+	health, needUpdate := devInstance.CheckHealth(devhandsk, node)
+	klog.V(5).InfoS("Device health check result", "nodeName", node.Name, "deviceVendor", devhandsk, "health", health, "needUpdate", needUpdate)
+
+	if !health {
+		klog.Warning("Device is unhealthy, cleaning up node", "nodeName", node.Name, "deviceVendor", devhandsk)
+		// Standbys only refresh their cache, node annotations are written by the leader.
+		if s.IsLeader() {
+			err := devInstance.NodeCleanUp(node.Name)
+			if err != nil {
+				klog.ErrorS(err, "Node cleanup failed", "nodeName", node.Name, "deviceVendor", devhandsk)
+			}
+		}
+
+		s.rmNodeDevices(node.Name, devhandsk)
+		return
+	}
+	if !needUpdate {
+		klog.V(5).InfoS("No update needed for device", "nodeName", node.Name, "deviceVendor", devhandsk)
+		return
+	}
+	_, ok := util.HandshakeAnnos[devhandsk]
+	if ok && s.IsLeader() {
+		tmppat := make(map[string]string)
+		tmppat[util.HandshakeAnnos[devhandsk]] = "Requesting_" + time.Now().Format(time.DateTime)
+		klog.InfoS("New timestamp for annotation", "nodeName", node.Name, "annotationKey", util.HandshakeAnnos[devhandsk], "annotationValue", tmppat[util.HandshakeAnnos[devhandsk]])
+		n, err := util.GetNode(node.Name)
+		if err != nil {
+			klog.ErrorS(err, "Failed to get node", "nodeName", node.Name)
+			return
+		}
+		klog.V(5).InfoS("Patching node annotations", "nodeName", node.Name, "annotations", tmppat)
+		if err := util.PatchNodeAnnotations(n, tmppat); err != nil {
+			klog.ErrorS(err, "Failed to patch node annotations", "nodeName", node.Name)
+		}
+	}
+	nodeInfo := &util.NodeInfo{}
+	nodeInfo.ID = node.Name
+	nodeInfo.Node = node
+	klog.V(5).InfoS("Fetching node devices", "nodeName", node.Name, "deviceVendor", devhandsk)
+	nodeInfo.Devices = make([]util.DeviceInfo, 0)
+	for _, deviceinfo := range nodedevices {
+		d := *deviceinfo
+		// Most vendors decode their devices without their vendor, they are
+		// kept under the vendor that registered them.
+		d.DeviceVendor = devhandsk
+		nodeInfo.Devices = append(nodeInfo.Devices, d)
+	}
+	_, err = s.GetNode(node.Name)
+	known := err == nil
+	s.addNode(node.Name, nodeInfo)
+	if len(nodeInfo.Devices) == 0 {
+		return
+	}
+	if known {
+		klog.V(5).InfoS("Node device updated", "nodeName", node.Name, "deviceVendor", devhandsk, "nodeInfo", nodeInfo)
+	} else {
+		klog.InfoS("Node device added", "nodeName", node.Name, "deviceVendor", devhandsk, "nodeInfo", nodeInfo)
+	}
+}
+
+// recheckHandshakes queues a node again once the handshakes it requested time
+// out, as no node event may come if its device plugins stopped answering.
+func (s *Scheduler) recheckHandshakes(node *corev1.Node) {
+	var after time.Duration
+	for _, annotation := range util.HandshakeAnnos {
+		handshake, ok := node.Annotations[annotation]
+		if !ok || !strings.HasPrefix(handshake, "Requesting_") {
+			continue
+		}
+		requested, err := time.Parse(time.DateTime, strings.TrimPrefix(handshake, "Requesting_"))
+		if err != nil {
+			continue
+		}
+		delay := min(time.Until(requested.Add(handshakeTimeout)), handshakeTimeout) + time.Second
+		if delay > 0 && (after == 0 || delay < after) {
+			after = delay
+		}
+	}
+	if after > 0 {
+		klog.V(5).InfoS("Checking node handshakes again", "nodeName", node.Name, "after", after)
+		s.nodeQueue.AddAfter(node.Name, after)
+	}
+}
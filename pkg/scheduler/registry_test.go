@@ -0,0 +1,243 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	listerscorev1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
+	"github.com/Project-HAMi/HAMi/pkg/util/client"
+)
+
+// recordingQueue records the nodes queued with a delay.
+type recordingQueue struct {
+	workqueue.TypedDelayingInterface[string]
+	after map[string]time.Duration
+}
+
+func (q *recordingQueue) AddAfter(item string, duration time.Duration) {
+	q.after[item] = duration
+}
+
+// newRegistryTestScheduler returns a scheduler listing nodes from an indexer.
+func newRegistryTestScheduler(t *testing.T) (*Scheduler, cache.Indexer) {
+	s := newTestScheduler(t)
+	s.rmNode("node1")
+	s.rmNode("node2")
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	s.nodeLister = listerscorev1.NewNodeLister(indexer)
+	return s, indexer
+}
+
+func addRegistryTestNode(t *testing.T, indexer cache.Indexer, node *corev1.Node) {
+	_, err := client.KubeClient.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, indexer.Add(node))
+}
+
+func Test_nodeRegistrationChanged(t *testing.T) {
+	node := simTestNode("node1", 1)
+	node.Labels = map[string]string{"gpu": "on"}
+	node.Status.Capacity = corev1.ResourceList{"hami.io/gpu": resource.MustParse("10")}
+
+	tests := []struct {
+		name   string
+		update func(*corev1.Node)
+		want   bool
+	}{
+		{
+			name: "status heartbeat",
+			update: func(n *corev1.Node) {
+				n.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, LastHeartbeatTime: metav1.Now()}}
+			},
+			want: false,
+		},
+		{
+			name:   "register annotation",
+			update: func(n *corev1.Node) { n.Annotations[nvidia.RegisterAnnos] = "" },
+			want:   true,
+		},
+		{
+			name:   "handshake annotation",
+			update: func(n *corev1.Node) { n.Annotations[nvidia.HandshakeAnnos] = "Reported_2025-06-13 09:07:40" },
+			want:   true,
+		},
+		{
+			name:   "label",
+			update: func(n *corev1.Node) { n.Labels["gpu"] = "off" },
+			want:   true,
+		},
+		{
+			name:   "capacity",
+			update: func(n *corev1.Node) { n.Status.Capacity["hami.io/gpu"] = resource.MustParse("20") },
+			want:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			updated := node.DeepCopy()
+			test.update(updated)
+			assert.Equal(t, test.want, nodeRegistrationChanged(node, updated))
+		})
+	}
+}
+
+func Test_onUpdateNode(t *testing.T) {
+	s := NewScheduler()
+	node := simTestNode("node1", 1)
+	node.ResourceVersion = "1"
+
+	heartbeat := node.DeepCopy()
+	heartbeat.ResourceVersion = "2"
+	heartbeat.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady}}
+	s.onUpdateNode(node, heartbeat)
+	assert.Equal(t, 0, s.nodeQueue.Len(), "status updates are ignored")
+
+	s.onUpdateNode(node, node)
+	assert.Equal(t, 1, s.nodeQueue.Len(), "resyncs register the node again")
+
+	s.onDelNode(cache.DeletedFinalStateUnknown{Key: "node2", Obj: simTestNode("node2", 1)})
+	assert.Equal(t, 2, s.nodeQueue.Len())
+}
+
+func Test_syncNode(t *testing.T) {
+	s, indexer := newRegistryTestScheduler(t)
+	node := simTestNode("node1", 2)
+	node.Labels = map[string]string{"gpu": "on"}
+	addRegistryTestNode(t, indexer, node)
+
+	s.syncNode("node1")
+	nodeInfo, err := s.GetNode("node1")
+	require.NoError(t, err)
+	assert.Len(t, nodeInfo.Devices, 2)
+	patched, err := client.KubeClient.CoreV1().Nodes().Get(context.Background(), "node1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, patched.Annotations[nvidia.HandshakeAnnos], "Requesting_", "the leader requests a handshake")
+
+	config.SetReloadable(&config.Reloadable{NodeLabelSelector: map[string]string{"gpu": "off"}})
+	t.Cleanup(func() { config.SetReloadable(nil) })
+	s.syncNode("node1")
+	_, err = s.GetNode("node1")
+	assert.Error(t, err, "nodes no longer selected are forgotten")
+
+	config.SetReloadable(nil)
+	s.syncNode("node1")
+	_, err = s.GetNode("node1")
+	require.NoError(t, err)
+	require.NoError(t, indexer.Delete(node))
+	s.syncNode("node1")
+	_, err = s.GetNode("node1")
+	assert.Error(t, err, "deleted nodes are forgotten")
+}
+
+func Test_syncNode_unhealthy(t *testing.T) {
+	s, indexer := newRegistryTestScheduler(t)
+	node := simTestNode("node1", 1)
+	addRegistryTestNode(t, indexer, node)
+	s.syncNode("node1")
+	_, err := s.GetNode("node1")
+	require.NoError(t, err)
+
+	expired := node.DeepCopy()
+	expired.Annotations[nvidia.HandshakeAnnos] = "Requesting_" + time.Now().Add(-2*handshakeTimeout).Format(time.DateTime)
+	require.NoError(t, indexer.Update(expired))
+	s.syncNode("node1")
+	cleaned, err := client.KubeClient.CoreV1().Nodes().Get(context.Background(), "node1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, cleaned.Annotations[nvidia.HandshakeAnnos], "Deleted_", "the leader cleans up a plugin not answering the handshake")
+	_, err = s.GetNode("node1")
+	assert.Error(t, err, "the unhealthy devices are removed")
+}
+
+func Test_recheckHandshakes(t *testing.T) {
+	s := NewScheduler()
+	queue := &recordingQueue{TypedDelayingInterface: s.nodeQueue, after: map[string]time.Duration{}}
+	s.nodeQueue = queue
+
+	node := simTestNode("node1", 1)
+	node.Annotations[nvidia.HandshakeAnnos] = "Reported_" + time.Now().Format(time.DateTime)
+	s.recheckHandshakes(node)
+	assert.Empty(t, queue.after, "no handshake is pending")
+
+	node.Annotations[nvidia.HandshakeAnnos] = "Requesting_" + time.Now().Format(time.DateTime)
+	s.recheckHandshakes(node)
+	assert.InDelta(t, handshakeTimeout+time.Second, queue.after["node1"], float64(2*time.Second))
+
+	delete(queue.after, "node1")
+	node.Annotations[nvidia.HandshakeAnnos] = "Requesting_" + time.Now().Add(-2*handshakeTimeout).Format(time.DateTime)
+	s.recheckHandshakes(node)
+	assert.Empty(t, queue.after, "the handshake already timed out")
+}
+
+// benchmarkRegistryNodes is the number of nodes of the registry benchmarks.
+const benchmarkRegistryNodes = 5000
+
+func newRegistryBenchmarkScheduler(b *testing.B) *Scheduler {
+	s := newTestScheduler(b)
+	// As a standby, which does not patch the handshakes.
+	s.leader.enabled = true
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for i := range benchmarkRegistryNodes {
+		require.NoError(b, indexer.Add(simTestNode(fmt.Sprintf("node%d", i), 8)))
+	}
+	s.nodeLister = listerscorev1.NewNodeLister(indexer)
+	nodes, err := s.nodeLister.List(nodeSelector())
+	require.NoError(b, err)
+	for _, node := range nodes {
+		s.syncNode(node.Name)
+	}
+	return s
+}
+
+// BenchmarkSyncNode registers the node that changed, as on a node event, and
+// refreshes the usage.
+func BenchmarkSyncNode(b *testing.B) {
+	s := newRegistryBenchmarkScheduler(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.syncNode(fmt.Sprintf("node%d", i%benchmarkRegistryNodes))
+		s.nodesUsage()
+	}
+}
+
+// BenchmarkSyncAllNodes registers every node, as the polling loop did on
+// every tick or node event.
+func BenchmarkSyncAllNodes(b *testing.B) {
+	s := newRegistryBenchmarkScheduler(b)
+	nodes, err := s.nodeLister.List(nodeSelector())
+	require.NoError(b, err)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, node := range nodes {
+			s.syncNode(node.Name)
+		}
+		s.nodesUsage()
+	}
+}
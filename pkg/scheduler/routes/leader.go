@@ -0,0 +1,131 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routes
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// forwardedHeader marks a request forwarded by a standby, so it is never forwarded twice.
+const forwardedHeader = "X-HAMi-Forwarded"
+
+var leaderClient = &http.Client{Timeout: 30 * time.Second}
+
+// SetLeaderCertificate makes requests forwarded to the leader use TLS. All
+// replicas serve the same certificate, so the leader is trusted only if it
+// presents exactly that certificate.
+func SetLeaderCertificate(certFile string) error {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("no certificate found in %s", certFile)
+	}
+	leaf := block.Bytes
+	leaderClient.Transport = &http.Transport{
+		TLSClientConfig: &tls.Config{
+			// The chain is checked by VerifyPeerCertificate against our own certificate.
+			InsecureSkipVerify: true, //nolint:gosec
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], leaf) {
+					return fmt.Errorf("leader does not present the scheduler serving certificate")
+				}
+				return nil
+			},
+		},
+	}
+	return nil
+}
+
+// forwardToLeader serves an extender request on a standby replica by proxying
+// it to the leader and copying its response back. If the leader is unknown or
+// cannot be reached, reject(reason) is returned to kube-scheduler instead, which
+// retries the pod later.
+func forwardToLeader(w http.ResponseWriter, r *http.Request, leader, self, verb string, reject func(reason string) any) {
+	target, err := leaderURL(r, leader, self, verb)
+	if err == nil {
+		err = proxy(w, r, target)
+	}
+	if err == nil {
+		return
+	}
+	klog.ErrorS(err, "Rejecting extender request on standby replica", "verb", verb, "leader", leader)
+	resultBody, err := json.Marshal(reject(err.Error()))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resultBody)
+}
+
+func leaderURL(r *http.Request, leader, self, verb string) (string, error) {
+	if r.Header.Get(forwardedHeader) != "" {
+		return "", fmt.Errorf("not the leader, refusing a request already forwarded")
+	}
+	if leader == "" || leader == self {
+		return "", fmt.Errorf("not the leader, no leader elected yet")
+	}
+	u, err := url.Parse(leader)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("not the leader, leader %s does not advertise an address", leader)
+	}
+	return u.JoinPath(verb).String(), nil
+}
+
+func proxy(w http.ResponseWriter, r *http.Request, target string) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(forwardedHeader, "true")
+	resp, err := leaderClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("not the leader, failed to forward to leader: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("not the leader, failed to read leader response: %v", err)
+	}
+	klog.V(4).InfoS("Forwarded extender request to leader", "target", target, "status", resp.StatusCode)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+	return nil
+}
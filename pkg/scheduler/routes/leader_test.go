@@ -0,0 +1,101 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+func rejectFilter(reason string) any {
+	return &extenderv1.ExtenderFilterResult{Error: reason}
+}
+
+func Test_forwardToLeader(t *testing.T) {
+	var gotPath, gotBody, gotHeader string
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotPath, gotBody, gotHeader = r.URL.Path, string(body), r.Header.Get(forwardedHeader)
+		w.Write([]byte(`{"NodeNames":["node1"]}`))
+	}))
+	defer leader.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/filter", strings.NewReader(`{"Pod":null}`))
+	w := httptest.NewRecorder()
+	forwardToLeader(w, req, leader.URL, "https://10.0.0.2:443", "filter", rejectFilter)
+
+	assert.Equal(t, "/filter", gotPath)
+	assert.Equal(t, `{"Pod":null}`, gotBody)
+	assert.Equal(t, "true", gotHeader)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"NodeNames":["node1"]}`, w.Body.String())
+}
+
+func Test_forwardToLeader_get(t *testing.T) {
+	var gotMethod, gotPath string
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath = r.Method, r.URL.Path
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer leader.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/explain/uid1", nil)
+	w := httptest.NewRecorder()
+	forwardToLeader(w, req, leader.URL, "https://10.0.0.2:443", "explain/uid1", rejectFilter)
+
+	assert.Equal(t, http.MethodGet, gotMethod)
+	assert.Equal(t, "/explain/uid1", gotPath)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_forwardToLeader_reject(t *testing.T) {
+	tests := []struct {
+		name      string
+		leader    string
+		forwarded bool
+		want      string
+	}{
+		{name: "no leader", leader: "", want: "no leader elected"},
+		{name: "leader is self", leader: "https://10.0.0.2:443", want: "no leader elected"},
+		{name: "leader without address", leader: "scheduler-0", want: "does not advertise an address"},
+		{name: "already forwarded", leader: "https://10.0.0.1:443", forwarded: true, want: "already forwarded"},
+		{name: "leader unreachable", leader: "http://127.0.0.1:1", want: "failed to forward"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/filter", strings.NewReader(`{}`))
+			if test.forwarded {
+				req.Header.Set(forwardedHeader, "true")
+			}
+			w := httptest.NewRecorder()
+			forwardToLeader(w, req, test.leader, "https://10.0.0.2:443", "filter", rejectFilter)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			var result extenderv1.ExtenderFilterResult
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+			assert.Contains(t, result.Error, test.want)
+		})
+	}
+}
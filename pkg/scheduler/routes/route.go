@@ -18,16 +18,21 @@ package routes
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2"
 	extenderv1 "k8s.io/kube-scheduler/extender/v1"
 
+	"github.com/Project-HAMi/HAMi/pkg/accounting"
 	"github.com/Project-HAMi/HAMi/pkg/scheduler"
 )
 
@@ -43,6 +48,12 @@ func PredicateRoute(s *scheduler.Scheduler) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		klog.Infoln("Entering Predicate Route handler")
 		checkBody(w, r)
+		if !s.IsLeader() {
+			forwardToLeader(w, r, s.Leader(), s.Identity(), "filter", func(reason string) any {
+				return &extenderv1.ExtenderFilterResult{Error: reason}
+			})
+			return
+		}
 
 		var buf bytes.Buffer
 		body := io.TeeReader(r.Body, &buf)
@@ -56,7 +67,7 @@ func PredicateRoute(s *scheduler.Scheduler) httprouter.Handle {
 				Error: err.Error(),
 			}
 		} else {
-			extenderFilterResult, err = s.Filter(extenderArgs)
+			extenderFilterResult, err = s.Filter(r.Context(), extenderArgs)
 			if err != nil {
 				klog.ErrorS(err, "Filter error for pod", "pod", extenderArgs.Pod.Name)
 				extenderFilterResult = &extenderv1.ExtenderFilterResult{
@@ -81,6 +92,12 @@ func PredicateRoute(s *scheduler.Scheduler) httprouter.Handle {
 func Bind(s *scheduler.Scheduler) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		klog.Infoln("Entering Bind handler")
+		if !s.IsLeader() {
+			forwardToLeader(w, r, s.Leader(), s.Identity(), "bind", func(reason string) any {
+				return &extenderv1.ExtenderBindingResult{Error: reason}
+			})
+			return
+		}
 		var buf bytes.Buffer
 		body := io.TeeReader(r.Body, &buf)
 		var extenderBindingArgs extenderv1.ExtenderBindingArgs
@@ -139,6 +156,123 @@ func WebHookRoute() httprouter.Handle {
 	}
 }
 
+// ValidatingWebHookRoute denies the pods whose device requests can never be
+// allocated in the cluster.
+func ValidatingWebHookRoute(s *scheduler.Scheduler) httprouter.Handle {
+	h, err := scheduler.NewValidatingWebHook(s)
+	if err != nil {
+		klog.ErrorS(err, "Failed to create new validating webhook")
+	}
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		klog.Infof("Handling validating webhook request on %s", r.URL.Path)
+		h.ServeHTTP(w, r)
+	}
+}
+
+// ExplainRoute returns the trace of the most recent scheduling decision for the
+// pod with the uid parameter. Decisions are kept by the leader, standbys
+// forward the request to it.
+func ExplainRoute(s *scheduler.Scheduler) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		uid := ps.ByName("uid")
+		if !s.IsLeader() {
+			forwardToLeader(w, r, s.Leader(), s.Identity(), "explain/"+uid, func(reason string) any {
+				return map[string]string{"error": reason}
+			})
+			return
+		}
+		decision := s.Explain(types.UID(uid))
+		if decision == nil {
+			http.Error(w, fmt.Sprintf("no scheduling decision kept for pod %s", uid), http.StatusNotFound)
+			return
+		}
+		resultBody, err := json.Marshal(decision)
+		if err != nil {
+			klog.ErrorS(err, "Failed to marshal scheduling decision", "uid", uid)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resultBody)
+	}
+}
+
+// InventoryRoute returns the devices of the registered nodes and their usage,
+// to the requests bearing token, for the advisor of several clusters.
+func InventoryRoute(s *scheduler.Scheduler, token string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || len(token) == 0 || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		resultBody, err := json.Marshal(s.Inventory())
+		if err != nil {
+			klog.ErrorS(err, "Failed to marshal inventory")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resultBody)
+	}
+}
+
+// AccountingRoute reports the devices allocated to each namespace, or pod with
+// by=pod, from the from to the to query parameters, as json or, with
+// format=csv, as CSV.
+func AccountingRoute(s *scheduler.Scheduler) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		query := r.URL.Query()
+		opts := accounting.ReportOptions{GroupBy: query.Get("by")}
+		var err error
+		if from := query.Get("from"); from != "" {
+			if opts.From, err = accounting.ParseTime(from); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if to := query.Get("to"); to != "" {
+			if opts.To, err = accounting.ParseTime(to); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := opts.Validate(time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format := query.Get("format")
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" && format != "csv" {
+			http.Error(w, fmt.Sprintf("unknown report format %q, expected csv or json", format), http.StatusBadRequest)
+			return
+		}
+		usage, err := s.AccountingReport(r.Context(), opts)
+		if err != nil {
+			klog.ErrorS(err, "Failed to build accounting report")
+			status := http.StatusInternalServerError
+			if errors.Is(err, scheduler.ErrAccountingDisabled) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(http.StatusOK)
+		if err := accounting.Write(w, format, usage); err != nil {
+			klog.ErrorS(err, "Failed to write accounting report")
+		}
+	}
+}
+
 func HealthzRoute() httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		klog.Infoln("Health check endpoint hit")
@@ -23,20 +23,27 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	listerscorev1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 	extenderv1 "k8s.io/kube-scheduler/extender/v1"
 
+	hamiv1alpha1 "github.com/Project-HAMi/HAMi/pkg/apis/hami/v1alpha1"
 	"github.com/Project-HAMi/HAMi/pkg/device"
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
 	"github.com/Project-HAMi/HAMi/pkg/k8sutil"
 	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
 	"github.com/Project-HAMi/HAMi/pkg/scheduler/policy"
@@ -48,15 +55,27 @@ type Scheduler struct {
 	*nodeManager
 	*podManager
 
+	gang       *gangManager
+	preemption *preemptionManager
+	leader     *leaderState
+	explain    *explainBuffer
+	// allocations is nil unless allocations are kept in DeviceAllocations.
+	allocations *allocationStore
+
 	stopCh     chan struct{}
 	kubeClient kubernetes.Interface
 	podLister  listerscorev1.PodLister
 	nodeLister listerscorev1.NodeLister
-	//Node status returned by filter
-	cachedstatus map[string]*NodeUsage
-	nodeNotify   chan struct{}
-	//Node Overview
-	overviewstatus map[string]*NodeUsage
+	nodeNotify chan struct{}
+	// nodeQueue holds the nodes to register the devices of again.
+	nodeQueue workqueue.TypedDelayingInterface[string]
+	// usage is the usage of the devices of every node.
+	usage usageCache
+	// nextStartNode is the offset, in the sorted nodes, of the first node the
+	// next Filter fits the pod on when it stops once enough nodes fit.
+	nextStartNode atomic.Uint64
+	// filterLatency is the latency of each stage of filtering pods.
+	filterLatency latencyRecorder
 
 	eventRecorder record.EventRecorder
 }
@@ -64,12 +83,16 @@ type Scheduler struct {
 func NewScheduler() *Scheduler {
 	klog.InfoS("Initializing HAMi scheduler")
 	s := &Scheduler{
-		stopCh:       make(chan struct{}),
-		cachedstatus: make(map[string]*NodeUsage),
-		nodeNotify:   make(chan struct{}, 1),
+		stopCh:     make(chan struct{}),
+		nodeNotify: make(chan struct{}, 1),
+		nodeQueue:  newNodeQueue(),
 	}
 	s.nodeManager = newNodeManager()
 	s.podManager = newPodManager()
+	s.gang = newGangManager()
+	s.preemption = newPreemptionManager()
+	s.leader = newLeaderState(config.LeaderElect, leaderElectionIdentity())
+	s.explain = newExplainBuffer(config.ExplainBufferSize)
 	klog.V(2).InfoS("Scheduler initialized successfully")
 	return s
 }
@@ -94,10 +117,19 @@ func (s *Scheduler) onAddPod(obj any) {
 	}
 	if k8sutil.IsPodInTerminatedState(pod) {
 		s.delPod(pod)
+		s.releaseAllocation(pod)
 		return
 	}
-	podDev, _ := util.DecodePodDevices(util.SupportDevices, pod.Annotations)
+	podDev, err := util.DecodePodDevices(util.SupportDevices, pod.Annotations)
+	if err != nil {
+		klog.ErrorS(err, "Failed to decode pod devices", "pod", klog.KObj(pod))
+	}
 	s.addPod(pod, nodeID, podDev)
+	if s.allocations != nil && s.IsLeader() {
+		if err := s.allocations.sync(context.Background(), pod, nodeID, podDev); err != nil {
+			klog.ErrorS(err, "Failed to sync device allocation", "pod", klog.KObj(pod))
+		}
+	}
 }
 
 func (s *Scheduler) onUpdatePod(_, newObj any) {
@@ -110,16 +142,44 @@ func (s *Scheduler) onDelPod(obj any) {
 		klog.Errorf("unknown add object type")
 		return
 	}
+	s.gang.removeMember(pod)
 	_, ok = pod.Annotations[util.AssignedNodeAnnotations]
 	if !ok {
 		return
 	}
 	s.delPod(pod)
+	s.releaseAllocation(pod)
+}
+
+// releaseAllocation deletes the DeviceAllocation of a pod whose devices were
+// released, if this replica leads.
+func (s *Scheduler) releaseAllocation(pod *corev1.Pod) {
+	if s.allocations == nil || !s.IsLeader() {
+		return
+	}
+	if err := s.allocations.release(context.Background(), pod); err != nil {
+		klog.ErrorS(err, "Failed to delete device allocation", "pod", klog.KObj(pod))
+	}
 }
 
 func (s *Scheduler) Start() {
 	klog.InfoS("Starting HAMi scheduler components")
 	s.kubeClient = client.GetClient()
+	if config.EnableDeviceAllocationCRD {
+		c, err := dynamic.NewForConfig(client.GetConfig())
+		if err != nil {
+			klog.Fatalf("Failed to create device allocation client: %v", err)
+		}
+		s.allocations = newAllocationStore(c)
+		if err := s.restoreAllocations(); err != nil {
+			klog.Fatalf("Failed to restore device allocations: %v", err)
+		}
+	}
+	if config.AccountingDir != "" {
+		if err := s.openLedger(); err != nil {
+			klog.Fatalf("Failed to open the accounting ledger: %v", err)
+		}
+	}
 	informerFactory := informers.NewSharedInformerFactoryWithOptions(s.kubeClient, time.Hour*1)
 	s.podLister = informerFactory.Core().V1().Pods().Lister()
 	s.nodeLister = informerFactory.Core().V1().Nodes().Lister()
@@ -130,222 +190,157 @@ func (s *Scheduler) Start() {
 		DeleteFunc: s.onDelPod,
 	})
 	informerFactory.Core().V1().Nodes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    func(_ any) { s.doNodeNotify() },
-		UpdateFunc: func(_, _ any) { s.doNodeNotify() },
-		DeleteFunc: func(_ any) { s.doNodeNotify() },
+		AddFunc:    s.onAddNode,
+		UpdateFunc: s.onUpdateNode,
+		DeleteFunc: s.onDelNode,
 	})
 	informerFactory.Start(s.stopCh)
 	informerFactory.WaitForCacheSync(s.stopCh)
+	s.startQuotaInformer()
 	s.addAllEventHandlers()
+	go wait.Until(s.releaseExpiredPodGroups, podGroupCheckInterval, s.stopCh)
+	if s.allocations != nil {
+		go wait.Until(s.collectAllocations, allocationGCInterval, s.stopCh)
+	}
+	if config.EnableMigReconfiguration {
+		go wait.Until(s.reconcileMigGeometries, migReconfigureInterval, s.stopCh)
+	}
+	if s.ledger != nil {
+		go s.runLedger()
+	}
 }
 
-func (s *Scheduler) Stop() {
-	close(s.stopCh)
+func (s *Scheduler) startQuotaInformer() {
+	if config.QuotaConfigMap == "" {
+		return
+	}
+	ns, name, err := cache.SplitMetaNamespaceKey(config.QuotaConfigMap)
+	if err != nil || ns == "" {
+		klog.ErrorS(err, "Invalid quota configmap, expected namespace/name", "quotaConfigMap", config.QuotaConfigMap)
+		return
+	}
+	klog.InfoS("Watching namespace quota configmap", "namespace", ns, "name", name)
+	factory := informers.NewSharedInformerFactoryWithOptions(s.kubeClient, time.Hour*1,
+		informers.WithNamespace(ns),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	factory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    s.quota.onQuotaConfigMapChange,
+		UpdateFunc: func(_, newObj any) { s.quota.onQuotaConfigMapChange(newObj) },
+		DeleteFunc: s.quota.onQuotaConfigMapDelete,
+	})
+	factory.Start(s.stopCh)
+	factory.WaitForCacheSync(s.stopCh)
 }
 
-func (s *Scheduler) RegisterFromNodeAnnotations() {
-	klog.InfoS("Entering RegisterFromNodeAnnotations")
-	defer klog.InfoS("Exiting RegisterFromNodeAnnotations")
-
-	labelSelector := labels.Set(config.NodeLabelSelector).AsSelector()
-	klog.InfoS("Using label selector for list nodes", "selector", labelSelector.String())
-
-	ticker := time.NewTicker(time.Second * 15)
-	defer ticker.Stop()
-	printedLog := map[string]bool{}
-	for {
-		select {
-		case <-s.nodeNotify:
-			klog.V(5).InfoS("Received node notification")
-		case <-ticker.C:
-			klog.InfoS("Ticker triggered")
-		case <-s.stopCh:
-			klog.InfoS("Received stop signal, exiting RegisterFromNodeAnnotations")
-			return
-		}
-		rawNodes, err := s.nodeLister.List(labelSelector)
-		if err != nil {
-			klog.ErrorS(err, "Failed to list nodes with selector", "selector", labelSelector.String())
-			continue
-		}
-		klog.V(5).InfoS("Listed nodes", "nodeCount", len(rawNodes))
-		var nodeNames []string
-		for _, val := range rawNodes {
-			nodeNames = append(nodeNames, val.Name)
-			klog.V(5).InfoS("Processing node", "nodeName", val.Name)
-
-			for devhandsk, devInstance := range device.GetDevices() {
-				klog.V(5).InfoS("Checking device health", "nodeName", val.Name, "deviceVendor", devhandsk)
-
-				nodedevices, err := devInstance.GetNodeDevices(*val)
-				if err != nil {
-					klog.V(5).InfoS("Failed to get node devices", "nodeName", val.Name, "deviceVendor", devhandsk)
-					continue
-				}
-
-				health, needUpdate := devInstance.CheckHealth(devhandsk, val)
-				klog.V(5).InfoS("Device health check result", "nodeName", val.Name, "deviceVendor", devhandsk, "health", health, "needUpdate", needUpdate)
-
-				if !health {
-					klog.Warning("Device is unhealthy, cleaning up node", "nodeName", val.Name, "deviceVendor", devhandsk)
-					err := devInstance.NodeCleanUp(val.Name)
-					if err != nil {
-						klog.ErrorS(err, "Node cleanup failed", "nodeName", val.Name, "deviceVendor", devhandsk)
-					}
-
-					s.rmNodeDevices(val.Name, devhandsk)
-					continue
-				}
-				if !needUpdate {
-					klog.V(5).InfoS("No update needed for device", "nodeName", val.Name, "deviceVendor", devhandsk)
-					continue
-				}
-				_, ok := util.HandshakeAnnos[devhandsk]
-				if ok {
-					tmppat := make(map[string]string)
-					tmppat[util.HandshakeAnnos[devhandsk]] = "Requesting_" + time.Now().Format(time.DateTime)
-					klog.InfoS("New timestamp for annotation", "nodeName", val.Name, "annotationKey", util.HandshakeAnnos[devhandsk], "annotationValue", tmppat[util.HandshakeAnnos[devhandsk]])
-					n, err := util.GetNode(val.Name)
-					if err != nil {
-						klog.ErrorS(err, "Failed to get node", "nodeName", val.Name)
-						continue
-					}
-					klog.V(5).InfoS("Patching node annotations", "nodeName", val.Name, "annotations", tmppat)
-					if err := util.PatchNodeAnnotations(n, tmppat); err != nil {
-						klog.ErrorS(err, "Failed to patch node annotations", "nodeName", val.Name)
-					}
-				}
-				nodeInfo := &util.NodeInfo{}
-				nodeInfo.ID = val.Name
-				nodeInfo.Node = val
-				klog.V(5).InfoS("Fetching node devices", "nodeName", val.Name, "deviceVendor", devhandsk)
-				nodeInfo.Devices = make([]util.DeviceInfo, 0)
-				for _, deviceinfo := range nodedevices {
-					nodeInfo.Devices = append(nodeInfo.Devices, *deviceinfo)
-				}
-				s.addNode(val.Name, nodeInfo)
-				if s.nodes[val.Name] != nil && len(nodeInfo.Devices) > 0 {
-					if printedLog[val.Name] {
-						klog.V(5).InfoS("Node device updated", "nodeName", val.Name, "deviceVendor", devhandsk, "nodeInfo", nodeInfo, "totalDevices", s.nodes[val.Name].Devices)
-					} else {
-						klog.InfoS("Node device added", "nodeName", val.Name, "deviceVendor", devhandsk, "nodeInfo", nodeInfo, "totalDevices", s.nodes[val.Name].Devices)
-						printedLog[val.Name] = true
-					}
-				}
-			}
-		}
-		_, _, err = s.getNodesUsage(&nodeNames, nil)
-		if err != nil {
-			klog.ErrorS(err, "Failed to get node usage", "nodeNames", nodeNames)
-		}
-	}
+func (s *Scheduler) Stop() {
+	close(s.stopCh)
 }
 
 // InspectAllNodesUsage is used by metrics monitor.
 func (s *Scheduler) InspectAllNodesUsage() *map[string]*NodeUsage {
-	return &s.overviewstatus
+	usage := s.nodesUsage()
+	return &usage
 }
 
 // returns all nodes and its device memory usage, and we filter it with nodeSelector, taints, nodeAffinity
-// unschedulerable and nodeName.
+// unschedulerable and nodeName. The usage is a copy the caller may modify, see sharedNodesUsage.
 func (s *Scheduler) getNodesUsage(nodes *[]string, task *corev1.Pod) (*map[string]*NodeUsage, map[string]string, error) {
-	overallnodeMap := make(map[string]*NodeUsage)
-	cachenodeMap := make(map[string]*NodeUsage)
-	failedNodes := make(map[string]string)
-	allNodes, err := s.ListNodes()
-	if err != nil {
-		return &overallnodeMap, failedNodes, err
+	shared, failedNodes := s.sharedNodesUsage(*nodes)
+	userGPUPolicy := util.GetGPUSchedulerPolicyByPod(config.Current().GPUSchedulerPolicy, task)
+	cachenodeMap := make(map[string]*NodeUsage, len(shared))
+	for nodeID, node := range shared {
+		cachenodeMap[nodeID] = node.clone()
+		cachenodeMap[nodeID].Devices.Policy = userGPUPolicy
 	}
+	return &cachenodeMap, failedNodes, nil
+}
 
-	for _, node := range allNodes {
-		nodeInfo := &NodeUsage{}
-		userGPUPolicy := util.GetGPUSchedulerPolicyByPod(config.GPUSchedulerPolicy, task)
-		nodeInfo.Node = node.Node
-		nodeInfo.Devices = policy.DeviceUsageList{
-			Policy:      userGPUPolicy,
-			DeviceLists: make([]*policy.DeviceListsScore, 0),
-		}
-		for _, d := range node.Devices {
-			nodeInfo.Devices.DeviceLists = append(nodeInfo.Devices.DeviceLists, &policy.DeviceListsScore{
-				Score: 0,
-				Device: &util.DeviceUsage{
-					ID:        d.ID,
-					Index:     d.Index,
-					Used:      0,
-					Count:     d.Count,
-					Usedmem:   0,
-					Totalmem:  d.Devmem,
-					Totalcore: d.Devcore,
-					Usedcores: 0,
-					MigUsage: util.MigInUse{
-						Index:     0,
-						UsageList: make(util.MIGS, 0),
-					},
-					MigTemplate: d.MIGTemplate,
-					Mode:        d.Mode,
-					Type:        d.Type,
-					Numa:        d.Numa,
-					Health:      d.Health,
-					CustomInfo:  maps.Clone(d.CustomInfo),
+// newNodeUsage returns the usage of a node's devices, with nothing allocated yet.
+func newNodeUsage(node *util.NodeInfo, task *corev1.Pod) *NodeUsage {
+	nodeInfo := &NodeUsage{}
+	userGPUPolicy := util.GetGPUSchedulerPolicyByPod(config.Current().GPUSchedulerPolicy, task)
+	nodeInfo.Node = node.Node
+	nodeInfo.Devices = policy.DeviceUsageList{
+		Policy:      userGPUPolicy,
+		DeviceLists: make([]*policy.DeviceListsScore, 0),
+	}
+	for _, d := range node.Devices {
+		nodeInfo.Devices.DeviceLists = append(nodeInfo.Devices.DeviceLists, &policy.DeviceListsScore{
+			Score: 0,
+			Device: &util.DeviceUsage{
+				ID:        d.ID,
+				Index:     d.Index,
+				Used:      0,
+				Count:     d.Count,
+				Usedmem:   0,
+				Totalmem:  d.Devmem,
+				Physmem:   physicalMemory(d),
+				Totalcore: d.Devcore,
+				Usedcores: 0,
+				MigUsage: util.MigInUse{
+					Index:     0,
+					UsageList: make(util.MIGS, 0),
 				},
-			})
-		}
-		overallnodeMap[node.ID] = nodeInfo
+				MigTemplate: d.MIGTemplate,
+				Mode:        d.Mode,
+				Type:        d.Type,
+				Numa:        d.Numa,
+				Health:      d.Health,
+				CustomInfo:  maps.Clone(d.CustomInfo),
+			},
+		})
 	}
+	presetMigGeometries(node.Node, nodeInfo)
+	return nodeInfo
+}
 
-	podsInfo := s.ListPodsInfo()
-	for _, p := range podsInfo {
-		node, ok := overallnodeMap[p.NodeID]
-		if !ok {
-			klog.V(5).InfoS("pod allocated unknown node resources",
-				"pod", klog.KRef(p.Namespace, p.Name), "nodeID", p.NodeID)
-			continue
-		}
-		for _, podsingleds := range p.Devices {
-			for _, ctrdevs := range podsingleds {
-				for _, udevice := range ctrdevs {
-					for _, d := range node.Devices.DeviceLists {
-						deviceID := udevice.UUID
-						if strings.Contains(deviceID, "[") {
-							deviceID = strings.Split(deviceID, "[")[0]
+// physicalMemory is the physical memory of a device, its registered memory
+// unless it is oversubscribed.
+func physicalMemory(d util.DeviceInfo) int32 {
+	if d.Physmem > 0 && d.Physmem < d.Devmem {
+		return d.Physmem
+	}
+	return d.Devmem
+}
+
+// addNodeDevicesUsage accounts the devices allocated to a pod on the usage of its node.
+func addNodeDevicesUsage(node *NodeUsage, devices util.PodDevices, tolerateOversubscription bool) {
+	for _, podsingleds := range devices {
+		for _, ctrdevs := range podsingleds {
+			for _, udevice := range ctrdevs {
+				for _, d := range node.Devices.DeviceLists {
+					deviceID := udevice.UUID
+					if strings.Contains(deviceID, "[") {
+						deviceID = strings.Split(deviceID, "[")[0]
+					}
+					if d.Device.ID == deviceID {
+						d.Device.Used++
+						d.Device.Usedmem += udevice.Usedmem
+						if !tolerateOversubscription {
+							d.Device.Guaranteedmem += udevice.Usedmem
 						}
-						if d.Device.ID == deviceID {
-							d.Device.Used++
-							d.Device.Usedmem += udevice.Usedmem
-							d.Device.Usedcores += udevice.Usedcores
-							if strings.Contains(udevice.UUID, "[") {
-								if strings.Compare(d.Device.Mode, "hami-core") == 0 {
-									klog.Errorf("found a mig task running on a hami-core GPU\n")
-									d.Device.Health = false
-									continue
-								}
-								tmpIdx, Instance, _ := util.ExtractMigTemplatesFromUUID(udevice.UUID)
-								if len(d.Device.MigUsage.UsageList) == 0 {
-									util.PlatternMIG(&d.Device.MigUsage, d.Device.MigTemplate, tmpIdx)
-								}
-								d.Device.MigUsage.UsageList[Instance].InUse = true
-								klog.V(5).Infoln("add mig usage", d.Device.MigUsage, "template=", d.Device.MigTemplate, "uuid=", d.Device.ID)
+						d.Device.Usedcores += udevice.Usedcores
+						if strings.Contains(udevice.UUID, "[") {
+							if d.Device.Mode == nvidia.HamiCoreMode || d.Device.Mode == nvidia.MpsMode {
+								klog.Errorf("found a mig task running on a %s GPU\n", d.Device.Mode)
+								d.Device.Health = false
+								continue
 							}
+							tmpIdx, Instance, _ := util.ExtractMigTemplatesFromUUID(udevice.UUID)
+							if len(d.Device.MigUsage.UsageList) == 0 || nvidia.MigDeviceIdle(d.Device) && int(d.Device.MigUsage.Index) != tmpIdx {
+								d.Device.MigUsage.UsageList = make(util.MIGS, 0)
+								util.PlatternMIG(&d.Device.MigUsage, d.Device.MigTemplate, tmpIdx)
+							}
+							d.Device.MigUsage.UsageList[Instance].InUse = true
+							klog.V(5).Infoln("add mig usage", d.Device.MigUsage, "template=", d.Device.MigTemplate, "uuid=", d.Device.ID)
 						}
 					}
 				}
 			}
 		}
-		klog.V(5).Infof("usage: pod %v assigned %v %v", p.Name, p.NodeID, p.Devices)
-	}
-	s.overviewstatus = overallnodeMap
-	for _, nodeID := range *nodes {
-		node, err := s.GetNode(nodeID)
-		if err != nil {
-			// The identified node does not have a gpu device, so the log here has no practical meaning,increase log priority.
-			klog.V(5).InfoS("node unregistered", "node", nodeID, "error", err)
-			failedNodes[nodeID] = "node unregistered"
-			continue
-		}
-		cachenodeMap[node.ID] = overallnodeMap[node.ID]
 	}
-	s.cachedstatus = cachenodeMap
-	return &cachenodeMap, failedNodes, nil
 }
 
 func (s *Scheduler) getPodUsage() (map[string]PodUseDeviceStat, error) {
@@ -426,6 +421,10 @@ func (s *Scheduler) Bind(args extenderv1.ExtenderBindingArgs) (*extenderv1.Exten
 		goto ReleaseNodeLocks
 	}
 
+	s.gang.markBound(current)
+	if err := s.allocations.setPhase(context.Background(), current, hamiv1alpha1.DeviceAllocationAllocating); err != nil {
+		klog.ErrorS(err, "Failed to update device allocation", "pod", klog.KObj(current))
+	}
 	s.recordScheduleBindingResultEvent(current, EventReasonBindingSucceed, []string{args.Node}, nil)
 	klog.InfoS("Successfully bound pod to node", "pod", args.PodName, "namespace", args.PodNamespace, "node", args.Node)
 	return &extenderv1.ExtenderBindingResult{Error: ""}, nil
@@ -439,7 +438,7 @@ ReleaseNodeLocks:
 	return &extenderv1.ExtenderBindingResult{Error: err.Error()}, nil
 }
 
-func (s *Scheduler) Filter(args extenderv1.ExtenderArgs) (*extenderv1.ExtenderFilterResult, error) {
+func (s *Scheduler) Filter(ctx context.Context, args extenderv1.ExtenderArgs) (*extenderv1.ExtenderFilterResult, error) {
 	klog.InfoS("Starting schedule filter process", "pod", args.Pod.Name, "uuid", args.Pod.UID, "namespace", args.Pod.Namespace)
 	resourceReqs := k8sutil.Resourcereqs(args.Pod)
 	resourceReqTotal := 0
@@ -458,27 +457,38 @@ func (s *Scheduler) Filter(args extenderv1.ExtenderArgs) (*extenderv1.ExtenderFi
 			Error:       "",
 		}, nil
 	}
+	if key, minMember, ok := podGroupOf(args.Pod); ok && s.gang != nil {
+		if res, handled, err := s.gangFilter(ctx, args, key, minMember); handled {
+			return res, err
+		}
+	}
 	annos := args.Pod.Annotations
 	s.delPod(args.Pod)
-	nodeUsage, failedNodes, err := s.getNodesUsage(args.NodeNames, args.Pod)
-	if err != nil {
-		s.recordScheduleFilterResultEvent(args.Pod, EventReasonFilteringFailed, "", err)
-		return nil, err
-	}
+	// The usage is shared with the cache, calcScore copies the nodes it fits the pod on.
+	start := time.Now()
+	nodeUsage, failedNodes := s.sharedNodesUsage(*args.NodeNames)
+	s.filterLatency.observe(filterStageUsage, start)
 	if len(failedNodes) != 0 {
 		klog.V(5).InfoS("Nodes failed during usage retrieval",
 			"nodes", failedNodes)
 	}
-	nodeScores, err := s.calcScore(nodeUsage, resourceReqs, annos, args.Pod, failedNodes)
+	snapshot := s.explain.snapshotUsage(nodeUsage)
+	start = time.Now()
+	nodeScores, err := s.calcScore(ctx, &nodeUsage, resourceReqs, annos, args.Pod, failedNodes, numFeasibleNodesToFind(len(nodeUsage)))
+	s.filterLatency.observe(filterStageFit, start)
 	if err != nil {
 		err := fmt.Errorf("calcScore failed %v for pod %v", err, args.Pod.Name)
 		s.recordScheduleFilterResultEvent(args.Pod, EventReasonFilteringFailed, "", err)
+		s.recordDecision(args.Pod, resourceReqs, snapshot, nil, failedNodes, "", err)
 		return nil, err
 	}
 	if len((*nodeScores).NodeList) == 0 {
 		klog.V(4).InfoS("No available nodes meet the required scores",
 			"pod", args.Pod.Name)
-		s.recordScheduleFilterResultEvent(args.Pod, EventReasonFilteringFailed, "", fmt.Errorf("no available node, %d nodes do not meet", len(*args.NodeNames)))
+		start = time.Now()
+		err := s.noAvailableNode(args.Pod, resourceReqs, failedNodes, len(*args.NodeNames))
+		s.filterLatency.observe(filterStagePreempt, start)
+		s.recordDecision(args.Pod, resourceReqs, snapshot, nodeScores, failedNodes, "", err)
 		return &extenderv1.ExtenderFilterResult{
 			FailedNodes: failedNodes,
 		}, nil
@@ -491,27 +501,61 @@ func (s *Scheduler) Filter(args extenderv1.ExtenderArgs) (*extenderv1.ExtenderFi
 		"podName", args.Pod.Name,
 		"nodeID", m.NodeID,
 		"devices", m.Devices)
-	annotations := make(map[string]string)
-	annotations[util.AssignedNodeAnnotations] = m.NodeID
-	annotations[util.AssignedTimeAnnotations] = strconv.FormatInt(time.Now().Unix(), 10)
-
-	for _, val := range device.GetDevices() {
-		val.PatchAnnotations(args.Pod, &annotations, m.Devices)
-	}
-
-	s.addPod(args.Pod, m.NodeID, m.Devices)
-	err = util.PatchPodAnnotations(args.Pod, annotations)
+	start = time.Now()
+	err = s.assignPod(args.Pod, m.NodeID, m.Devices, m.Scores)
+	s.filterLatency.observe(filterStageAssign, start)
 	if err != nil {
 		s.recordScheduleFilterResultEvent(args.Pod, EventReasonFilteringFailed, "", err)
-		s.delPod(args.Pod)
+		s.recordDecision(args.Pod, resourceReqs, snapshot, nodeScores, failedNodes, "", err)
 		return nil, err
 	}
+	s.recordDecision(args.Pod, resourceReqs, snapshot, nodeScores, failedNodes, m.NodeID, nil)
 	successMsg := genSuccessMsg(len(*args.NodeNames), m.NodeID, nodeScores.NodeList)
 	s.recordScheduleFilterResultEvent(args.Pod, EventReasonFilteringSucceed, successMsg, nil)
 	res := extenderv1.ExtenderFilterResult{NodeNames: &[]string{m.NodeID}}
 	return &res, nil
 }
 
+// noAvailableNode tries to make room for a pod that fits on none of the nodes
+// by preemption, and reports why it did not fit. It returns the reported error.
+func (s *Scheduler) noAvailableNode(pod *corev1.Pod, resourceReqs util.PodDeviceRequests, failedNodes map[string]string, totalNodes int) error {
+	filterErr := fmt.Errorf("no available node, %d nodes do not meet", totalNodes)
+	for _, reason := range failedNodes {
+		if strings.HasPrefix(reason, namespaceQuotaExceeded) {
+			filterErr = fmt.Errorf("no available node, %s", reason)
+			break
+		}
+	}
+	if msg := s.preempt(pod, resourceReqs, failedNodes); msg != "" {
+		filterErr = fmt.Errorf("no available node, %s", msg)
+	}
+	s.recordScheduleFilterResultEvent(pod, EventReasonFilteringFailed, "", filterErr)
+	return filterErr
+}
+
+// assignPod reserves devices for the pod on a node and records the assignment in the pod annotations.
+func (s *Scheduler) assignPod(pod *corev1.Pod, nodeID string, devices util.PodDevices, scores map[string]float32) error {
+	annotations := make(map[string]string)
+	annotations[util.AssignedNodeAnnotations] = nodeID
+	annotations[util.AssignedTimeAnnotations] = strconv.FormatInt(time.Now().Unix(), 10)
+
+	for _, val := range device.GetDevices() {
+		val.PatchAnnotations(pod, &annotations, devices)
+	}
+
+	s.addPod(pod, nodeID, devices)
+	s.setScores(pod.UID, scores)
+	err := util.PatchPodAnnotations(pod, annotations)
+	if err != nil {
+		s.delPod(pod)
+		return err
+	}
+	if err := s.allocations.reserve(context.Background(), pod, nodeID, devices, hamiv1alpha1.DeviceAllocationReserved); err != nil {
+		klog.ErrorS(err, "Failed to write device allocation", "pod", klog.KObj(pod))
+	}
+	return nil
+}
+
 func genSuccessMsg(totalNodes int, target string, nodes []*policy.NodeScore) string {
 	successMsg := "find fit node(%s), %d nodes not fit, %d nodes fit(%s)"
 	var scores []string
@@ -603,7 +603,7 @@ func Test_Filter(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			initNode()
 			client.KubeClient.CoreV1().Pods(test.args.Pod.Namespace).Create(context.Background(), test.args.Pod, metav1.CreateOptions{})
-			got, gotErr := s.Filter(test.args)
+			got, gotErr := s.Filter(context.Background(), test.args)
 			assert.DeepEqual(t, test.wantErr, gotErr)
 			assert.DeepEqual(t, test.want, got)
 			getPod, _ := client.KubeClient.CoreV1().Pods(test.args.Pod.Namespace).Get(context.Background(), test.args.Pod.Name, metav1.GetOptions{})
@@ -834,3 +834,47 @@ func Test_RegisterFromNodeAnnotations_NIL(t *testing.T) {
 		})
 	}
 }
+
+func Test_Filter_memoryOversubscription(t *testing.T) {
+	s := newTestScheduler(t)
+	for _, nodeID := range []string{"node1", "node2"} {
+		s.addNode(nodeID, &util.NodeInfo{
+			ID:   nodeID,
+			Node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeID}},
+			Devices: []util.DeviceInfo{{
+				ID:           nodeID + "-gpu0",
+				Count:        10,
+				Devmem:       8000,
+				Physmem:      4000,
+				Devcore:      100,
+				Mode:         "hami-core",
+				Type:         nvidia.NvidiaGPUDevice,
+				Health:       true,
+				DeviceVendor: nvidia.NvidiaGPUDevice,
+			}},
+		})
+	}
+	tolerant := map[string]string{util.MemoryOversubscriptionAnnotation: "true"}
+	running := explainTestPod(t, "running", 5000)
+	running.Annotations = tolerant
+	s.addPod(running, "node1", util.PodDevices{nvidia.NvidiaGPUDevice: util.PodSingleDevice{
+		{{UUID: "node1-gpu0", Type: nvidia.NvidiaGPUDevice, Usedmem: 5000}},
+	}})
+
+	// node1 is oversubscribed, so a pod that needs guaranteed memory only fits on node2.
+	res, err := s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: explainTestPod(t, "guaranteed", 1000), NodeNames: &[]string{"node1", "node2"}})
+	require.NoError(t, err)
+	require.NotNil(t, res.NodeNames)
+	assert.DeepEqual(t, []string{"node2"}, *res.NodeNames)
+	usage, _, err := s.getNodesUsage(&[]string{"node2"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1000), (*usage)["node2"].Devices.DeviceLists[0].Device.Guaranteedmem)
+
+	// node2 does not hand out host memory next to the guaranteed pod.
+	pod := explainTestPod(t, "tolerant", 3500)
+	pod.Annotations = tolerant
+	res, err = s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: pod, NodeNames: &[]string{"node1", "node2"}})
+	require.NoError(t, err)
+	assert.Assert(t, res.NodeNames == nil)
+	assert.Equal(t, 2, len(res.FailedNodes))
+}
@@ -16,9 +16,13 @@ limitations under the License.
 package scheduler
 
 import (
+	"context"
+	"maps"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	corev1 "k8s.io/api/core/v1"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
@@ -81,11 +85,20 @@ func fitInDevices(node *NodeUsage, requests util.ContainerDeviceRequests, annos
 			return false, nodeInsufficientDevice
 		}
 		sort.Sort(node.Devices)
-		_, ok := device.GetDevices()[k.Type]
+		vendor, ok := device.GetDevices()[k.Type]
 		if !ok {
 			return false, "Device type not found"
 		}
-		fit, tmpDevs, devreason := device.GetDevices()[k.Type].Fit(getNodeResources(*node, k.Type), k, annos, pod, nodeInfo, devinput)
+		// The devices may be shared with the usage cache and other Filters, and
+		// vendors may modify those they fit on: they are copied first.
+		devices := make([]*util.DeviceUsage, 0, len(node.Devices.DeviceLists))
+		for _, d := range node.Devices.DeviceLists {
+			if strings.Contains(d.Device.Type, k.Type) {
+				d.Device = cloneDeviceUsage(d.Device)
+				devices = append(devices, d.Device)
+			}
+		}
+		fit, tmpDevs, devreason := vendor.Fit(devices, k, annos, pod, nodeInfo, devinput)
 		reason := "node:" + node.Node.Name + " " + "resaon:" + devreason
 		if fit {
 			for idx, val := range tmpDevs[k.Type] {
@@ -100,7 +113,7 @@ func fitInDevices(node *NodeUsage, requests util.ContainerDeviceRequests, annos
 					free += v.Device.Count - v.Device.Used
 					freeCore += v.Device.Totalcore - v.Device.Usedcores
 					freeMem += v.Device.Totalmem - v.Device.Usedmem
-					err := device.GetDevices()[k.Type].AddResourceUsage(pod, node.Devices.DeviceLists[nidx].Device, &tmpDevs[k.Type][idx])
+					err := vendor.AddResourceUsage(pod, node.Devices.DeviceLists[nidx].Device, &tmpDevs[k.Type][idx])
 					if err != nil {
 						klog.Errorf("AddResourceUsage failed:%s", err.Error())
 						return false, "AddResourceUsage failed"
@@ -117,83 +130,187 @@ func fitInDevices(node *NodeUsage, requests util.ContainerDeviceRequests, annos
 	return true, ""
 }
 
-func (s *Scheduler) calcScore(nodes *map[string]*NodeUsage, resourceReqs util.PodDeviceRequests, annos map[string]string, task *corev1.Pod, failedNodes map[string]string) (*policy.NodeScoreList, error) {
-	userNodePolicy := config.NodeSchedulerPolicy
-	if annos != nil {
-		if value, ok := annos[policy.NodeSchedulerPolicyAnnotationKey]; ok {
-			userNodePolicy = value
+// fitInNode fits every container request of task on node, appending the
+// allocated devices to devinput. It returns false if any container does not fit.
+func fitInNode(node *NodeUsage, resourceReqs util.PodDeviceRequests, annos map[string]string, task *corev1.Pod, nodeInfo *util.NodeInfo, devinput *util.PodDevices) (bool, string) {
+	//This loop is for different container request
+	ctrfit := false
+	for ctrid, n := range resourceReqs {
+		sums := 0
+		for _, k := range n {
+			sums += int(k.Nums)
+		}
+
+		if sums == 0 {
+			for idx := range *devinput {
+				for len((*devinput)[idx]) < ctrid {
+					defaultContainerDevices := util.ContainerDevices{}
+					defaultPodSingleDevice := util.PodSingleDevice{}
+					defaultPodSingleDevice = append(defaultPodSingleDevice, defaultContainerDevices)
+					(*devinput)[idx] = append(defaultPodSingleDevice, (*devinput)[idx]...)
+				}
+				defaultContainerDevices := util.ContainerDevices{}
+				(*devinput)[idx] = append((*devinput)[idx], defaultContainerDevices)
+			}
 		}
+		klog.V(5).InfoS("fitInDevices", "pod", klog.KObj(task), "node", nodeInfo.ID)
+		fit, reason := fitInDevices(node, n, annos, task, nodeInfo, devinput)
+		ctrfit = fit
+		if !fit {
+			return false, reason
+		}
+	}
+	return ctrfit, ""
+}
+
+const (
+	// minFeasibleNodesToFind is the least number of nodes a pod is fitted on
+	// before Filter stops, as in kube-scheduler.
+	minFeasibleNodesToFind = 100
+	// minFeasibleNodesPercentageToFind is the least percentage of the nodes a
+	// pod is fitted on when the percentage adapts to the size of the cluster.
+	minFeasibleNodesPercentageToFind = 5
+)
+
+// numFeasibleNodesToFind returns how many nodes a pod must fit on before Filter
+// stops fitting it on the others, from config.PercentageOfNodesToScore as
+// kube-scheduler does, or 0 to fit it on every node.
+func numFeasibleNodesToFind(numAllNodes int) int {
+	percentage := config.PercentageOfNodesToScore
+	if numAllNodes < minFeasibleNodesToFind || percentage >= 100 {
+		return 0
 	}
+	if percentage <= 0 {
+		percentage = max(50-numAllNodes/125, minFeasibleNodesPercentageToFind)
+	}
+	return max(numAllNodes*percentage/100, minFeasibleNodesToFind)
+}
+
+// nodeFit is the result of fitting a task on a node: its score if the task
+// fits, else the reason it does not, if any.
+type nodeFit struct {
+	score  *policy.NodeScore
+	reason string
+	err    error
+}
+
+// NodeSchedulerPolicy returns the node policy of a pod with annos, the one of
+// its annotation or else the configured one.
+func NodeSchedulerPolicy(annos map[string]string) string {
+	if value, ok := annos[policy.NodeSchedulerPolicyAnnotationKey]; ok {
+		return value
+	}
+	return config.Current().NodeSchedulerPolicy
+}
+
+// calcScore fits a task on the nodes, config.FilterParallelism at a time, and
+// scores those it fits on. The nodes are fitted in the order of their names
+// and the scores are returned in that order. If feasibleNodes is not 0, the
+// nodes are fitted from an offset rotated on each call, and fitting stops once
+// the task fits on feasibleNodes of them.
+func (s *Scheduler) calcScore(ctx context.Context, nodes *map[string]*NodeUsage, resourceReqs util.PodDeviceRequests, annos map[string]string, task *corev1.Pod, failedNodes map[string]string, feasibleNodes int) (*policy.NodeScoreList, error) {
+	nodePolicy := NodeSchedulerPolicy(annos)
 	res := policy.NodeScoreList{
-		Policy:   userNodePolicy,
+		Policy:   nodePolicy,
 		NodeList: make([]*policy.NodeScore, 0),
 	}
 
-	wg := sync.WaitGroup{}
-	mutex := sync.Mutex{}
-	errCh := make(chan error, len(*nodes))
-	for nodeID, node := range *nodes {
-		wg.Add(1)
-		go func(nodeID string, node *NodeUsage) {
-			defer wg.Done()
+	pods := make(map[string]int)
+	for _, pi := range s.ListPodsInfo() {
+		pods[pi.NodeID]++
+	}
+	gpuPolicy := util.GetGPUSchedulerPolicyByPod(config.Current().GPUSchedulerPolicy, task)
 
-			viewStatus(*node)
-			score := policy.NodeScore{NodeID: nodeID, Node: node.Node, Devices: make(util.PodDevices), Score: 0}
-			score.ComputeDefaultScore(node.Devices)
-			snapshot := score.SnapshotDevice(node.Devices)
+	fit := func(nodeID string, node *NodeUsage) nodeFit {
+		// Fitting scores and sorts the devices, and adds the usage of the
+		// task to some of them: the usage of nodes is copied on write.
+		node = node.copyOnWrite(gpuPolicy)
+		viewStatus(*node)
+		score := policy.NodeScore{NodeID: nodeID, Node: node.Node, Devices: make(util.PodDevices), Score: 0}
+		snapshot := score.SnapshotDevice(node.Devices)
 
-			nodeInfo, err := s.GetNode(nodeID)
-			if err != nil {
-				klog.ErrorS(err, "Failed to get node", "nodeID", nodeID)
-				errCh <- err
-				return
-			}
+		nodeInfo, err := s.GetNode(nodeID)
+		if err != nil {
+			klog.ErrorS(err, "Failed to get node", "nodeID", nodeID)
+			return nodeFit{err: err}
+		}
 
-			//This loop is for different container request
-			ctrfit := false
-			for ctrid, n := range resourceReqs {
-				sums := 0
-				for _, k := range n {
-					sums += int(k.Nums)
-				}
+		ctrfit, reason := fitInNode(node, resourceReqs, annos, task, nodeInfo, &score.Devices)
+		if !ctrfit {
+			if reason != "" {
+				klog.V(4).InfoS(nodeUnfitPod, "pod", klog.KObj(task), "node", nodeID, "reason", reason)
+				return nodeFit{reason: nodeUnfitPod}
+			}
+			return nodeFit{}
+		}
+		if ok, reason := s.quota.fitQuota(task.Namespace, score.Devices); !ok {
+			klog.V(4).InfoS(namespaceQuotaExceeded, "pod", klog.KObj(task), "node", nodeID, "reason", reason)
+			return nodeFit{reason: reason}
+		}
+		score.ComputeScore(policy.ScoreArgs{NodeInfo: nodeInfo, Previous: snapshot, Pods: pods[nodeID], Policy: nodePolicy})
+		score.OverrideScore(snapshot, nodePolicy)
+		klog.V(4).InfoS(nodeFitPod, "pod", klog.KObj(task), "node", nodeID, "score", score.Score)
+		return nodeFit{score: &score}
+	}
 
-				if sums == 0 {
-					for idx := range score.Devices {
-						for len(score.Devices[idx]) < ctrid {
-							defaultContainerDevices := util.ContainerDevices{}
-							defaultPodSingleDevice := util.PodSingleDevice{}
-							defaultPodSingleDevice = append(defaultPodSingleDevice, defaultContainerDevices)
-							score.Devices[idx] = append(defaultPodSingleDevice, score.Devices[idx]...)
-						}
-						defaultContainerDevices := util.ContainerDevices{}
-						score.Devices[idx] = append(score.Devices[idx], defaultContainerDevices)
-					}
+	nodeIDs := slices.Sorted(maps.Keys(*nodes))
+	start := 0
+	if feasibleNodes <= 0 || feasibleNodes >= len(nodeIDs) {
+		feasibleNodes = len(nodeIDs)
+	} else {
+		start = int(s.nextStartNode.Load() % uint64(len(nodeIDs)))
+	}
+	fitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The workers take the nodes in order, so the nodes fitted are always the
+	// first ones, whichever worker stops first.
+	results := make([]nodeFit, len(nodeIDs))
+	var next, fits atomic.Int64
+	wg := sync.WaitGroup{}
+	for range max(1, min(config.FilterParallelism, len(nodeIDs))) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fitCtx.Err() == nil {
+				i := int(next.Add(1) - 1)
+				if i >= len(nodeIDs) {
+					return
 				}
-				klog.V(5).InfoS("fitInDevices", "pod", klog.KObj(task), "node", nodeID)
-				fit, reason := fitInDevices(node, n, annos, task, nodeInfo, &score.Devices)
-				ctrfit = fit
-				if !fit {
-					klog.V(4).InfoS(nodeUnfitPod, "pod", klog.KObj(task), "node", nodeID, "reason", reason)
-					failedNodes[nodeID] = nodeUnfitPod
-					break
+				nodeID := nodeIDs[(start+i)%len(nodeIDs)]
+				results[i] = fit(nodeID, (*nodes)[nodeID])
+				if results[i].score != nil && int(fits.Add(1)) >= feasibleNodes {
+					cancel()
 				}
 			}
-
-			if ctrfit {
-				mutex.Lock()
-				res.NodeList = append(res.NodeList, &score)
-				mutex.Unlock()
-				score.OverrideScore(snapshot, userNodePolicy)
-				klog.V(4).InfoS(nodeFitPod, "pod", klog.KObj(task), "node", nodeID, "score", score.Score)
-			}
-		}(nodeID, node)
+		}()
 	}
 	wg.Wait()
-	close(errCh)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
+	// Only the results up to the feasibleNodes-th node the task fits on are
+	// kept, whatever the workers fitted past it.
 	var errorsSlice []error
-	for e := range errCh {
-		errorsSlice = append(errorsSlice, e)
+	fitted := 0
+	for i := range min(int(next.Load()), len(nodeIDs)) {
+		if len(res.NodeList) == feasibleNodes {
+			break
+		}
+		fitted++
+		r := results[i]
+		switch {
+		case r.err != nil:
+			errorsSlice = append(errorsSlice, r.err)
+		case r.score != nil:
+			res.NodeList = append(res.NodeList, r.score)
+		case r.reason != "":
+			failedNodes[nodeIDs[(start+i)%len(nodeIDs)]] = r.reason
+		}
+	}
+	if feasibleNodes < len(nodeIDs) {
+		s.nextStartNode.Add(uint64(fitted))
 	}
+	klog.V(4).InfoS("Fitted pod on nodes", "pod", klog.KObj(task), "nodes", len(nodeIDs), "fitted", fitted, "fits", len(res.NodeList))
 	return &res, utilerrors.NewAggregate(errorsSlice)
 }
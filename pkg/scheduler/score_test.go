@@ -17,6 +17,8 @@ limitations under the License.
 package scheduler
 
 import (
+	"context"
+	"fmt"
 	"strconv"
 	"strings"
 	"testing"
@@ -32,6 +34,8 @@ import (
 	"github.com/Project-HAMi/HAMi/pkg/device/kunlun"
 	"github.com/Project-HAMi/HAMi/pkg/device/metax"
 	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/k8sutil"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
 	"github.com/Project-HAMi/HAMi/pkg/scheduler/policy"
 	"github.com/Project-HAMi/HAMi/pkg/util"
 )
@@ -2420,7 +2424,7 @@ func Test_calcScore(t *testing.T) {
 				s.addNode(nodeName, &util.NodeInfo{nodeName, nodeUsage.Node, devices})
 			}
 			failedNodes := map[string]string{}
-			got, gotErr := s.calcScore(test.args.nodes, test.args.nums, test.args.annos, test.args.task, failedNodes)
+			got, gotErr := s.calcScore(context.Background(), test.args.nodes, test.args.nums, test.args.annos, test.args.task, failedNodes, 0)
 			assert.DeepEqual(t, test.wants.err, gotErr)
 			wantMap := make(map[string]*policy.NodeScore)
 			for index, node := range (*(test.wants.want)).NodeList {
@@ -3352,3 +3356,114 @@ func Test_Nvidia_GPU_Topology(t *testing.T) {
 		})
 	}
 }
+
+func Test_numFeasibleNodesToFind(t *testing.T) {
+	percentage := config.PercentageOfNodesToScore
+	t.Cleanup(func() { config.PercentageOfNodesToScore = percentage })
+
+	tests := []struct {
+		percentage int
+		nodes      int
+		want       int
+	}{
+		{percentage: 100, nodes: 5000, want: 0},
+		{percentage: 50, nodes: 50, want: 0},
+		{percentage: 50, nodes: 1000, want: 500},
+		{percentage: 1, nodes: 1000, want: 100},
+		{percentage: 0, nodes: 1000, want: 420},
+		{percentage: 0, nodes: 5000, want: 500},
+		{percentage: 0, nodes: 20000, want: 1000},
+	}
+	for _, test := range tests {
+		config.PercentageOfNodesToScore = test.percentage
+		assert.Equal(t, numFeasibleNodesToFind(test.nodes), test.want, "%d%% of %d nodes", test.percentage, test.nodes)
+	}
+}
+
+// newCalcScoreTestScheduler returns a scheduler with the nodes node000 to
+// node<n-1>, the pods of calcScoreTestPod fit on those that are not unfit, and
+// their usage.
+func newCalcScoreTestScheduler(t *testing.T, n int, unfit func(i int) bool) (*Scheduler, map[string]*NodeUsage) {
+	s := newTestScheduler(t)
+	s.rmNode("node1")
+	s.rmNode("node2")
+	nodes := make([]string, 0, n)
+	for i := range n {
+		nodeID := fmt.Sprintf("node%03d", i)
+		devmem := int32(8000)
+		if unfit(i) {
+			devmem = 1000
+		}
+		s.addNode(nodeID, &util.NodeInfo{
+			ID:   nodeID,
+			Node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeID}},
+			Devices: []util.DeviceInfo{{
+				ID: nodeID + "-gpu0", Count: 10, Devmem: devmem, Devcore: 100,
+				Mode: "hami-core", Type: nvidia.NvidiaGPUDevice, Health: true, DeviceVendor: nvidia.NvidiaGPUDevice,
+			}},
+		})
+		nodes = append(nodes, nodeID)
+	}
+	usage, failedNodes := s.sharedNodesUsage(nodes)
+	assert.Equal(t, len(failedNodes), 0)
+	return s, usage
+}
+
+func calcScoreNodeIDs(scores *policy.NodeScoreList) []string {
+	ids := make([]string, 0, len(scores.NodeList))
+	for _, score := range scores.NodeList {
+		ids = append(ids, score.NodeID)
+	}
+	return ids
+}
+
+func Test_calcScore_parallel(t *testing.T) {
+	parallelism := config.FilterParallelism
+	config.FilterParallelism = 4
+	t.Cleanup(func() { config.FilterParallelism = parallelism })
+	s, usage := newCalcScoreTestScheduler(t, 250, func(i int) bool { return i%3 == 0 })
+	pod := explainTestPod(t, "pod", 2000)
+	resourceReqs := k8sutil.Resourcereqs(pod)
+
+	var fits []string
+	unfits := map[string]string{}
+	for i := range 250 {
+		if i%3 == 0 {
+			unfits[fmt.Sprintf("node%03d", i)] = nodeUnfitPod
+		} else {
+			fits = append(fits, fmt.Sprintf("node%03d", i))
+		}
+	}
+	for range 3 {
+		failedNodes := map[string]string{}
+		scores, err := s.calcScore(context.Background(), &usage, resourceReqs, nil, pod, failedNodes, 0)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, calcScoreNodeIDs(scores), fits)
+		assert.DeepEqual(t, failedNodes, unfits)
+	}
+	assert.Equal(t, s.nextStartNode.Load(), uint64(0), "the nodes are not rotated when every node is fitted")
+
+	// Two nodes in three fit: the 100th is node149.
+	failedNodes := map[string]string{}
+	scores, err := s.calcScore(context.Background(), &usage, resourceReqs, nil, pod, failedNodes, 100)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, calcScoreNodeIDs(scores), fits[:100])
+	assert.Equal(t, len(failedNodes), 50, "only the nodes before the last fit are reported")
+	assert.Equal(t, s.nextStartNode.Load(), uint64(150))
+
+	failedNodes = map[string]string{}
+	scores, err = s.calcScore(context.Background(), &usage, resourceReqs, nil, pod, failedNodes, 100)
+	assert.NilError(t, err)
+	assert.Equal(t, len(scores.NodeList), 100)
+	assert.Equal(t, scores.NodeList[0].NodeID, "node151", "the next call starts after the nodes fitted")
+	assert.Equal(t, scores.NodeList[99].NodeID, "node050", "the nodes wrap around")
+}
+
+func Test_calcScore_cancelled(t *testing.T) {
+	s, usage := newCalcScoreTestScheduler(t, 10, func(int) bool { return false })
+	pod := explainTestPod(t, "pod", 2000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := s.calcScore(ctx, &usage, k8sutil.Resourcereqs(pod), nil, pod, map[string]string{}, 0)
+	assert.ErrorIs(t, err, context.Canceled)
+}
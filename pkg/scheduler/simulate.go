@@ -0,0 +1,259 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/device"
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/k8sutil"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// SimulationOptions override the device plugin config of every node in a
+// simulation, to try a config before rolling it out.
+type SimulationOptions struct {
+	// DeviceSplitCount replaces the split count of NVIDIA GPUs, if not 0.
+	DeviceSplitCount int32
+	// DeviceMemoryScaling replaces the memory scaling of NVIDIA GPUs, if not 0.
+	// The registered memory is taken as scaled by RegisteredMemoryScaling,
+	// unless the node registered the physical memory of the device.
+	DeviceMemoryScaling     float64
+	RegisteredMemoryScaling float64
+}
+
+// PodPlacement is where a simulation placed a pending pod, or why it did not.
+type PodPlacement struct {
+	Namespace string          `json:"namespace"`
+	Name      string          `json:"name"`
+	Node      string          `json:"node,omitempty"`
+	Score     float32         `json:"score,omitempty"`
+	Devices   util.PodDevices `json:"devices,omitempty"`
+	// Reason is why the pod is unschedulable, FailedNodes why each node was rejected.
+	Reason      string            `json:"reason,omitempty"`
+	FailedNodes map[string]string `json:"failedNodes,omitempty"`
+}
+
+// NodeFragmentation is how scattered the free device memory of a node is once
+// the pending pods are placed.
+type NodeFragmentation struct {
+	Node string `json:"node"`
+	// FreeMem is the free memory of the devices that can still take a pod,
+	// LargestFreeMem the largest of them.
+	FreeMem        int32 `json:"freeMem"`
+	LargestFreeMem int32 `json:"largestFreeMem"`
+	// StrandedMem is the free memory of devices without a free slot or cores.
+	StrandedMem int32 `json:"strandedMem"`
+	// Fragmentation is 1 - LargestFreeMem/FreeMem, 0 if all free memory is on one device.
+	Fragmentation float64 `json:"fragmentation"`
+}
+
+type SimulationResult struct {
+	Placements    []PodPlacement      `json:"placements"`
+	Nodes         []NodeFragmentation `json:"nodes"`
+	Scheduled     int                 `json:"scheduled"`
+	Unschedulable int                 `json:"unschedulable"`
+}
+
+// Simulate schedules the pending pods of a cluster snapshot one after the other
+// with the current scheduler config, as Filter would, without an API server.
+// Pods already assigned by HAMi are accounted on their nodes, pending pods are
+// tried in priority order. Pod groups and preemption are not simulated.
+// Devices must have been initialized.
+func Simulate(nodes []*corev1.Node, pods []*corev1.Pod, opts SimulationOptions) *SimulationResult {
+	s := NewScheduler()
+	for _, node := range nodes {
+		s.registerSimulatedNode(node, opts)
+	}
+	pending := make([]*corev1.Pod, 0)
+	for _, p := range pods {
+		pod := p.DeepCopy()
+		if pod.UID == "" {
+			pod.UID = k8stypes.UID(pod.Namespace + "/" + pod.Name)
+		}
+		if k8sutil.IsPodInTerminatedState(pod) {
+			continue
+		}
+		if _, ok := pod.Annotations[util.AssignedNodeAnnotations]; ok {
+			s.onAddPod(pod)
+			continue
+		}
+		if pod.Spec.NodeName == "" {
+			pending = append(pending, pod)
+		}
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return podPriority(pending[i]) > podPriority(pending[j])
+	})
+
+	res := &SimulationResult{Placements: make([]PodPlacement, 0), Nodes: make([]NodeFragmentation, 0)}
+	allNodes, _ := s.ListNodes()
+	nodeNames := make([]string, 0, len(allNodes))
+	for nodeID := range allNodes {
+		nodeNames = append(nodeNames, nodeID)
+	}
+	sort.Strings(nodeNames)
+	for _, pod := range pending {
+		resourceReqs := k8sutil.Resourcereqs(pod)
+		if !RequestsDevices(resourceReqs) {
+			continue
+		}
+		placement := s.simulatePod(pod, resourceReqs, nodeNames)
+		if placement.Node != "" {
+			res.Scheduled++
+		} else {
+			res.Unschedulable++
+		}
+		res.Placements = append(res.Placements, placement)
+	}
+
+	usage, _, _ := s.getNodesUsage(&nodeNames, nil)
+	for _, nodeID := range nodeNames {
+		if node, ok := (*usage)[nodeID]; ok {
+			res.Nodes = append(res.Nodes, nodeFragmentation(nodeID, node))
+		}
+	}
+	return res
+}
+
+// registerSimulatedNode registers the devices of a node like
+// RegisterFromNodeAnnotations does, with the config overrides applied.
+func (s *Scheduler) registerSimulatedNode(node *corev1.Node, opts SimulationOptions) {
+	for vendor, devInstance := range device.GetDevices() {
+		nodedevices, err := devInstance.GetNodeDevices(*node)
+		if err != nil {
+			continue
+		}
+		nodeInfo := &util.NodeInfo{ID: node.Name, Node: node, Devices: make([]util.DeviceInfo, 0)}
+		for _, d := range nodedevices {
+			if vendor == nvidia.NvidiaGPUDevice && d.Mode != nvidia.MigMode {
+				if opts.DeviceSplitCount > 0 {
+					d.Count = opts.DeviceSplitCount
+				}
+				if opts.DeviceMemoryScaling > 0 && opts.RegisteredMemoryScaling > 0 {
+					physmem := float64(d.Devmem) / opts.RegisteredMemoryScaling
+					if d.Physmem > 0 {
+						physmem = float64(d.Physmem)
+					}
+					d.Devmem = int32(physmem * opts.DeviceMemoryScaling)
+					d.Physmem = 0
+					if opts.DeviceMemoryScaling > 1 {
+						d.Physmem = int32(physmem)
+					}
+				}
+			}
+			nodeInfo.Devices = append(nodeInfo.Devices, *d)
+		}
+		s.addNode(node.Name, nodeInfo)
+	}
+}
+
+// simulatePod places a pod on the best node and accounts its devices there.
+func (s *Scheduler) simulatePod(pod *corev1.Pod, resourceReqs util.PodDeviceRequests, nodeNames []string) PodPlacement {
+	placement := PodPlacement{Namespace: pod.Namespace, Name: pod.Name}
+	nodeUsage, failedNodes, err := s.getNodesUsage(&nodeNames, pod)
+	if err != nil {
+		placement.Reason = err.Error()
+		return placement
+	}
+	nodeScores, err := s.calcScore(context.Background(), nodeUsage, resourceReqs, pod.Annotations, pod, failedNodes, 0)
+	if err != nil {
+		placement.Reason = fmt.Sprintf("calcScore failed %v", err)
+		return placement
+	}
+	if len(nodeScores.NodeList) == 0 {
+		placement.Reason = fmt.Sprintf("no available node, %d nodes do not meet", len(nodeNames))
+		placement.FailedNodes = s.unfitReasons(pod, resourceReqs, failedNodes)
+		return placement
+	}
+	sort.Sort(nodeScores)
+	m := nodeScores.NodeList[len(nodeScores.NodeList)-1]
+	klog.V(4).InfoS("Simulated pod placement", "pod", klog.KObj(pod), "node", m.NodeID, "score", m.Score)
+	s.addPod(pod, m.NodeID, m.Devices)
+	placement.Node = m.NodeID
+	placement.Score = m.Score
+	placement.Devices = m.Devices
+	return placement
+}
+
+// unfitReasons replaces the generic reason calcScore records for nodes a pod
+// does not fit on with the reason of the device that rejected it.
+func (s *Scheduler) unfitReasons(pod *corev1.Pod, resourceReqs util.PodDeviceRequests, failedNodes map[string]string) map[string]string {
+	unfit := make([]string, 0)
+	for nodeID, reason := range failedNodes {
+		if reason == nodeUnfitPod {
+			unfit = append(unfit, nodeID)
+		}
+	}
+	usage, _, err := s.getNodesUsage(&unfit, pod)
+	if err != nil {
+		return failedNodes
+	}
+	for _, nodeID := range unfit {
+		nodeInfo, err := s.GetNode(nodeID)
+		if err != nil {
+			continue
+		}
+		devices := make(util.PodDevices)
+		if fit, reason := fitInNode((*usage)[nodeID], resourceReqs, pod.Annotations, pod, nodeInfo, &devices); !fit && reason != "" {
+			failedNodes[nodeID] = reason
+		}
+	}
+	return failedNodes
+}
+
+func nodeFragmentation(nodeID string, node *NodeUsage) NodeFragmentation {
+	f := NodeFragmentation{Node: nodeID}
+	for _, d := range node.Devices.DeviceLists {
+		if !d.Device.Health {
+			continue
+		}
+		free := d.Device.Totalmem - d.Device.Usedmem
+		if free <= 0 {
+			continue
+		}
+		if d.Device.Used >= d.Device.Count || (d.Device.Totalcore > 0 && d.Device.Usedcores >= d.Device.Totalcore) {
+			f.StrandedMem += free
+			continue
+		}
+		f.FreeMem += free
+		f.LargestFreeMem = max(f.LargestFreeMem, free)
+	}
+	if f.FreeMem > 0 {
+		f.Fragmentation = 1 - float64(f.LargestFreeMem)/float64(f.FreeMem)
+	}
+	return f
+}
+
+// RequestsDevices reports whether any container requests devices.
+func RequestsDevices(resourceReqs util.PodDeviceRequests) bool {
+	for _, ctr := range resourceReqs {
+		for _, req := range ctr {
+			if req.Nums > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/device"
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/policy"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+func simTestNode(name string, gpus int) *corev1.Node {
+	devices := make([]*util.DeviceInfo, 0, gpus)
+	for i := range gpus {
+		devices = append(devices, &util.DeviceInfo{
+			ID:      name + "-gpu" + string(rune('0'+i)),
+			Index:   uint(i),
+			Count:   2,
+			Devmem:  8000,
+			Devcore: 100,
+			Type:    "NVIDIA-A10",
+			Mode:    "hami-core",
+			Health:  true,
+		})
+	}
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        name,
+		Annotations: map[string]string{nvidia.RegisterAnnos: util.EncodeNodeDevices(devices)},
+	}}
+}
+
+func simTestPod(name string, gpus, mem int64, annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "main",
+				Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{
+						"hami.io/gpu":    *resource.NewQuantity(gpus, resource.BinarySI),
+						"hami.io/gpumem": *resource.NewQuantity(mem, resource.BinarySI),
+					},
+				},
+			}},
+		},
+	}
+}
+
+func initSimTestDevices(t *testing.T) {
+	err := device.InitDevicesWithConfig(&device.Config{
+		NvidiaConfig: nvidia.NvidiaConfig{
+			ResourceCountName:            "hami.io/gpu",
+			ResourceMemoryName:           "hami.io/gpumem",
+			ResourceMemoryPercentageName: "hami.io/gpumem-percentage",
+			ResourceCoreName:             "hami.io/gpucores",
+			DefaultGPUNum:                1,
+		},
+	})
+	require.NoError(t, err)
+}
+
+func Test_Simulate(t *testing.T) {
+	initSimTestDevices(t)
+	// running is already assigned the first GPU of node1.
+	running := simTestPod("running", 1, 6000, util.EncodePodDevices(util.SupportDevices, util.PodDevices{
+		nvidia.NvidiaGPUDevice: util.PodSingleDevice{{{Idx: 0, UUID: "node1-gpu0", Type: nvidia.NvidiaGPUDevice, Usedmem: 6000}}},
+	}))
+	running.Annotations[util.AssignedNodeAnnotations] = "node1"
+	running.Spec.NodeName = "node1"
+	bound := simTestPod("bound-elsewhere", 1, 1000, nil)
+	bound.Spec.NodeName = "node1"
+	high := simTestPod("high", 1, 8000, nil)
+	priority := int32(100)
+	high.Spec.Priority = &priority
+	pods := []*corev1.Pod{
+		running,
+		bound,
+		simTestPod("small", 1, 2000, nil),
+		simTestPod("too-big", 1, 9000, nil),
+		high,
+		{ObjectMeta: metav1.ObjectMeta{Name: "cpu-only", Namespace: "default"}, Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "main"}}}},
+	}
+
+	res := Simulate([]*corev1.Node{simTestNode("node1", 2)}, pods, SimulationOptions{})
+
+	require.Len(t, res.Placements, 3, "pods already bound or without devices are not simulated")
+	assert.Equal(t, 2, res.Scheduled)
+	assert.Equal(t, 1, res.Unschedulable)
+	assert.Equal(t, "high", res.Placements[0].Name, "pods are tried in priority order")
+	assert.Equal(t, "node1", res.Placements[0].Node)
+	assert.Equal(t, "node1-gpu1", res.Placements[0].Devices[nvidia.NvidiaGPUDevice][0][0].UUID)
+	assert.Equal(t, "small", res.Placements[1].Name)
+	assert.Equal(t, "node1", res.Placements[1].Node)
+	assert.Equal(t, "too-big", res.Placements[2].Name)
+	assert.Empty(t, res.Placements[2].Node)
+	assert.Contains(t, res.Placements[2].FailedNodes["node1"], "CardInsufficientMemory", "the reason of the device is reported")
+
+	require.Len(t, res.Nodes, 1)
+	assert.Equal(t, NodeFragmentation{Node: "node1", FreeMem: 0, LargestFreeMem: 0, StrandedMem: 0}, res.Nodes[0])
+}
+
+func Test_Simulate_options(t *testing.T) {
+	initSimTestDevices(t)
+	pods := []*corev1.Pod{
+		simTestPod("a", 1, 1000, nil),
+		simTestPod("b", 1, 1000, nil),
+		simTestPod("c", 1, 1000, nil),
+	}
+	nodes := []*corev1.Node{simTestNode("node1", 1)}
+
+	res := Simulate(nodes, pods, SimulationOptions{})
+	assert.Equal(t, 2, res.Scheduled)
+	assert.Equal(t, NodeFragmentation{Node: "node1", StrandedMem: 6000}, res.Nodes[0], "a GPU without free slots strands its memory")
+
+	res = Simulate(nodes, pods, SimulationOptions{DeviceSplitCount: 4, DeviceMemoryScaling: 2, RegisteredMemoryScaling: 1})
+	assert.Equal(t, 3, res.Scheduled)
+	assert.Equal(t, NodeFragmentation{Node: "node1", FreeMem: 13000, LargestFreeMem: 13000}, res.Nodes[0])
+}
+
+func Test_nodeFragmentation(t *testing.T) {
+	node := &NodeUsage{}
+	for _, d := range []*util.DeviceUsage{
+		{ID: "gpu0", Count: 10, Used: 1, Totalmem: 8000, Usedmem: 2000, Totalcore: 100, Health: true},
+		{ID: "gpu1", Count: 10, Used: 1, Totalmem: 8000, Usedmem: 6000, Totalcore: 100, Health: true},
+		{ID: "gpu2", Count: 10, Used: 1, Totalmem: 8000, Usedmem: 4000, Totalcore: 100, Usedcores: 100, Health: true},
+		{ID: "gpu3", Count: 10, Totalmem: 8000, Totalcore: 100},
+	} {
+		node.Devices.DeviceLists = append(node.Devices.DeviceLists, &policy.DeviceListsScore{Device: d})
+	}
+	f := nodeFragmentation("node1", node)
+	assert.Equal(t, int32(8000), f.FreeMem)
+	assert.Equal(t, int32(6000), f.LargestFreeMem)
+	assert.Equal(t, int32(4000), f.StrandedMem, "a GPU without free cores strands its memory")
+	assert.InDelta(t, 0.25, f.Fragmentation, 0.001)
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"maps"
+	"slices"
+	"sync"
+
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/policy"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// usageCache keeps the usage of the devices of each node, so that it is
+// rebuilt only for the nodes whose devices or pods changed since it was last
+// read. Its zero value is ready to use.
+type usageCache struct {
+	mutex sync.Mutex
+	// nodeVersion and podVersion are the versions of the node and pod
+	// managers all was built from.
+	nodeVersion uint64
+	podVersion  uint64
+	entries     map[string]*usageEntry
+	// all is the usage of every node. It is replaced, never updated, when a
+	// node changes, and must not be modified.
+	all map[string]*NodeUsage
+}
+
+// usageEntry is the usage of a node, with the versions of its devices and its
+// pods it was built from.
+type usageEntry struct {
+	usage       *NodeUsage
+	nodeVersion uint64
+	podVersion  uint64
+}
+
+// nodesUsage returns the usage of every node, rebuilding that of the nodes
+// that changed. The usage returned must not be modified, see clone.
+func (s *Scheduler) nodesUsage() map[string]*NodeUsage {
+	c := &s.usage
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	nm, pm := s.nodeManager, s.podManager
+	nm.mutex.RLock()
+	defer nm.mutex.RUnlock()
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	if c.all != nil && c.nodeVersion == nm.version && c.podVersion == pm.version {
+		return c.all
+	}
+	all := make(map[string]*NodeUsage, len(nm.nodes))
+	entries := make(map[string]*usageEntry, len(nm.nodes))
+	rebuilt := 0
+	for nodeID, node := range nm.nodes {
+		nodeVersion, podVersion := nm.versions[nodeID], pm.nodeVersions[nodeID]
+		e, ok := c.entries[nodeID]
+		if !ok || e.nodeVersion != nodeVersion || e.podVersion != podVersion {
+			e = &usageEntry{usage: newNodeUsage(node, nil), nodeVersion: nodeVersion, podVersion: podVersion}
+			for _, p := range pm.nodePods[nodeID] {
+				addNodeDevicesUsage(e.usage, p.Devices, p.TolerateOversubscription)
+			}
+			rebuilt++
+		}
+		entries[nodeID] = e
+		all[nodeID] = e.usage
+	}
+	c.entries, c.all = entries, all
+	c.nodeVersion, c.podVersion = nm.version, pm.version
+	klog.V(5).InfoS("Refreshed node usage", "nodeCount", len(all), "rebuiltCount", rebuilt)
+	return all
+}
+
+// sharedNodesUsage returns the usage of nodes, shared with the cache and the
+// other calls: it must not be modified, calcScore copies it on write. The
+// nodes that are not registered are returned as failed.
+func (s *Scheduler) sharedNodesUsage(nodes []string) (map[string]*NodeUsage, map[string]string) {
+	all := s.nodesUsage()
+	usage := make(map[string]*NodeUsage, len(nodes))
+	failedNodes := make(map[string]string)
+	for _, nodeID := range nodes {
+		node, ok := all[nodeID]
+		if !ok {
+			// The identified node does not have a gpu device, so the log here has no practical meaning,increase log priority.
+			klog.V(5).InfoS("node unregistered", "node", nodeID)
+			failedNodes[nodeID] = "node unregistered"
+			continue
+		}
+		usage[nodeID] = node
+	}
+	return usage, failedNodes
+}
+
+// clone returns a copy of the usage that can be modified without changing
+// the original. The node is shared.
+func (n *NodeUsage) clone() *NodeUsage {
+	c := &NodeUsage{
+		Node: n.Node,
+		Devices: policy.DeviceUsageList{
+			Policy:      n.Devices.Policy,
+			DeviceLists: make([]*policy.DeviceListsScore, 0, len(n.Devices.DeviceLists)),
+		},
+	}
+	for _, d := range n.Devices.DeviceLists {
+		c.Devices.DeviceLists = append(c.Devices.DeviceLists, &policy.DeviceListsScore{Device: cloneDeviceUsage(d.Device), Score: d.Score})
+	}
+	return c
+}
+
+// copyOnWrite returns a copy of the usage whose devices can be scored and
+// sorted, with the GPU policy set. The devices are shared with the original:
+// fitInDevices copies them with cloneDeviceUsage before it gives them to the
+// vendors, whose Fit may modify them.
+func (n *NodeUsage) copyOnWrite(gpuPolicy string) *NodeUsage {
+	scores := make([]policy.DeviceListsScore, len(n.Devices.DeviceLists))
+	c := &NodeUsage{
+		Node: n.Node,
+		Devices: policy.DeviceUsageList{
+			Policy:      gpuPolicy,
+			DeviceLists: make([]*policy.DeviceListsScore, len(n.Devices.DeviceLists)),
+		},
+	}
+	for i, d := range n.Devices.DeviceLists {
+		scores[i] = *d
+		c.Devices.DeviceLists[i] = &scores[i]
+	}
+	return c
+}
+
+// cloneDeviceUsage returns a copy of the usage of a device that can be
+// modified without changing the original.
+func cloneDeviceUsage(d *util.DeviceUsage) *util.DeviceUsage {
+	c := *d
+	c.MigUsage.UsageList = slices.Clone(d.MigUsage.UsageList)
+	c.CustomInfo = maps.Clone(d.CustomInfo)
+	return &c
+}
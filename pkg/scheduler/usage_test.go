@@ -0,0 +1,329 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"github.com/Project-HAMi/HAMi/pkg/device"
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+	"github.com/Project-HAMi/HAMi/pkg/k8sutil"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+func usageTestPod(name, nodeID string, mem int32) (*corev1.Pod, util.PodDevices) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: k8stypes.UID(name)}}
+	devices := util.PodDevices{nvidia.NvidiaGPUDevice: util.PodSingleDevice{
+		{{UUID: nodeID + "-gpu0", Type: nvidia.NvidiaGPUDevice, Usedmem: mem, Usedcores: 10}},
+	}}
+	return pod, devices
+}
+
+func Test_nodesUsage(t *testing.T) {
+	s := newTestScheduler(t)
+	pod, devices := usageTestPod("pod1", "node1", 1000)
+	s.addPod(pod, "node1", devices)
+
+	usage := s.nodesUsage()
+	require.Len(t, usage, 2)
+	assert.Equal(t, int32(1000), usage["node1"].Devices.DeviceLists[0].Device.Usedmem)
+	assert.Equal(t, int32(0), usage["node2"].Devices.DeviceLists[0].Device.Usedmem)
+	node1, node2 := usage["node1"], usage["node2"]
+
+	pod2, devices2 := usageTestPod("pod2", "node2", 2000)
+	s.addPod(pod2, "node2", devices2)
+	usage = s.nodesUsage()
+	assert.Same(t, node1, usage["node1"], "the usage of unchanged nodes is kept")
+	assert.NotSame(t, node2, usage["node2"])
+	assert.Equal(t, int32(2000), usage["node2"].Devices.DeviceLists[0].Device.Usedmem)
+	assert.Equal(t, int32(0), node2.Devices.DeviceLists[0].Device.Usedmem, "the usage is replaced, not updated")
+
+	s.delPod(pod)
+	usage = s.nodesUsage()
+	assert.Equal(t, int32(0), usage["node1"].Devices.DeviceLists[0].Device.Usedmem)
+
+	s.rmNode("node2")
+	usage = s.nodesUsage()
+	assert.NotContains(t, usage, "node2")
+	assert.Equal(t, reflect.ValueOf(usage).Pointer(), reflect.ValueOf(s.nodesUsage()).Pointer(), "the usage is not rebuilt if nothing changed")
+}
+
+func Test_getNodesUsage_copies(t *testing.T) {
+	s := newTestScheduler(t)
+	nodes := []string{"node1", "node3"}
+	usage, failedNodes, err := s.getNodesUsage(&nodes, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"node3": "node unregistered"}, failedNodes)
+	require.Len(t, *usage, 1)
+
+	(*usage)["node1"].Devices.DeviceLists[0].Device.Used++
+	again, _, err := s.getNodesUsage(&nodes, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), (*again)["node1"].Devices.DeviceLists[0].Device.Used, "each call gets its own copy")
+}
+
+func Test_copyOnWrite(t *testing.T) {
+	s := newTestScheduler(t)
+	shared := s.nodesUsage()["node1"]
+	nodeInfo, err := s.GetNode("node1")
+	require.NoError(t, err)
+	pod := explainTestPod(t, "pod", 1000)
+
+	usage := shared.copyOnWrite(util.GPUSchedulerPolicySpread.String())
+	devices := util.PodDevices{}
+	fit, reason := fitInNode(usage, k8sutil.Resourcereqs(pod), nil, pod, nodeInfo, &devices)
+	require.True(t, fit, reason)
+	assert.Equal(t, int32(1), usage.Devices.DeviceLists[0].Device.Used)
+	assert.Equal(t, util.GPUSchedulerPolicySpread.String(), usage.Devices.Policy)
+	assert.Equal(t, int32(0), shared.Devices.DeviceLists[0].Device.Used, "the shared usage is not modified")
+	assert.Equal(t, float32(0), shared.Devices.DeviceLists[0].Score)
+}
+
+func Test_Filter_concurrent(t *testing.T) {
+	s := newTestScheduler(t)
+	s.explain = newExplainBuffer(4)
+	pods := make([]*corev1.Pod, 0, 10)
+	for i := range 10 {
+		pods = append(pods, explainTestPod(t, fmt.Sprintf("pod%d", i), 500))
+	}
+	node1, err := s.GetNode("node1")
+	require.NoError(t, err)
+
+	// Filters run concurrently with the registration of nodes and the metrics.
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+	repeat := func(f func()) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					f()
+				}
+			}
+		}()
+	}
+	repeat(func() {
+		s.addNode("node1", &util.NodeInfo{ID: "node1", Node: node1.Node, Devices: node1.Devices})
+	})
+	repeat(func() {
+		for _, usage := range *s.InspectAllNodesUsage() {
+			for _, d := range usage.Devices.DeviceLists {
+				_ = d.Device.Usedmem
+			}
+		}
+		nodes, _ := s.ListNodes()
+		for _, node := range nodes {
+			_ = len(node.Devices)
+		}
+	})
+	var filters sync.WaitGroup
+	for _, pod := range pods {
+		filters.Add(1)
+		go func() {
+			defer filters.Done()
+			res, err := s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: pod, NodeNames: &[]string{"node1", "node2"}})
+			assert.NoError(t, err)
+			if assert.NotNil(t, res) && assert.NotNil(t, res.NodeNames) {
+				assert.Len(t, *res.NodeNames, 1)
+			}
+		}()
+	}
+	filters.Wait()
+	close(stop)
+	background.Wait()
+
+	used, usedmem := int32(0), int32(0)
+	for _, usage := range s.nodesUsage() {
+		for _, d := range usage.Devices.DeviceLists {
+			used += d.Device.Used
+			usedmem += d.Device.Usedmem
+		}
+	}
+	assert.Equal(t, int32(10), used)
+	assert.Equal(t, int32(5000), usedmem)
+}
+
+// customInfoVendor is a vendor whose Fit records the pod in the CustomInfo of
+// the devices it is given, as the Fit of AWS Neuron devices records their core
+// usage.
+type customInfoVendor struct {
+	device.Devices
+}
+
+func (v customInfoVendor) Fit(devices []*util.DeviceUsage, request util.ContainerDeviceRequest, annos map[string]string, pod *corev1.Pod, nodeInfo *util.NodeInfo, allocated *util.PodDevices) (bool, map[string]util.ContainerDevices, string) {
+	for _, d := range devices {
+		d.CustomInfo["fitted"] = pod.Name
+	}
+	return v.Devices.Fit(devices, request, annos, pod, nodeInfo, allocated)
+}
+
+func Test_Filter_concurrentFit(t *testing.T) {
+	s := newTestScheduler(t)
+	devices := device.GetDevices()
+	vendor := devices[nvidia.NvidiaGPUDevice]
+	devices[nvidia.NvidiaGPUDevice] = customInfoVendor{Devices: vendor}
+	t.Cleanup(func() { devices[nvidia.NvidiaGPUDevice] = vendor })
+	for _, nodeID := range []string{"node1", "node2"} {
+		nodeInfo, err := s.GetNode(nodeID)
+		require.NoError(t, err)
+		nodeDevices := slices.Clone(nodeInfo.Devices)
+		nodeDevices[0].CustomInfo = map[string]any{}
+		s.addNode(nodeID, &util.NodeInfo{ID: nodeID, Node: nodeInfo.Node, Devices: nodeDevices})
+	}
+
+	pod := explainTestPod(t, "fitted", 1000)
+	usage, _ := s.sharedNodesUsage([]string{"node1", "node2"})
+	_, err := s.calcScore(context.Background(), &usage, k8sutil.Resourcereqs(pod), nil, pod, map[string]string{}, 0)
+	require.NoError(t, err)
+	for _, node := range s.nodesUsage() {
+		assert.NotContains(t, node.Devices.DeviceLists[0].Device.CustomInfo, "fitted", "the cached usage is not modified by Fit")
+	}
+
+	pods := make([]*corev1.Pod, 0, 10)
+	for i := range 10 {
+		pods = append(pods, explainTestPod(t, fmt.Sprintf("pod%d", i), 500))
+	}
+	start := make(chan struct{})
+	var filters sync.WaitGroup
+	for _, pod := range pods {
+		filters.Add(1)
+		go func() {
+			defer filters.Done()
+			<-start
+			_, err := s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: pod, NodeNames: &[]string{"node1", "node2"}})
+			assert.NoError(t, err)
+		}()
+	}
+	close(start)
+	filters.Wait()
+}
+
+// newUsageBenchmarkScheduler returns a scheduler with benchmarkRegistryNodes
+// nodes of 8 devices, each running 4 pods.
+func newUsageBenchmarkScheduler(b *testing.B) (*Scheduler, []string) {
+	s := newTestScheduler(b)
+	nodes := make([]string, 0, benchmarkRegistryNodes)
+	for i := range benchmarkRegistryNodes {
+		nodeID := fmt.Sprintf("node%d", i)
+		nodes = append(nodes, nodeID)
+		devices := make([]util.DeviceInfo, 0, 8)
+		for d := range 8 {
+			devices = append(devices, util.DeviceInfo{
+				ID: fmt.Sprintf("%s-gpu%d", nodeID, d), Count: 10, Devmem: 8000, Devcore: 100,
+				Mode: "hami-core", Type: nvidia.NvidiaGPUDevice, Health: true, DeviceVendor: nvidia.NvidiaGPUDevice,
+			})
+		}
+		s.addNode(nodeID, &util.NodeInfo{ID: nodeID, Node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeID}}, Devices: devices})
+		for p := range 4 {
+			pod, podDevices := usageTestPod(fmt.Sprintf("%s-pod%d", nodeID, p), nodeID, 1000)
+			s.addPod(pod, nodeID, podDevices)
+		}
+	}
+	return s, nodes
+}
+
+// BenchmarkGetNodesUsage gets a copy of the usage of every node after a pod
+// changed.
+func BenchmarkGetNodesUsage(b *testing.B) {
+	s, nodes := newUsageBenchmarkScheduler(b)
+	pod, devices := usageTestPod("filtered", "node0", 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.addPod(pod, "node0", devices)
+		if _, _, err := s.getNodesUsage(&nodes, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSharedNodesUsage gets the usage of every node after a pod changed,
+// without copying it, as each Filter does.
+func BenchmarkSharedNodesUsage(b *testing.B) {
+	s, nodes := newUsageBenchmarkScheduler(b)
+	pod, devices := usageTestPod("filtered", "node0", 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.addPod(pod, "node0", devices)
+		s.sharedNodesUsage(nodes)
+	}
+}
+
+// BenchmarkGetNodesUsage_rebuild rebuilds the usage of every node on each
+// call, as before it was cached.
+func BenchmarkGetNodesUsage_rebuild(b *testing.B) {
+	s, nodes := newUsageBenchmarkScheduler(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.usage = usageCache{}
+		if _, _, err := s.getNodesUsage(&nodes, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkFilter(b *testing.B, rebuild bool) {
+	s, nodes := newUsageBenchmarkScheduler(b)
+	s.explain = nil
+	pod := explainTestPod(b, "filtered", 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if rebuild {
+			s.usage = usageCache{}
+		}
+		if _, err := s.Filter(context.Background(), extenderv1.ExtenderArgs{Pod: pod, NodeNames: &nodes}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkFilter filters the nodes for a pod, with the usage cached.
+func BenchmarkFilter(b *testing.B) {
+	benchmarkFilter(b, false)
+}
+
+// BenchmarkFilter_rebuild filters the nodes for a pod, rebuilding the usage
+// of every node as before it was cached.
+func BenchmarkFilter_rebuild(b *testing.B) {
+	benchmarkFilter(b, true)
+}
+
+// BenchmarkFilter_percentageOfNodesToScore filters the nodes for a pod,
+// stopping once it fits on the percentage of the nodes kube-scheduler adapts
+// to the size of the cluster.
+func BenchmarkFilter_percentageOfNodesToScore(b *testing.B) {
+	percentage := config.PercentageOfNodesToScore
+	config.PercentageOfNodesToScore = 0
+	b.Cleanup(func() { config.PercentageOfNodesToScore = percentage })
+	benchmarkFilter(b, false)
+}
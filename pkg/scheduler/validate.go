@@ -0,0 +1,219 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/Project-HAMi/HAMi/pkg/device"
+	"github.com/Project-HAMi/HAMi/pkg/scheduler/config"
+	"github.com/Project-HAMi/HAMi/pkg/util"
+)
+
+// vendorCapacity is the largest device of a vendor registered in the cluster.
+type vendorCapacity struct {
+	// Devmem and Devcore are the largest memory and cores of a device.
+	Devmem  int32
+	Devcore int32
+	// Devices is the largest number of devices on a node.
+	Devices int32
+}
+
+// clusterCapacity is the capacity of the registered devices of each vendor,
+// and their types.
+type clusterCapacity struct {
+	vendors map[string]vendorCapacity
+	types   []string
+}
+
+// capacity returns the capacity of the devices registered in the cluster.
+func (m *nodeManager) capacity() clusterCapacity {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	c := clusterCapacity{vendors: make(map[string]vendorCapacity)}
+	for _, node := range m.nodes {
+		devices := make(map[string]int32)
+		for _, d := range node.Devices {
+			v := c.vendors[d.DeviceVendor]
+			v.Devmem = max(v.Devmem, d.Devmem)
+			v.Devcore = max(v.Devcore, d.Devcore)
+			c.vendors[d.DeviceVendor] = v
+			devices[d.DeviceVendor]++
+			if !slices.Contains(c.types, d.Type) {
+				c.types = append(c.types, d.Type)
+			}
+		}
+		for vendor, n := range devices {
+			v := c.vendors[vendor]
+			v.Devices = max(v.Devices, n)
+			c.vendors[vendor] = v
+		}
+	}
+	return c
+}
+
+// hasType reports whether a registered device type contains cardtype, compared
+// like the use-type annotations are when filtering devices.
+func (c clusterCapacity) hasType(cardtype string) bool {
+	return slices.ContainsFunc(c.types, func(t string) bool {
+		return strings.Contains(strings.ToUpper(t), strings.ToUpper(cardtype))
+	})
+}
+
+// noUseAnnotation returns the annotation excluding what a use annotation
+// selects, e.g. nvidia.com/nouse-gpuuuid for nvidia.com/use-gpuuuid.
+func noUseAnnotation(key string, annos map[string]string) (string, bool) {
+	for _, prefix := range []string{"/nouse-", "/no-use-"} {
+		noUse := strings.Replace(key, "/use-", prefix, 1)
+		if _, ok := annos[noUse]; ok {
+			return noUse, true
+		}
+	}
+	return "", false
+}
+
+func splitAnnotation(value string) []string {
+	values := make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// validateAnnotations checks that the use and no-use annotations of a pod do
+// not select the same devices, and that the device types it uses are
+// registered.
+func validateAnnotations(annos map[string]string, capacity clusterCapacity) []string {
+	reasons := make([]string, 0)
+	keys := make([]string, 0, len(annos))
+	for key := range annos {
+		if strings.Contains(key, "/use-") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		use := splitAnnotation(annos[key])
+		if noUse, ok := noUseAnnotation(key, annos); ok {
+			for _, v := range splitAnnotation(annos[noUse]) {
+				if slices.Contains(use, v) {
+					reasons = append(reasons, fmt.Sprintf("annotations %s and %s both contain %q", key, noUse, v))
+				}
+			}
+		}
+		if strings.HasSuffix(key, "type") && len(capacity.types) > 0 {
+			if !slices.ContainsFunc(use, capacity.hasType) {
+				reasons = append(reasons, fmt.Sprintf("annotation %s selects no registered device type, registered types are %s",
+					key, strings.Join(capacity.types, ",")))
+			}
+		}
+	}
+	return reasons
+}
+
+// validateRequest checks a device request of a container against the largest
+// registered device of its vendor. Requests of vendors without registered
+// devices are only checked for core percentages over 100, as their nodes may
+// not be registered yet.
+func validateRequest(ctr string, req util.ContainerDeviceRequest, capacity clusterCapacity) []string {
+	reasons := make([]string, 0)
+	if req.Coresreq > 100 {
+		reasons = append(reasons, fmt.Sprintf("container %s requests %d%% of the cores of each %s device, more than 100%%", ctr, req.Coresreq, req.Type))
+	}
+	if req.Memreq == 0 && req.MemPercentagereq > 100 {
+		reasons = append(reasons, fmt.Sprintf("container %s requests %d%% of the memory of each %s device, more than 100%%", ctr, req.MemPercentagereq, req.Type))
+	}
+	c, ok := capacity.vendors[req.Type]
+	if !ok {
+		return reasons
+	}
+	if req.Nums > c.Devices {
+		reasons = append(reasons, fmt.Sprintf("container %s requests %d %s devices, nodes have at most %d", ctr, req.Nums, req.Type, c.Devices))
+	}
+	if req.Memreq > c.Devmem {
+		reasons = append(reasons, fmt.Sprintf("container %s requests %dMiB of memory on each %s device, the largest has %dMiB", ctr, req.Memreq, req.Type, c.Devmem))
+	}
+	if req.Coresreq > c.Devcore && req.Coresreq <= 100 {
+		reasons = append(reasons, fmt.Sprintf("container %s requests %d%% of the cores of each %s device, the largest has %d%%", ctr, req.Coresreq, req.Type, c.Devcore))
+	}
+	return reasons
+}
+
+// ValidatePod returns the reasons the devices a pod requests can never be
+// allocated in the cluster, empty if they may be.
+func (s *Scheduler) ValidatePod(pod *corev1.Pod) []string {
+	capacity := s.capacity()
+	reasons := validateAnnotations(pod.Annotations, capacity)
+	for _, ctr := range pod.Spec.Containers {
+		if ctr.SecurityContext != nil && ctr.SecurityContext.Privileged != nil && *ctr.SecurityContext.Privileged {
+			continue
+		}
+		for _, vendor := range device.GetDevices() {
+			req := vendor.GenerateResourceRequests(&ctr)
+			if req.Nums == 0 {
+				continue
+			}
+			reasons = append(reasons, validateRequest(ctr.Name, req, capacity)...)
+		}
+	}
+	return reasons
+}
+
+type validatingWebhook struct {
+	decoder admission.Decoder
+	s       *Scheduler
+}
+
+// NewValidatingWebHook returns the webhook denying the pods whose device
+// requests can never be allocated, with the reasons why.
+func NewValidatingWebHook(s *Scheduler) (*admission.Webhook, error) {
+	schema := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(schema); err != nil {
+		return nil, err
+	}
+	return &admission.Webhook{Handler: &validatingWebhook{decoder: admission.NewDecoder(schema), s: s}}, nil
+}
+
+func (h *validatingWebhook) Handle(_ context.Context, req admission.Request) admission.Response {
+	pod := &corev1.Pod{}
+	if err := h.decoder.Decode(req, pod); err != nil {
+		klog.Errorf("Failed to decode request: %v", err)
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if len(config.SchedulerName) > 0 && pod.Spec.SchedulerName != config.SchedulerName {
+		return admission.Allowed("pod is not scheduled by HAMi")
+	}
+	reasons := h.s.ValidatePod(pod)
+	if len(reasons) > 0 {
+		klog.Infof(template+" - Denying admission: %s", req.Namespace, pod.Name, req.UID, strings.Join(reasons, "; "))
+		return admission.Denied(strings.Join(reasons, "; "))
+	}
+	return admission.Allowed("")
+}
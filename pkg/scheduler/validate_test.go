@@ -0,0 +1,138 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/Project-HAMi/HAMi/pkg/device/nvidia"
+)
+
+func Test_ValidatePod(t *testing.T) {
+	tests := []struct {
+		name        string
+		limits      corev1.ResourceList
+		annotations map[string]string
+		want        []string
+	}{
+		{
+			name:   "fits",
+			limits: corev1.ResourceList{"hami.io/gpu": resource.MustParse("1"), "hami.io/gpumem": resource.MustParse("4000")},
+			want:   []string{},
+		},
+		{
+			name:   "more memory than the largest device",
+			limits: corev1.ResourceList{"hami.io/gpu": resource.MustParse("1"), "hami.io/gpumem": resource.MustParse("16000")},
+			want:   []string{"container main requests 16000MiB of memory on each NVIDIA device, the largest has 8000MiB"},
+		},
+		{
+			name:   "more devices than a node has",
+			limits: corev1.ResourceList{"hami.io/gpu": resource.MustParse("2")},
+			want:   []string{"container main requests 2 NVIDIA devices, nodes have at most 1"},
+		},
+		{
+			name:   "cores over 100",
+			limits: corev1.ResourceList{"hami.io/gpu": resource.MustParse("1"), "hami.io/gpucores": resource.MustParse("150")},
+			want:   []string{"container main requests 150% of the cores of each NVIDIA device, more than 100%"},
+		},
+		{
+			name:   "memory percentage over 100",
+			limits: corev1.ResourceList{"hami.io/gpu": resource.MustParse("1"), "hami.io/gpumem-percentage": resource.MustParse("120")},
+			want:   []string{"container main requests 120% of the memory of each NVIDIA device, more than 100%"},
+		},
+		{
+			name:        "conflicting uuid annotations",
+			limits:      corev1.ResourceList{"hami.io/gpu": resource.MustParse("1")},
+			annotations: map[string]string{nvidia.GPUUseUUID: "node1-gpu0,node2-gpu0", nvidia.GPUNoUseUUID: "node2-gpu0"},
+			want:        []string{`annotations nvidia.com/use-gpuuuid and nvidia.com/nouse-gpuuuid both contain "node2-gpu0"`},
+		},
+		{
+			name:        "unknown card type",
+			limits:      corev1.ResourceList{"hami.io/gpu": resource.MustParse("1")},
+			annotations: map[string]string{nvidia.GPUInUse: "A100"},
+			want:        []string{"annotation nvidia.com/use-gputype selects no registered device type, registered types are NVIDIA"},
+		},
+		{
+			name:        "known card type",
+			limits:      corev1.ResourceList{"hami.io/gpu": resource.MustParse("1")},
+			annotations: map[string]string{nvidia.GPUInUse: "A100,nvidia"},
+			want:        []string{},
+		},
+	}
+	s := newTestScheduler(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "validate", Namespace: "default", Annotations: test.annotations},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name:      "main",
+					Resources: corev1.ResourceRequirements{Limits: test.limits},
+				}}},
+			}
+			assert.Equal(t, test.want, s.ValidatePod(pod))
+		})
+	}
+}
+
+func Test_ValidatingWebHook(t *testing.T) {
+	s := newTestScheduler(t)
+	wh, err := NewValidatingWebHook(s)
+	require.NoError(t, err)
+
+	pod := explainTestPod(t, "too-large", 16000)
+	raw, err := json.Marshal(pod)
+	require.NoError(t, err)
+	resp := wh.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		UID:       "test-uid",
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+	assert.False(t, resp.Allowed)
+	assert.Equal(t, "container main requests 16000MiB of memory on each NVIDIA device, the largest has 8000MiB", resp.Result.Message)
+}
+
+func Test_ValidatePod_registeredNode(t *testing.T) {
+	s, indexer := newRegistryTestScheduler(t)
+	addRegistryTestNode(t, indexer, simTestNode("node1", 2))
+	s.syncNode("node1")
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "validate", Namespace: "default"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name: "main",
+			Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+				"hami.io/gpu": resource.MustParse("3"), "hami.io/gpumem": resource.MustParse("16000"),
+			}},
+		}}},
+	}
+	assert.Equal(t, []string{
+		"container main requests 3 NVIDIA devices, nodes have at most 2",
+		"container main requests 16000MiB of memory on each NVIDIA device, the largest has 8000MiB",
+	}, s.ValidatePod(pod))
+}
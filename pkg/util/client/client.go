@@ -36,6 +36,8 @@ type Client struct {
 
 var (
 	KubeClient kubernetes.Interface
+	// KubeConfig is the config KubeClient was created with, for clients of custom resources.
+	KubeConfig *rest.Config
 	once       sync.Once
 )
 
@@ -48,6 +50,11 @@ func GetClient() kubernetes.Interface {
 	return KubeClient
 }
 
+// GetConfig returns the config of the global Kubernetes client.
+func GetConfig() *rest.Config {
+	return KubeConfig
+}
+
 // NewClient creates a new Kubernetes client with the given options.
 func NewClient(opts ...Option) (*Client, error) {
 	restConfig, err := loadKubeConfig()
@@ -82,6 +89,7 @@ func InitGlobalClient(opts ...Option) {
 			klog.Fatalf("Failed to initialize global client: %v", err)
 		}
 		KubeClient = client.Interface
+		KubeConfig = client.config
 	})
 }
 
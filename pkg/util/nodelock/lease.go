@@ -0,0 +1,374 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	"github.com/Project-HAMi/HAMi/pkg/util/client"
+)
+
+const (
+	// LeaseNamePrefix is prepended to the node and lock names to get the name of a lock Lease.
+	LeaseNamePrefix = "hami-node-lock-"
+	// LeaseOwnerAnnotation records the identity of the process that took the lock.
+	LeaseOwnerAnnotation = "hami.io/node-lock-owner"
+	// LeaseTokenAnnotation records on the pod the fencing token of the lock
+	// taken for it, checked by the device plugin before allocating its devices.
+	LeaseTokenAnnotation = "hami.io/node-lock-token"
+)
+
+var (
+	// LeaseNamespace is the namespace of the node lock Leases.
+	LeaseNamespace = "kube-system"
+	// LeaseDuration is how long a lock outlives its last renewal. The owner renews
+	// it every LeaseDuration/3, until it is released or held for NodeLockTimeout.
+	LeaseDuration = 30 * time.Second
+)
+
+// heldLease is a lock taken by this process. The fencing token is the Lease's
+// LeaseTransitions when it was acquired; renewals stop as soon as the Lease is
+// taken over or released, since the token or holder no longer match. The
+// token is also written to the pod, so that the device plugin does not
+// allocate the devices of a pod whose lock was taken over.
+type heldLease struct {
+	holder   string
+	token    int32
+	acquired time.Time
+	stopCh   chan struct{}
+}
+
+// leaseLocker locks a node with a coordination.k8s.io/v1 Lease. The holder
+// identity is the namespace/name of the pod being allocated, so the device
+// plugin can find and release it. Unlike the annotation lock, a lock left by a
+// crashed scheduler expires after LeaseDuration, not NodeLockTimeout.
+type leaseLocker struct {
+	identity string
+	held     map[string]*heldLease
+	mutex    sync.Mutex
+}
+
+func newLeaseLocker() *leaseLocker {
+	identity, err := os.Hostname()
+	if err != nil {
+		klog.ErrorS(err, "Failed to get hostname for node lock owner identity")
+		identity = "unknown"
+	}
+	return &leaseLocker{
+		identity: identity,
+		held:     make(map[string]*heldLease),
+	}
+}
+
+// leaseName returns the name of the Lease of a lock of a node. The lock name
+// is lowercased and every character not allowed in a name replaced by '-'.
+func leaseName(nodeName string, lockname string) string {
+	lock := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, lockname)
+	return LeaseNamePrefix + nodeName + "-" + lock
+}
+
+func leaseHolder(pod *corev1.Pod) string {
+	if pod == nil {
+		return ""
+	}
+	return pod.Namespace + "/" + pod.Name
+}
+
+func leaseHolderIdentity(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+// leaseTransitions returns the fencing token of the lease's current holder.
+func leaseTransitions(lease *coordinationv1.Lease) int32 {
+	if lease.Spec.LeaseTransitions == nil {
+		return 0
+	}
+	return *lease.Spec.LeaseTransitions
+}
+
+// leaseExpired reports whether the lease is free to be taken at now.
+func leaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if leaseHolderIdentity(lease) == "" {
+		return true
+	}
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return now.After(expiry)
+}
+
+func (l *leaseLocker) Lock(nodeName string, lockname string, pod *corev1.Pod) error {
+	ctx := context.Background()
+	leases := client.GetClient().CoordinationV1().Leases(LeaseNamespace)
+	name := leaseName(nodeName, lockname)
+	holder := leaseHolder(pod)
+	var err error
+	for i := 0; i <= MaxLockRetry; i++ {
+		if i > 0 {
+			klog.ErrorS(err, "Failed to acquire node lock lease", "node", nodeName, "retry", i)
+			time.Sleep(100 * time.Millisecond)
+		}
+		var lease *coordinationv1.Lease
+		lease, err = leases.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			lease = &coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: LeaseNamespace},
+			}
+			l.acquire(lease, holder)
+			lease, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+		} else if err == nil {
+			now := time.Now()
+			if !leaseExpired(lease, now) {
+				return fmt.Errorf("node %s is locked by %s until %v", nodeName, *lease.Spec.HolderIdentity,
+					lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds)*time.Second).Format(time.RFC3339))
+			}
+			if leaseHolderIdentity(lease) != "" {
+				klog.InfoS("Node lock lease expired", "node", nodeName, "holder", leaseHolderIdentity(lease), "renewTime", lease.Spec.RenewTime)
+			}
+			l.acquire(lease, holder)
+			// Update carries the resourceVersion we read, so a concurrent locker gets a conflict.
+			lease, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+		}
+		if err == nil {
+			token := leaseTransitions(lease)
+			l.startRenew(name, holder, token)
+			if err := patchLeaseToken(ctx, pod, token); err != nil {
+				l.Release(nodeName, lockname, pod, false)
+				return fmt.Errorf("record node lock token on pod %s: %v", holder, err)
+			}
+			klog.InfoS("Node lock set", "node", nodeName, "podName", holder, "token", token)
+			return nil
+		}
+		if !apierrors.IsConflict(err) && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("lockNode exceeds retry count %d: %v", MaxLockRetry, err)
+}
+
+// patchLeaseToken writes the fencing token of the lock taken for pod to it.
+func patchLeaseToken(ctx context.Context, pod *corev1.Pod, token int32) error {
+	patchData := fmt.Sprintf(`{"metadata":{"annotations":{"%s":"%d"}}}`, LeaseTokenAnnotation, token)
+	_, err := client.GetClient().CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, []byte(patchData), metav1.PatchOptions{})
+	return err
+}
+
+// acquire fills in the lease for a new holder and bumps the fencing token.
+func (l *leaseLocker) acquire(lease *coordinationv1.Lease, holder string) {
+	now := metav1.NewMicroTime(time.Now())
+	transitions := int32(0)
+	if lease.Spec.LeaseTransitions != nil {
+		transitions = *lease.Spec.LeaseTransitions + 1
+	}
+	if lease.Annotations == nil {
+		lease.Annotations = make(map[string]string)
+	}
+	lease.Annotations[LeaseOwnerAnnotation] = l.identity
+	lease.Spec.HolderIdentity = &holder
+	duration := int32(LeaseDuration.Seconds())
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	lease.Spec.LeaseTransitions = &transitions
+}
+
+func (l *leaseLocker) Release(nodeName string, lockname string, pod *corev1.Pod, force bool) error {
+	ctx := context.Background()
+	leases := client.GetClient().CoordinationV1().Leases(LeaseNamespace)
+	name := leaseName(nodeName, lockname)
+	holder := leaseHolder(pod)
+	var err error
+	for i := 0; i <= MaxLockRetry; i++ {
+		if i > 0 {
+			klog.ErrorS(err, "Failed to release node lock lease", "node", nodeName, "retry", i)
+			time.Sleep(100 * time.Millisecond)
+		}
+		var lease *coordinationv1.Lease
+		lease, err = leases.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			continue
+		}
+		current := leaseHolderIdentity(lease)
+		if current == "" {
+			return nil
+		}
+		if current != holder && !force {
+			klog.InfoS("NodeLock is not set by this pod", "holder", current, "pod", holder)
+			return nil
+		}
+		l.stopRenew(name)
+		// The Lease is kept, so LeaseTransitions keeps growing across holders.
+		lease.Spec.HolderIdentity = nil
+		lease.Spec.RenewTime = nil
+		_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+		if err == nil {
+			klog.InfoS("Node lock released", "node", nodeName, "podName", current)
+			return nil
+		}
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("releaseNodeLock exceeds retry count %d: %v", MaxLockRetry, err)
+}
+
+func (l *leaseLocker) Holder(ctx context.Context, nodeName string, lockname string) (ns, name string, err error) {
+	lease, err := client.GetClient().CoordinationV1().Leases(LeaseNamespace).Get(ctx, leaseName(nodeName, lockname), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if leaseExpired(lease, time.Now()) {
+		return "", "", nil
+	}
+	ns, name, found := strings.Cut(*lease.Spec.HolderIdentity, "/")
+	if !found {
+		return "", "", nil
+	}
+	return ns, name, nil
+}
+
+// Check fails if the lock taken for pod was taken over since: the Lease is
+// held by another pod, or its fencing token moved past the one of the pod.
+// Pods locked without a token, e.g. by the annotation backend, are not checked.
+func (l *leaseLocker) Check(ctx context.Context, nodeName string, lockname string, pod *corev1.Pod) error {
+	value, ok := pod.Annotations[LeaseTokenAnnotation]
+	if !ok {
+		return nil
+	}
+	token, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid node lock token %q of pod %s: %v", value, leaseHolder(pod), err)
+	}
+	lease, err := client.GetClient().CoordinationV1().Leases(LeaseNamespace).Get(ctx, leaseName(nodeName, lockname), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if current := leaseHolderIdentity(lease); current != "" && current != leaseHolder(pod) {
+		return fmt.Errorf("node lock of %s for pod %s was taken over by %s", nodeName, leaseHolder(pod), current)
+	}
+	if leaseTransitions(lease) != int32(token) {
+		return fmt.Errorf("node lock of %s for pod %s was taken over, token %d, current %d", nodeName, leaseHolder(pod), token, leaseTransitions(lease))
+	}
+	return nil
+}
+
+func (l *leaseLocker) startRenew(name string, holder string, token int32) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if prev, ok := l.held[name]; ok {
+		close(prev.stopCh)
+	}
+	h := &heldLease{
+		holder:   holder,
+		token:    token,
+		acquired: time.Now(),
+		stopCh:   make(chan struct{}),
+	}
+	l.held[name] = h
+	go l.renewLoop(name, h)
+}
+
+func (l *leaseLocker) stopRenew(name string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if h, ok := l.held[name]; ok {
+		close(h.stopCh)
+		delete(l.held, name)
+	}
+}
+
+// forget drops h from the held locks, unless it was already replaced.
+func (l *leaseLocker) forget(name string, h *heldLease) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.held[name] == h {
+		delete(l.held, name)
+	}
+}
+
+func (l *leaseLocker) renewLoop(name string, h *heldLease) {
+	ticker := time.NewTicker(LeaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stopCh:
+			return
+		case <-ticker.C:
+		}
+		if !l.renew(name, h) {
+			l.forget(name, h)
+			return
+		}
+	}
+}
+
+// renew extends a lock held by this process. It returns false once the lock is
+// no longer ours to renew: released, taken over, or held for NodeLockTimeout.
+func (l *leaseLocker) renew(name string, h *heldLease) bool {
+	if timeout := lockTimeout(); time.Since(h.acquired) > timeout {
+		klog.InfoS("Node lock held too long, letting it expire", "lease", name, "holder", h.holder, "timeout", timeout)
+		return false
+	}
+	ctx := context.Background()
+	leases := client.GetClient().CoordinationV1().Leases(LeaseNamespace)
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		klog.ErrorS(err, "Failed to get node lock lease for renewal", "lease", name)
+		return !apierrors.IsNotFound(err)
+	}
+	if leaseHolderIdentity(lease) != h.holder || leaseTransitions(lease) != h.token {
+		klog.V(4).InfoS("Node lock no longer held, stop renewing", "lease", name, "holder", h.holder, "token", h.token)
+		return false
+	}
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec.RenewTime = &now
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		klog.ErrorS(err, "Failed to renew node lock lease", "lease", name)
+	}
+	return true
+}
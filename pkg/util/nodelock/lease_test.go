@@ -0,0 +1,178 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/Project-HAMi/HAMi/pkg/util/client"
+)
+
+func leaseTestPod(name string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "hami-ns"}}
+}
+
+// newLeaseTestClient returns a fake client with pods named names, which locks
+// record their fencing tokens on.
+func newLeaseTestClient(t *testing.T, names ...string) {
+	client.KubeClient = fake.NewSimpleClientset()
+	for _, name := range names {
+		_, err := client.KubeClient.CoreV1().Pods("hami-ns").Create(context.Background(), leaseTestPod(name), metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+}
+
+func getLease(t *testing.T, nodeName string) *coordinationv1.Lease {
+	lease, err := client.GetClient().CoordinationV1().Leases(LeaseNamespace).Get(context.Background(), leaseName(nodeName, "lock"), metav1.GetOptions{})
+	require.NoError(t, err)
+	return lease
+}
+
+func getLeaseTestPod(t *testing.T, name string) *corev1.Pod {
+	pod, err := client.GetClient().CoreV1().Pods("hami-ns").Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return pod
+}
+
+func Test_leaseLocker(t *testing.T) {
+	newLeaseTestClient(t, "pod1", "pod2")
+	l := newLeaseLocker()
+	ctx := context.Background()
+
+	ns, name, err := l.Holder(ctx, "node1", "lock")
+	require.NoError(t, err)
+	assert.Empty(t, ns+name, "a node without lease is not locked")
+
+	require.NoError(t, l.Lock("node1", "lock", leaseTestPod("pod1")))
+	lease := getLease(t, "node1")
+	assert.Equal(t, "hami-ns/pod1", *lease.Spec.HolderIdentity)
+	assert.Equal(t, int32(0), *lease.Spec.LeaseTransitions)
+	assert.Equal(t, l.identity, lease.Annotations[LeaseOwnerAnnotation])
+	assert.Equal(t, "0", getLeaseTestPod(t, "pod1").Annotations[LeaseTokenAnnotation])
+	ns, name, err = l.Holder(ctx, "node1", "lock")
+	require.NoError(t, err)
+	assert.Equal(t, "hami-ns", ns)
+	assert.Equal(t, "pod1", name)
+
+	assert.Error(t, l.Lock("node1", "lock", leaseTestPod("pod2")), "a held lock cannot be taken")
+
+	// Only the holder, or a forced release, unlocks the node.
+	require.NoError(t, l.Release("node1", "lock", leaseTestPod("pod2"), false))
+	assert.Equal(t, "hami-ns/pod1", *getLease(t, "node1").Spec.HolderIdentity)
+	require.NoError(t, l.Release("node1", "lock", leaseTestPod("pod1"), false))
+	assert.Nil(t, getLease(t, "node1").Spec.HolderIdentity)
+	assert.Empty(t, l.held)
+
+	require.NoError(t, l.Lock("node1", "lock", leaseTestPod("pod2")))
+	assert.Equal(t, int32(1), *getLease(t, "node1").Spec.LeaseTransitions, "every acquisition bumps the fencing token")
+	require.NoError(t, l.Release("node1", "lock", leaseTestPod("pod3"), true))
+	assert.Nil(t, getLease(t, "node1").Spec.HolderIdentity)
+}
+
+func Test_leaseLocker_expired(t *testing.T) {
+	newLeaseTestClient(t, "pod1", "pod2")
+	crashed := newLeaseLocker()
+	require.NoError(t, crashed.Lock("node1", "lock", leaseTestPod("pod1")))
+	crashed.stopRenew(leaseName("node1", "lock"))
+
+	// The owner stopped renewing: once LeaseDuration has passed the lock is free.
+	lease := getLease(t, "node1")
+	old := metav1.NewMicroTime(time.Now().Add(-2 * LeaseDuration))
+	lease.Spec.RenewTime = &old
+	_, err := client.GetClient().CoordinationV1().Leases(LeaseNamespace).Update(context.Background(), lease, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	ns, name, err := crashed.Holder(context.Background(), "node1", "lock")
+	require.NoError(t, err)
+	assert.Empty(t, ns+name)
+
+	l := newLeaseLocker()
+	require.NoError(t, l.Lock("node1", "lock", leaseTestPod("pod2")))
+	lease = getLease(t, "node1")
+	assert.Equal(t, "hami-ns/pod2", *lease.Spec.HolderIdentity)
+	assert.Equal(t, int32(1), *lease.Spec.LeaseTransitions)
+
+	// The stale owner's fencing token no longer matches, it must not renew.
+	stale := &heldLease{holder: "hami-ns/pod1", token: 0, acquired: time.Now(), stopCh: make(chan struct{})}
+	assert.False(t, crashed.renew(leaseName("node1", "lock"), stale))
+	current := &heldLease{holder: "hami-ns/pod2", token: 1, acquired: time.Now(), stopCh: make(chan struct{})}
+	assert.True(t, l.renew(leaseName("node1", "lock"), current))
+	tooLong := &heldLease{holder: "hami-ns/pod2", token: 1, acquired: time.Now().Add(-2 * NodeLockTimeout), stopCh: make(chan struct{})}
+	assert.False(t, l.renew(leaseName("node1", "lock"), tooLong))
+}
+
+func Test_leaseName(t *testing.T) {
+	assert.Equal(t, "hami-node-lock-node1-hami.io-mutex.lock", leaseName("node1", NodeLockKey))
+	assert.Equal(t, "hami-node-lock-node1-acme-lock", leaseName("node1", "ACME_lock"))
+}
+
+func Test_leaseLocker_lockNames(t *testing.T) {
+	newLeaseTestClient(t, "pod1", "pod2")
+	l := newLeaseLocker()
+	require.NoError(t, l.Lock("node1", "lock", leaseTestPod("pod1")))
+	require.NoError(t, l.Lock("node1", "other", leaseTestPod("pod2")), "every lock name has its own lease")
+	ns, name, err := l.Holder(context.Background(), "node1", "other")
+	require.NoError(t, err)
+	assert.Equal(t, "hami-ns/pod2", ns+"/"+name)
+
+	assert.Error(t, l.Lock("node1", "third", leaseTestPod("missing")), "the token cannot be recorded on a missing pod")
+	ns, name, err = l.Holder(context.Background(), "node1", "third")
+	require.NoError(t, err)
+	assert.Empty(t, ns+name, "the lock is released if its token cannot be recorded")
+}
+
+func Test_leaseLocker_Check(t *testing.T) {
+	newLeaseTestClient(t, "pod1", "pod2")
+	ctx := context.Background()
+	crashed := newLeaseLocker()
+	require.NoError(t, crashed.Lock("node1", "lock", leaseTestPod("pod1")))
+	pod1 := getLeaseTestPod(t, "pod1")
+	require.NoError(t, crashed.Check(ctx, "node1", "lock", pod1))
+	assert.NoError(t, crashed.Check(ctx, "node1", "lock", leaseTestPod("untracked")), "pods without a token are not checked")
+
+	// The lock of pod1 expires and is taken for pod2, then released.
+	crashed.stopRenew(leaseName("node1", "lock"))
+	lease := getLease(t, "node1")
+	old := metav1.NewMicroTime(time.Now().Add(-2 * LeaseDuration))
+	lease.Spec.RenewTime = &old
+	_, err := client.GetClient().CoordinationV1().Leases(LeaseNamespace).Update(ctx, lease, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	l := newLeaseLocker()
+	require.NoError(t, l.Lock("node1", "lock", leaseTestPod("pod2")))
+	assert.ErrorContains(t, l.Check(ctx, "node1", "lock", pod1), "taken over by hami-ns/pod2")
+	require.NoError(t, l.Check(ctx, "node1", "lock", getLeaseTestPod(t, "pod2")))
+	require.NoError(t, l.Release("node1", "lock", leaseTestPod("pod2"), false))
+	assert.ErrorContains(t, l.Check(ctx, "node1", "lock", pod1), "token 0, current 1")
+}
+
+func Test_SetBackend(t *testing.T) {
+	defer func() { locker = &annotationLocker{} }()
+
+	require.NoError(t, SetBackend(BackendLease))
+	assert.IsType(t, &leaseLocker{}, locker)
+	require.NoError(t, SetBackend(BackendAnnotation))
+	assert.IsType(t, &annotationLocker{}, locker)
+	assert.Error(t, SetBackend("etcd"))
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelock
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// BackendAnnotation locks a node with the NodeLockKey annotation on the Node object.
+	BackendAnnotation = "annotation"
+	// BackendLease locks a node with a coordination.k8s.io/v1 Lease.
+	BackendLease = "lease"
+)
+
+// Locker serializes device allocations on a node. The scheduler takes the lock
+// when it binds a pod, and the device plugin releases it once the devices of
+// that pod are allocated.
+type Locker interface {
+	// Lock locks the node for pod, it fails if the node is already locked.
+	Lock(nodeName string, lockname string, pod *corev1.Pod) error
+	// Release unlocks the node if it is locked by pod, or whoever holds it if force is set.
+	Release(nodeName string, lockname string, pod *corev1.Pod, force bool) error
+	// Holder returns the namespace and name of the pod holding the lock, both
+	// empty if the node is not locked.
+	Holder(ctx context.Context, nodeName string, lockname string) (ns, name string, err error)
+	// Check fails if the lock taken for pod was taken over by another one since.
+	Check(ctx context.Context, nodeName string, lockname string, pod *corev1.Pod) error
+}
+
+var locker Locker = &annotationLocker{}
+
+// SetBackend selects the Locker used by LockNode, ReleaseNodeLock, GetNodeLockHolder
+// and CheckNodeLock.
+// The scheduler and the device plugins must use the same backend.
+func SetBackend(backend string) error {
+	switch backend {
+	case "", BackendAnnotation:
+		locker = &annotationLocker{}
+	case BackendLease:
+		locker = newLeaseLocker()
+	default:
+		return fmt.Errorf("unknown node lock backend %q, expected %s or %s", backend, BackendAnnotation, BackendLease)
+	}
+	klog.InfoS("Node lock backend selected", "backend", backend)
+	return nil
+}
+
+func LockNode(nodeName string, lockname string, pods *corev1.Pod) error {
+	return locker.Lock(nodeName, lockname, pods)
+}
+
+func ReleaseNodeLock(nodeName string, lockname string, pod *corev1.Pod, timeout bool) error {
+	return locker.Release(nodeName, lockname, pod, timeout)
+}
+
+// GetNodeLockHolder returns the namespace and name of the pod holding the lock on a node.
+func GetNodeLockHolder(ctx context.Context, nodeName string, lockname string) (ns, name string, err error) {
+	return locker.Holder(ctx, nodeName, lockname)
+}
+
+// CheckNodeLock fails if the lock of a node taken for pod was taken over since,
+// so that the devices of pod are not allocated along with those of another.
+func CheckNodeLock(ctx context.Context, nodeName string, lockname string, pod *corev1.Pod) error {
+	return locker.Check(ctx, nodeName, lockname, pod)
+}
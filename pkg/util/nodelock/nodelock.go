@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Project-HAMi/HAMi/pkg/util/client"
@@ -41,8 +42,23 @@ var (
 	lock sync.Mutex
 	// NodeLockTimeout is the global timeout for node locks.
 	NodeLockTimeout time.Duration = time.Minute * 5
+	// reloadedTimeout overrides NodeLockTimeout once set at runtime, 0 if not.
+	reloadedTimeout atomic.Int64
 )
 
+// SetNodeLockTimeout overrides NodeLockTimeout at runtime, 0 reverts to it.
+func SetNodeLockTimeout(timeout time.Duration) {
+	reloadedTimeout.Store(int64(timeout))
+}
+
+// lockTimeout returns the node lock timeout in effect.
+func lockTimeout() time.Duration {
+	if timeout := reloadedTimeout.Load(); timeout > 0 {
+		return time.Duration(timeout)
+	}
+	return NodeLockTimeout
+}
+
 func SetNodeLock(nodeName string, lockname string, pods *corev1.Pod) error {
 	lock.Lock()
 	defer lock.Unlock()
@@ -74,7 +90,11 @@ func SetNodeLock(nodeName string, lockname string, pods *corev1.Pod) error {
 	return nil
 }
 
-func ReleaseNodeLock(nodeName string, lockname string, pod *corev1.Pod, timeout bool) error {
+// annotationLocker locks a node with the NodeLockKey annotation. A lock is only
+// taken over once it is older than NodeLockTimeout.
+type annotationLocker struct{}
+
+func (l *annotationLocker) Release(nodeName string, lockname string, pod *corev1.Pod, timeout bool) error {
 	lock.Lock()
 	defer lock.Unlock()
 	ctx := context.Background()
@@ -110,7 +130,7 @@ func ReleaseNodeLock(nodeName string, lockname string, pod *corev1.Pod, timeout
 	return nil
 }
 
-func LockNode(nodeName string, lockname string, pods *corev1.Pod) error {
+func (l *annotationLocker) Lock(nodeName string, lockname string, pods *corev1.Pod) error {
 	ctx := context.Background()
 	node, err := client.GetClient().CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
 	if err != nil {
@@ -123,16 +143,37 @@ func LockNode(nodeName string, lockname string, pods *corev1.Pod) error {
 	if err != nil {
 		return err
 	}
-	if time.Since(lockTime) > NodeLockTimeout {
-		klog.InfoS("Node lock expired", "node", nodeName, "lockTime", lockTime, "timeout", NodeLockTimeout)
-		err = ReleaseNodeLock(nodeName, lockname, pods, true)
+	timeout := lockTimeout()
+	if time.Since(lockTime) > timeout {
+		klog.InfoS("Node lock expired", "node", nodeName, "lockTime", lockTime, "timeout", timeout)
+		err = l.Release(nodeName, lockname, pods, true)
 		if err != nil {
 			klog.ErrorS(err, "Failed to release node lock", "node", nodeName)
 			return err
 		}
 		return SetNodeLock(nodeName, lockname, pods)
 	}
-	return fmt.Errorf("node %s has been locked within %v", nodeName, NodeLockTimeout)
+	return fmt.Errorf("node %s has been locked within %v", nodeName, timeout)
+}
+
+func (l *annotationLocker) Holder(ctx context.Context, nodeName string, lockname string) (ns, name string, err error) {
+	node, err := client.GetClient().CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", "", err
+	}
+	value, ok := node.Annotations[NodeLockKey]
+	if !ok {
+		return "", "", nil
+	}
+	klog.V(2).Infof("node annotation key is %s, value is %s ", NodeLockKey, value)
+	_, ns, name, err = ParseNodeLock(value)
+	return ns, name, err
+}
+
+// Check does nothing: the annotation lock has no fencing token, it is only
+// taken over once older than NodeLockTimeout.
+func (l *annotationLocker) Check(ctx context.Context, nodeName string, lockname string, pod *corev1.Pod) error {
+	return nil
 }
 
 func ParseNodeLock(value string) (lockTime time.Time, ns, name string, err error) {
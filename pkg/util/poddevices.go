@@ -0,0 +1,143 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Encodings of the devices-to-allocate and devices-allocated pod annotations.
+// Readers accept both, so that all components can be upgraded before the
+// scheduler starts writing JSON: the legacy string drops the device index and
+// CustomInfo, the JSON one keeps them.
+const (
+	PodDevicesEncodingLegacy = "legacy"
+	PodDevicesEncodingJSON   = "json"
+
+	// PodDevicesVersion is the version of the JSON encoding.
+	PodDevicesVersion = 1
+)
+
+// PodDevicesEncoding is the encoding EncodePodSingleDevice writes.
+var PodDevicesEncoding = PodDevicesEncodingLegacy
+
+// ValidatePodDevicesEncoding checks an encoding set by a flag.
+func ValidatePodDevicesEncoding(encoding string) error {
+	if encoding != PodDevicesEncodingLegacy && encoding != PodDevicesEncodingJSON {
+		return fmt.Errorf("unknown pod devices encoding %s, expected %s or %s", encoding, PodDevicesEncodingLegacy, PodDevicesEncodingJSON)
+	}
+	return nil
+}
+
+// podDevicesJSON is the JSON encoding of a PodSingleDevice, one entry of
+// Containers per container of the pod.
+type podDevicesJSON struct {
+	Version    int                     `json:"version"`
+	Containers [][]containerDeviceJSON `json:"containers"`
+}
+
+type containerDeviceJSON struct {
+	Idx        int            `json:"idx"`
+	UUID       string         `json:"uuid"`
+	Type       string         `json:"type"`
+	Usedmem    int32          `json:"usedmem"`
+	Usedcores  int32          `json:"usedcores"`
+	CustomInfo map[string]any `json:"customInfo,omitempty"`
+}
+
+// IsJSONPodDevices reports whether a pod annotation holds JSON encoded devices.
+func IsJSONPodDevices(str string) bool {
+	return strings.HasPrefix(strings.TrimSpace(str), "{")
+}
+
+// MarshalPodSingleDevice returns the JSON encoding of the devices of a pod.
+func MarshalPodSingleDevice(pd PodSingleDevice) (string, error) {
+	res := podDevicesJSON{Version: PodDevicesVersion, Containers: make([][]containerDeviceJSON, 0, len(pd))}
+	for _, ctrdevs := range pd {
+		ctr := make([]containerDeviceJSON, 0, len(ctrdevs))
+		for _, d := range ctrdevs {
+			ctr = append(ctr, containerDeviceJSON(d))
+		}
+		res.Containers = append(res.Containers, ctr)
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// UnmarshalPodSingleDevice decodes JSON encoded devices of a pod, rejecting
+// unknown versions and fields and devices without a UUID or type.
+func UnmarshalPodSingleDevice(str string) (PodSingleDevice, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(str)))
+	decoder.DisallowUnknownFields()
+	encoded := podDevicesJSON{}
+	if err := decoder.Decode(&encoded); err != nil {
+		return nil, fmt.Errorf("invalid pod devices: %v", err)
+	}
+	if encoded.Version != PodDevicesVersion {
+		return nil, fmt.Errorf("unsupported pod devices version %d, expected %d", encoded.Version, PodDevicesVersion)
+	}
+	pd := make(PodSingleDevice, 0, len(encoded.Containers))
+	for ctridx, ctr := range encoded.Containers {
+		cd := make(ContainerDevices, 0, len(ctr))
+		for _, d := range ctr {
+			if d.UUID == "" || d.Type == "" {
+				return nil, fmt.Errorf("invalid pod devices: device of container %d without uuid or type", ctridx)
+			}
+			if d.Usedmem < 0 || d.Usedcores < 0 {
+				return nil, fmt.Errorf("invalid pod devices: negative usage of device %s", d.UUID)
+			}
+			cd = append(cd, ContainerDevice(d))
+		}
+		pd = append(pd, cd)
+	}
+	return pd, nil
+}
+
+// DecodePodSingleDevice decodes the devices of a pod in either encoding.
+// Containers without devices are skipped, as they are in the legacy encoding.
+func DecodePodSingleDevice(str string) (PodSingleDevice, error) {
+	pd := make(PodSingleDevice, 0)
+	if IsJSONPodDevices(str) {
+		decoded, err := UnmarshalPodSingleDevice(str)
+		if err != nil {
+			return nil, err
+		}
+		for _, cd := range decoded {
+			if len(cd) > 0 {
+				pd = append(pd, cd)
+			}
+		}
+		return pd, nil
+	}
+	for s := range strings.SplitSeq(str, OnePodMultiContainerSplitSymbol) {
+		cd, err := DecodeContainerDevices(s)
+		if err != nil {
+			return nil, err
+		}
+		if len(cd) == 0 {
+			continue
+		}
+		pd = append(pd, cd)
+	}
+	return pd, nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2024 The HAMi Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func testPodSingleDevice() PodSingleDevice {
+	return PodSingleDevice{
+		{
+			{Idx: 1, UUID: "GPU-0", Type: "NVIDIA", Usedmem: 500, Usedcores: 30, CustomInfo: map[string]any{"numa": float64(1)}},
+			{Idx: 3, UUID: "GPU-1", Type: "NVIDIA", Usedmem: 1000},
+		},
+		{
+			{Idx: 0, UUID: "GPU-2[1g.10gb-0]", Type: "NVIDIA", Usedmem: 10240, Usedcores: 0},
+		},
+	}
+}
+
+func Test_MarshalPodSingleDevice_roundTrip(t *testing.T) {
+	encoded, err := MarshalPodSingleDevice(testPodSingleDevice())
+	assert.NilError(t, err)
+	assert.Assert(t, IsJSONPodDevices(encoded))
+
+	decoded, err := UnmarshalPodSingleDevice(encoded)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, testPodSingleDevice(), decoded)
+
+	decoded, err = DecodePodSingleDevice(encoded)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, testPodSingleDevice(), decoded)
+}
+
+func Test_DecodePodSingleDevice_legacy(t *testing.T) {
+	legacy := PodSingleDevice{}
+	for _, ctr := range testPodSingleDevice() {
+		cd := ContainerDevices{}
+		for _, d := range ctr {
+			cd = append(cd, ContainerDevice{UUID: d.UUID, Type: d.Type, Usedmem: d.Usedmem, Usedcores: d.Usedcores})
+		}
+		legacy = append(legacy, cd)
+	}
+	PodDevicesEncoding = PodDevicesEncodingLegacy
+	encoded := EncodePodSingleDevice(testPodSingleDevice())
+	assert.Assert(t, !IsJSONPodDevices(encoded))
+
+	decoded, err := DecodePodSingleDevice(encoded)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, legacy, decoded)
+}
+
+func Test_EncodePodSingleDevice_json(t *testing.T) {
+	PodDevicesEncoding = PodDevicesEncodingJSON
+	defer func() { PodDevicesEncoding = PodDevicesEncodingLegacy }()
+
+	encoded := EncodePodSingleDevice(testPodSingleDevice())
+	assert.Assert(t, IsJSONPodDevices(encoded))
+	decoded, err := DecodePodSingleDevice(encoded)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, testPodSingleDevice(), decoded)
+
+	// Containers without devices are skipped in both encodings.
+	encoded = EncodePodSingleDevice(PodSingleDevice{{}, testPodSingleDevice()[1]})
+	decoded, err = DecodePodSingleDevice(encoded)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, PodSingleDevice{testPodSingleDevice()[1]}, decoded)
+}
+
+func Test_DecodePodSingleDevice_invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		str  string
+		err  string
+	}{
+		{
+			name: "unknown version",
+			str:  `{"version":2,"containers":[]}`,
+			err:  "unsupported pod devices version 2, expected 1",
+		},
+		{
+			name: "unknown field",
+			str:  `{"version":1,"containers":[[{"uuid":"GPU-0","type":"NVIDIA","memory":1}]]}`,
+			err:  `invalid pod devices: json: unknown field "memory"`,
+		},
+		{
+			name: "missing uuid",
+			str:  `{"version":1,"containers":[[{"type":"NVIDIA","usedmem":1}]]}`,
+			err:  "invalid pod devices: device of container 0 without uuid or type",
+		},
+		{
+			name: "negative usage",
+			str:  `{"version":1,"containers":[[{"uuid":"GPU-0","type":"NVIDIA","usedmem":-1}]]}`,
+			err:  "invalid pod devices: negative usage of device GPU-0",
+		},
+		{
+			name: "truncated json",
+			str:  `{"version":1,"containers":[[`,
+			err:  "invalid pod devices: unexpected EOF",
+		},
+		{
+			name: "legacy invalid memory",
+			str:  "GPU-0,NVIDIA,abc,30:;",
+			err:  `pod annotation format error; invalid memory of device GPU-0: strconv.ParseInt: parsing "abc": invalid syntax`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodePodSingleDevice(test.str)
+			assert.Error(t, err, test.err)
+		})
+	}
+}
+
+func Test_DecodePodDevices_mixed(t *testing.T) {
+	checklist := map[string]string{"NVIDIA": "hami.io/vgpu-devices-allocated", "MLU": "hami.io/cambricon-mlu-devices-allocated"}
+	jsonEncoded, err := MarshalPodSingleDevice(testPodSingleDevice())
+	assert.NilError(t, err)
+	annos := map[string]string{
+		"hami.io/vgpu-devices-allocated":          jsonEncoded,
+		"hami.io/cambricon-mlu-devices-allocated": "MLU-0,MLU,1024,0:;",
+	}
+	pd, err := DecodePodDevices(checklist, annos)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, PodDevices{
+		"NVIDIA": testPodSingleDevice(),
+		"MLU":    {{{UUID: "MLU-0", Type: "MLU", Usedmem: 1024}}},
+	}, pd)
+
+	annos["hami.io/vgpu-devices-allocated"] = `{"version":9}`
+	_, err = DecodePodDevices(checklist, annos)
+	assert.ErrorContains(t, err, "failed to decode annotation hami.io/vgpu-devices-allocated")
+}
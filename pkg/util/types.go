@@ -28,6 +28,16 @@ const (
 	BindTimeAnnotations     = "hami.io/bind-time"
 	DeviceBindPhase         = "hami.io/bind-phase"
 
+	// PodGroupAnnotation is the name of the group a pod is gang scheduled with.
+	PodGroupAnnotation = "hami.io/pod-group"
+	// PodGroupMinMemberAnnotation is the number of group members that must fit before any of them is scheduled.
+	PodGroupMinMemberAnnotation = "hami.io/pod-group-min-member"
+
+	// MemoryOversubscriptionAnnotation set to "true" lets a pod use device memory
+	// backed by host memory on oversubscribed devices. Other pods only get
+	// physical memory, on devices that are not oversubscribed.
+	MemoryOversubscriptionAnnotation = "hami.io/tolerate-memory-oversubscription"
+
 	DeviceBindAllocating = "allocating"
 	DeviceBindFailed     = "failed"
 	DeviceBindSuccess    = "success"
@@ -54,7 +64,7 @@ var (
 )
 
 type ContainerDevice struct {
-	// TODO current Idx cannot use, because EncodeContainerDevices method not encode this filed.
+	// Idx and CustomInfo are only kept in annotations by the JSON encoding, see PodDevicesEncoding.
 	Idx        int
 	UUID       string
 	Type       string
@@ -121,6 +131,12 @@ type DeviceUsage struct {
 	Type        string
 	Health      bool
 	CustomInfo  map[string]any
+	// Physmem is the physical memory of the device, less than Totalmem if it is
+	// oversubscribed. Usedmem beyond Physmem is backed by host memory.
+	Physmem int32
+	// Guaranteedmem is the part of Usedmem allocated to pods that do not
+	// tolerate memory oversubscription.
+	Guaranteedmem int32
 }
 
 type DeviceInfo struct {
@@ -137,6 +153,8 @@ type DeviceInfo struct {
 	DeviceVendor    string          `json:"devicevendor,omitempty"`
 	CustomInfo      map[string]any  `json:"custominfo,omitempty"`
 	DevicePairScore DevicePairScore `json:"devicepairscore,omitempty"`
+	// Physmem is the physical memory of a device registered with a deviceMemoryScaling above 1, 0 otherwise.
+	Physmem int32 `json:"physmem,omitempty"`
 }
 
 type DevicePairScores []DevicePairScore
@@ -171,6 +189,9 @@ const (
 	NodeSchedulerPolicyAnnotationKey = "hami.io/node-scheduler-policy"
 	// GPUSchedulerPolicyAnnotationKey is user set Pod annotation to change this default GPU policy.
 	GPUSchedulerPolicyAnnotationKey = "hami.io/gpu-scheduler-policy"
+	// NodeDeviceTemperatureAnnotation is the node annotation the device plugin keeps the
+	// temperatures of the devices in, a JSON object of the degrees Celsius by device ID.
+	NodeDeviceTemperatureAnnotation = "hami.io/node-device-temperature"
 )
 
 func (s SchedulerPolicyName) String() string {
@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Project-HAMi/HAMi/pkg/util/client"
@@ -58,6 +59,44 @@ func init() {
 	HandshakeAnnos = make(map[string]string)
 }
 
+var (
+	// deviceAnnosMutex guards keepDeviceAnnos and changedDeviceAnnos.
+	deviceAnnosMutex   sync.Mutex
+	keepDeviceAnnos    bool
+	changedDeviceAnnos []string
+)
+
+// SetDeviceAnno sets the annotation of a device in InRequestDevices,
+// SupportDevices or HandshakeAnnos, unless already set. The maps are read
+// without a lock, so within KeepDeviceAnnos they are never written, a
+// different annotation is reported instead.
+func SetDeviceAnno(annos map[string]string, device string, anno string) {
+	if annos[device] == anno {
+		return
+	}
+	deviceAnnosMutex.Lock()
+	defer deviceAnnosMutex.Unlock()
+	if keepDeviceAnnos {
+		changedDeviceAnnos = append(changedDeviceAnnos, device+"="+anno)
+		return
+	}
+	annos[device] = anno
+}
+
+// KeepDeviceAnnos runs f, which reinitializes devices while the annotation
+// maps are read, without modifying them. It returns the annotations f set to
+// a different value, as device=annotation.
+func KeepDeviceAnnos(f func()) []string {
+	deviceAnnosMutex.Lock()
+	keepDeviceAnnos, changedDeviceAnnos = true, nil
+	deviceAnnosMutex.Unlock()
+	f()
+	deviceAnnosMutex.Lock()
+	defer deviceAnnosMutex.Unlock()
+	keepDeviceAnnos = false
+	return changedDeviceAnnos
+}
+
 func GetNode(nodename string) (*corev1.Node, error) {
 	if nodename == "" {
 		klog.ErrorS(nil, "Node name is empty")
@@ -127,22 +166,14 @@ func GetPendingPod(ctx context.Context, node string) (*corev1.Pod, error) {
 }
 
 func GetAllocatePodByNode(ctx context.Context, nodeName string) (*corev1.Pod, error) {
-	node, err := client.GetClient().CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	ns, name, err := nodelock.GetNodeLockHolder(ctx, nodeName, nodelock.NodeLockKey)
 	if err != nil {
 		return nil, err
 	}
-	if value, ok := node.Annotations[nodelock.NodeLockKey]; ok {
-		klog.V(2).Infof("node annotation key is %s, value is %s ", nodelock.NodeLockKey, value)
-		_, ns, name, err := nodelock.ParseNodeLock(value)
-		if err != nil {
-			return nil, err
-		}
-		if ns == "" || name == "" {
-			return nil, nil
-		}
-		return client.GetClient().CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+	if ns == "" || name == "" {
+		return nil, nil
 	}
-	return nil, nil
+	return client.GetClient().CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
 }
 
 func DecodeNodeDevices(str string) ([]*DeviceInfo, error) {
@@ -154,7 +185,7 @@ func DecodeNodeDevices(str string) ([]*DeviceInfo, error) {
 	for _, val := range tmp {
 		if strings.Contains(val, ",") {
 			items := strings.Split(val, ",")
-			if len(items) == 7 || len(items) == 9 {
+			if len(items) == 7 || len(items) == 9 || len(items) == 10 {
 				count, _ := strconv.ParseInt(items[1], 10, 32)
 				devmem, _ := strconv.ParseInt(items[2], 10, 32)
 				devcore, _ := strconv.ParseInt(items[3], 10, 32)
@@ -162,10 +193,14 @@ func DecodeNodeDevices(str string) ([]*DeviceInfo, error) {
 				numa, _ := strconv.Atoi(items[5])
 				mode := "hami-core"
 				index := 0
-				if len(items) == 9 {
+				if len(items) >= 9 {
 					index, _ = strconv.Atoi(items[7])
 					mode = items[8]
 				}
+				physmem := int64(0)
+				if len(items) == 10 {
+					physmem, _ = strconv.ParseInt(items[9], 10, 32)
+				}
 				count32, err := safecast.ToInt32(count)
 				if err != nil {
 					return []*DeviceInfo{}, errors.New("node annotations not decode successfully")
@@ -178,6 +213,10 @@ func DecodeNodeDevices(str string) ([]*DeviceInfo, error) {
 				if err != nil {
 					return []*DeviceInfo{}, errors.New("node annotations not decode successfully")
 				}
+				physmem32, err := safecast.ToInt32(physmem)
+				if err != nil {
+					return []*DeviceInfo{}, errors.New("node annotations not decode successfully")
+				}
 				i := DeviceInfo{
 					ID:      items[0],
 					Count:   count32,
@@ -188,6 +227,7 @@ func DecodeNodeDevices(str string) ([]*DeviceInfo, error) {
 					Health:  health,
 					Mode:    mode,
 					Index:   uint(index),
+					Physmem: physmem32,
 				}
 				retval = append(retval, &i)
 			} else {
@@ -226,6 +266,12 @@ func EncodeNodeDevices(dlist []*DeviceInfo) string {
 		builder.WriteString(strconv.Itoa(int(val.Index)))
 		builder.WriteString(",")
 		builder.WriteString(val.Mode)
+		// The physical memory is only written for oversubscribed devices, which
+		// schedulers that do not know it cannot place pods on correctly.
+		if val.Physmem > 0 {
+			builder.WriteString(",")
+			builder.WriteString(strconv.Itoa(int(val.Physmem)))
+		}
 		builder.WriteString(OneContainerMultiDeviceSplitSymbol)
 		//tmp += val.ID + "," + strconv.FormatInt(int64(val.Count), 10) + "," + strconv.Itoa(int(val.Devmem)) + "," + strconv.Itoa(int(val.Devcore)) + "," + val.Type + "," + strconv.Itoa(val.Numa) + "," + strconv.FormatBool(val.Health) + "," + strconv.Itoa(val.Index) + OneContainerMultiDeviceSplitSymbol
 	}
@@ -270,7 +316,17 @@ func EncodeContainerDeviceType(cd ContainerDevices, t string) string {
 	return tmp
 }
 
+// EncodePodSingleDevice encodes the devices of a pod for the devices-to-allocate
+// and devices-allocated annotations, in PodDevicesEncoding.
 func EncodePodSingleDevice(pd PodSingleDevice) string {
+	if PodDevicesEncoding == PodDevicesEncodingJSON {
+		res, err := MarshalPodSingleDevice(pd)
+		if err == nil {
+			klog.Infof("Encoded pod single devices %s", res)
+			return res
+		}
+		klog.ErrorS(err, "Failed to marshal pod devices, falling back to the legacy encoding")
+	}
 	res := ""
 	for _, ctrdevs := range pd {
 		res = res + EncodeContainerDevices(ctrdevs)
@@ -310,9 +366,15 @@ func DecodeContainerDevices(str string) (ContainerDevices, error) {
 			}
 			tmpdev.UUID = tmpstr[0]
 			tmpdev.Type = tmpstr[1]
-			devmem, _ := strconv.ParseInt(tmpstr[2], 10, 32)
+			devmem, err := strconv.ParseInt(tmpstr[2], 10, 32)
+			if err != nil {
+				return ContainerDevices{}, fmt.Errorf("pod annotation format error; invalid memory of device %s: %v", tmpstr[0], err)
+			}
 			tmpdev.Usedmem = int32(devmem)
-			devcores, _ := strconv.ParseInt(tmpstr[3], 10, 32)
+			devcores, err := strconv.ParseInt(tmpstr[3], 10, 32)
+			if err != nil {
+				return ContainerDevices{}, fmt.Errorf("pod annotation format error; invalid cores of device %s: %v", tmpstr[0], err)
+			}
 			tmpdev.Usedcores = int32(devcores)
 			contdev = append(contdev, tmpdev)
 		}
@@ -332,17 +394,11 @@ func DecodePodDevices(checklist map[string]string, annos map[string]string) (Pod
 		if !ok {
 			continue
 		}
-		pd[devID] = make(PodSingleDevice, 0)
-		for s := range strings.SplitSeq(str, OnePodMultiContainerSplitSymbol) {
-			cd, err := DecodeContainerDevices(s)
-			if err != nil {
-				return PodDevices{}, nil
-			}
-			if len(cd) == 0 {
-				continue
-			}
-			pd[devID] = append(pd[devID], cd)
+		single, err := DecodePodSingleDevice(str)
+		if err != nil {
+			return PodDevices{}, fmt.Errorf("failed to decode annotation %s: %v", devs, err)
 		}
+		pd[devID] = single
 	}
 	klog.InfoS("Decoded pod annos", "poddevices", pd)
 	return pd, nil
@@ -494,6 +550,12 @@ func GetDevicesUUIDList(infos []*DeviceInfo) []string {
 	return uuids
 }
 
+// ToleratesMemoryOversubscription reports whether a pod may be given device
+// memory backed by host memory.
+func ToleratesMemoryOversubscription(pod *corev1.Pod) bool {
+	return pod != nil && pod.Annotations[MemoryOversubscriptionAnnotation] == "true"
+}
+
 func GetGPUSchedulerPolicyByPod(defaultPolicy string, task *corev1.Pod) string {
 	userGPUPolicy := defaultPolicy
 	if task != nil && task.Annotations != nil {
@@ -471,6 +471,30 @@ func Test_DecodeNodeDevices(t *testing.T) {
 				err: nil,
 			},
 		},
+		{
+			name: "str with physical memory",
+			args: "GPU-ebe7c3f7-303d-558d-435e-99a160631fe4,10,15360,100,NVIDIA-Tesla P4,0,true,1,hami-core,7680:",
+			want: struct {
+				di  []*DeviceInfo
+				err error
+			}{
+				di: []*DeviceInfo{
+					{
+						ID:      "GPU-ebe7c3f7-303d-558d-435e-99a160631fe4",
+						Index:   1,
+						Count:   10,
+						Devmem:  15360,
+						Physmem: 7680,
+						Devcore: 100,
+						Type:    "NVIDIA-Tesla P4",
+						Mode:    "hami-core",
+						Numa:    0,
+						Health:  true,
+					},
+				},
+				err: nil,
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -524,6 +548,24 @@ func Test_EncodeNodeDevices(t *testing.T) {
 			},
 			want: "GPU-ebe7c3f7-303d-558d-435e-99a160631fe4,10,7680,100,NVIDIA-Tesla P4,0,true,1,hami-core:",
 		},
+		{
+			name: "oversubscribed device",
+			args: []*DeviceInfo{
+				{
+					ID:      "GPU-ebe7c3f7-303d-558d-435e-99a160631fe4",
+					Index:   1,
+					Count:   10,
+					Devmem:  15360,
+					Physmem: 7680,
+					Devcore: 100,
+					Mode:    "hami-core",
+					Type:    "NVIDIA-Tesla P4",
+					Numa:    0,
+					Health:  true,
+				},
+			},
+			want: "GPU-ebe7c3f7-303d-558d-435e-99a160631fe4,10,15360,100,NVIDIA-Tesla P4,0,true,1,hami-core,7680:",
+		},
 	}
 
 	for _, test := range tests {
@@ -1,6 +1,6 @@
 GO=go
 GO111MODULE=on
-CMDS=scheduler vGPUmonitor
+CMDS=scheduler vGPUmonitor dra-driver hami-sim hami-advisor hami-accounting
 DEVICES=nvidia
 OUTPUT_DIR=bin
 TARGET_ARCH=amd64